@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// driveAccount is the Drive account, user@domain, to run as, using its own
+// token file, token-<account>.json, beside the -token-file; empty to use the
+// -token-file
+var driveAccount string
+
+// accountTokenFile returns the token file of an account, beside tokenFile
+func accountTokenFile(tokenFile, account string) (string, error) {
+	if account == "" {
+		return tokenFile, nil
+	}
+	if !strings.Contains(account, "@") || strings.ContainsAny(account, `/\`) || strings.TrimSpace(account) != account {
+		return "", fmt.Errorf("invalid -account %q, expected an email address such as user@example.com", account)
+	}
+	return filepath.Join(filepath.Dir(tokenFile), "token-"+strings.ToLower(account)+".json"), nil
+}
+
+// authCodeOptions are the options of the consent URL, asking for a refresh
+// token and, with -account, preselecting the account
+func authCodeOptions() []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if driveAccount != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", driveAccount))
+	}
+	return opts
+}
+
+// checkAccount checks Drive is authorized as the -account, removing its token
+// file otherwise, so the next run authorizes again rather than migrating
+// another account's files
+func checkAccount(ctx context.Context) error {
+	if driveAccount == "" {
+		return nil
+	}
+	user, err := driveSrv.User(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to check the Drive account: %w", err)
+	}
+	if !strings.EqualFold(user, driveAccount) {
+		os.Remove(tokenFile)
+		return fmt.Errorf("authorized as %s, not -account %s; removed %s, run again and choose %s", user, driveAccount, tokenFile, driveAccount)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAccountTokenFile(t *testing.T) {
+	for _, c := range []struct{ tokenFile, account, want string }{
+		{"token.json", "", "token.json"},
+		{"token.json", "Ana@Example.com", "token-ana@example.com.json"},
+		{"/secrets/token.json", "bo@example.com", "/secrets/token-bo@example.com.json"},
+	} {
+		if got, err := accountTokenFile(c.tokenFile, c.account); err != nil || got != c.want {
+			t.Errorf("accountTokenFile(%q, %q) = %q, %v, want %q", c.tokenFile, c.account, got, err, c.want)
+		}
+	}
+	for _, account := range []string{"ana", "../ana@example.com"} {
+		if _, err := accountTokenFile("token.json", account); err == nil {
+			t.Errorf("accepted -account %q", account)
+		}
+	}
+}
+
+func TestCheckAccount(t *testing.T) {
+	f := useFakes(t)
+	prevAccount, prevToken := driveAccount, tokenFile
+	t.Cleanup(func() { driveAccount, tokenFile = prevAccount, prevToken })
+	tokenFile = filepath.Join(t.TempDir(), "token-ana@example.com.json")
+	os.WriteFile(tokenFile, []byte("{}"), 0o600)
+	driveAccount = "ana@example.com"
+
+	f.drive.user = "Ana@example.com"
+	if err := checkAccount(context.Background()); err != nil {
+		t.Errorf("checkAccount as the account = %v", err)
+	}
+	f.drive.user = "bo@example.com"
+	if err := checkAccount(context.Background()); err == nil || !strings.Contains(err.Error(), "bo@example.com") {
+		t.Errorf("checkAccount as another account = %v", err)
+	}
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Error("the other account's token was kept")
+	}
+	if opts := authCodeOptions(); len(opts) != 2 {
+		t.Errorf("auth code options = %d, want a login hint", len(opts))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// activeHoursSpec is the -active-hours window, e.g. 22:00-06:00, empty to
+// run at any time
+var activeHoursSpec string
+
+// activeHours is the daily window in which files are started, nil for any
+// time
+var activeHours *hoursWindow
+
+// activeClock tells the time the window is checked against, replaced in tests
+var activeClock = time.Now
+
+// pauseFor waits out a pause, returning early with the context's error
+var pauseFor = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// hoursWindow is a daily window of local time, from start up to end, which
+// wraps past midnight when end is before start
+type hoursWindow struct {
+	startHour, startMinute int
+	endHour, endMinute     int
+}
+
+// parseActiveHours parses a window such as 22:00-06:00
+func parseActiveHours(s string) (*hoursWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid -active-hours %q, expected a window such as 22:00-06:00", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -active-hours start %q, expected HH:MM", from)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -active-hours end %q, expected HH:MM", to)
+	}
+	if start.Equal(end) {
+		return nil, fmt.Errorf("invalid -active-hours %q, the window is empty", s)
+	}
+	return &hoursWindow{start.Hour(), start.Minute(), end.Hour(), end.Minute()}, nil
+}
+
+// contains reports whether a time is within the window
+func (w *hoursWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	start, end := w.startHour*60+w.startMinute, w.endHour*60+w.endMinute
+	if start < end {
+		return m >= start && m < end
+	}
+	return m >= start || m < end
+}
+
+// nextStart returns when the window next opens after t
+func (w *hoursWindow) nextStart(t time.Time) time.Time {
+	y, mo, d := t.Date()
+	start := time.Date(y, mo, d, w.startHour, w.startMinute, 0, 0, t.Location())
+	if !start.After(t) {
+		start = time.Date(y, mo, d+1, w.startHour, w.startMinute, 0, 0, t.Location())
+	}
+	return start
+}
+
+// gateActiveHours passes the files on as they are started, holding them back
+// outside -active-hours. The files in progress when the window closes finish;
+// the rest wait for it to open again, written to -checkpoint meanwhile so a
+// run stopped while paused can resume from them with -manifest.
+func gateActiveHours(ctx context.Context, files <-chan drive.File) <-chan drive.File {
+	out := make(chan drive.File)
+	go func() {
+		defer close(out)
+		var pending []drive.File // files received while paused
+		for {
+			var file drive.File
+			if len(pending) > 0 {
+				file, pending = pending[0], pending[1:]
+			} else {
+				f, ok := <-files
+				if !ok {
+					return
+				}
+				file = f
+			}
+			if now := activeClock(); !activeHours.contains(now) {
+				var err error
+				if pending, err = pauseRun(ctx, now, file, pending, files); err != nil {
+					return
+				}
+			}
+			select {
+			case out <- file:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// pauseRun waits for -active-hours to open, checkpointing the files not
+// started, next and those still to come, which are received and returned
+// pending. With -stdin, the files to come aren't known, and aren't
+// checkpointed.
+func pauseRun(ctx context.Context, now time.Time, next drive.File, pending []drive.File, files <-chan drive.File) ([]drive.File, error) {
+	resume := activeHours.nextStart(now)
+	log.Printf("outside -active-hours %s, pausing until %s", activeHoursSpec, resume.Format("Mon 15:04"))
+	checkpointed := checkpointFile != "" && !readStdin
+	if checkpointed {
+		for file := range files {
+			pending = append(pending, file)
+		}
+		if err := writeCheckpoint(checkpointFile, append([]drive.File{next}, pending...)); err != nil {
+			log.Printf("%v", err)
+			checkpointed = false
+		} else {
+			log.Printf("%d files not started, resume with -manifest %s if the run is stopped while paused", len(pending)+1, checkpointFile)
+		}
+	}
+	start := time.Now()
+	err := pauseFor(ctx, resume.Sub(now))
+	stats.pause(time.Since(start))
+	if err != nil {
+		return pending, err
+	}
+	log.Printf("within -active-hours %s, resuming", activeHoursSpec)
+	if checkpointed {
+		// the run goes on with the files in memory
+		if err := os.Remove(checkpointFile); err != nil {
+			log.Printf("unable to remove checkpoint: %v", err)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestParseActiveHours(t *testing.T) {
+	w, err := parseActiveHours("22:00-06:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(h, m int) time.Time { return time.Date(2025, 3, 10, h, m, 0, 0, time.Local) }
+	for _, c := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{day(23, 0), true}, {day(2, 0), true}, {day(6, 29), true},
+		{day(6, 30), false}, {day(12, 0), false}, {day(21, 59), false}, {day(22, 0), true},
+	} {
+		if got := w.contains(c.at); got != c.want {
+			t.Errorf("contains(%s) = %v, want %v", c.at.Format("15:04"), got, c.want)
+		}
+	}
+	if got := w.nextStart(day(12, 0)); !got.Equal(day(22, 0)) {
+		t.Errorf("nextStart(12:00) = %s", got)
+	}
+	if got := w.nextStart(day(22, 0)); !got.Equal(day(22, 0).AddDate(0, 0, 1)) {
+		t.Errorf("nextStart(22:00) = %s, want the next day", got)
+	}
+
+	for _, s := range []string{"22:00", "22-06", "06:00-06:00", "25:00-06:00"} {
+		if _, err := parseActiveHours(s); err == nil {
+			t.Errorf("parseActiveHours(%q) accepted", s)
+		}
+	}
+}
+
+func TestGateActiveHours(t *testing.T) {
+	useFakes(t)
+	defer func(w *hoursWindow, clock func() time.Time, pause func(context.Context, time.Duration) error, cp string) {
+		activeHours, activeClock, pauseFor, checkpointFile = w, clock, pause, cp
+	}(activeHours, activeClock, pauseFor, checkpointFile)
+	activeHours, _ = parseActiveHours("22:00-06:00")
+	now := time.Date(2025, 3, 10, 21, 0, 0, 0, time.Local)
+	activeClock = func() time.Time { return now }
+	checkpointFile = filepath.Join(t.TempDir(), "checkpoint.csv")
+	var paused time.Duration
+	var checkpoint string
+	pauseFor = func(ctx context.Context, d time.Duration) error {
+		paused += d
+		time.Sleep(time.Millisecond)
+		b, _ := os.ReadFile(checkpointFile)
+		checkpoint = string(b)
+		now = now.Add(d)
+		return nil
+	}
+
+	files := make(chan drive.File, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		files <- drive.File{Id: id, Name: id + ".jpg"}
+	}
+	close(files)
+	var got []string
+	for file := range gateActiveHours(context.Background(), files) {
+		got = append(got, file.Id)
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("files = %v, want all of them in order", got)
+	}
+	if paused != time.Hour {
+		t.Errorf("paused for %s, want until 22:00", paused)
+	}
+	if s := stats.summary(); s.PausedSeconds <= 0 || s.Stages["paused"] != (stageSummary{}) {
+		t.Errorf("summary paused %fs, stages %v, want the pause reported apart from the stages", s.PausedSeconds, s.Stages)
+	}
+	if strings.Count(checkpoint, "\n") != 4 {
+		t.Errorf("checkpoint while paused = %q, want the 3 files not started", checkpoint)
+	}
+	if _, err := os.Stat(checkpointFile); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left after resuming: %v", err)
+	}
+}
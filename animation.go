@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"google.golang.org/api/drive/v3"
+)
+
+// animation modes, how animated GIFs and PNGs are described
+const (
+	animationFrame        = "frame"         // the middle frame
+	animationContactSheet = "contact-sheet" // a grid of frames across the animation
+	animationFirst        = "first"         // the first frame
+)
+
+var animationModes = []string{animationFrame, animationContactSheet, animationFirst}
+
+// animationMode is how animated images are described
+var animationMode = animationFrame
+
+// contactSheetFrames is the most frames in a contact sheet
+const contactSheetFrames = 9
+
+// maxContactSheetSide is the longest side of a contact sheet, in pixels
+const maxContactSheetSide = 3072
+
+// object metadata keys of animated images
+const (
+	framesKey   = "frames"
+	durationKey = "duration"
+)
+
+// animationInfo is the frame count and duration of an animated image
+type animationInfo struct {
+	Frames     int   `json:"frames"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// duration returns the length of one loop of the animation
+func (a animationInfo) duration() time.Duration {
+	return time.Duration(a.DurationMs) * time.Millisecond
+}
+
+// animations holds the animation info of this run's animated images, by
+// Drive file ID
+var animations sync.Map
+
+// animationFrames are the frames of an animation, each composited onto the
+// canvas as it is displayed, with their delays
+type animationFrames struct {
+	frames []image.Image
+	delays []time.Duration
+}
+
+// info returns the frame count and duration of the animation
+func (a animationFrames) info() animationInfo {
+	var d time.Duration
+	for _, delay := range a.delays {
+		d += delay
+	}
+	return animationInfo{Frames: len(a.frames), DurationMs: d.Milliseconds()}
+}
+
+// decodeAnimation decodes the frames of an animated GIF or PNG, returning
+// false for other images, including GIFs and PNGs with a single frame
+func decodeAnimation(data []byte, mimeType string) (animationFrames, bool, error) {
+	switch mimeType {
+	case "image/gif":
+		return decodeGIF(data)
+	case "image/png", "image/apng":
+		return decodeAPNG(data)
+	}
+	return animationFrames{}, false, nil
+}
+
+// decodeGIF composites the frames of an animated GIF
+func decodeGIF(data []byte) (animationFrames, bool, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return animationFrames{}, false, fmt.Errorf("unable to decode GIF: %v", err)
+	}
+	if len(g.Image) < 2 {
+		return animationFrames{}, false, nil
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	var a animationFrames
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		if g.Disposal[i] == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		a.frames = append(a.frames, cloneRGBA(canvas))
+		a.delays = append(a.delays, time.Duration(g.Delay[i])*10*time.Millisecond)
+		switch g.Disposal[i] {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return a, true, nil
+}
+
+// pngSignature starts every PNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// pngChunk is a chunk of a PNG file
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// apngFrame is a frame control chunk of an APNG and its image data
+type apngFrame struct {
+	width, height, x, y int
+	delay               time.Duration
+	dispose, blend      byte
+	data                []byte
+}
+
+// decodeAPNG composites the frames of an animated PNG, assembling each frame
+// into a standalone PNG to decode it
+func decodeAPNG(data []byte) (animationFrames, bool, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return animationFrames{}, false, fmt.Errorf("not a PNG")
+	}
+	var header []byte
+	var shared []pngChunk // chunks every frame needs, such as the palette
+	var frames []*apngFrame
+	animated := false
+	for rest := data[len(pngSignature):]; len(rest) >= 12; {
+		n := binary.BigEndian.Uint32(rest)
+		if uint64(n)+12 > uint64(len(rest)) {
+			return animationFrames{}, false, fmt.Errorf("truncated PNG chunk")
+		}
+		c := pngChunk{typ: string(rest[4:8]), data: rest[8 : 8+n]}
+		rest = rest[12+n:]
+		switch c.typ {
+		case "IHDR":
+			header = c.data
+		case "acTL":
+			animated = true
+		case "PLTE", "tRNS", "gAMA", "cHRM", "sRGB", "iCCP", "sBIT":
+			shared = append(shared, c)
+		case "fcTL":
+			if len(c.data) < 26 {
+				return animationFrames{}, false, fmt.Errorf("invalid fcTL chunk")
+			}
+			num, den := binary.BigEndian.Uint16(c.data[20:]), binary.BigEndian.Uint16(c.data[22:])
+			if den == 0 {
+				den = 100
+			}
+			frames = append(frames, &apngFrame{
+				width:   int(binary.BigEndian.Uint32(c.data[4:])),
+				height:  int(binary.BigEndian.Uint32(c.data[8:])),
+				x:       int(binary.BigEndian.Uint32(c.data[12:])),
+				y:       int(binary.BigEndian.Uint32(c.data[16:])),
+				delay:   time.Duration(num) * time.Second / time.Duration(den),
+				dispose: c.data[24],
+				blend:   c.data[25],
+			})
+		case "IDAT":
+			// the default image is the first frame if a frame control precedes it
+			if len(frames) > 0 {
+				frames[len(frames)-1].data = append(frames[len(frames)-1].data, c.data...)
+			}
+		case "fdAT":
+			if len(frames) > 0 && len(c.data) > 4 {
+				frames[len(frames)-1].data = append(frames[len(frames)-1].data, c.data[4:]...)
+			}
+		}
+	}
+	if !animated || len(frames) < 2 || len(header) < 13 {
+		return animationFrames{}, false, nil
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, int(binary.BigEndian.Uint32(header)), int(binary.BigEndian.Uint32(header[4:]))))
+	var a animationFrames
+	for i, f := range frames {
+		img, err := png.Decode(bytes.NewReader(assemblePNG(header, shared, f)))
+		if err != nil {
+			return animationFrames{}, false, fmt.Errorf("unable to decode APNG frame %d: %v", i+1, err)
+		}
+		rect := image.Rect(f.x, f.y, f.x+f.width, f.y+f.height)
+		var previous *image.RGBA
+		if f.dispose == 2 {
+			previous = cloneRGBA(canvas)
+		}
+		op := draw.Over
+		if f.blend == 0 {
+			op = draw.Src
+		}
+		draw.Draw(canvas, rect, img, image.Point{}, op)
+		a.frames = append(a.frames, cloneRGBA(canvas))
+		a.delays = append(a.delays, f.delay)
+		switch f.dispose {
+		case 1:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case 2:
+			canvas = previous
+		}
+	}
+	return a, true, nil
+}
+
+// assemblePNG builds a standalone PNG of an APNG frame
+func assemblePNG(header []byte, shared []pngChunk, f *apngFrame) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	ihdr := bytes.Clone(header)
+	binary.BigEndian.PutUint32(ihdr, uint32(f.width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(f.height))
+	writePNGChunk(&buf, "IHDR", ihdr)
+	for _, c := range shared {
+		writePNGChunk(&buf, c.typ, c.data)
+	}
+	writePNGChunk(&buf, "IDAT", f.data)
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+// writePNGChunk writes a PNG chunk with its length and CRC
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// cloneRGBA copies an image
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+// describedFrame returns the image describing an animation in the
+// -animation mode, encoded as PNG
+func describedFrame(a animationFrames) ([]byte, error) {
+	var img image.Image
+	switch animationMode {
+	case animationFirst:
+		img = a.frames[0]
+	case animationContactSheet:
+		img = contactSheet(a.frames)
+	default:
+		img = a.frames[len(a.frames)/2]
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("unable to encode frame: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// contactSheet lays out up to contactSheetFrames frames, evenly spaced
+// across the animation, in a grid in reading order
+func contactSheet(frames []image.Image) image.Image {
+	n := min(len(frames), contactSheetFrames)
+	cols := int(math.Ceil(math.Sqrt(float64(n))))
+	rows := (n + cols - 1) / cols
+	w, h := frames[0].Bounds().Dx(), frames[0].Bounds().Dy()
+	if scale := float64(maxContactSheetSide) / float64(max(w*cols, h*rows)); scale < 1 {
+		w, h = max(int(float64(w)*scale), 1), max(int(float64(h)*scale), 1)
+	}
+	sheet := image.NewRGBA(image.Rect(0, 0, w*cols, h*rows))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+	for i := range n {
+		frame := frames[i*(len(frames)-1)/max(n-1, 1)]
+		cell := image.Rect(i%cols*w, i/cols*h, i%cols*w+w, i/cols*h+h)
+		draw.ApproxBiLinear.Scale(sheet, cell, frame, frame.Bounds(), draw.Over, nil)
+	}
+	return sheet
+}
+
+// recordAnimation records the frame count and duration of an animated image,
+// returning its frames, or false if it isn't animated
+func recordAnimation(file drive.File, data []byte) (animationFrames, bool) {
+	a, ok, err := decodeAnimation(data, file.MimeType)
+	if err != nil || !ok {
+		return animationFrames{}, false
+	}
+	animations.Store(file.Id, a.info())
+	return a, true
+}
+
+// animationMetadata returns the object metadata of an animated image
+func animationMetadata(info animationInfo) map[string]string {
+	return map[string]string{
+		framesKey:   strconv.Itoa(info.Frames),
+		durationKey: info.duration().String(),
+	}
+}
+
+// animationNote tells Gemini what the image of an animation shows
+func animationNote(info animationInfo) string {
+	switch animationMode {
+	case animationContactSheet:
+		return fmt.Sprintf("The image is a contact sheet of up to %d frames, in reading order, of an animation of %d frames lasting %s.", contactSheetFrames, info.Frames, info.duration())
+	case animationFirst:
+		return fmt.Sprintf("The image is the first frame of an animation of %d frames lasting %s.", info.Frames, info.duration())
+	}
+	return fmt.Sprintf("The image is the middle frame of an animation of %d frames lasting %s.", info.Frames, info.duration())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// frameColors are the colors of the test animations' frames
+var frameColors = []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+
+func testGIF(t *testing.T) []byte {
+	t.Helper()
+	g := &gif.GIF{}
+	for _, c := range frameColors {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+		for i := range frame.Pix {
+			frame.Pix[i] = uint8(color.Palette(palette.Plan9).Index(c))
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 50)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testAPNG builds an APNG of solid frames, the first being the default image
+func testAPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	seq := uint32(0)
+	for i, c := range frameColors {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		for p := 0; p < len(img.Pix); p += 4 {
+			img.Pix[p], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3] = c.R, c.G, c.B, c.A
+		}
+		var enc bytes.Buffer
+		png.Encode(&enc, img)
+		var idat []byte
+		var ihdr []byte
+		for rest := enc.Bytes()[len(pngSignature):]; len(rest) >= 12; {
+			n := binary.BigEndian.Uint32(rest)
+			switch string(rest[4:8]) {
+			case "IHDR":
+				ihdr = rest[8 : 8+n]
+			case "IDAT":
+				idat = append(idat, rest[8:8+n]...)
+			}
+			rest = rest[12+n:]
+		}
+		if i == 0 {
+			writePNGChunk(&buf, "IHDR", ihdr)
+			actl := binary.BigEndian.AppendUint32(nil, uint32(len(frameColors)))
+			writePNGChunk(&buf, "acTL", binary.BigEndian.AppendUint32(actl, 0))
+		}
+		fctl := binary.BigEndian.AppendUint32(nil, seq)
+		for _, v := range []uint32{8, 8, 0, 0} {
+			fctl = binary.BigEndian.AppendUint32(fctl, v)
+		}
+		fctl = binary.BigEndian.AppendUint16(fctl, 1)
+		fctl = binary.BigEndian.AppendUint16(fctl, 4) // a quarter second
+		fctl = append(fctl, 0, 0)
+		writePNGChunk(&buf, "fcTL", fctl)
+		seq++
+		if i == 0 {
+			writePNGChunk(&buf, "IDAT", idat)
+		} else {
+			writePNGChunk(&buf, "fdAT", append(binary.BigEndian.AppendUint32(nil, seq), idat...))
+			seq++
+		}
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func TestDecodeAnimation(t *testing.T) {
+	tests := []struct {
+		name, mimeType string
+		data           []byte
+		want           animationInfo
+	}{
+		{"gif", "image/gif", testGIF(t), animationInfo{Frames: 3, DurationMs: 1500}},
+		{"apng", "image/png", testAPNG(t), animationInfo{Frames: 3, DurationMs: 750}},
+	}
+	for _, tt := range tests {
+		a, ok, err := decodeAnimation(tt.data, tt.mimeType)
+		if err != nil || !ok || a.info() != tt.want {
+			t.Errorf("%s: decodeAnimation = %+v, %t, %v, want %+v", tt.name, a.info(), ok, err, tt.want)
+			continue
+		}
+		for i, c := range frameColors {
+			if got := color.RGBAModel.Convert(a.frames[i].At(4, 4)); got != c {
+				t.Errorf("%s: frame %d color = %v, want %v", tt.name, i, got, c)
+			}
+		}
+	}
+	if _, ok, err := decodeAnimation(testPNG(t, 8, 8), "image/png"); ok || err != nil {
+		t.Errorf("decodeAnimation(still PNG) = %t, %v, want not animated", ok, err)
+	}
+}
+
+func TestDescribeAnimation(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev string) { animationMode = prev }(animationMode)
+	f.drive.add("a", "spinner.gif", "image/gif", "root", testGIF(t))
+
+	r := processFile(context.Background(), *f.drive.files["a"], "spinner.gif")
+	if r.Animation == nil || columns["frames"](r) != "3" || columns["duration"](r) != (1500*time.Millisecond).String() {
+		t.Errorf("record animation = %+v", r.Animation)
+	}
+	if md := f.storage.attrs["test-bucket/spinner.gif"].Metadata; md[framesKey] != "3" || md[durationKey] != "1.5s" {
+		t.Errorf("metadata = %v, want the frames and duration", md)
+	}
+	// the middle frame is described, with a note
+	part := f.generator.contents[0].Parts[0]
+	img, err := png.Decode(bytes.NewReader(part.InlineData.Data))
+	if err != nil || part.InlineData.MIMEType != "image/png" || color.RGBAModel.Convert(img.At(4, 4)) != frameColors[1] {
+		t.Errorf("described %s, %v, want the middle frame", part.InlineData.MIMEType, err)
+	}
+	if note := f.generator.contents[len(f.generator.contents)-1].Parts[0].Text; note != "The image is the middle frame of an animation of 3 frames lasting 1.5s." {
+		t.Errorf("note = %q", note)
+	}
+
+	animationMode = animationContactSheet
+	a, _, _ := decodeAnimation(testGIF(t), "image/gif")
+	sheet, err := describedFrame(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ = png.Decode(bytes.NewReader(sheet))
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+		t.Errorf("contact sheet is %v, want a 2x2 grid", b)
+	}
+	for i, p := range []image.Point{{4, 4}, {12, 4}, {4, 12}} {
+		if got := color.RGBAModel.Convert(img.At(p.X, p.Y)); got != frameColors[i] {
+			t.Errorf("contact sheet cell %d = %v, want %v", i, got, frameColors[i])
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assetsAddr is where the serve command serves the assets API, empty not to
+var assetsAddr string
+
+// assetFilter selects catalog entries by the query parameters of GET /assets
+type assetFilter struct {
+	// mime is a prefix of the MIME type, such as image/
+	mime string
+	// tag is one of the tags, in any case
+	tag string
+	// folder is the Drive folder ID or name the file was in
+	folder string
+	// since and until bound the time the file was described
+	since, until time.Time
+}
+
+// parseAssetFilter reads a filter from query parameters, with since and until
+// as dates, such as 2025-06-01, or RFC 3339 times. A date until is inclusive.
+func parseAssetFilter(q url.Values) (assetFilter, error) {
+	f := assetFilter{mime: q.Get("mime"), tag: q.Get("tag"), folder: q.Get("folder")}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &f.since}, {"until", &f.until}} {
+		v := q.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, v); err != nil {
+				return f, fmt.Errorf("invalid %s %q, expected a date or RFC 3339 time", bound.name, v)
+			}
+			if bound.name == "until" {
+				t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+		}
+		*bound.t = t
+	}
+	return f, nil
+}
+
+// checkColumns checks the catalog has the columns the filter reads, as a
+// default catalog has none of tags, folder_id, folder_name and described_at
+func (f assetFilter) checkColumns(catalog *catalogTable) error {
+	var missing []string
+	switch {
+	case f.tag != "" && !catalog.has("tags"):
+		missing = []string{"tag", "tags"}
+	case f.folder != "" && !catalog.has("folder_id") && !catalog.has("folder_name"):
+		missing = []string{"folder", "folder_id or folder_name"}
+	case (!f.since.IsZero() || !f.until.IsZero()) && !catalog.has("described_at"):
+		missing = []string{"since and until", "described_at"}
+	default:
+		return nil
+	}
+	return fmt.Errorf("filtering by %s needs the %s column, which the catalog doesn't have; add it to the -columns of the runs writing it", missing[0], missing[1])
+}
+
+// matches reports whether a catalog row passes the filter. Rows without a
+// described_at time don't pass a filter by date.
+func (f assetFilter) matches(catalog *catalogTable, row []string) bool {
+	if !strings.HasPrefix(catalog.get(row, "mime_type"), f.mime) {
+		return false
+	}
+	if f.tag != "" && !slices.ContainsFunc(strings.Split(catalog.get(row, "tags"), ";"), func(t string) bool {
+		return strings.EqualFold(strings.TrimSpace(t), f.tag)
+	}) {
+		return false
+	}
+	if f.folder != "" && catalog.get(row, "folder_id") != f.folder && catalog.get(row, "folder_name") != f.folder {
+		return false
+	}
+	if !f.since.IsZero() || !f.until.IsZero() {
+		t, err := time.Parse(time.RFC3339, catalog.get(row, "described_at"))
+		if err != nil || t.Before(f.since) || !f.until.IsZero() && t.After(f.until) {
+			return false
+		}
+	}
+	return true
+}
+
+// assetsPage is the response of GET /assets
+type assetsPage struct {
+	Assets        []map[string]string `json:"assets"`
+	NextPageToken string              `json:"next_page_token,omitempty"`
+}
+
+// assetsHandler serves GET /assets, the entries of the catalog at path, read
+// on each request, as JSON objects of their columns. They are filtered by the
+// mime, tag, folder, since and until parameters, and paged by page_size, up to
+// 1000, and the next_page_token of the previous page as page_token.
+func assetsHandler(path string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /assets", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter, err := parseAssetFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		size := 100
+		if v := q.Get("page_size"); v != "" {
+			if size, err = strconv.Atoi(v); err != nil || size < 1 {
+				http.Error(w, fmt.Sprintf("invalid page_size %q", v), http.StatusBadRequest)
+				return
+			}
+			size = min(size, 1000)
+		}
+		var offset int
+		if v := q.Get("page_token"); v != "" {
+			if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+				http.Error(w, fmt.Sprintf("invalid page_token %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+		catalog, err := loadCatalog(r.Context(), path)
+		if err != nil {
+			log.Printf("assets: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err := filter.checkColumns(catalog); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := assetsPage{Assets: []map[string]string{}}
+		var matched int
+		for _, row := range catalog.rows {
+			if !filter.matches(catalog, row) {
+				continue
+			}
+			matched++
+			if matched <= offset {
+				continue
+			}
+			if len(page.Assets) == size {
+				page.NextPageToken = strconv.Itoa(offset + size)
+				break
+			}
+			page.Assets = append(page.Assets, catalogAsset(catalog, row))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	})
+	return mux
+}
+
+// catalogAsset returns a catalog row as a map of its columns
+func catalogAsset(catalog *catalogTable, row []string) map[string]string {
+	asset := map[string]string{}
+	for _, column := range catalog.header {
+		asset[column] = catalog.get(row, column)
+	}
+	return asset
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const assetsTestCatalog = `name,mime_type,drive_id,folder_id,folder_name,tags,described_at,description
+beach.jpg,image/jpeg,1,f1,Summer,beach;Sunset,2025-06-01T10:00:00Z,A sunset over a sandy beach.
+waves.mp4,video/mp4,2,f1,Summer,beach,2025-06-02T10:00:00Z,Waves at dusk.
+mountain.png,image/png,3,f2,Winter,mountain,2025-12-24T10:00:00Z,A mountain in the snow.
+skipped.png,image/png,4,f2,Winter,,,
+`
+
+func TestAssetsHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	if err := os.WriteFile(path, []byte(assetsTestCatalog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(assetsHandler(path))
+	defer srv.Close()
+	get := func(query string) (int, assetsPage) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/assets?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var page assetsPage
+		json.NewDecoder(resp.Body).Decode(&page)
+		return resp.StatusCode, page
+	}
+	names := func(page assetsPage) string {
+		var names []string
+		for _, a := range page.Assets {
+			names = append(names, a["name"])
+		}
+		return strings.Join(names, ",")
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"", "beach.jpg,waves.mp4,mountain.png,skipped.png"},
+		{"mime=image/", "beach.jpg,mountain.png,skipped.png"},
+		{"tag=sunset", "beach.jpg"},
+		{"tag=beach&mime=video/", "waves.mp4"},
+		{"folder=f2", "mountain.png,skipped.png"},
+		{"folder=Summer", "beach.jpg,waves.mp4"},
+		{"since=2025-06-02", "waves.mp4,mountain.png"},
+		{"until=2025-06-02", "beach.jpg,waves.mp4"},
+		{"since=2025-06-01T12:00:00Z&until=2025-07-01", "waves.mp4"},
+	} {
+		code, page := get(tc.query)
+		if got := names(page); code != http.StatusOK || got != tc.want {
+			t.Errorf("GET /assets?%s = %d %s, want %s", tc.query, code, got, tc.want)
+		}
+	}
+
+	code, page := get("mime=image/&page_size=2")
+	if code != http.StatusOK || names(page) != "beach.jpg,mountain.png" || page.NextPageToken != "2" {
+		t.Fatalf("page 1 = %d %+v", code, page)
+	}
+	if page.Assets[0]["description"] != "A sunset over a sandy beach." || page.Assets[0]["drive_id"] != "1" {
+		t.Errorf("asset = %v", page.Assets[0])
+	}
+	if code, page := get("mime=image/&page_size=2&page_token=2"); code != http.StatusOK || names(page) != "skipped.png" || page.NextPageToken != "" {
+		t.Errorf("page 2 = %d %+v", code, page)
+	}
+
+	for _, query := range []string{"since=yesterday", "page_size=0", "page_token=x"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("GET /assets?%s = %d, want 400", query, code)
+		}
+	}
+	if resp, err := http.Post(srv.URL+"/assets", "application/json", nil); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /assets = %v, %v, want 405", resp, err)
+	}
+}
+
+func TestAssetsHandlerMissingColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	catalog := "name,size,mime_type,drive_id,object_path,description\nbeach.jpg,1,image/jpeg,1,photos/beach.jpg,A beach.\n"
+	if err := os.WriteFile(path, []byte(catalog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(assetsHandler(path))
+	defer srv.Close()
+	for query, want := range map[string]string{
+		"tag=beach":         "the tags column",
+		"folder=f1":         "the folder_id or folder_name column",
+		"since=2025-06-01":  "the described_at column",
+		"until=2025-06-01":  "the described_at column",
+		"mime=image/&tag=x": "-columns",
+	} {
+		resp, err := http.Get(srv.URL + "/assets?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), want) {
+			t.Errorf("GET /assets?%s = %d %q, want 400 mentioning %q", query, resp.StatusCode, body, want)
+		}
+	}
+	if resp, err := http.Get(srv.URL + "/assets?mime=image/"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /assets?mime=image/ = %v, %v, want 200", resp, err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// auditLogPath is a local JSON Lines file every Gemini request and response
+// is appended to, or a gs:// folder each run writes <run-id>.jsonl to
+var auditLogPath string
+
+// auditRedact replaces the prompts and responses in the audit log with their
+// SHA-256 hashes and lengths
+var auditRedact bool
+
+// auditPart is a part of a Gemini request or response in the audit log.
+// Media is recorded by its type and size or URI, never its contents.
+type auditPart struct {
+	Text             string         `json:"text,omitempty"`
+	MimeType         string         `json:"mime_type,omitempty"`
+	Bytes            int            `json:"bytes,omitempty"`
+	URI              string         `json:"uri,omitempty"`
+	FunctionCall     string         `json:"function_call,omitempty"`
+	FunctionResponse string         `json:"function_response,omitempty"`
+	Args             map[string]any `json:"args,omitempty"`
+}
+
+// auditContent is a turn of a Gemini conversation in the audit log
+type auditContent struct {
+	Role  string      `json:"role,omitempty"`
+	Parts []auditPart `json:"parts"`
+}
+
+// auditEntry is a Gemini request and its response
+type auditEntry struct {
+	Time              string         `json:"time"`
+	RunID             string         `json:"run_id"`
+	DriveID           string         `json:"drive_id,omitempty"`
+	Model             string         `json:"model"`
+	Region            string         `json:"region,omitempty"`
+	SystemInstruction []auditPart    `json:"system_instruction,omitempty"`
+	Request           []auditContent `json:"request"`
+	Response          []auditPart    `json:"response,omitempty"`
+	FinishReason      string         `json:"finish_reason,omitempty"`
+	PromptTokens      *int32         `json:"prompt_tokens,omitempty"`
+	ResponseTokens    *int32         `json:"response_tokens,omitempty"`
+	Error             string         `json:"error,omitempty"`
+	DurationMs        int64          `json:"duration_ms"`
+}
+
+// auditLog writes audit entries as JSON Lines, appending to a local file as
+// they are made, or uploading them to GCS on close
+type auditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	buf    bytes.Buffer
+	bucket string
+	object string
+}
+
+// openAuditLog opens the audit log at auditLogPath
+func openAuditLog() (*auditLog, error) {
+	if folder, ok := strings.CutPrefix(auditLogPath, "gs://"); ok {
+		bucket, prefix, _ := strings.Cut(folder, "/")
+		return &auditLog{bucket: bucket, object: objectPath(strings.Trim(prefix, "/"), runID+".jsonl")}, nil
+	}
+	f, err := os.OpenFile(auditLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log: %v", err)
+	}
+	return &auditLog{file: f}, nil
+}
+
+// write appends an entry to the audit log
+func (l *auditLog) write(e auditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		_, err = l.file.Write(b)
+		return err
+	}
+	l.buf.Write(b)
+	return nil
+}
+
+// close closes the local audit log, or uploads the audit log to GCS
+func (l *auditLog) close(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		if err := l.file.Sync(); err != nil {
+			l.file.Close()
+			return err
+		}
+		return l.file.Close()
+	}
+	if l.buf.Len() == 0 {
+		return nil
+	}
+	attrs := storage.ObjectAttrs{ContentType: "application/x-ndjson", Metadata: map[string]string{runIDKey: runID}}
+	if _, err := storageSrv.Upload(ctx, l.bucket, l.object, l.buf.Bytes(), attrs); err != nil {
+		return fmt.Errorf("unable to upload audit log to gs://%s/%s: %v", l.bucket, l.object, err)
+	}
+	return nil
+}
+
+// auditFileKey is the context key of the Drive file ID a request describes
+type auditFileKey struct{}
+
+// withAuditFile returns a context attributing the requests through it to a
+// Drive file in the audit log
+func withAuditFile(ctx context.Context, file drive.File) context.Context {
+	return context.WithValue(ctx, auditFileKey{}, file.Id)
+}
+
+// auditedGenerator records every request and response of a generator in an
+// audit log
+type auditedGenerator struct {
+	generator
+	log *auditLog
+}
+
+func (g *auditedGenerator) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	ctx, region := auditRegion(ctx)
+	start := time.Now()
+	resp, err := g.generator.GenerateContent(ctx, model, contents, config)
+	g.record(ctx, model, contents, config, []*genai.GenerateContentResponse{resp}, err, *region, start)
+	return resp, err
+}
+
+// GenerateContentStream records the streamed response as a whole once it ends
+func (g *auditedGenerator) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		ctx, region := auditRegion(ctx)
+		start := time.Now()
+		var chunks []*genai.GenerateContentResponse
+		var streamErr error
+		defer func() { g.record(ctx, model, contents, config, chunks, streamErr, *region, start) }()
+		for resp, err := range g.generator.GenerateContentStream(ctx, model, contents, config) {
+			if err != nil {
+				streamErr = err
+			} else {
+				chunks = append(chunks, resp)
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// auditRegion returns a context recording the region serving a request,
+// sharing the caller's record of it if there is one
+func auditRegion(ctx context.Context) (context.Context, *string) {
+	if region, ok := ctx.Value(regionKey{}).(*string); ok {
+		return ctx, region
+	}
+	region := new(string)
+	return withServedRegion(ctx, region), region
+}
+
+// record writes a request and its response, or its error, to the audit log
+func (g *auditedGenerator) record(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig, chunks []*genai.GenerateContentResponse, err error, region string, start time.Time) {
+	e := auditEntry{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		RunID:      runID,
+		Model:      model,
+		Region:     region,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	e.DriveID, _ = ctx.Value(auditFileKey{}).(string)
+	if config != nil && config.SystemInstruction != nil {
+		e.SystemInstruction = auditParts(config.SystemInstruction.Parts)
+	}
+	for _, c := range contents {
+		e.Request = append(e.Request, auditContent{Role: c.Role, Parts: auditParts(c.Parts)})
+	}
+	var text strings.Builder
+	for _, resp := range chunks {
+		if resp == nil {
+			continue
+		}
+		if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
+			for _, p := range resp.Candidates[0].Content.Parts {
+				if p != nil && p.Text != "" {
+					text.WriteString(p.Text)
+				} else {
+					e.Response = append(e.Response, auditParts([]*genai.Part{p})...)
+				}
+			}
+			if r := resp.Candidates[0].FinishReason; r != "" {
+				e.FinishReason = string(r)
+			}
+		}
+		if u := resp.UsageMetadata; u != nil {
+			e.PromptTokens, e.ResponseTokens = u.PromptTokenCount, u.CandidatesTokenCount
+		}
+	}
+	if text.Len() > 0 {
+		e.Response = append([]auditPart{{Text: redact(text.String())}}, e.Response...)
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if werr := g.log.write(e); werr != nil {
+		stats.failErr("audit", werr)
+	}
+}
+
+// auditParts records Gemini parts, redacting their text with -audit-redact
+func auditParts(parts []*genai.Part) []auditPart {
+	var out []auditPart
+	for _, p := range parts {
+		if p == nil {
+			continue
+		}
+		var a auditPart
+		switch {
+		case p.Text != "":
+			a.Text = redact(p.Text)
+		case p.InlineData != nil:
+			a.MimeType, a.Bytes = p.InlineData.MIMEType, len(p.InlineData.Data)
+		case p.FileData != nil:
+			a.MimeType, a.URI = p.FileData.MIMEType, p.FileData.FileURI
+		case p.FunctionCall != nil:
+			a.FunctionCall = p.FunctionCall.Name
+			if !auditRedact {
+				a.Args = p.FunctionCall.Args
+			}
+		case p.FunctionResponse != nil:
+			a.FunctionResponse = p.FunctionResponse.Name
+			if !auditRedact {
+				a.Args = p.FunctionResponse.Response
+			}
+		default:
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// redact returns the SHA-256 hash and length of a text with -audit-redact,
+// so identical prompts and responses can be matched without being kept
+func redact(text string) string {
+	if !auditRedact {
+		return text
+	}
+	sum := sha256.Sum256([]byte(text))
+	return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:]), len(text))
+}
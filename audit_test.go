@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAuditLog(t *testing.T, data []byte) []auditEntry {
+	t.Helper()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid audit entry %s: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	f := useFakes(t)
+	prevPath, prevRedact := auditLogPath, auditRedact
+	t.Cleanup(func() { auditLogPath, auditRedact = prevPath, prevRedact })
+	auditLogPath = filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genaiClient = &auditedGenerator{generator: f.generator, log: audit}
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("jpeg"))
+
+	r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+	if err := audit.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(auditLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := readAuditLog(t, data)
+	if len(entries) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.DriveID != "a" || e.Model != model || e.RunID != runID {
+		t.Errorf("entry = %+v", e)
+	}
+	if media := e.Request[0].Parts[0]; media.MimeType != "image/jpeg" || media.Bytes != 4 || media.Text != "" {
+		t.Errorf("media part = %+v, want its type and size only", media)
+	}
+	if len(e.Request) < 2 || e.Request[1].Parts[0].Text == "" {
+		t.Errorf("prompt not recorded: %+v", e.Request)
+	}
+	if len(e.Response) != 1 || e.Response[0].Text != r.Description {
+		t.Errorf("response = %+v, want %q", e.Response, r.Description)
+	}
+}
+
+func TestAuditLogRedactedToGCS(t *testing.T) {
+	f := useFakes(t)
+	prevPath, prevRedact := auditLogPath, auditRedact
+	t.Cleanup(func() { auditLogPath, auditRedact = prevPath, prevRedact })
+	auditLogPath, auditRedact = "gs://audit-bucket/gemini/", true
+	audit, err := openAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.generator.response = "A secret plan"
+	g := &auditedGenerator{generator: f.generator, log: audit}
+	if _, err := g.GenerateContent(context.Background(), model, nil, generateConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, ok := f.storage.objects["audit-bucket/gemini/"+runID+".jsonl"]
+	if !ok {
+		t.Fatalf("audit log not uploaded: %v", sortedKeys(f.storage.objects))
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("audit log has the unredacted response: %s", data)
+	}
+	entries := readAuditLog(t, data)
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].Response[0].Text, "sha256:") {
+		t.Errorf("entries = %+v", entries)
+	}
+}
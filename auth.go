@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
@@ -12,25 +15,84 @@ import (
 	"github.com/fatih/color"
 	"github.com/skratchdot/open-golang/open"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 )
 
+// tokenFile is the -token-file caching the OAuth token
+var tokenFile string = "token.json"
+
+// authorization flows obtaining the OAuth token
+const (
+	authBrowser = "browser" // open a browser, receiving the code on localhost
+	authManual  = "manual"  // print the consent URL and read the code pasted back
+	authDevice  = "device"  // print a code to enter on another device
+)
+
+var authModes = []string{authBrowser, authManual, authDevice}
+
+// authMode is the authorization flow run when there is no -token-file
+var authMode = authBrowser
+
 // Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config, manualAuth bool) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
+func getClient(config *oauth2.Config) *http.Client {
+	// The -token-file, token.json, stores the user's access and refresh
+	// tokens, and is created automatically when the authorization flow
+	// completes for the first time.
+	tok, err := tokenFromFile(tokenFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("unable to read %s, authorizing again: %v", tokenFile, err)
+	}
+	if err == nil && tokenEncryption != tokenPlain {
+		// encrypt a token cached before -token-encryption was set
+		if data, err := os.ReadFile(tokenFile); err == nil && !isSealed(data) {
+			if err := saveToken(tokenFile, tok); err != nil {
+				fatal(exitFailure, "%v", err)
+			}
+		}
+	}
 	if err != nil {
-		if !manualAuth {
-			tok = getTokenFromWebLaunch(config)
-			saveToken(tokFile, tok)
-		} else {
-			tok = getTokenFromWeb(config)
-			saveToken(tokFile, tok)
+		tok = authorize(config)
+		if err := saveToken(tokenFile, tok); err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+	}
+	return oauth2.NewClient(context.Background(), newReauthTokenSource(config, tok))
+}
+
+// authorize runs the -auth-mode authorization flow, returning the token
+func authorize(config *oauth2.Config) *oauth2.Token {
+	switch authMode {
+	case authDevice:
+		tok, err := getTokenFromDevice(context.Background(), config, os.Stderr)
+		if err != nil {
+			fatal(exitAuth, "%v", err)
 		}
+		return tok
+	case authManual:
+		return getTokenFromWeb(config)
+	default:
+		return getTokenFromWebLaunch(config)
 	}
-	return config.Client(context.Background(), tok)
+}
+
+// getTokenFromDevice retrieves an OAuth2 token with the device authorization
+// flow, printing a URL and code to enter on any device with a browser, for
+// machines without one, such as a VM over SSH
+func getTokenFromDevice(ctx context.Context, config *oauth2.Config, out io.Writer) (*oauth2.Token, error) {
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+	da, err := config.DeviceAuth(ctx, oauth2.AccessTypeOffline)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start device authorization, which needs an OAuth client of type TVs and Limited Input devices: %v", err)
+	}
+	fmt.Fprintln(out, color.CyanString("To authorize, visit %s on any device and enter the code %s", da.VerificationURI, da.UserCode))
+	tok, err := config.DeviceAccessToken(ctx, da, oauth2.AccessTypeOffline)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization failed: %v", err)
+	}
+	fmt.Fprintln(out, color.CyanString("Authentication successful"))
+	return tok, nil
 }
 
 // getTokenFromWebLaunch retrieves an exchanged OAuth2 token after launching a web browser
@@ -40,51 +102,61 @@ func getTokenFromWebLaunch(config *oauth2.Config) *oauth2.Token {
 
 	// Redirect user to Google's consent page to ask for permission
 	// for the scopes specified above.
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	authURL := config.AuthCodeURL("state-token", authCodeOptions()...)
 
 	// obtain the token from oauth flow
 	log.Println(color.CyanString("You will now be taken to your browser for authentication"))
 	time.Sleep(1 * time.Second)
 	err := open.Run(authURL)
 	if err != nil {
-		log.Fatalf("unable to open browser: %v", err)
+		fatal(exitAuth, "unable to open browser: %v", err)
 	}
 	time.Sleep(1 * time.Second)
 	log.Printf("Authentication URL: %s\n", authURL)
 
 	var code string
 
-	errorChan := make(chan error)
+	errorChan := make(chan error, 1)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// a server of its own, as a revoked token authorizes again mid-run
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		authCode := r.URL.Query().Get("code")
 		// Use the authorization code that is pushed to the redirect URL.
 		if authCode != "" {
 			code = authCode
 			w.Write([]byte("Authentication successful. You may close this browser window.\n"))
 
-			errorChan <- nil
+			select {
+			case errorChan <- nil:
+			default:
+			}
 			return
 		}
 		//log.Fatal("No code in exchange")
-		errorChan <- fmt.Errorf("no code in exchange")
+		select {
+		case errorChan <- fmt.Errorf("no code in exchange"):
+		default:
+		}
 	})
+	srv := &http.Server{Addr: "localhost:8080", Handler: mux}
 	go func() {
 		log.Printf("listening on %s", ":8080")
-		err := http.ListenAndServe("localhost:8080", nil)
-		if err != nil {
-			log.Fatal(err)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			fatal(exitAuth, "unable to listen for token: %v", err)
 		}
 	}()
 	err = <-errorChan
+	srv.Close()
 	if err != nil {
-		log.Fatalf("received an error while listening for token: %v", err)
+		fatal(exitAuth, "received an error while listening for token: %v", err)
 	}
 
 	// Handle the exchange code to initiate a transport.
 	tok, err := config.Exchange(context.TODO(), code)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		fatal(exitAuth, "Unable to retrieve token from web: %v", err)
 	}
 	log.Println(color.CyanString("Authentication successful"))
 	return tok
@@ -92,41 +164,49 @@ func getTokenFromWebLaunch(config *oauth2.Config) *oauth2.Token {
 
 // Request a token from the web, then returns the retrieved token.
 func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	authURL := config.AuthCodeURL("state-token", authCodeOptions()...)
 	fmt.Printf("Go to the following link in your browser then type the "+
 		"authorization code: \n%v\n", authURL)
 
 	var authCode string
 	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+		fatal(exitAuth, "Unable to read authorization code: %v", err)
 	}
 
 	tok, err := config.Exchange(context.TODO(), authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		fatal(exitAuth, "Unable to retrieve token from web: %v", err)
 	}
 	return tok
 }
 
-// Retrieves a token from a local file.
+// Retrieves a token from a local file, decrypting it if it is encrypted.
 func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	if data, err = openToken(file, data); err != nil {
+		return nil, err
+	}
 	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
+	err = json.Unmarshal(data, tok)
 	return tok, err
 }
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
+// Saves a token to a file path, encrypted first and then written in place
+// of the previous one, which is kept if either fails.
+func saveToken(path string, token *oauth2.Token) error {
 	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	data, err := json.Marshal(token)
+	if err == nil {
+		data, err = sealToken(path, data)
+	}
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return fmt.Errorf("unable to encrypt oauth token: %v", err)
+	}
+	if err := writeFileAtomicMode(path, data, tokenMode); err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return nil
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestGetTokenFromDevice(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/device":
+			fmt.Fprint(w, `{"device_code": "dev", "user_code": "ABCD-EFGH", "verification_url": "https://www.google.com/device", "expires_in": 60, "interval": 1}`)
+		case "/token":
+			if polls++; polls == 1 {
+				w.WriteHeader(http.StatusPreconditionRequired)
+				fmt.Fprint(w, `{"error": "authorization_pending"}`)
+				return
+			}
+			if r.FormValue("device_code") != "dev" {
+				t.Errorf("device_code = %q", r.FormValue("device_code"))
+			}
+			fmt.Fprint(w, `{"access_token": "access", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600}`)
+		}
+	}))
+	defer srv.Close()
+
+	config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{DeviceAuthURL: srv.URL + "/device", TokenURL: srv.URL + "/token"}}
+	var out bytes.Buffer
+	tok, err := getTokenFromDevice(context.Background(), config, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" || polls != 2 {
+		t.Errorf("token = %+v after %d polls", tok, polls)
+	}
+	if !strings.Contains(out.String(), "https://www.google.com/device") || !strings.Contains(out.String(), "ABCD-EFGH") {
+		t.Errorf("output = %q, want the verification URL and code", out.String())
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+
+	"google.golang.org/genai"
+)
+
+// The Gemini backends: Vertex AI, with the project's credentials, or the
+// Gemini Developer API, with an API key
+const (
+	backendVertex    = "vertex"
+	backendGeminiAPI = "geminiapi"
+)
+
+var backends = []string{backendVertex, backendGeminiAPI}
+
+// backend is the -backend describing files
+var backend string = backendVertex
+
+// geminiAPIKey returns the Gemini Developer API key from GEMINI_API_KEY, or
+// GOOGLE_API_KEY
+func geminiAPIKey() string {
+	if key := os.Getenv("GEMINI_API_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("GOOGLE_API_KEY")
+}
+
+// genaiConfig returns the genai client config for the backend, in a Vertex AI
+// location
+func genaiConfig(location string) *genai.ClientConfig {
+	if backend == backendGeminiAPI {
+		return &genai.ClientConfig{APIKey: geminiAPIKey(), Backend: genai.BackendGeminiAPI}
+	}
+	return &genai.ClientConfig{
+		Project:     projectID,
+		Location:    location,
+		Backend:     genai.BackendVertexAI,
+		Credentials: vertexCreds,
+		HTTPOptions: genai.HTTPOptions{BaseURL: vertexBaseURL(location)},
+	}
+}
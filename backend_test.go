@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestGenaiConfig(t *testing.T) {
+	defer func(prev, prevProject string) { backend, projectID = prev, prevProject }(backend, projectID)
+	t.Setenv("GEMINI_API_KEY", "")
+	t.Setenv("GOOGLE_API_KEY", "google-key")
+	projectID = "test-project"
+
+	backend = backendVertex
+	if c := genaiConfig("europe-west4"); c.Backend != genai.BackendVertexAI || c.Project != "test-project" || c.Location != "europe-west4" || c.APIKey != "" {
+		t.Errorf("vertex config = %+v", c)
+	}
+
+	backend = backendGeminiAPI
+	if c := genaiConfig("europe-west4"); c.Backend != genai.BackendGeminiAPI || c.APIKey != "google-key" || c.Project != "" {
+		t.Errorf("geminiapi config = %+v", c)
+	}
+	t.Setenv("GEMINI_API_KEY", "gemini-key")
+	if c := genaiConfig("europe-west4"); c.APIKey != "gemini-key" {
+		t.Errorf("geminiapi key = %q, want GEMINI_API_KEY", c.APIKey)
+	}
+}
+
+func TestDescribePartGeminiAPIOverInlineLimit(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev string, prevMax int64) { backend, maxInlineBytes = prev, prevMax }(backend, maxInlineBytes)
+	backend, maxInlineBytes = backendGeminiAPI, 4
+	f.drive.add("doc", "big.pdf", "application/pdf", "root", []byte("contents"))
+
+	_, _, err := describePart(context.Background(), *f.drive.files["doc"], []byte("contents"))
+	if err == nil || !strings.Contains(err.Error(), "cannot read files from Cloud Storage") {
+		t.Errorf("describePart = %v, want an error", err)
+	}
+	if len(f.storage.objects) != 0 {
+		t.Errorf("staged %d objects, want none", len(f.storage.objects))
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/serviceusage/v1"
+)
+
+// bootstrapAccount is the ID of the service account bootstrap creates for runs
+var bootstrapAccount = "drivetogcs"
+
+// bootstrapDataset is a BigQuery dataset for the catalog bootstrap creates,
+// empty for none
+var bootstrapDataset string
+
+// bucketObjectAdmin is the role the service account is granted on the bucket
+const bucketObjectAdmin = "roles/storage.objectAdmin"
+
+// bootstrapPollInterval is how often an API enablement is checked for
+// completion
+var bootstrapPollInterval = 2 * time.Second
+
+// provisioner is the subset of the Service Usage, IAM, Resource Manager,
+// Cloud Storage and BigQuery APIs bootstrap uses. Each call is idempotent,
+// reporting whether it changed anything.
+type provisioner interface {
+	// EnableService enables an API on a project, waiting until it is enabled
+	EnableService(ctx context.Context, project, service string) (bool, error)
+	// CreateBucket creates a bucket with uniform bucket-level access
+	CreateBucket(ctx context.Context, project, bucket, location string) (bool, error)
+	// CreateServiceAccount creates a service account, returning its email
+	CreateServiceAccount(ctx context.Context, project, accountID, displayName string) (string, bool, error)
+	// GrantProjectRoles grants a member roles on a project
+	GrantProjectRoles(ctx context.Context, project, member string, roles []string) (bool, error)
+	// GrantBucketRole grants a member bucketObjectAdmin on a bucket
+	GrantBucketRole(ctx context.Context, bucket, member string) (bool, error)
+	// CreateDataset creates a BigQuery dataset
+	CreateDataset(ctx context.Context, project, dataset, location string) (bool, error)
+}
+
+// bootstrapPlan is what bootstrap creates
+type bootstrapPlan struct {
+	Project  string
+	Location string
+	Bucket   string
+	Account  string
+	Dataset  string
+}
+
+// services returns the APIs a run needs
+func (p bootstrapPlan) services() []string {
+	services := []string{"drive.googleapis.com", "storage.googleapis.com", "iam.googleapis.com"}
+	if backend == backendVertex {
+		services = append(services, "aiplatform.googleapis.com")
+	}
+	if p.Dataset != "" {
+		services = append(services, "bigquery.googleapis.com")
+	}
+	return services
+}
+
+// projectRoles returns the project roles the service account needs
+func (p bootstrapPlan) projectRoles() []string {
+	var roles []string
+	if backend == backendVertex {
+		roles = append(roles, "roles/aiplatform.user")
+	}
+	if p.Dataset != "" {
+		roles = append(roles, "roles/bigquery.dataEditor", "roles/bigquery.jobUser")
+	}
+	return roles
+}
+
+func bootstrapFlags() {
+	flag.StringVar(&bootstrapAccount, "service-account", bootstrapAccount, "ID of the service account to create for runs")
+	flag.StringVar(&bootstrapDataset, "dataset", bootstrapDataset, "BigQuery dataset to create for the catalog, empty for none")
+}
+
+// runBootstrap runs drivetogcs bootstrap, creating the bucket, service
+// account and its roles, and enabling the APIs a run needs in the -project
+func runBootstrap(ctx context.Context, args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs bootstrap [-gcs-bucket bucket] [-service-account id] [-dataset dataset]")
+		return exitFailure
+	}
+	d := &doctor{out: os.Stdout}
+	plan := bootstrapPlan{Project: projectID, Location: location, Bucket: gcsBucket, Account: bootstrapAccount, Dataset: bootstrapDataset}
+	if plan.Project == "" {
+		d.fail("environment", errors.New("no project: neither -project nor PROJECT_ID is set"), "export PROJECT_ID=$(gcloud config get-value core/project)")
+		return exitFailure
+	}
+	if plan.Bucket == "" {
+		plan.Bucket = fmt.Sprintf("%s-media", plan.Project)
+	}
+
+	p, closeProvisioner, err := newCloudProvisioner(ctx)
+	if err != nil {
+		d.fail("credentials", err, "run gcloud auth application-default login")
+		return exitFailure
+	}
+	defer closeProvisioner()
+	bootstrap(ctx, d, p, plan)
+	if d.failures > 0 {
+		fmt.Fprintf(d.out, "%d steps failed; fix them and run bootstrap again\n", d.failures)
+		return exitFailure
+	}
+	fmt.Fprintf(d.out, "ready to run: export PROJECT_ID=%s and run with -gcs-bucket %s as %s\n", plan.Project, plan.Bucket, serviceAccountEmail(plan.Project, plan.Account))
+	return exitSuccess
+}
+
+// bootstrap creates what the plan needs, printing each step; steps already
+// done are left as they are, so it can be run again after a failure
+func bootstrap(ctx context.Context, d *doctor, p provisioner, plan bootstrapPlan) {
+	for _, service := range plan.services() {
+		changed, err := p.EnableService(ctx, plan.Project, service)
+		if err != nil {
+			d.fail("api", fmt.Errorf("%s: %v", service, err), "grant your account roles/serviceusage.serviceUsageAdmin")
+			return // the resources below need the APIs
+		}
+		d.pass("api", "%s %s", service, done(changed, "enabled"))
+	}
+
+	changed, bucketErr := p.CreateBucket(ctx, plan.Project, plan.Bucket, plan.Location)
+	if err := bucketErr; err != nil {
+		d.fail("bucket", err, "bucket names are global; choose another with -gcs-bucket")
+	} else {
+		d.pass("bucket", "gs://%s in %s %s", plan.Bucket, plan.Location, done(changed, "created"))
+	}
+
+	email, changed, err := p.CreateServiceAccount(ctx, plan.Project, plan.Account, "drivetogcs migrations")
+	if err != nil {
+		d.fail("service account", err, "grant your account roles/iam.serviceAccountAdmin")
+		return
+	}
+	d.pass("service account", "%s %s", email, done(changed, "created"))
+	member := "serviceAccount:" + email
+	if roles := plan.projectRoles(); len(roles) > 0 {
+		changed, err := p.GrantProjectRoles(ctx, plan.Project, member, roles)
+		if err != nil {
+			d.fail("roles", err, "grant your account roles/resourcemanager.projectIamAdmin")
+		} else {
+			d.pass("roles", "%v on %s %s", roles, plan.Project, done(changed, "granted"))
+		}
+	}
+	if bucketErr == nil {
+		changed, err := p.GrantBucketRole(ctx, plan.Bucket, member)
+		if err != nil {
+			d.fail("roles", err, fmt.Sprintf("grant your account roles/storage.admin on gs://%s", plan.Bucket))
+		} else {
+			d.pass("roles", "%s on gs://%s %s", bucketObjectAdmin, plan.Bucket, done(changed, "granted"))
+		}
+	}
+
+	if plan.Dataset != "" {
+		changed, err := p.CreateDataset(ctx, plan.Project, plan.Dataset, plan.Location)
+		if err != nil {
+			d.fail("dataset", err, "grant your account roles/bigquery.admin")
+		} else {
+			d.pass("dataset", "%s.%s %s", plan.Project, plan.Dataset, done(changed, "created"))
+		}
+	}
+}
+
+// done describes a step that changed something, or was done already
+func done(changed bool, verb string) string {
+	if changed {
+		return verb
+	}
+	return "already " + verb
+}
+
+// serviceAccountEmail returns the email of a service account in a project
+func serviceAccountEmail(project, accountID string) string {
+	return fmt.Sprintf("%s@%s.iam.gserviceaccount.com", accountID, project)
+}
+
+// isConflict reports whether err is an HTTP 409, something already existing
+func isConflict(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusConflict
+}
+
+// cloudProvisioner implements provisioner with the Google APIs
+type cloudProvisioner struct {
+	usage    *serviceusage.Service
+	iam      *iam.Service
+	projects *cloudresourcemanager.Service
+	bigquery *bigquery.Service
+	storage  *storage.Client
+}
+
+// newCloudProvisioner creates the API clients with the application default
+// credentials, returning a function to close them
+func newCloudProvisioner(ctx context.Context) (*cloudProvisioner, func(), error) {
+	var p cloudProvisioner
+	var err error
+	if p.usage, err = serviceusage.NewService(ctx); err != nil {
+		return nil, nil, err
+	}
+	if p.iam, err = iam.NewService(ctx); err != nil {
+		return nil, nil, err
+	}
+	if p.projects, err = cloudresourcemanager.NewService(ctx); err != nil {
+		return nil, nil, err
+	}
+	if p.bigquery, err = bigquery.NewService(ctx); err != nil {
+		return nil, nil, err
+	}
+	if p.storage, err = storage.NewClient(ctx); err != nil {
+		return nil, nil, err
+	}
+	return &p, func() { p.storage.Close() }, nil
+}
+
+func (p *cloudProvisioner) EnableService(ctx context.Context, project, service string) (bool, error) {
+	name := fmt.Sprintf("projects/%s/services/%s", project, service)
+	s, err := p.usage.Services.Get(name).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+	if s.State == "ENABLED" {
+		return false, nil
+	}
+	op, err := p.usage.Services.Enable(name, &serviceusage.EnableServiceRequest{}).Context(ctx).Do()
+	for err == nil && !op.Done {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(bootstrapPollInterval):
+		}
+		op, err = p.usage.Operations.Get(op.Name).Context(ctx).Do()
+	}
+	if err != nil {
+		return false, err
+	}
+	if op.Error != nil {
+		return false, errors.New(op.Error.Message)
+	}
+	return true, nil
+}
+
+func (p *cloudProvisioner) CreateBucket(ctx context.Context, project, bucket, location string) (bool, error) {
+	err := p.storage.Bucket(bucket).Create(ctx, project, &storage.BucketAttrs{
+		Location:                 location,
+		UniformBucketLevelAccess: storage.UniformBucketLevelAccess{Enabled: true},
+	})
+	if isConflict(err) {
+		// bucket names are global, so it must also be in the project
+		if _, err := p.storage.Bucket(bucket).Attrs(ctx); err != nil {
+			return false, fmt.Errorf("gs://%s belongs to another project: %v", bucket, err)
+		}
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *cloudProvisioner) CreateServiceAccount(ctx context.Context, project, accountID, displayName string) (string, bool, error) {
+	_, err := p.iam.Projects.ServiceAccounts.Create("projects/"+project, &iam.CreateServiceAccountRequest{
+		AccountId:      accountID,
+		ServiceAccount: &iam.ServiceAccount{DisplayName: displayName},
+	}).Context(ctx).Do()
+	if err != nil && !isConflict(err) {
+		return "", false, err
+	}
+	return serviceAccountEmail(project, accountID), err == nil, nil
+}
+
+func (p *cloudProvisioner) GrantProjectRoles(ctx context.Context, project, member string, roles []string) (bool, error) {
+	policy, err := p.projects.Projects.GetIamPolicy(project, &cloudresourcemanager.GetIamPolicyRequest{}).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, role := range roles {
+		i := slices.IndexFunc(policy.Bindings, func(b *cloudresourcemanager.Binding) bool { return b.Role == role && b.Condition == nil })
+		switch {
+		case i < 0:
+			policy.Bindings = append(policy.Bindings, &cloudresourcemanager.Binding{Role: role, Members: []string{member}})
+		case !slices.Contains(policy.Bindings[i].Members, member):
+			policy.Bindings[i].Members = append(policy.Bindings[i].Members, member)
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	// the policy's etag fails the update if it changed since it was read
+	_, err = p.projects.Projects.SetIamPolicy(project, &cloudresourcemanager.SetIamPolicyRequest{Policy: policy}).Context(ctx).Do()
+	return err == nil, err
+}
+
+func (p *cloudProvisioner) GrantBucketRole(ctx context.Context, bucket, member string) (bool, error) {
+	handle := p.storage.Bucket(bucket).IAM()
+	policy, err := handle.Policy(ctx)
+	if err != nil {
+		return false, err
+	}
+	if policy.HasRole(member, bucketObjectAdmin) {
+		return false, nil
+	}
+	policy.Add(member, bucketObjectAdmin)
+	err = handle.SetPolicy(ctx, policy)
+	return err == nil, err
+}
+
+func (p *cloudProvisioner) CreateDataset(ctx context.Context, project, dataset, location string) (bool, error) {
+	_, err := p.bigquery.Datasets.Insert(project, &bigquery.Dataset{
+		DatasetReference: &bigquery.DatasetReference{ProjectId: project, DatasetId: dataset},
+		Location:         location,
+		Description:      "drivetogcs catalog",
+	}).Context(ctx).Do()
+	if isConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
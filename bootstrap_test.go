@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeProvisioner records what bootstrap creates, failing the calls in errs
+type fakeProvisioner struct {
+	services []string
+	buckets  []string
+	accounts []string
+	roles    map[string][]string // by project or gs:// bucket
+	datasets []string
+	errs     map[string]error
+}
+
+func (p *fakeProvisioner) EnableService(ctx context.Context, project, service string) (bool, error) {
+	if slices.Contains(p.services, service) {
+		return false, nil
+	}
+	p.services = append(p.services, service)
+	return true, nil
+}
+
+func (p *fakeProvisioner) CreateBucket(ctx context.Context, project, bucket, location string) (bool, error) {
+	if err := p.errs["bucket"]; err != nil {
+		return false, err
+	}
+	if slices.Contains(p.buckets, bucket) {
+		return false, nil
+	}
+	p.buckets = append(p.buckets, bucket)
+	return true, nil
+}
+
+func (p *fakeProvisioner) CreateServiceAccount(ctx context.Context, project, accountID, displayName string) (string, bool, error) {
+	email := serviceAccountEmail(project, accountID)
+	if slices.Contains(p.accounts, email) {
+		return email, false, nil
+	}
+	p.accounts = append(p.accounts, email)
+	return email, true, nil
+}
+
+func (p *fakeProvisioner) grant(resource, member string, roles []string) bool {
+	if p.roles == nil {
+		p.roles = map[string][]string{}
+	}
+	changed := false
+	for _, role := range roles {
+		if binding := role + "=" + member; !slices.Contains(p.roles[resource], binding) {
+			p.roles[resource] = append(p.roles[resource], binding)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *fakeProvisioner) GrantProjectRoles(ctx context.Context, project, member string, roles []string) (bool, error) {
+	return p.grant(project, member, roles), nil
+}
+
+func (p *fakeProvisioner) GrantBucketRole(ctx context.Context, bucket, member string) (bool, error) {
+	return p.grant("gs://"+bucket, member, []string{bucketObjectAdmin}), nil
+}
+
+func (p *fakeProvisioner) CreateDataset(ctx context.Context, project, dataset, location string) (bool, error) {
+	if slices.Contains(p.datasets, dataset) {
+		return false, nil
+	}
+	p.datasets = append(p.datasets, dataset)
+	return true, nil
+}
+
+func TestBootstrap(t *testing.T) {
+	prev := backend
+	t.Cleanup(func() { backend = prev })
+	backend = backendVertex
+	ctx := context.Background()
+	plan := bootstrapPlan{Project: "proj", Location: "us-central1", Bucket: "proj-media", Account: "drivetogcs", Dataset: "catalog"}
+	p := &fakeProvisioner{}
+
+	var out bytes.Buffer
+	d := &doctor{out: &out}
+	bootstrap(ctx, d, p, plan)
+	if d.failures != 0 {
+		t.Fatalf("failures = %d:\n%s", d.failures, out.String())
+	}
+	member := "serviceAccount:drivetogcs@proj.iam.gserviceaccount.com"
+	if !slices.Contains(p.services, "aiplatform.googleapis.com") || !slices.Contains(p.services, "bigquery.googleapis.com") {
+		t.Errorf("services = %v", p.services)
+	}
+	if !slices.Contains(p.roles["proj"], "roles/aiplatform.user="+member) || !slices.Contains(p.roles["gs://proj-media"], bucketObjectAdmin+"="+member) {
+		t.Errorf("roles = %v", p.roles)
+	}
+	if !slices.Equal(p.buckets, []string{"proj-media"}) || !slices.Equal(p.datasets, []string{"catalog"}) {
+		t.Errorf("buckets = %v, datasets = %v", p.buckets, p.datasets)
+	}
+
+	// running again changes nothing
+	out.Reset()
+	bootstrap(ctx, d, p, plan)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.Contains(line, "already") {
+			t.Errorf("second run changed something: %s", line)
+		}
+	}
+
+	// a bucket that can't be created fails, and its role isn't granted
+	out.Reset()
+	d = &doctor{out: &out}
+	p = &fakeProvisioner{errs: map[string]error{"bucket": errors.New("gs://proj-media belongs to another project")}}
+	bootstrap(ctx, d, p, plan)
+	if d.failures != 1 || len(p.roles["gs://proj-media"]) != 0 || len(p.accounts) != 1 {
+		t.Errorf("failures = %d, roles = %v:\n%s", d.failures, p.roles, out.String())
+	}
+}
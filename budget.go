@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// maxBytesFlag is the -max-bytes byte budget, e.g. 500GB, empty for no budget
+var maxBytesFlag string
+
+// maxBytes is the total Drive size of the files started in a run, 0 for no budget
+var maxBytes int64
+
+// checkpointFile is where the files not started within the byte budget are
+// written, as a manifest to resume from
+var checkpointFile string = "checkpoint.csv"
+
+// byteUnits are the multipliers of the byte size suffixes, longest first
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"K", 1e3},
+	{"B", 1},
+}
+
+// parseBytes parses a byte size such as 1048576, 500MB or 2TiB
+func parseBytes(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, unit = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q, expected e.g. 500GB or 2TiB", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// writeCheckpoint writes the files not processed as a manifest CSV, keeping
+// where each was found so a recursive run resumes into the same object paths
+func writeCheckpoint(path string, files []drive.File) error {
+	f, err := createPending(path)
+	if err != nil {
+		return fmt.Errorf("unable to create checkpoint: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"drive_id", "name", "folder_id", "relative_path"})
+	for _, file := range files {
+		w.Write([]string{file.Id, file.Name, fileFolderID(file), relativePath(file)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.abort()
+		return fmt.Errorf("unable to write checkpoint: %v", err)
+	}
+	return f.commit()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := map[string]int64{
+		"1048576": 1048576,
+		"500MB":   500e6,
+		"1.5 GB":  1.5e9,
+		"2TiB":    2 << 40,
+		"10k":     10e3,
+	}
+	for s, want := range tests {
+		if got, err := parseBytes(s); err != nil || got != want {
+			t.Errorf("parseBytes(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "lots", "-1GB"} {
+		if _, err := parseBytes(s); err == nil {
+			t.Errorf("parseBytes(%q) = nil error, want error", s)
+		}
+	}
+}
+
+func TestByteBudgetCheckpoint(t *testing.T) {
+	f := useFakes(t)
+	prevMax, prevCheckpoint := maxBytes, checkpointFile
+	defer func() { maxBytes, checkpointFile = prevMax, prevCheckpoint }()
+	maxBytes = 5
+	checkpointFile = filepath.Join(t.TempDir(), "checkpoint.csv")
+	fileLocations.Store("3", fileLocation{folderID: "sub", relativePath: "Sub", ancestors: []string{"root", "sub"}})
+
+	files := make(chan drive.File, 3)
+	for _, file := range []drive.File{
+		{Id: "1", Name: "a.jpg", MimeType: "image/jpeg", Size: 4},
+		{Id: "2", Name: "b.jpg", MimeType: "image/jpeg", Size: 4},
+		{Id: "3", Name: "c.jpg", MimeType: "image/jpeg", Size: 4},
+	} {
+		f.drive.add(file.Id, file.Name, file.MimeType, "root", []byte("data"))
+		files <- file
+	}
+	close(files)
+	output := &markdownRecordWriter{}
+	processFiles(context.Background(), files, output)
+
+	if len(output.records) != 2 {
+		t.Errorf("processed %d files, want 2 within the budget", len(output.records))
+	}
+	if summary := stats.summary(); summary.Skipped["budget"] != 1 {
+		t.Errorf("skipped = %v, want 1 over budget", summary.Skipped)
+	}
+	rows := readCSV(t, checkpointFile)
+	if len(rows) != 2 || strings.Join(rows[1], ",") != "3,c.jpg,sub,Sub" {
+		t.Fatalf("checkpoint = %v", rows)
+	}
+
+	// resuming from the checkpoint restores where the file was found
+	fileLocations.Clear()
+	ids, err := readManifest(checkpointFile)
+	if err != nil || len(ids) != 1 || ids[0] != "3" {
+		t.Fatalf("readManifest() = %v, %v", ids, err)
+	}
+	file := drive.File{Id: "3", Parents: []string{"root"}}
+	if fileFolderID(file) != "sub" || relativePath(file) != "Sub" {
+		t.Errorf("resumed location = %q, %q, want sub, Sub", fileFolderID(file), relativePath(file))
+	}
+}
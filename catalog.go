@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+)
+
+// catalogTable is a CSV catalog, or a spreadsheet exported from one, read
+// into memory to be looked up and edited by column name
+type catalogTable struct {
+	header  []string
+	rows    [][]string
+	columns map[string]int
+}
+
+// readCatalog reads a CSV with a header row
+func readCatalog(path string) (*catalogTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open catalog: %w", err)
+	}
+	defer f.Close()
+	return parseCatalog(path, f)
+}
+
+// parseCatalog parses a CSV with a header row, read from path
+func parseCatalog(path string, r io.Reader) (*catalogTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog %s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("catalog %s is empty", path)
+	}
+	t := &catalogTable{header: records[0], rows: records[1:], columns: map[string]int{}}
+	for i, name := range t.header {
+		t.columns[name] = i
+	}
+	return t, nil
+}
+
+// has reports whether the catalog has a column
+func (t *catalogTable) has(column string) bool {
+	_, ok := t.columns[column]
+	return ok
+}
+
+// get returns a row's value of a column, or an empty string if there is no
+// such column
+func (t *catalogTable) get(row []string, column string) string {
+	i, ok := t.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// set sets a row's value of a column, if the catalog has the column
+func (t *catalogTable) set(row []string, column, value string) {
+	if i, ok := t.columns[column]; ok && i < len(row) {
+		row[i] = value
+	}
+}
+
+// find returns the row with the Drive file ID, or nil
+func (t *catalogTable) find(driveID string) []string {
+	for _, row := range t.rows {
+		if t.get(row, "drive_id") == driveID {
+			return row
+		}
+	}
+	return nil
+}
+
+// write replaces the catalog file, writing to a pending file first so a
+// failed write leaves the original in place
+func (t *catalogTable) write(path string) error {
+	f, err := createPending(path)
+	if err != nil {
+		return fmt.Errorf("unable to create catalog: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write(t.header)
+	w.WriteAll(t.rows)
+	if err := w.Error(); err != nil {
+		f.abort()
+		return fmt.Errorf("unable to write catalog: %v", err)
+	}
+	if err := f.commit(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to write catalog: %v", err)
+	}
+	return nil
+}
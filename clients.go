@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/genai"
+)
+
+// driveClient is the subset of the Drive API used by the pipeline
+type driveClient interface {
+	// List returns all files matching the Drive query, across pages
+	List(ctx context.Context, query string) ([]*drive.File, error)
+	// Get returns the metadata of a file, limited to fields
+	Get(ctx context.Context, id string, fields string) (*drive.File, error)
+	// Download returns the contents of a file
+	Download(ctx context.Context, id string) (io.ReadCloser, error)
+	// Revisions returns all revisions of a file, oldest first
+	Revisions(ctx context.Context, id string) ([]*drive.Revision, error)
+	// DownloadRevision returns the contents of a file revision
+	DownloadRevision(ctx context.Context, id, revisionID string) (io.ReadCloser, error)
+	// Comments returns all comments on a file, with their replies
+	Comments(ctx context.Context, id string) ([]*drive.Comment, error)
+	// UpdateDescription sets the description of a file
+	UpdateDescription(ctx context.Context, id, description string) error
+	// User returns the email address of the authorized user
+	User(ctx context.Context) (string, error)
+}
+
+// storageClient is the subset of Cloud Storage used by the pipeline
+type storageClient interface {
+	// Attrs returns the attributes of an object, or storage.ErrObjectNotExist
+	Attrs(ctx context.Context, bucket, object string) (*storage.ObjectAttrs, error)
+	// Read returns the contents of an object, or storage.ErrObjectNotExist
+	Read(ctx context.Context, bucket, object string) ([]byte, error)
+	// Upload writes data to an object with the given attributes
+	Upload(ctx context.Context, bucket, object string, data []byte, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error)
+	// SignedURL returns a V4 signed GET URL for an object, valid for ttl
+	SignedURL(bucket, object string, ttl time.Duration) (string, error)
+	// UpdateMetadata sets custom metadata keys of an object, leaving the others
+	UpdateMetadata(ctx context.Context, bucket, object string, metadata map[string]string) error
+	// UploadIfGeneration writes data to an object only if its generation
+	// matches, or it does not exist for generation 0, returning
+	// errPreconditionFailed otherwise
+	UploadIfGeneration(ctx context.Context, bucket, object string, data []byte, attrs storage.ObjectAttrs, generation int64) (*storage.ObjectAttrs, error)
+	// DeleteIfGeneration deletes an object only if its generation matches
+	DeleteIfGeneration(ctx context.Context, bucket, object string, generation int64) error
+	// Copy copies an object within a bucket, server side, with the given
+	// attributes
+	Copy(ctx context.Context, bucket, src, dst string, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error)
+	// BucketAttrs returns the attributes of a bucket, or storage.ErrBucketNotExist
+	BucketAttrs(ctx context.Context, bucket string) (*storage.BucketAttrs, error)
+	// TestPermissions returns the subset of permissions the caller has on a bucket
+	TestPermissions(ctx context.Context, bucket string, permissions []string) ([]string, error)
+	// List returns the attributes of the objects under a prefix, by name
+	List(ctx context.Context, bucket, prefix string) ([]*storage.ObjectAttrs, error)
+}
+
+// errPreconditionFailed is returned when a conditional write's generation does not match
+var errPreconditionFailed = errors.New("precondition failed")
+
+// generator is the subset of the genai Models API used by the pipeline
+type generator interface {
+	GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+	GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error]
+}
+
+// embedder is the subset of the genai Models API embedding text
+type embedder interface {
+	EmbedContent(ctx context.Context, model string, contents []*genai.Content, config *genai.EmbedContentConfig) (*genai.EmbedContentResponse, error)
+}
+
+// driveService implements driveClient with the Drive API
+type driveService struct {
+	srv *drive.Service
+}
+
+func (d *driveService) List(ctx context.Context, query string) ([]*drive.File, error) {
+	var files []*drive.File
+	err := d.srv.Files.List().
+		PageSize(1000).
+		Q(query).
+		Fields("nextPageToken, files(id, name, mimeType, parents, size, md5Checksum, createdTime, modifiedTime, imageMediaMetadata(width, height))").
+		Pages(ctx, func(page *drive.FileList) error {
+			files = append(files, page.Files...)
+			return nil
+		})
+	return files, err
+}
+
+func (d *driveService) Get(ctx context.Context, id string, fields string) (*drive.File, error) {
+	return d.srv.Files.Get(id).Fields(googleapi.Field(fields)).Context(ctx).Do()
+}
+
+func (d *driveService) User(ctx context.Context) (string, error) {
+	about, err := d.srv.About.Get().Fields("user(emailAddress)").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return about.User.EmailAddress, nil
+}
+
+func (d *driveService) Download(ctx context.Context, id string) (io.ReadCloser, error) {
+	resp, err := d.srv.Files.Get(id).Context(ctx).Download()
+	if err != nil {
+		return nil, err
+	}
+	// Check the response status
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Error: HTTP status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (d *driveService) Revisions(ctx context.Context, id string) ([]*drive.Revision, error) {
+	var revisions []*drive.Revision
+	err := d.srv.Revisions.List(id).
+		Fields("nextPageToken, revisions(id, mimeType, modifiedTime, size, originalFilename)").
+		Pages(ctx, func(page *drive.RevisionList) error {
+			revisions = append(revisions, page.Revisions...)
+			return nil
+		})
+	return revisions, err
+}
+
+func (d *driveService) DownloadRevision(ctx context.Context, id, revisionID string) (io.ReadCloser, error) {
+	resp, err := d.srv.Revisions.Get(id, revisionID).Context(ctx).Download()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Error: HTTP status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (d *driveService) Comments(ctx context.Context, id string) ([]*drive.Comment, error) {
+	var comments []*drive.Comment
+	err := d.srv.Comments.List(id).
+		Fields("nextPageToken, comments(id, author(displayName, emailAddress), content, quotedFileContent, createdTime, resolved, replies(author(displayName, emailAddress), content, createdTime, action))").
+		PageSize(100).
+		Pages(ctx, func(page *drive.CommentList) error {
+			comments = append(comments, page.Comments...)
+			return nil
+		})
+	return comments, err
+}
+
+func (d *driveService) UpdateDescription(ctx context.Context, id, description string) error {
+	_, err := d.srv.Files.Update(id, &drive.File{Description: description}).Fields("id").Context(ctx).Do()
+	return err
+}
+
+// gcsStorage implements storageClient with Cloud Storage
+type gcsStorage struct {
+	client *storage.Client
+}
+
+func (g *gcsStorage) Attrs(ctx context.Context, bucket, object string) (*storage.ObjectAttrs, error) {
+	attrs, err := g.client.Bucket(bucket).Object(object).Attrs(ctx)
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+		// Bucket or Object does not exist
+		return nil, storage.ErrObjectNotExist
+	}
+	return attrs, err
+}
+
+func (g *gcsStorage) Read(ctx context.Context, bucket, object string) ([]byte, error) {
+	r, err := g.client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (g *gcsStorage) Upload(ctx context.Context, bucket, object string, data []byte, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error) {
+	return g.write(ctx, g.client.Bucket(bucket).Object(object), data, attrs)
+}
+
+func (g *gcsStorage) UpdateMetadata(ctx context.Context, bucket, object string, metadata map[string]string) error {
+	_, err := g.client.Bucket(bucket).Object(object).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	return err
+}
+
+func (g *gcsStorage) UploadIfGeneration(ctx context.Context, bucket, object string, data []byte, attrs storage.ObjectAttrs, generation int64) (*storage.ObjectAttrs, error) {
+	o := g.client.Bucket(bucket).Object(object).If(generationConditions(generation))
+	written, err := g.write(ctx, o, data, attrs)
+	if isPreconditionFailed(err) {
+		return nil, errPreconditionFailed
+	}
+	return written, err
+}
+
+func (g *gcsStorage) DeleteIfGeneration(ctx context.Context, bucket, object string, generation int64) error {
+	err := g.client.Bucket(bucket).Object(object).If(generationConditions(generation)).Delete(ctx)
+	if isPreconditionFailed(err) {
+		return errPreconditionFailed
+	}
+	return err
+}
+
+// generationConditions returns the preconditions matching an object
+// generation, or requiring that it does not exist for generation 0
+func generationConditions(generation int64) storage.Conditions {
+	if generation == 0 {
+		return storage.Conditions{DoesNotExist: true}
+	}
+	return storage.Conditions{GenerationMatch: generation}
+}
+
+func isPreconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
+
+func (g *gcsStorage) write(ctx context.Context, o *storage.ObjectHandle, data []byte, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error) {
+	bucket, object := o.BucketName(), o.ObjectName()
+	wc := o.NewWriter(ctx)
+	wc.ObjectAttrs = attrs
+	wc.ObjectAttrs.Bucket = bucket
+	wc.ObjectAttrs.Name = object
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return nil, fmt.Errorf("failed to write file to GCS: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close writer: %v", err)
+	}
+	return wc.Attrs(), nil
+}
+
+func (g *gcsStorage) Copy(ctx context.Context, bucket, src, dst string, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error) {
+	b := g.client.Bucket(bucket)
+	c := b.Object(dst).CopierFrom(b.Object(src))
+	c.ObjectAttrs = attrs
+	return c.Run(ctx)
+}
+
+func (g *gcsStorage) BucketAttrs(ctx context.Context, bucket string) (*storage.BucketAttrs, error) {
+	return g.client.Bucket(bucket).Attrs(ctx)
+}
+
+func (g *gcsStorage) TestPermissions(ctx context.Context, bucket string, permissions []string) ([]string, error) {
+	return g.client.Bucket(bucket).IAM().TestPermissions(ctx, permissions)
+}
+
+func (g *gcsStorage) List(ctx context.Context, bucket, prefix string) ([]*storage.ObjectAttrs, error) {
+	var objects []*storage.ObjectAttrs
+	it := g.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, attrs)
+	}
+}
+
+func (g *gcsStorage) SignedURL(bucket, object string, ttl time.Duration) (string, error) {
+	return g.client.Bucket(bucket).SignedURL(object, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(ttl),
+	})
+}
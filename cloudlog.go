@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// log formats
+const (
+	logFormatAuto = "auto" // json on GCP, text elsewhere
+	logFormatText = "text" // plain lines on stderr
+	logFormatJSON = "json" // Cloud Logging structured entries on stderr
+)
+
+var logFormats = []string{logFormatAuto, logFormatText, logFormatJSON}
+
+// logFormat is how log lines are written
+var logFormat = logFormatAuto
+
+// Cloud Logging severities
+const (
+	severityInfo     = "INFO"
+	severityWarning  = "WARNING"
+	severityError    = "ERROR"
+	severityCritical = "CRITICAL"
+)
+
+// gcpRuntimeVars are set by the GCP runtimes whose stderr Cloud Logging
+// collects: Cloud Run services and jobs, Cloud Functions and App Engine
+var gcpRuntimeVars = []string{"K_SERVICE", "CLOUD_RUN_JOB", "FUNCTION_TARGET", "GAE_SERVICE"}
+
+// onGCP reports whether the process runs on a GCP runtime
+func onGCP() bool {
+	for _, v := range gcpRuntimeVars {
+		if os.Getenv(v) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// logEntry is a Cloud Logging structured log entry
+type logEntry struct {
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	Time     string            `json:"time"`
+	Labels   map[string]string `json:"logging.googleapis.com/labels,omitempty"`
+	Trace    string            `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID   string            `json:"logging.googleapis.com/spanId,omitempty"`
+}
+
+// structuredLogger writes each log line as a logEntry, labeled with the run
+// ID and in the run's trace, so a run's lines can be found together
+type structuredLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	trace  string
+	spanID string
+}
+
+// structured is the structured logger when logs are written as JSON
+var structured *structuredLogger
+
+// configureLogging writes log lines as Cloud Logging structured entries with
+// -log-format json, or auto on GCP
+func configureLogging() {
+	if logFormat == logFormatText || logFormat == logFormatAuto && !onGCP() {
+		return
+	}
+	structured = &structuredLogger{w: os.Stderr}
+	structured.trace, structured.spanID = runTrace(projectID)
+	log.SetFlags(0)
+	log.SetOutput(structured)
+}
+
+// runTrace returns the Cloud Trace resource name and span ID to correlate the
+// run's logs by: the trace of a W3C TRACEPARENT the run was started with, as
+// by Workflows, or else one derived from the run ID
+func runTrace(project string) (string, string) {
+	if project == "" {
+		project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if project == "" {
+		return "", ""
+	}
+	// TRACEPARENT is version-traceid-spanid-flags
+	if parts := strings.Split(os.Getenv("TRACEPARENT"), "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		return "projects/" + project + "/traces/" + parts[1], parts[2]
+	}
+	sum := sha256.Sum256([]byte(runID))
+	return "projects/" + project + "/traces/" + hex.EncodeToString(sum[:16]), ""
+}
+
+// Write writes a log line as a structured entry, with a severity told from
+// its wording
+func (l *structuredLogger) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	return len(p), l.write(severityOf(message), message)
+}
+
+// write writes a message as a structured entry of a severity
+func (l *structuredLogger) write(severity, message string) error {
+	e := logEntry{
+		Severity: severity,
+		Message:  message,
+		Time:     time.Now().UTC().Format(time.RFC3339Nano),
+		Trace:    l.trace,
+		SpanID:   l.spanID,
+	}
+	if runID != "" {
+		e.Labels = map[string]string{runIDKey: runID}
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(b, '\n'))
+	return err
+}
+
+// logSeverity logs a message at a severity, rather than the one told from its
+// wording
+func logSeverity(severity, message string) {
+	if structured != nil {
+		structured.write(severity, message)
+		return
+	}
+	log.Print(message)
+}
+
+// severityOf tells the severity of a log line from its wording, as the
+// log.Printf calls don't give one
+func severityOf(message string) string {
+	m := strings.ToLower(message)
+	switch {
+	case strings.Contains(m, "unable to"), strings.Contains(m, "failed"), strings.Contains(m, "error"), strings.Contains(m, "cannot"):
+		return severityError
+	case strings.Contains(m, "skipping"), strings.Contains(m, "warning"), strings.Contains(m, "retry"), strings.Contains(m, "exceeded"):
+		return severityWarning
+	}
+	return severityInfo
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSeverityOf(t *testing.T) {
+	for message, want := range map[string]string{
+		"uploaded to bucket/a.png":                   severityInfo,
+		"a.png: unable to sign URL: denied":          severityError,
+		"skipping a.png (1): matches a rule":         severityWarning,
+		"failed to write csv output: disk full":      severityError,
+		"quota exceeded, processing 2 files at once": severityWarning,
+	} {
+		if got := severityOf(message); got != want {
+			t.Errorf("severityOf(%q) = %s, want %s", message, got, want)
+		}
+	}
+}
+
+func TestStructuredLogger(t *testing.T) {
+	prev := runID
+	t.Cleanup(func() { runID = prev })
+	runID = "run-1"
+	t.Setenv("TRACEPARENT", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	trace, span := runTrace("my-project")
+	if trace != "projects/my-project/traces/0af7651916cd43dd8448eb211c80319c" || span != "b7ad6b7169203331" {
+		t.Errorf("runTrace = %s, %s", trace, span)
+	}
+
+	var buf bytes.Buffer
+	l := &structuredLogger{w: &buf, trace: trace, spanID: span}
+	log.New(l, "", 0).Printf("unable to describe: %v", "timeout")
+	var e logEntry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if e.Severity != severityError || e.Message != "unable to describe: timeout" || e.Trace != trace || e.Labels[runIDKey] != "run-1" {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+func TestConfigureLogging(t *testing.T) {
+	prevFormat, prevStructured, prevFlags := logFormat, structured, log.Flags()
+	t.Cleanup(func() {
+		logFormat, structured = prevFormat, prevStructured
+		log.SetFlags(prevFlags)
+		log.SetOutput(os.Stderr)
+	})
+	for _, v := range gcpRuntimeVars {
+		t.Setenv(v, "")
+	}
+	logFormat = logFormatAuto
+	configureLogging()
+	if structured != nil {
+		t.Error("structured logs off GCP")
+	}
+	t.Setenv("CLOUD_RUN_JOB", "migrate")
+	configureLogging()
+	if structured == nil {
+		t.Error("plain logs on Cloud Run")
+	}
+}
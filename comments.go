@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+var exportComments bool
+
+// comment is a Drive comment or reply
+type comment struct {
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email,omitempty"`
+	Content     string    `json:"content"`
+	Quoted      string    `json:"quoted,omitempty"`
+	Created     string    `json:"created"`
+	Resolved    bool      `json:"resolved,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	Replies     []comment `json:"replies,omitempty"`
+}
+
+// addComments retrieves a file's Drive comments and adds them to the record
+func addComments(ctx context.Context, r *record) error {
+	comments, err := driveSrv.Comments(ctx, r.ID)
+	if err != nil {
+		return fmt.Errorf("unable to list comments: %w", err)
+	}
+	r.Comments = nil
+	for _, c := range comments {
+		converted := comment{
+			Content:  c.Content,
+			Created:  c.CreatedTime,
+			Resolved: c.Resolved,
+		}
+		converted.Author, converted.AuthorEmail = author(c.Author)
+		if c.QuotedFileContent != nil {
+			converted.Quoted = c.QuotedFileContent.Value
+		}
+		for _, reply := range c.Replies {
+			rc := comment{Content: reply.Content, Created: reply.CreatedTime, Action: reply.Action}
+			rc.Author, rc.AuthorEmail = author(reply.Author)
+			converted.Replies = append(converted.Replies, rc)
+		}
+		r.Comments = append(r.Comments, converted)
+	}
+	return nil
+}
+
+func author(user *drive.User) (string, string) {
+	if user == nil {
+		return "", ""
+	}
+	return user.DisplayName, user.EmailAddress
+}
+
+// formatComments formats comments and replies for a single catalog column
+func formatComments(comments []comment) string {
+	var lines []string
+	for _, c := range comments {
+		lines = append(lines, fmt.Sprintf("%s: %s", c.Author, c.Content))
+		for _, reply := range c.Replies {
+			lines = append(lines, fmt.Sprintf("  %s: %s", reply.Author, reply.Content))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
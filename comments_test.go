@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestAddComments(t *testing.T) {
+	f := useFakes(t)
+	f.drive.comments["1"] = []*drive.Comment{
+		{
+			Author:            &drive.User{DisplayName: "Ana", EmailAddress: "ana@example.com"},
+			Content:           "Crop the left edge",
+			QuotedFileContent: &drive.CommentQuotedFileContent{Value: "left"},
+			Resolved:          true,
+			Replies: []*drive.Reply{
+				{Author: &drive.User{DisplayName: "Bo"}, Content: "Done", Action: "resolve"},
+			},
+		},
+	}
+
+	r := record{ID: "1"}
+	if err := addComments(context.Background(), &r); err != nil {
+		t.Fatalf("addComments() error = %v", err)
+	}
+	if len(r.Comments) != 1 || r.Comments[0].AuthorEmail != "ana@example.com" || r.Comments[0].Quoted != "left" || !r.Comments[0].Resolved {
+		t.Fatalf("comments = %+v", r.Comments)
+	}
+	if got, want := columns["comments"](r), "Ana: Crop the left edge\n  Bo: Done"; got != want {
+		t.Errorf("comments column = %q, want %q", got, want)
+	}
+}
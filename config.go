@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+var configFile string
+
+// profile is the -profile of the config file to use
+var profile string
+
+// cfg is the loaded configuration file, if any
+var cfg config
+
+// config is the JSON configuration file
+type config struct {
+	// Flags set flags by name, such as "folder" or "gcs-bucket", unless
+	// they are given on the command line or in the environment
+	Flags map[string]json.RawMessage `json:"flags,omitempty"`
+	// Routes send files in matching Drive folders to other destinations; the
+	// first matching route is used
+	Routes []route `json:"routes,omitempty"`
+	// Rules apply prompts, models, destinations and skips to files by type,
+	// name and size; the first matching rule is used
+	Rules []rule `json:"rules,omitempty"`
+	// Labels attach static labels to files by Drive folder or path; every
+	// matching label rule applies
+	Labels []labelRule `json:"labels,omitempty"`
+	// Profiles are named sets of flags, routes and rules for a migration,
+	// selected with -profile
+	Profiles map[string]config `json:"profiles,omitempty"`
+}
+
+// route maps a Drive folder, or a folder path in recursive mode, to a destination
+type route struct {
+	// FolderID matches files in the Drive folder, or its subfolders in recursive mode
+	FolderID string `json:"folder_id,omitempty"`
+	// Path matches files whose folder path relative to the source folder is, or
+	// is within, this slash separated path, in recursive mode
+	Path string `json:"path,omitempty"`
+
+	// Bucket overrides -gcs-bucket
+	Bucket string `json:"bucket,omitempty"`
+	// Prefix overrides -gcs-path; use "/" for the root of the bucket
+	Prefix string `json:"prefix,omitempty"`
+	// StorageClass sets the storage class of the objects, e.g. NEARLINE
+	StorageClass string `json:"storage_class,omitempty"`
+	// retention places holds, a retention period and a custom time on the
+	// objects
+	retention
+}
+
+// rule applies settings to files matching on MIME type, name and size
+type rule struct {
+	// MimeType matches the file's MIME type, a glob such as video/*
+	MimeType string `json:"mime_type,omitempty"`
+	// Name matches the file name, case insensitively, a glob such as *.psd
+	Name string `json:"name,omitempty"`
+	// MinSize and MaxSize match the file's Drive size, e.g. 10MB
+	MinSize string `json:"min_size,omitempty"`
+	MaxSize string `json:"max_size,omitempty"`
+
+	// Skip skips matching files
+	Skip bool `json:"skip,omitempty"`
+	// Describe overrides -describe
+	Describe *bool `json:"describe,omitempty"`
+	// Prompt overrides -prompt
+	Prompt string `json:"prompt,omitempty"`
+	// Model overrides the Gemini model
+	Model string `json:"model,omitempty"`
+	// Bucket, Prefix and StorageClass override the destination, including a
+	// matching route's
+	Bucket       string `json:"bucket,omitempty"`
+	Prefix       string `json:"prefix,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	// retention adds holds to, and overrides the retention period and
+	// custom time of, a matching route's
+	retention
+
+	minSize, maxSize int64
+}
+
+// storageClasses are the valid Cloud Storage storage classes
+var storageClasses = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE"}
+
+// destination is where a file's object is uploaded
+type destination struct {
+	Bucket       string
+	Prefix       string
+	StorageClass string
+	Retention    retention
+}
+
+// loadConfig reads and validates the JSON configuration file
+func loadConfig(path string) (config, error) {
+	var c config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, fmt.Errorf("unable to read config: %v", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("unable to parse config %s: %v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return c, fmt.Errorf("config %v", err)
+	}
+	for name, p := range c.Profiles {
+		if len(p.Profiles) > 0 {
+			return c, fmt.Errorf("config profile %s: profiles cannot be nested", name)
+		}
+		if err := p.validate(); err != nil {
+			return c, fmt.Errorf("config profile %s: %v", name, err)
+		}
+		c.Profiles[name] = p
+	}
+	return c, nil
+}
+
+// validate checks the routes, rules and label rules, cleaning their paths and
+// parsing their sizes and storage classes
+func (c *config) validate() error {
+	var err error
+	for i, r := range c.Routes {
+		if r.FolderID == "" && r.Path == "" {
+			return fmt.Errorf("route %d: folder_id or path is required", i+1)
+		}
+		if c.Routes[i].StorageClass, err = parseStorageClass(r.StorageClass); err != nil {
+			return fmt.Errorf("route %d: %v", i+1, err)
+		}
+		if err := c.Routes[i].retention.validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i+1, err)
+		}
+		c.Routes[i].Path = strings.Trim(r.Path, "/")
+	}
+	for i := range c.Rules {
+		if err := c.Rules[i].validate(); err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	for i := range c.Labels {
+		if err := c.Labels[i].validate(); err != nil {
+			return fmt.Errorf("label rule %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// withProfile returns the config with a profile applied: its flags override
+// the top-level flags, and its routes, rules and label rules are matched first
+func (c config) withProfile(name string) (config, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return c, fmt.Errorf("unknown profile %q, the config has %s", name, strings.Join(sortedKeys(c.Profiles), ", "))
+	}
+	flags := maps.Clone(c.Flags)
+	if flags == nil {
+		flags = map[string]json.RawMessage{}
+	}
+	maps.Copy(flags, p.Flags)
+	return config{
+		Flags:  flags,
+		Routes: append(slices.Clone(p.Routes), c.Routes...),
+		Rules:  append(slices.Clone(p.Rules), c.Rules...),
+		Labels: append(slices.Clone(p.Labels), c.Labels...),
+	}, nil
+}
+
+// parseStorageClass validates and upper cases a storage class
+func parseStorageClass(class string) (string, error) {
+	if class == "" {
+		return "", nil
+	}
+	upper := strings.ToUpper(class)
+	if !slices.Contains(storageClasses, upper) {
+		return "", fmt.Errorf("unknown storage class %q, expected one of %s", class, strings.Join(storageClasses, ", "))
+	}
+	return upper, nil
+}
+
+// validate checks a rule's patterns, parsing its sizes and storage class
+func (r *rule) validate() error {
+	if r.MimeType == "" && r.Name == "" && r.MinSize == "" && r.MaxSize == "" {
+		return fmt.Errorf("mime_type, name, min_size or max_size is required")
+	}
+	for _, pattern := range []string{r.MimeType, r.Name} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	var err error
+	if r.MinSize != "" {
+		if r.minSize, err = parseBytes(r.MinSize); err != nil {
+			return fmt.Errorf("min_size: %v", err)
+		}
+	}
+	if r.MaxSize != "" {
+		if r.maxSize, err = parseBytes(r.MaxSize); err != nil {
+			return fmt.Errorf("max_size: %v", err)
+		}
+	}
+	if r.StorageClass, err = parseStorageClass(r.StorageClass); err != nil {
+		return err
+	}
+	if err := r.retention.validate(); err != nil {
+		return err
+	}
+	if r.Prompt != "" {
+		if _, err := os.Stat(r.Prompt); err != nil {
+			return fmt.Errorf("prompt: %v", err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a file
+func (r *rule) matches(file drive.File) bool {
+	if ok, _ := path.Match(r.MimeType, file.MimeType); r.MimeType != "" && !ok {
+		return false
+	}
+	if ok, _ := path.Match(strings.ToLower(r.Name), strings.ToLower(file.Name)); r.Name != "" && !ok {
+		return false
+	}
+	if r.MinSize != "" && file.Size < r.minSize {
+		return false
+	}
+	if r.MaxSize != "" && file.Size > r.maxSize {
+		return false
+	}
+	return true
+}
+
+// ruleFor returns the first config rule matching a file, or nil
+func ruleFor(file drive.File) *rule {
+	for i := range cfg.Rules {
+		if cfg.Rules[i].matches(file) {
+			return &cfg.Rules[i]
+		}
+	}
+	return nil
+}
+
+// describeSettings are how a file is described
+type describeSettings struct {
+	Describe bool
+	Prompt   string
+	Model    string
+}
+
+// describeSettingsFor returns how a file is described, from the flags and any
+// matching rule
+func describeSettingsFor(file drive.File) describeSettings {
+	s := describeSettings{Describe: createDescription, Prompt: customPromptLocation, Model: model}
+	if isZip(file.MimeType) {
+		s.Describe = false // archives are uploaded as is, their files described with -expand-zips
+		return s
+	}
+	if r := ruleFor(file); r != nil {
+		if r.Describe != nil {
+			s.Describe = *r.Describe
+		}
+		if r.Prompt != "" {
+			s.Prompt = r.Prompt
+		}
+		if r.Model != "" {
+			s.Model = r.Model
+		}
+	}
+	if langs := spokenLanguages(file); len(langs) > 0 {
+		s.Prompt = localizedPrompt(s.Prompt, langs[0])
+	}
+	return s
+}
+
+// describesFiles reports whether the run may describe any file with Gemini:
+// with -describe, or by a config rule turning describing on. Without, the run
+// is transfer only, and needs no Gemini client.
+func describesFiles() bool {
+	return createDescription || slices.ContainsFunc(cfg.Rules, func(r rule) bool { return r.Describe != nil && *r.Describe })
+}
+
+// matches reports whether the route applies to a file
+func (r route) matches(file drive.File) bool {
+	if r.FolderID != "" && !slices.Contains(fileAncestors(file), r.FolderID) {
+		return false
+	}
+	if r.Path != "" {
+		rel := relativePath(file)
+		if rel != r.Path && !strings.HasPrefix(rel, r.Path+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// routeFor returns the destination of a file's object
+func routeFor(file drive.File) destination {
+	dest := destination{Bucket: gcsBucket, Prefix: gcsFolderPath}
+	for _, r := range cfg.Routes {
+		if !r.matches(file) {
+			continue
+		}
+		if r.Bucket != "" {
+			dest.Bucket = r.Bucket
+		}
+		if r.Prefix != "" {
+			dest.Prefix = r.Prefix
+		}
+		dest.StorageClass = r.StorageClass
+		dest.Retention = r.retention
+		break
+	}
+	if r := ruleFor(file); r != nil {
+		if r.Bucket != "" {
+			dest.Bucket = r.Bucket
+		}
+		if r.Prefix != "" {
+			dest.Prefix = r.Prefix
+		}
+		if r.StorageClass != "" {
+			dest.StorageClass = r.StorageClass
+		}
+		dest.Retention = dest.Retention.merge(r.retention)
+	}
+	if piiAction == piiRestrict && len(piiFound(file)) > 0 {
+		dest.Bucket = piiBucket
+	}
+	return dest
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadConfig(t *testing.T) {
+	c, err := loadConfig(writeConfig(t, `{"routes": [{"path": "/Raw/", "bucket": "archive", "storage_class": "coldline"}]}`))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if r := c.Routes[0]; r.Path != "Raw" || r.StorageClass != "COLDLINE" {
+		t.Errorf("loadConfig() route = %+v, want normalized path and storage class", r)
+	}
+
+	for _, contents := range []string{
+		`{"routes": [{"bucket": "archive"}]}`,
+		`{"routes": [{"path": "Raw", "storage_class": "cold"}]}`,
+		`{"routes": [`,
+	} {
+		if _, err := loadConfig(writeConfig(t, contents)); err == nil {
+			t.Errorf("loadConfig(%s) = nil error, want error", contents)
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	f := useFakes(t)
+	prev := cfg
+	defer func() { cfg = prev }()
+	cfg = config{Routes: []route{
+		{Path: "Raw", Bucket: "archive", Prefix: "footage", StorageClass: "COLDLINE"},
+		{FolderID: "cdn", Bucket: "cdn-bucket", Prefix: "/"},
+	}}
+	sourceFolderID = "root"
+	gcsFolderPath = "media"
+	f.drive.add("raw", "Raw", folderMimeType, "root", nil)
+	f.drive.add("cdn", "Web", folderMimeType, "root", nil)
+	f.drive.add("icons", "Icons", folderMimeType, "cdn", nil)
+	f.drive.add("1", "a.jpg", "image/jpeg", "root", []byte("a"))
+	f.drive.add("2", "a.jpg", "image/jpeg", "raw", []byte("b"))
+	f.drive.add("3", "a.jpg", "image/jpeg", "icons", []byte("c"))
+
+	files, err := listFilesRecursive(context.Background(), "root", []string{"image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &markdownRecordWriter{}
+	ch := make(chan drive.File, len(files))
+	for _, file := range files {
+		ch <- file
+	}
+	close(ch)
+	processFiles(context.Background(), ch, output)
+
+	want := map[string]string{
+		"test-bucket/media/a.jpg":    "",
+		"archive/footage/Raw/a.jpg":  "COLDLINE",
+		"cdn-bucket/Web/Icons/a.jpg": "",
+	}
+	for object, class := range want {
+		attrs, ok := f.storage.attrs[object]
+		if !ok {
+			t.Errorf("object %s not uploaded, have %v", object, sortedKeys(f.storage.attrs))
+			continue
+		}
+		if attrs.StorageClass != class {
+			t.Errorf("%s storage class = %q, want %q", object, attrs.StorageClass, class)
+		}
+	}
+	for _, r := range output.records {
+		if uri := r.gcsURI(); !strings.HasPrefix(uri, "gs://"+r.Bucket+"/") {
+			t.Errorf("gcsURI() = %s, want bucket %s", uri, r.Bucket)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	f := useFakes(t)
+	prompt := filepath.Join(t.TempDir(), "video.tpl")
+	if err := os.WriteFile(prompt, []byte("Describe the video {{.ImageName}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(writeConfig(t, `{"rules": [
+		{"name": "*.psd", "skip": true},
+		{"mime_type": "video/*", "prompt": "`+prompt+`", "model": "gemini-2.5-pro", "bucket": "videos", "storage_class": "nearline"},
+		{"min_size": "1KB", "describe": false, "prefix": "large"}
+	]}`))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	prev := cfg
+	cfg = c
+	defer func() { cfg = prev }()
+
+	f.drive.add("psd", "Layers.PSD", "image/png", "root", []byte("p"))
+	f.drive.add("video", "clip.mp4", "video/mp4", "root", []byte("v"))
+	f.drive.add("large", "large.png", "image/png", "root", make([]byte, 2000))
+	f.drive.add("small", "small.png", "image/png", "root", []byte("s"))
+	output := &markdownRecordWriter{}
+	ch := make(chan drive.File, 4)
+	for _, id := range []string{"psd", "video", "large", "small"} {
+		ch <- *f.drive.files[id]
+	}
+	close(ch)
+	processFiles(context.Background(), ch, output)
+
+	if got := stats.summary().Skipped["rule"]; got != 1 {
+		t.Errorf("skipped by rule = %d, want 1", got)
+	}
+	if attrs, ok := f.storage.attrs["videos/clip.mp4"]; !ok || attrs.StorageClass != "NEARLINE" {
+		t.Errorf("video attrs = %+v, want a NEARLINE object in the videos bucket, have %v", attrs, sortedKeys(f.storage.attrs))
+	}
+	for _, object := range []string{"test-bucket/large/large.png", "test-bucket/small.png"} {
+		if _, ok := f.storage.attrs[object]; !ok {
+			t.Errorf("object %s not uploaded, have %v", object, sortedKeys(f.storage.attrs))
+		}
+	}
+	if got := strings.Join(f.generator.models, ","); got != model+",gemini-2.5-pro" && got != "gemini-2.5-pro,"+model {
+		t.Errorf("models = %s, want the video described with gemini-2.5-pro and the small image with %s", got, model)
+	}
+	for _, r := range output.records {
+		if r.ID == "large" && r.Description != "Description skipped" {
+			t.Errorf("large description = %q, want skipped", r.Description)
+		}
+	}
+
+	for _, contents := range []string{
+		`{"rules": [{"skip": true}]}`,
+		`{"rules": [{"name": "[", "skip": true}]}`,
+		`{"rules": [{"min_size": "big"}]}`,
+		`{"rules": [{"name": "*.mov", "prompt": "missing.tpl"}]}`,
+	} {
+		if _, err := loadConfig(writeConfig(t, contents)); err == nil {
+			t.Errorf("loadConfig(%s) = nil error, want error", contents)
+		}
+	}
+}
+
+func TestDescribesFiles(t *testing.T) {
+	f := useFakes(t)
+	prev := cfg
+	defer func() { cfg = prev }()
+	describe := true
+	cfg = config{Rules: []rule{{MimeType: "video/*", Describe: &describe}}}
+
+	createDescription = false
+	if !describesFiles() {
+		t.Error("describesFiles = false with a rule describing videos")
+	}
+	cfg = config{}
+	if describesFiles() {
+		t.Error("describesFiles = true with -describe=false")
+	}
+
+	// transfer only, without a Gemini client
+	genaiClient = nil
+	f.drive.add("a", "a.png", "image/png", "root", []byte("png"))
+	ch := make(chan drive.File, 1)
+	ch <- *f.drive.files["a"]
+	close(ch)
+	output := &markdownRecordWriter{}
+	processFiles(context.Background(), ch, output)
+	if _, ok := f.storage.attrs["test-bucket/a.png"]; !ok {
+		t.Errorf("a.png not uploaded, have %v", sortedKeys(f.storage.attrs))
+	}
+	if len(output.records) != 1 || output.records[0].Description != "Description skipped" {
+		t.Errorf("records = %+v, want a.png with its description skipped", output.records)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// assumeYes skips the confirmation of large runs
+var assumeYes bool
+
+// confirmOverFiles and confirmOverCost are the file count and estimated
+// Gemini cost, in US dollars, of runs to confirm before starting, 0 to never
+// confirm
+var confirmOverFiles int = 1000
+var confirmOverCost float64 = 10
+
+// confirmIn and confirmOut are where the confirmation is asked
+var (
+	confirmIn  io.Reader = os.Stdin
+	confirmOut io.Writer = os.Stderr
+)
+
+// interactive reports whether confirmIn is a terminal that can be asked
+var interactive = func() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// modelPrice is the price of a Gemini model in US dollars per million input
+// and output tokens
+type modelPrice struct {
+	input, output float64
+}
+
+// modelPrices are the standard prices of the models, by model name prefix,
+// longest first
+var modelPrices = []struct {
+	prefix string
+	price  modelPrice
+}{
+	{"gemini-2.0-flash-lite", modelPrice{0.075, 0.30}},
+	{"gemini-2.0-flash", modelPrice{0.15, 0.60}},
+	{"gemini-2.5-flash-lite", modelPrice{0.10, 0.40}},
+	{"gemini-2.5-flash", modelPrice{0.30, 2.50}},
+	{"gemini-2.5-pro", modelPrice{1.25, 10}},
+}
+
+// Rough token counts for estimating, without downloading the files: the
+// prompt and description of each file, videos by their size at about 1MB a
+// second, and documents by their size at about 100KB a page
+const (
+	promptTokens      = 100
+	descriptionTokens = 150
+	videoTokensPerMB  = 263
+	pageTokensPer100K = 258
+)
+
+// estimateTokens estimates the input tokens of describing a file
+func estimateTokens(file drive.File) int64 {
+	switch {
+	case strings.HasPrefix(file.MimeType, "image/"):
+		if m := file.ImageMediaMetadata; m != nil && m.Width > 0 && m.Height > 0 {
+			w, h := int(m.Width), int(m.Height)
+			if maxSide > 0 && max(w, h) > maxSide {
+				w, h = w*maxSide/max(w, h), h*maxSide/max(w, h)
+			}
+			return promptTokens + int64(imageTokens(w, h))
+		}
+		return promptTokens + 258
+	case strings.HasPrefix(file.MimeType, "video/"):
+		return promptTokens + max(file.Size/1e6, 1)*videoTokensPerMB
+	}
+	return promptTokens + max(file.Size/1e5, 1)*pageTokensPer100K
+}
+
+// estimateCost estimates the Gemini cost of describing files in US dollars,
+// and whether the price of each file's model is known
+func estimateCost(files []drive.File) (float64, bool) {
+	var cost float64
+	known := true
+	for _, file := range files {
+		if _, ok := ignored(file); ok {
+			continue
+		}
+		if r := ruleFor(file); r != nil && r.Skip {
+			continue
+		}
+		settings := describeSettingsFor(file)
+		if !settings.Describe {
+			continue
+		}
+		price, ok := priceOf(settings.Model)
+		if !ok {
+			known = false
+			continue
+		}
+		cost += (float64(estimateTokens(file))*price.input + descriptionTokens*price.output) / 1e6
+	}
+	return cost, known
+}
+
+// priceOf returns the price of a model
+func priceOf(model string) (modelPrice, bool) {
+	for _, p := range modelPrices {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.price, true
+		}
+	}
+	return modelPrice{}, false
+}
+
+// confirmRun asks to confirm a run of more than -confirm-over-files files or
+// -confirm-over-cost estimated Gemini cost, unless -yes, returning an error
+// if it is not confirmed or there is no terminal to ask
+func confirmRun(files []drive.File) error {
+	if assumeYes {
+		return nil
+	}
+	n := len(files)
+	if maxFiles > 0 {
+		n = min(n, maxFiles)
+	}
+	cost, known := estimateCost(files[:n])
+	var reasons []string
+	if confirmOverFiles > 0 && n > confirmOverFiles {
+		reasons = append(reasons, fmt.Sprintf("%d files", n))
+	}
+	if confirmOverCost > 0 && cost > confirmOverCost {
+		reasons = append(reasons, fmt.Sprintf("an estimated $%.2f of Gemini usage", cost))
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	summary := fmt.Sprintf("this run would process %s", strings.Join(reasons, " and "))
+	if !known {
+		summary += ", not counting models without a known price"
+	}
+	if !interactive() {
+		return fmt.Errorf("%s; pass -yes to run without confirming", summary)
+	}
+	fmt.Fprintf(confirmOut, "%s. Continue? [y/N] ", summary)
+	answer, _ := bufio.NewReader(confirmIn).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("run not confirmed")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestConfirmRun(t *testing.T) {
+	defer func(files int, cost float64, yes bool, in func() bool) {
+		confirmOverFiles, confirmOverCost, assumeYes, interactive = files, cost, yes, in
+		confirmIn, confirmOut = os.Stdin, os.Stderr
+	}(confirmOverFiles, confirmOverCost, assumeYes, interactive)
+	confirmOverFiles, confirmOverCost, assumeYes = 3, 0, false
+	var out bytes.Buffer
+	confirmOut = &out
+
+	var files []drive.File
+	for i := range 5 {
+		files = append(files, drive.File{Id: fmt.Sprint(i), Name: fmt.Sprintf("%d.jpg", i), MimeType: "image/jpeg"})
+	}
+	if err := confirmRun(files[:3]); err != nil {
+		t.Errorf("confirmRun(3 files) = %v, want no confirmation", err)
+	}
+
+	interactive = func() bool { return false }
+	if err := confirmRun(files); err == nil || !strings.Contains(err.Error(), "pass -yes") {
+		t.Errorf("confirmRun without a terminal = %v, want -yes asked for", err)
+	}
+
+	interactive = func() bool { return true }
+	confirmIn = strings.NewReader("n\n")
+	if err := confirmRun(files); err == nil {
+		t.Error("confirmRun answered no succeeded")
+	}
+	if !strings.Contains(out.String(), "this run would process 5 files. Continue?") {
+		t.Errorf("asked %q", out.String())
+	}
+	confirmIn = strings.NewReader("y\n")
+	if err := confirmRun(files); err != nil {
+		t.Errorf("confirmRun answered yes = %v", err)
+	}
+
+	assumeYes, confirmIn = true, strings.NewReader("")
+	if err := confirmRun(files); err != nil {
+		t.Errorf("confirmRun with -yes = %v", err)
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	defer func(prev string) { model = prev }(model)
+	model = "gemini-2.5-pro"
+	files := []drive.File{
+		{Name: "photo.jpg", MimeType: "image/jpeg", ImageMediaMetadata: &drive.FileImageMediaMetadata{Width: 1536, Height: 768}},
+		{Name: "clip.mp4", MimeType: "video/mp4", Size: 10e6},
+	}
+	// (100+516 + 100+2630 input tokens) * $1.25/M + 2 * 150 output tokens * $10/M
+	want := (3346*1.25 + 300*10) / 1e6
+	if cost, known := estimateCost(files); !known || fmt.Sprintf("%.6f", cost) != fmt.Sprintf("%.6f", want) {
+		t.Errorf("estimateCost = %f, %t, want %f", cost, known, want)
+	}
+	model = "custom-model"
+	if _, known := estimateCost(files); known {
+		t.Error("estimateCost of an unknown model known")
+	}
+}
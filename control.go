@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// controlAddr is where to serve the run's control endpoint: a unix socket,
+// as unix:/path/to.sock, or a localhost address such as localhost:6061;
+// empty to not serve it
+var controlAddr string
+
+// controlStatus is the /status response
+type controlStatus struct {
+	RunID string `json:"run_id"`
+	throttleState
+	Files          int            `json:"files"`
+	Bytes          int64          `json:"bytes"`
+	Failures       map[string]int `json:"failures"`
+	Skipped        map[string]int `json:"skipped"`
+	ElapsedSeconds float64        `json:"elapsed_seconds"`
+}
+
+// controlHandler lets an operator throttle a live run: POST /pause stops
+// starting files, letting those in flight finish, POST /resume starts them
+// again, POST /concurrency?n=4 changes -concurrency, 0 for no limit, and GET
+// /status reports the run's progress and throttle
+func controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeControlStatus(w)
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		throttler.setPaused(true)
+		log.Println("control: paused, no files are started until resumed")
+		writeControlStatus(w)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		throttler.setPaused(false)
+		log.Println("control: resumed")
+		writeControlStatus(w)
+	})
+	mux.HandleFunc("POST /concurrency", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.FormValue("n"))
+		if err != nil || n < 0 {
+			http.Error(w, "expected n, the files to process at once, 0 for no limit", http.StatusBadRequest)
+			return
+		}
+		throttler.setCeiling(n)
+		log.Printf("control: processing up to %d files at once", n)
+		writeControlStatus(w)
+	})
+	return mux
+}
+
+func writeControlStatus(w http.ResponseWriter) {
+	s := stats.summary()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(controlStatus{
+		RunID:          runID,
+		throttleState:  throttler.state(),
+		Files:          s.Files,
+		Bytes:          s.Bytes,
+		Failures:       s.Failures,
+		Skipped:        s.Skipped,
+		ElapsedSeconds: s.ElapsedSeconds,
+	})
+}
+
+// controlListener listens on controlAddr
+func controlListener() (net.Listener, error) {
+	return localListener(controlAddr, "-control")
+}
+
+// localListener listens on an address of an unauthenticated endpoint, set by
+// a flag: TCP addresses must be on the loopback interface, and a unix socket,
+// as unix:/path/to.sock, is only accessible to the user.
+func localListener(addr, name string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// a socket left by a run that didn't exit cleanly; anything else at
+		// the path, such as a file given by mistake, is left alone
+		if info, err := os.Lstat(path); err == nil {
+			if info.Mode().Type() != fs.ModeSocket {
+				return nil, fmt.Errorf("%s %s is not a socket", name, path)
+			}
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("unable to remove stale socket: %v", err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q, expected unix:/path/to.sock or localhost:port", name, addr)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s %s is not on localhost; the endpoint is unauthenticated", name, addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serveControl serves controlHandler on controlAddr, returning a function that
+// stops serving it
+func serveControl() (func(), error) {
+	ln, err := controlListener()
+	if err != nil {
+		return nil, fmt.Errorf("unable to serve control endpoint: %v", err)
+	}
+	log.Printf("serving the control endpoint on %s", controlAddr)
+	srv := &http.Server{Handler: controlHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	return func() { srv.Close() }, nil
+}
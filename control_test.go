@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlHandler(t *testing.T) {
+	useFakes(t)
+	defer func(prev *throttle) { throttler = prev }(throttler)
+	throttler = newThrottle(8)
+	srv := httptest.NewServer(controlHandler())
+	defer srv.Close()
+	post := func(path string) controlStatus {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s status = %d", path, resp.StatusCode)
+		}
+		var s controlStatus
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if s := post("/pause"); !s.Paused {
+		t.Errorf("status after pause = %+v", s)
+	}
+	started := make(chan bool)
+	go func() {
+		throttler.acquire()
+		started <- true
+	}()
+	select {
+	case <-started:
+		t.Fatal("a file started while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s := post("/resume"); s.Paused {
+		t.Errorf("status after resume = %+v", s)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the file didn't start once resumed")
+	}
+
+	if s := post("/concurrency?n=2"); s.Ceiling != 2 || s.Limit != 2 || s.Active != 1 {
+		t.Errorf("status after concurrency=2 = %+v", s)
+	}
+	resp, err := http.Post(srv.URL+"/concurrency?n=-1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("concurrency=-1 status = %d, want 400", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var s controlStatus
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil || s.RunID != runID {
+		t.Errorf("status = %+v, %v", s, err)
+	}
+}
+
+func TestControlListener(t *testing.T) {
+	defer func(prev string) { controlAddr = prev }(controlAddr)
+
+	controlAddr = "0.0.0.0:0"
+	if _, err := controlListener(); err == nil || !strings.Contains(err.Error(), "localhost") {
+		t.Errorf("controlListener(%s) = %v, want refused", controlAddr, err)
+	}
+	controlAddr = "127.0.0.1:0"
+	ln, err := controlListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	controlAddr = "unix:" + filepath.Join(t.TempDir(), "control.sock")
+	ln, err = controlListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a socket left behind is replaced
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if ln, err = controlListener(); err != nil {
+		t.Fatalf("controlListener over a stale socket = %v", err)
+	}
+	ln.Close()
+
+	// a file that isn't a socket is kept
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	os.WriteFile(path, []byte("name\n"), 0600)
+	controlAddr = "unix:" + path
+	if _, err := controlListener(); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("controlListener(%s) = %v, want refused", controlAddr, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("file removed: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+// The OAuth scopes the clients are authorized for
+const (
+	driveScope         = "https://www.googleapis.com/auth/drive"
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// driveCredentials is the path of the credentials JSON Drive is accessed
+// with: an OAuth client, authorizing a user, a service account key or an
+// authorized_user file; empty for GOOGLE_CREDENTIALS
+var driveCredentials string
+
+// storageCredentials is the path of the service account key or
+// authorized_user file Cloud Storage is accessed with; empty for Application
+// Default Credentials
+var storageCredentials string
+
+// vertexCredentials is the path of the service account key or
+// authorized_user file Vertex AI is accessed with; empty for Application
+// Default Credentials
+var vertexCredentials string
+
+// vertexCreds are the -vertex-credentials, loaded, or nil for Application
+// Default Credentials
+var vertexCreds *google.Credentials
+
+// credentials file types
+const (
+	credentialsOAuthClient    = "oauth_client"
+	credentialsServiceAccount = "service_account"
+	credentialsAuthorizedUser = "authorized_user"
+)
+
+// credentialsType tells the type of a credentials JSON: an OAuth client, of
+// a Desktop app or Web application, or else its "type"
+func credentialsType(data []byte) string {
+	var c struct {
+		Type      string          `json:"type"`
+		Installed json.RawMessage `json:"installed"`
+		Web       json.RawMessage `json:"web"`
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return ""
+	}
+	if c.Installed != nil || c.Web != nil {
+		return credentialsOAuthClient
+	}
+	return c.Type
+}
+
+// driveCredentialsFile returns the path of the Drive credentials, from
+// -drive-credentials or GOOGLE_CREDENTIALS
+func driveCredentialsFile() string {
+	return cmp.Or(driveCredentials, os.Getenv("GOOGLE_CREDENTIALS"))
+}
+
+// driveHTTPClient returns an HTTP client authorized for Drive with a
+// credentials JSON, and who it is authorized as. An OAuth client authorizes
+// the user with -auth-mode, caching the token in -token-file; a service
+// account acts as the -account by domain-wide delegation, or as itself.
+func driveHTTPClient(ctx context.Context, data []byte) (*http.Client, string, error) {
+	switch t := credentialsType(data); t {
+	case credentialsOAuthClient:
+		config, err := google.ConfigFromJSON(data, driveScope)
+		if err != nil {
+			return nil, "", err
+		}
+		return getClient(config), "OAuth client " + config.ClientID, nil
+	case credentialsServiceAccount, credentialsAuthorizedUser:
+		creds, err := google.CredentialsFromJSONWithParams(ctx, data, google.CredentialsParams{
+			Scopes:  []string{driveScope},
+			Subject: driveAccount,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return oauth2.NewClient(ctx, creds.TokenSource), describeCredentials(t, data), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported credentials type %q, expected an OAuth client, service account key or authorized_user JSON", t)
+	}
+}
+
+// describeCredentials names a service account key by its email, or else by
+// its type
+func describeCredentials(t string, data []byte) string {
+	var c struct {
+		ClientEmail string `json:"client_email"`
+	}
+	json.Unmarshal(data, &c)
+	if t == credentialsServiceAccount && c.ClientEmail != "" {
+		if driveAccount != "" {
+			return "service account " + c.ClientEmail + " as " + driveAccount
+		}
+		return "service account " + c.ClientEmail
+	}
+	return t
+}
+
+// loadCredentials reads a service account key or authorized_user file for
+// the cloud-platform scope; nil for an empty path, to use Application Default
+// Credentials
+func loadCredentials(ctx context.Context, path string) (*google.Credentials, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch t := credentialsType(data); t {
+	case credentialsServiceAccount, credentialsAuthorizedUser:
+	default:
+		return nil, fmt.Errorf("%s: unsupported credentials type %q, expected a service account key or authorized_user JSON", path, t)
+	}
+	return google.CredentialsFromJSON(ctx, data, cloudPlatformScope)
+}
+
+// storageOptions returns the Cloud Storage client options for the
+// -storage-credentials and -storage-endpoint
+func storageOptions(ctx context.Context) ([]option.ClientOption, error) {
+	creds, err := loadCredentials(ctx, storageCredentials)
+	if err != nil || creds == nil {
+		return storageEndpointOptions(), err
+	}
+	return append(storageEndpointOptions(), option.WithCredentials(creds)), nil
+}
+
+// loadVertexCredentials loads the -vertex-credentials the genai clients are
+// created with
+func loadVertexCredentials(ctx context.Context) error {
+	creds, err := loadCredentials(ctx, vertexCredentials)
+	if err != nil {
+		return err
+	}
+	vertexCreds = creds
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DAM and CMS output formats, writing an import file of the uploaded assets
+const (
+	formatAEM        = "aem"        // Adobe Experience Manager metadata import CSV
+	formatWordPress  = "wordpress"  // WordPress WXR of media attachments
+	formatContentful = "contentful" // contentful-import JSON of assets
+)
+
+// damFiles are the default import file paths of the DAM output formats
+var damFiles = map[string]string{
+	formatAEM:        "aem-metadata.csv",
+	formatWordPress:  "wordpress-media.xml",
+	formatContentful: "contentful-assets.json",
+}
+
+// damFile is the path of the DAM import file, defaulting to damFiles
+var damFile string
+
+// aemRoot is the DAM folder AEM asset paths are under
+var aemRoot = "/content/dam"
+
+// contentfulLocale is the locale of the Contentful asset fields
+var contentfulLocale = "en-US"
+
+// damRecordWriter collects the records of uploaded files and writes them as
+// an import file for a DAM or CMS on Close
+type damRecordWriter struct {
+	mu      sync.Mutex
+	format  string
+	path    string
+	records []record
+}
+
+// newDAMRecordWriter returns a writer of a DAM output format
+func newDAMRecordWriter(format string) *damRecordWriter {
+	p := damFile
+	if p == "" {
+		p = damFiles[format]
+	}
+	return &damRecordWriter{format: format, path: p}
+}
+
+// Write keeps a record, skipping files that weren't uploaded or whose
+// description failed
+func (w *damRecordWriter) Write(r record) error {
+	if r.ObjectPath == "" || r.NeedsReview {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.records = append(w.records, r)
+	return nil
+}
+
+func (w *damRecordWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var data []byte
+	var err error
+	switch w.format {
+	case formatAEM:
+		data, err = aemMetadata(w.records)
+	case formatWordPress:
+		data, err = wordpressMedia(w.records)
+	case formatContentful:
+		data, err = contentfulAssets(w.records)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to encode %s import: %v", w.format, err)
+	}
+	if err := writeFile(w.path, data); err != nil {
+		return fmt.Errorf("unable to write %s import: %v", w.format, err)
+	}
+	return nil
+}
+
+// recordLink returns the URL an asset is linked to or fetched from: its
+// signed URL, its public URL when objects are public, or the console URL
+func recordLink(r record) string {
+	if r.SignedURL != "" {
+		return r.SignedURL
+	}
+	if makePublic {
+		return publicURL(r.bucket(), r.ObjectPath)
+	}
+	return r.browserURL()
+}
+
+// assetTitle is the title of an asset, its file name without extension
+func assetTitle(r record) string {
+	return strings.TrimSuffix(r.Name, path.Ext(r.Name))
+}
+
+// aemMetadata renders the records as an AEM metadata import CSV, with typed
+// property columns and multi-valued tags separated by |
+func aemMetadata(records []record) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"assetPath", "dc:title{{String}}", "dc:description{{String}}", "dc:format{{String}}", "dc:source{{String}}", "cq:tags{{String: multi}}"})
+	for _, r := range records {
+		w.Write([]string{
+			path.Join(aemRoot, r.ObjectPath),
+			assetTitle(r),
+			r.Description,
+			r.MimeType,
+			r.gcsURI(),
+			strings.Join(r.Tags, "|"),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// WordPress eXtended RSS, as read by the WordPress importer
+type wxr struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Excerpt string     `xml:"xmlns:excerpt,attr"`
+	Content string     `xml:"xmlns:content,attr"`
+	WP      string     `xml:"xmlns:wp,attr"`
+	Channel wxrChannel `xml:"channel"`
+}
+
+type wxrChannel struct {
+	Title      string    `xml:"title"`
+	WXRVersion string    `xml:"wp:wxr_version"`
+	Items      []wxrItem `xml:"item"`
+}
+
+type wxrItem struct {
+	Title         string        `xml:"title"`
+	Link          string        `xml:"link"`
+	Content       wxrCDATA      `xml:"content:encoded"`
+	Excerpt       wxrCDATA      `xml:"excerpt:encoded"`
+	PostName      string        `xml:"wp:post_name"`
+	Status        string        `xml:"wp:status"`
+	PostType      string        `xml:"wp:post_type"`
+	AttachmentURL string        `xml:"wp:attachment_url"`
+	Categories    []wxrCategory `xml:"category"`
+	Meta          []wxrMeta     `xml:"wp:postmeta"`
+}
+
+type wxrCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+type wxrCategory struct {
+	Domain   string `xml:"domain,attr"`
+	Nicename string `xml:"nicename,attr"`
+	Text     string `xml:",cdata"`
+}
+
+type wxrMeta struct {
+	Key   string   `xml:"wp:meta_key"`
+	Value wxrCDATA `xml:"wp:meta_value"`
+}
+
+// wordpressMedia renders the records as a WXR file of media attachments,
+// with the description as both the alt text and the caption
+func wordpressMedia(records []record) ([]byte, error) {
+	doc := wxr{
+		Version: "2.0",
+		Excerpt: "http://wordpress.org/export/1.2/excerpt/",
+		Content: "http://purl.org/rss/1.0/modules/content/",
+		WP:      "http://wordpress.org/export/1.2/",
+		Channel: wxrChannel{Title: "drivetogcs", WXRVersion: "1.2"},
+	}
+	for _, r := range records {
+		item := wxrItem{
+			Title:         assetTitle(r),
+			Link:          recordLink(r),
+			Content:       wxrCDATA{r.Description},
+			Excerpt:       wxrCDATA{r.Description},
+			PostName:      slug(assetTitle(r)),
+			Status:        "inherit",
+			PostType:      "attachment",
+			AttachmentURL: recordLink(r),
+			Meta:          []wxrMeta{{Key: "_wp_attachment_image_alt", Value: wxrCDATA{r.Description}}},
+		}
+		for _, tag := range r.Tags {
+			item.Categories = append(item.Categories, wxrCategory{Domain: "post_tag", Nicename: slug(tag), Text: tag})
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// slugUnsafe matches the runs of characters that aren't allowed in slugs
+var slugUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug returns the lowercase, hyphenated form of a name used in URLs and IDs
+func slug(s string) string {
+	return strings.Trim(slugUnsafe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// contentfulLink links an entity to another by ID
+type contentfulLink struct {
+	Sys struct {
+		Type     string `json:"type"`
+		LinkType string `json:"linkType"`
+		ID       string `json:"id"`
+	} `json:"sys"`
+}
+
+// contentfulUnsafe matches the runs of characters that aren't allowed in
+// Contentful IDs
+var contentfulUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// contentfulID returns a Contentful entity ID, up to 64 letters, digits,
+// hyphens, underscores and periods
+func contentfulID(s string) string {
+	id := contentfulUnsafe.ReplaceAllString(s, "-")
+	if len(id) > 64 {
+		id = id[:64]
+	}
+	return id
+}
+
+// contentfulAssets renders the records as a contentful-import JSON file of
+// assets, with the description as the asset description and the tags as
+// Contentful tags
+func contentfulAssets(records []record) ([]byte, error) {
+	localized := func(v any) map[string]any { return map[string]any{contentfulLocale: v} }
+	var assets, tags []map[string]any
+	seen := map[string]bool{}
+	for _, r := range records {
+		links := []contentfulLink{}
+		for _, tag := range r.Tags {
+			id := contentfulID(slug(tag))
+			var link contentfulLink
+			link.Sys.Type, link.Sys.LinkType, link.Sys.ID = "Link", "Tag", id
+			links = append(links, link)
+			if !seen[id] {
+				seen[id] = true
+				tags = append(tags, map[string]any{
+					"sys":  map[string]any{"id": id, "type": "Tag", "visibility": "public"},
+					"name": tag,
+				})
+			}
+		}
+		assets = append(assets, map[string]any{
+			"sys":      map[string]any{"id": contentfulID(r.ID), "type": "Asset"},
+			"metadata": map[string]any{"tags": links},
+			"fields": map[string]any{
+				"title":       localized(assetTitle(r)),
+				"description": localized(r.Description),
+				"file": localized(map[string]any{
+					"upload":      recordLink(r),
+					"fileName":    r.Name,
+					"contentType": r.MimeType,
+				}),
+			},
+		})
+	}
+	if assets == nil {
+		assets = []map[string]any{}
+	}
+	if tags == nil {
+		tags = []map[string]any{}
+	}
+	data, err := json.MarshalIndent(map[string]any{"assets": assets, "tags": tags}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDAMRecords() []record {
+	return []record{
+		{Name: "Beach Day.jpg", MimeType: "image/jpeg", ID: "1", Bucket: "test-bucket", ObjectPath: "shoots/Beach Day.jpg", Description: "Waves at <dusk> & gulls", Tags: []string{"beach", "Sunset Sky"}},
+		{Name: "b.png", MimeType: "image/png", ID: "2", Bucket: "test-bucket", ObjectPath: "b.png", Description: "A chart", Tags: []string{"beach"}},
+		{Name: "c.png", MimeType: "image/png", ID: "3", Bucket: "test-bucket", ObjectPath: "needs-review/c.png", Description: "Error: blocked", NeedsReview: true},
+		{Name: "d.png", MimeType: "image/png", ID: "4", Description: "not uploaded"},
+	}
+}
+
+func writeDAM(t *testing.T, format string) string {
+	t.Helper()
+	prevFile, prevPublic := damFile, makePublic
+	t.Cleanup(func() { damFile, makePublic = prevFile, prevPublic })
+	damFile, makePublic = filepath.Join(t.TempDir(), "import"), true
+	w, err := newRecordWriter(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range testDAMRecords() {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(damFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAEMMetadata(t *testing.T) {
+	rows := strings.Split(strings.TrimSpace(writeDAM(t, formatAEM)), "\n")
+	if len(rows) != 3 {
+		t.Fatalf("AEM import has %d rows, want header and 2 uploaded assets:\n%s", len(rows), strings.Join(rows, "\n"))
+	}
+	if !strings.HasPrefix(rows[0], "assetPath,dc:title{{String}}") {
+		t.Errorf("header = %s", rows[0])
+	}
+	if want := "/content/dam/shoots/Beach Day.jpg,Beach Day,Waves at <dusk> & gulls,image/jpeg,gs://test-bucket/shoots/Beach Day.jpg,beach|Sunset Sky"; rows[1] != want {
+		t.Errorf("row = %s, want %s", rows[1], want)
+	}
+}
+
+func TestWordPressMedia(t *testing.T) {
+	doc := writeDAM(t, formatWordPress)
+	for _, want := range []string{
+		`<wp:post_type>attachment</wp:post_type>`,
+		`<wp:attachment_url>https://storage.googleapis.com/test-bucket/shoots/Beach%20Day.jpg</wp:attachment_url>`,
+		`<wp:post_name>beach-day</wp:post_name>`,
+		`<wp:meta_key>_wp_attachment_image_alt</wp:meta_key>`,
+		`<![CDATA[Waves at <dusk> & gulls]]>`,
+		`<category domain="post_tag" nicename="sunset-sky"><![CDATA[Sunset Sky]]></category>`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("WXR missing %s:\n%s", want, doc)
+		}
+	}
+	if n := strings.Count(doc, "<item>"); n != 2 {
+		t.Errorf("WXR has %d items, want 2", n)
+	}
+}
+
+func TestContentfulAssets(t *testing.T) {
+	var doc struct {
+		Assets []struct {
+			Sys struct {
+				ID string `json:"id"`
+			} `json:"sys"`
+			Metadata struct {
+				Tags []contentfulLink `json:"tags"`
+			} `json:"metadata"`
+			Fields struct {
+				Title       map[string]string `json:"title"`
+				Description map[string]string `json:"description"`
+				File        map[string]struct {
+					Upload      string `json:"upload"`
+					ContentType string `json:"contentType"`
+				} `json:"file"`
+			} `json:"fields"`
+		} `json:"assets"`
+		Tags []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(writeDAM(t, formatContentful)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Assets) != 2 || len(doc.Tags) != 2 {
+		t.Fatalf("contentful import has %d assets and %d tags, want 2 and 2", len(doc.Assets), len(doc.Tags))
+	}
+	a := doc.Assets[0]
+	if a.Sys.ID != "1" || a.Fields.Title["en-US"] != "Beach Day" || a.Fields.Description["en-US"] != "Waves at <dusk> & gulls" {
+		t.Errorf("asset = %+v", a)
+	}
+	if f := a.Fields.File["en-US"]; f.Upload != "https://storage.googleapis.com/test-bucket/shoots/Beach%20Day.jpg" || f.ContentType != "image/jpeg" {
+		t.Errorf("file = %+v", f)
+	}
+	if len(a.Metadata.Tags) != 2 || a.Metadata.Tags[1].Sys.ID != "sunset-sky" {
+		t.Errorf("tags = %+v", a.Metadata.Tags)
+	}
+	if got := contentfulID("1abc/DCIM/IMG 1.jpg"); got != "1abc-DCIM-IMG-1.jpg" {
+		t.Errorf("contentfulID = %q", got)
+	}
+}
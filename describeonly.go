@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// describeOnly describes the Drive files and writes only the catalog,
+// without Cloud Storage: an inventory of a Drive folder with AI descriptions,
+// rather than a migration
+var describeOnly bool
+
+// checkDescribeOnlyFlags returns an error if a flag writing to or reading
+// from Cloud Storage is set with -describe-only, which has no bucket
+func checkDescribeOnlyFlags() error {
+	if !describeOnly {
+		return nil
+	}
+	if !createDescription {
+		return errors.New("-describe-only with -describe=false has nothing to do")
+	}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{gcsBucket != "", "-gcs-bucket"},
+		{alwaysUploadToGCS, "-always-upload"},
+		{failOnChangedContent, "-fail-on-changed-content"},
+		{makePublic, "-public"},
+		{migrateRevisions, "-revisions"},
+		{signedURLTTL > 0, "-signed-url-ttl"},
+		{verifySample > 0, "-verify-sample"},
+		{replicaBucketsList != "", "-replica-buckets"},
+		{transferOverFlag != "", "-transfer-over"},
+		{writeSidecars, "-sidecar"},
+		{useLock, "-lock"},
+		{describeFromGCS, "-describe-from-gcs"},
+		{redescribeIfPromptChanged, "-redescribe-if-prompt-changed"},
+		{piiAction == piiRestrict, "-pii restrict"},
+		{strings.HasPrefix(auditLogPath, "gs://"), "a gs:// -audit-log"},
+	} {
+		if f.set {
+			return fmt.Errorf("-describe-only doesn't use Cloud Storage, so it can't be combined with %s", f.name)
+		}
+	}
+	return nil
+}
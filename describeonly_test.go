@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestCheckDescribeOnlyFlags(t *testing.T) {
+	defer func(only, sidecars bool) { describeOnly, writeSidecars = only, sidecars }(describeOnly, writeSidecars)
+	useFakes(t)
+	gcsBucket = ""
+
+	describeOnly = true
+	if err := checkDescribeOnlyFlags(); err != nil {
+		t.Errorf("checkDescribeOnlyFlags = %v", err)
+	}
+	writeSidecars = true
+	if err := checkDescribeOnlyFlags(); err == nil || !strings.Contains(err.Error(), "-sidecar") {
+		t.Errorf("checkDescribeOnlyFlags with -sidecar = %v, want an error", err)
+	}
+	writeSidecars = false
+	createDescription = false
+	if err := checkDescribeOnlyFlags(); err == nil {
+		t.Error("checkDescribeOnlyFlags accepted -describe=false")
+	}
+}
+
+func TestDescribeOnly(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev bool) { describeOnly = prev }(describeOnly)
+	describeOnly = true
+	storageSrv = nil // any use of Cloud Storage panics
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("jpeg"))
+
+	files := make(chan drive.File, 1)
+	file, _ := f.drive.Get(context.Background(), "a", "")
+	files <- *file
+	close(files)
+	output := &markdownRecordWriter{}
+	processFiles(context.Background(), files, output)
+
+	if len(output.records) != 1 {
+		t.Fatalf("records = %d", len(output.records))
+	}
+	r := output.records[0]
+	if r.Description != "A test description." {
+		t.Errorf("description = %q", r.Description)
+	}
+	if r.Bucket != "" || r.ObjectPath != "" || r.gcsURI() != "" {
+		t.Errorf("record has an object: bucket %q, path %q, uri %q", r.Bucket, r.ObjectPath, r.gcsURI())
+	}
+}
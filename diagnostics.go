@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// debugAddr is the address to serve /debug/pprof and /healthz on during a
+// run, such as localhost:6060, empty to not serve them
+var debugAddr string
+
+// cpuProfile and memProfile are paths to write a CPU profile of the run and
+// a heap profile at its end to, empty to not profile
+var cpuProfile, memProfile string
+
+// health is the /healthz response
+type health struct {
+	Status         string  `json:"status"`
+	RunID          string  `json:"run_id"`
+	Files          int     `json:"files"`
+	Bytes          int64   `json:"bytes"`
+	Goroutines     int     `json:"goroutines"`
+	HeapBytes      uint64  `json:"heap_bytes"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// debugHandler serves the pprof profiles under /debug/pprof and the run's
+// progress and memory use as JSON on /healthz
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		s := stats.summary()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health{
+			Status:         "ok",
+			RunID:          runID,
+			Files:          s.Files,
+			Bytes:          s.Bytes,
+			Goroutines:     runtime.NumGoroutine(),
+			HeapBytes:      mem.HeapAlloc,
+			ElapsedSeconds: s.ElapsedSeconds,
+		})
+	})
+	return mux
+}
+
+// serveDebug serves debugHandler on debugAddr for the rest of the process,
+// which as the profiles aren't authenticated, and the command line they
+// include names the run's files and bucket, must be a unix socket or on
+// localhost, as -control is
+func serveDebug() error {
+	ln, err := localListener(debugAddr, "-debug-addr")
+	if err != nil {
+		return fmt.Errorf("unable to serve diagnostics: %v", err)
+	}
+	log.Printf("serving /debug/pprof and /healthz on %s", debugAddr)
+	srv := &http.Server{Handler: debugHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	return nil
+}
+
+// startProfiling starts the CPU profile, returning a function that stops it
+// and writes the heap profile
+func startProfiling() (func(), error) {
+	var cpu *os.File
+	if cpuProfile != "" {
+		var err error
+		if cpu, err = createFile(cpuProfile); err != nil {
+			return nil, fmt.Errorf("unable to create CPU profile: %v", err)
+		}
+		if err := rpprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, fmt.Errorf("unable to start CPU profile: %v", err)
+		}
+	}
+	return func() {
+		if cpu != nil {
+			rpprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				log.Printf("unable to write CPU profile: %v", err)
+			}
+		}
+		if memProfile != "" {
+			if err := writeHeapProfile(memProfile); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+	}, nil
+}
+
+// writeHeapProfile writes the heap profile, after a garbage collection so it
+// shows the memory in use
+func writeHeapProfile(path string) error {
+	f, err := createFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to create heap profile: %v", err)
+	}
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write heap profile: %v", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	useFakes(t)
+	srv := httptest.NewServer(debugHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var h health
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Status != "ok" || h.Goroutines == 0 || h.HeapBytes == 0 {
+		t.Errorf("healthz = %+v", h)
+	}
+
+	resp, err = http.Get(srv.URL + "/debug/pprof/heap?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("heap profile status = %d", resp.StatusCode)
+	}
+}
+
+func TestProfiling(t *testing.T) {
+	prevCPU, prevMem := cpuProfile, memProfile
+	t.Cleanup(func() { cpuProfile, memProfile = prevCPU, prevMem })
+	dir := t.TempDir()
+	cpuProfile, memProfile = filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+
+	stop, err := startProfiling()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	for _, path := range []string{cpuProfile, memProfile} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("%s not written: %v", path, err)
+		}
+	}
+}
+
+func TestServeDebugOnlyLocal(t *testing.T) {
+	defer func(prev string) { debugAddr = prev }(debugAddr)
+	for _, addr := range []string{":6060", "0.0.0.0:6060", "example.com:6060"} {
+		debugAddr = addr
+		if err := serveDebug(); err == nil {
+			t.Errorf("serveDebug(%s) = nil, want error", addr)
+		}
+	}
+	debugAddr = "unix:" + filepath.Join(t.TempDir(), "debug.sock")
+	if err := serveDebug(); err != nil {
+		t.Errorf("serveDebug(%s) = %v", debugAddr, err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/fatih/color"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/genai"
+)
+
+// doctorPermissions are the bucket permissions a run needs
+var doctorPermissions = []string{
+	"storage.objects.create",
+	"storage.objects.get",
+	"storage.objects.delete", // to overwrite objects with -always-upload
+}
+
+// doctor runs preflight checks, printing a diagnostic for each
+type doctor struct {
+	out      io.Writer
+	failures int
+	quota    bool
+}
+
+// pass prints a passing check
+func (d *doctor) pass(check, format string, args ...any) {
+	fmt.Fprintf(d.out, "%s %s: %s\n", color.GreenString("✓"), check, fmt.Sprintf(format, args...))
+}
+
+// warn prints a check that could not be completed, but doesn't fail
+func (d *doctor) warn(check, format string, args ...any) {
+	fmt.Fprintf(d.out, "%s %s: %s\n", color.YellowString("!"), check, fmt.Sprintf(format, args...))
+}
+
+// fail prints a failed check, the error and a hint to fix it
+func (d *doctor) fail(check string, err error, hint string) {
+	d.failures++
+	if classifyError(err) == errorClassQuota {
+		d.quota = true
+	}
+	fmt.Fprintf(d.out, "%s %s: %v\n", color.RedString("✗"), check, err)
+	if hint != "" {
+		fmt.Fprintf(d.out, "  %s %s\n", color.CyanString("→"), hint)
+	}
+}
+
+// runDoctor validates the environment, credentials, Drive folder, bucket and
+// Gemini access, returning the process exit code
+func runDoctor(ctx context.Context) int {
+	d := &doctor{out: os.Stdout}
+
+	credentials := driveCredentialsFile()
+	switch {
+	case projectID == "" && (backend == backendVertex && describesFiles() || gcsBucket == "" && !describeOnly || transferOver > 0):
+		d.fail("environment", errors.New("no project: neither -project nor PROJECT_ID is set"), "export PROJECT_ID=$(gcloud config get-value core/project)")
+	case backend == backendGeminiAPI && describesFiles() && geminiAPIKey() == "":
+		d.fail("environment", errors.New("GEMINI_API_KEY is not set"), "create a Gemini Developer API key at https://aistudio.google.com/apikey")
+	case publicDrive && driveAPIKey() == "":
+		d.fail("environment", errors.New("DRIVE_API_KEY is not set"), "create an API key restricted to the Drive API on the Cloud console Credentials page")
+	case credentials == "" && !publicDrive:
+		d.fail("environment", errors.New("GOOGLE_CREDENTIALS is not set"), "export GOOGLE_CREDENTIALS to the path of the OAuth2 client credentials JSON")
+	case !describesFiles():
+		d.pass("environment", "bucket %s, transfer only", cmp.Or(gcsBucket, projectID+"-media"))
+	case backend == backendGeminiAPI:
+		d.pass("environment", "bucket %s, Gemini Developer API", cmp.Or(gcsBucket, projectID+"-media"))
+	default:
+		d.pass("environment", "project %s, location %s", projectID, strings.Join(locations, ", "))
+	}
+	if d.failures > 0 {
+		return exitFailure
+	}
+	if gcsBucket == "" {
+		gcsBucket = fmt.Sprintf("%s-media", projectID)
+	}
+
+	// Drive
+	if publicDrive {
+		srv, err := newPublicDrive(ctx)
+		if err != nil {
+			d.fail("drive", err, "")
+		} else {
+			d.pass("credentials", "API key, public files only")
+			driveSrv = srv
+			d.checkFolder(ctx)
+		}
+	} else {
+		b, err := os.ReadFile(credentials)
+		if err != nil {
+			d.fail("credentials", err, "check the GOOGLE_CREDENTIALS or -drive-credentials path")
+			return exitFailure
+		}
+		client, identity, err := driveHTTPClient(ctx, b)
+		if err != nil {
+			d.fail("credentials", err, "download the JSON of an OAuth client ID of type Desktop app from the Cloud console Credentials page")
+			return exitFailure
+		}
+		d.pass("credentials", "%s", identity)
+		srv, err := drive.NewService(ctx, append(driveOptions(), option.WithHTTPClient(client))...)
+		if err != nil {
+			d.fail("drive", err, "")
+		} else {
+			driveSrv = &driveService{srv: srv}
+			if err := checkAccount(ctx); err != nil {
+				d.fail("drive", err, "")
+			}
+			d.checkFolder(ctx)
+		}
+	}
+
+	// Cloud Storage
+	if describeOnly {
+		d.pass("bucket", "not used with -describe-only")
+	} else {
+		opts, err := storageOptions(ctx)
+		if err != nil {
+			d.fail("bucket", err, "check the -storage-credentials path")
+			return exitFailure
+		}
+		gcsClient, err := storage.NewClient(ctx, opts...)
+		if err != nil {
+			d.fail("bucket", err, "run gcloud auth application-default login")
+		} else {
+			defer gcsClient.Close()
+			storageSrv = &gcsStorage{client: gcsClient}
+			d.checkBucket(ctx)
+		}
+	}
+
+	// Gemini
+	if describesFiles() {
+		if err := loadVertexCredentials(ctx); err != nil {
+			d.fail("gemini", err, "check the -vertex-credentials path")
+			return exitFailure
+		}
+		gc, err := createGenaiClient(ctx)
+		if err != nil {
+			d.fail("gemini", err, "run gcloud auth application-default login")
+		} else {
+			genaiClient = gc
+			d.checkModel(ctx)
+		}
+	}
+
+	d.checkQuota()
+	if d.failures > 0 {
+		fmt.Fprintf(d.out, "%d checks failed\n", d.failures)
+		return exitFailure
+	}
+	fmt.Fprintln(d.out, "ready to run")
+	return exitSuccess
+}
+
+// checkFolder checks the source Drive folder can be listed
+func (d *doctor) checkFolder(ctx context.Context) {
+	if sourceFolderID == "" {
+		d.warn("drive", "no -folder given, skipping the folder check")
+		return
+	}
+	folder, err := driveSrv.Get(ctx, sourceFolderID, "id, name, mimeType")
+	if err != nil {
+		d.fail("drive", err, fmt.Sprintf("check the folder ID %s and that it is shared with the account you authenticated as", sourceFolderID))
+		return
+	}
+	if folder.MimeType != folderMimeType {
+		d.fail("drive", fmt.Errorf("%s (%s) is a %s, not a folder", folder.Name, sourceFolderID, folder.MimeType), "use the ID at the end of the folder's URL, drive.google.com/drive/folders/<id>")
+		return
+	}
+	files, err := listFiles(ctx, sourceFolderID, mimeTypes)
+	if err != nil {
+		d.fail("drive", err, "check you have at least Viewer access to the folder")
+		return
+	}
+	d.pass("drive", "folder %q has %d files of types %s", folder.Name, len(files), strings.Join(mimeTypes, ","))
+}
+
+// checkBucket checks the destination bucket exists and can be written
+func (d *doctor) checkBucket(ctx context.Context) {
+	attrs, err := storageSrv.BucketAttrs(ctx, gcsBucket)
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		d.fail("bucket", fmt.Errorf("gs://%s does not exist", gcsBucket), fmt.Sprintf("gcloud storage buckets create gs://%s --location %s", gcsBucket, location))
+		return
+	}
+	if err != nil {
+		d.fail("bucket", err, fmt.Sprintf("grant your account roles/storage.objectAdmin on gs://%s", gcsBucket))
+		return
+	}
+	granted, err := storageSrv.TestPermissions(ctx, gcsBucket, doctorPermissions)
+	if err != nil {
+		d.fail("bucket", err, "")
+		return
+	}
+	var missing []string
+	for _, p := range doctorPermissions {
+		if !slices.Contains(granted, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		d.fail("bucket", fmt.Errorf("missing %s on gs://%s", strings.Join(missing, ", "), gcsBucket), fmt.Sprintf("grant your account roles/storage.objectAdmin on gs://%s", gcsBucket))
+		return
+	}
+	d.pass("bucket", "gs://%s in %s is writable", gcsBucket, attrs.Location)
+}
+
+// checkModel checks the Vertex AI API is enabled by generating a short response
+func (d *doctor) checkModel(ctx context.Context) {
+	maxTokens := int32(8)
+	region := location
+	if backend == backendGeminiAPI {
+		region = "the Gemini Developer API"
+	}
+	_, err := genaiClient.GenerateContent(withServedRegion(ctx, &region), model, genai.Text("Reply with OK."), &genai.GenerateContentConfig{MaxOutputTokens: &maxTokens})
+	if err != nil {
+		hint := fmt.Sprintf("gcloud services enable aiplatform.googleapis.com --project %s", projectID)
+		if classifyError(err) == errorClassQuota {
+			hint = "wait for the quota to reset, or request a quota increase"
+		}
+		d.fail("gemini", err, hint)
+		return
+	}
+	d.pass("gemini", "%s responds in %s", model, region)
+}
+
+// checkQuota reports whether any check ran into exhausted quota; remaining
+// headroom is not reported by the APIs themselves
+func (d *doctor) checkQuota() {
+	if d.quota {
+		d.fail("quota", errors.New("a quota is exhausted"), "see the failed checks above, and the IAM & Admin Quotas page of the Cloud console")
+		return
+	}
+	d.pass("quota", "no quota errors")
+}
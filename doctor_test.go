@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestDoctorChecks(t *testing.T) {
+	f := useFakes(t)
+	f.drive.add("root", "Media", folderMimeType, "", nil)
+	f.drive.add("a", "a.png", "image/png", "root", []byte("a"))
+	f.drive.add("file", "notes.png", "image/png", "root", []byte("n"))
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	d := &doctor{out: &out}
+	sourceFolderID = "root"
+	d.checkFolder(ctx)
+	d.checkBucket(ctx)
+	d.checkModel(ctx)
+	d.checkQuota()
+	if d.failures != 0 {
+		t.Fatalf("failures = %d, want 0:\n%s", d.failures, out.String())
+	}
+	if !strings.Contains(out.String(), `folder "Media" has 2 files`) {
+		t.Errorf("output = %q, want the folder file count", out.String())
+	}
+
+	out.Reset()
+	d = &doctor{out: &out}
+	sourceFolderID = "file"
+	d.checkFolder(ctx)
+	gcsBucket = "missing"
+	d.checkBucket(ctx)
+	if d.failures != 2 {
+		t.Fatalf("failures = %d, want 2:\n%s", d.failures, out.String())
+	}
+	for _, want := range []string{"not a folder", "gs://missing does not exist", "gcloud storage buckets create gs://missing"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output = %q, want %q", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	d = &doctor{out: &out}
+	gcsBucket = "test-bucket"
+	f.storage.permissions = []string{"storage.objects.get"}
+	d.checkBucket(ctx)
+	if !strings.Contains(out.String(), "missing storage.objects.create, storage.objects.delete") {
+		t.Errorf("output = %q, want the missing permissions", out.String())
+	}
+
+	out.Reset()
+	d = &doctor{out: &out}
+	f.generator.err = &googleapi.Error{Code: 429}
+	d.checkModel(ctx)
+	d.checkQuota()
+	if d.failures != 2 || !strings.Contains(out.String(), "a quota is exhausted") {
+		t.Errorf("failures = %d, want the model and quota checks to fail:\n%s", d.failures, out.String())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+// overwriteEdits regenerates descriptions that were edited since they were
+// generated, instead of keeping the edits
+var overwriteEdits bool
+
+// descriptionEdit is a description edited since it was generated
+type descriptionEdit struct {
+	Description string
+	EditedAt    string
+}
+
+// editedDescriptions holds the edits kept for this run's files, by Drive file ID
+var editedDescriptions sync.Map
+
+// editedDescription returns a file's description if it was edited since it
+// was generated: in its sidecar, by review import, or as the Drive file's
+// description. Edits can only be told apart from generated descriptions with
+// -sidecar.
+func editedDescription(ctx context.Context, file drive.File) (descriptionEdit, bool, error) {
+	name, err := objectName(file)
+	if err != nil {
+		return descriptionEdit{}, false, nil
+	}
+	dest := routeFor(file)
+	sidecarObject := sidecarPath(objectPath(dest.Prefix, contentName(file, name)))
+	b, err := storageSrv.Read(ctx, dest.Bucket, sidecarObject)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return descriptionEdit{}, false, nil // never described
+	}
+	if err != nil {
+		return descriptionEdit{}, false, fmt.Errorf("unable to read sidecar: %w", err)
+	}
+	var sidecar record
+	if err := json.Unmarshal(b, &sidecar); err != nil {
+		return descriptionEdit{}, false, fmt.Errorf("unable to parse sidecar %s: %v", sidecarObject, err)
+	}
+	if sidecar.NeedsReview || sidecar.DescribedAt == "" && sidecar.EditedAt == "" {
+		return descriptionEdit{}, false, nil // no description to keep
+	}
+
+	f, err := driveSrv.Get(ctx, file.Id, "description")
+	if err != nil {
+		return descriptionEdit{}, false, fmt.Errorf("unable to get Drive description: %w", err)
+	}
+	if f.Description != "" && f.Description != sidecar.Description {
+		return descriptionEdit{Description: f.Description, EditedAt: time.Now().UTC().Format(time.RFC3339)}, true, nil
+	}
+	if sidecar.EditedAt != "" {
+		return descriptionEdit{Description: sidecar.Description, EditedAt: sidecar.EditedAt}, true, nil
+	}
+	return descriptionEdit{}, false, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEditedDescriptionsKept(t *testing.T) {
+	f := useFakes(t)
+	prev := writeSidecars
+	writeSidecars = true
+	defer func() { writeSidecars = prev }()
+	f.drive.add("a", "a.png", "image/png", "root", []byte("a"))
+	ctx := context.Background()
+
+	f.generator.response = "Generated."
+	processFile(ctx, *f.drive.files["a"], "a.png")
+
+	// a description edited in Drive is kept instead of regenerated
+	f.drive.files["a"].Description = "Edited in Drive."
+	f.generator.response = "Regenerated."
+	calls := f.generator.calls
+	r := processFile(ctx, *f.drive.files["a"], "a.png")
+	if r.Description != "Edited in Drive." || r.EditedAt == "" || r.Model != "" {
+		t.Errorf("record = %+v, want the Drive edit kept", r)
+	}
+	if f.generator.calls != calls {
+		t.Errorf("Gemini called %d times, want the edited description not regenerated", f.generator.calls-calls)
+	}
+	if len(r.DescriptionVersions) != 1 || r.DescriptionVersions[0].Description != "Generated." {
+		t.Errorf("versions = %+v, want the generated description", r.DescriptionVersions)
+	}
+
+	// the sidecar keeps the edit on later runs
+	editedAt := r.EditedAt
+	editedDescriptions.Clear()
+	r = processFile(ctx, *f.drive.files["a"], "a.png")
+	if r.Description != "Edited in Drive." || r.EditedAt != editedAt || len(r.DescriptionVersions) != 1 {
+		t.Errorf("rerun record = %+v, want the edit from %s kept once", r, editedAt)
+	}
+
+	// -overwrite-edits regenerates, keeping the edit as a prior version
+	overwriteEdits = true
+	defer func() { overwriteEdits = false }()
+	editedDescriptions.Clear()
+	r = processFile(ctx, *f.drive.files["a"], "a.png")
+	if r.Description != "Regenerated." || r.EditedAt != "" || len(r.DescriptionVersions) != 2 || r.DescriptionVersions[1].EditedAt != editedAt {
+		t.Errorf("overwritten record = %+v, want Regenerated. with the edit as a prior version", r)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// emailTo is a comma-separated list of addresses to email the run summary to
+var emailTo string
+var emailFrom string = "drivetogcs@localhost"
+
+// smtpServer is the host:port of the SMTP server; without it, mail is sent
+// with the SendGrid API
+var smtpServer string
+
+// sendGridURL is the SendGrid v3 mail send endpoint
+var sendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// maxEmailFailures limits the failed files listed in the email
+const maxEmailFailures = 100
+
+// emailStatus emails the run status to emailTo, with SMTP if smtpServer is
+// set, or the SendGrid API otherwise; credentials are read from the
+// SMTP_USERNAME and SMTP_PASSWORD or SENDGRID_API_KEY environment variables
+func emailStatus(status runStatus) error {
+	to := strings.Split(emailTo, ",")
+	for i := range to {
+		to[i] = strings.TrimSpace(to[i])
+	}
+	subject := fmt.Sprintf("drivetogcs run %s: %s", status.RunID, status.Status)
+	body := emailBody(status)
+
+	if smtpServer != "" {
+		return sendSMTP(smtpServer, emailFrom, to, subject, body)
+	}
+	apiKey := os.Getenv("SENDGRID_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("provide -smtp-server or the SENDGRID_API_KEY environment variable to send email")
+	}
+	return sendSendGrid(apiKey, emailFrom, to, subject, body)
+}
+
+// emailBody renders the run status as a plain text email
+func emailBody(status runStatus) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Run %s finished with status %s (exit code %d).\n", status.RunID, status.Status, status.ExitCode)
+	if status.Message != "" {
+		fmt.Fprintf(&sb, "\n%s\n", status.Message)
+	}
+	s := status.Summary
+	if s == nil {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "\nFiles: %d\nBytes: %d\nElapsed: %.1fs\nGemini tokens: %d\n", s.Files, s.Bytes, s.ElapsedSeconds, s.TotalTokens)
+	if len(s.Failures) > 0 {
+		fmt.Fprintf(&sb, "\nFailures:\n")
+		for _, category := range sortedKeys(s.Failures) {
+			fmt.Fprintf(&sb, "  %s: %d\n", category, s.Failures[category])
+		}
+	}
+	if len(s.Skipped) > 0 {
+		fmt.Fprintf(&sb, "\nSkipped:\n")
+		for _, reason := range sortedKeys(s.Skipped) {
+			fmt.Fprintf(&sb, "  %s: %d\n", reason, s.Skipped[reason])
+		}
+	}
+	if len(s.FailedFiles) > 0 {
+		fmt.Fprintf(&sb, "\nFailed files:\n")
+		for i, f := range s.FailedFiles {
+			if i == maxEmailFailures {
+				fmt.Fprintf(&sb, "  ... and %d more, see %s\n", len(s.FailedFiles)-i, summaryFile)
+				break
+			}
+			stage := f.Stage
+			if stage != "" {
+				stage += ": "
+			}
+			fmt.Fprintf(&sb, "  %s (%s): %s%s\n", f.Name, f.DriveID, stage, f.Error)
+		}
+	}
+	return sb.String()
+}
+
+// sendSMTP sends a plain text email with SMTP, authenticating if SMTP_USERNAME is set
+func sendSMTP(server, from string, to []string, subject, body string) error {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return fmt.Errorf("invalid -smtp-server %q: %v", server, err)
+	}
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(server, auth, from, to, msg.Bytes())
+}
+
+// sendSendGrid sends a plain text email with the SendGrid v3 API
+func sendSendGrid(apiKey, from string, to []string, subject, body string) error {
+	type address struct {
+		Email string `json:"email"`
+	}
+	recipients := make([]address, len(to))
+	for i, addr := range to {
+		recipients[i] = address{Email: addr}
+	}
+	payload := map[string]any{
+		"personalizations": []map[string]any{{"to": recipients}},
+		"from":             address{Email: from},
+		"subject":          subject,
+		"content":          []map[string]string{{"type": "text/plain", "value": body}},
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal email: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, sendGridURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("SendGrid returned HTTP status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmailBody(t *testing.T) {
+	body := emailBody(runStatus{RunID: "r1", Status: "partial", ExitCode: exitPartial, Summary: &runSummary{
+		Files:       2,
+		Failures:    map[string]int{stageDescribe: 1},
+		FailedFiles: []fileFailure{{DriveID: "1", Name: "a.jpg", Stage: stageDescribe, Error: "model unavailable"}},
+	}})
+	for _, want := range []string{"Run r1 finished with status partial", "Files: 2", "  describe: 1", "  a.jpg (1): describe: model unavailable"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("email body missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestSendGrid(t *testing.T) {
+	var auth string
+	var payload struct {
+		Subject          string
+		Personalizations []struct{ To []struct{ Email string } }
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	prev := sendGridURL
+	sendGridURL = srv.URL
+	defer func() { sendGridURL = prev }()
+
+	if err := sendSendGrid("key", "from@example.com", []string{"a@example.com", "b@example.com"}, "subject", "body"); err != nil {
+		t.Fatalf("sendSendGrid() error = %v", err)
+	}
+	if auth != "Bearer key" || payload.Subject != "subject" || len(payload.Personalizations[0].To) != 2 {
+		t.Errorf("SendGrid request auth = %q, payload = %+v", auth, payload)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// embedMetadata embeds the description and tags into the XMP, and IPTC for
+// JPEGs, of the uploaded copies of JPEG and PNG images, so they travel with
+// the images when downloaded and shared
+var embedMetadata bool
+
+// stageEmbed is the stage of embedding metadata into images
+const stageEmbed = "embed"
+
+// markers of the JPEG segments holding embedded metadata
+const (
+	jpegSOI   = 0xd8
+	jpegSOS   = 0xda
+	jpegEOI   = 0xd9
+	jpegAPP0  = 0xe0
+	jpegAPP1  = 0xe1
+	jpegAPP13 = 0xed
+)
+
+// headers identifying the metadata in JPEG APP1 and APP13 segments
+const (
+	xmpHeader       = "http://ns.adobe.com/xap/1.0/\x00"
+	exifHeader      = "Exif\x00\x00"
+	photoshopHeader = "Photoshop 3.0\x00"
+	xmpKeyword      = "XML:com.adobe.xmp"
+)
+
+// IPTC limits of the Caption-Abstract and Keywords datasets, in bytes
+const (
+	maxIPTCCaption = 2000
+	maxIPTCKeyword = 64
+)
+
+// iptcResourceID is the Photoshop image resource holding IPTC-IIM datasets
+const iptcResourceID = 0x0404
+
+// embeds reports whether metadata is embedded into a file of a MIME type
+func embeds(mimeType string) bool {
+	return embedMetadata && (mimeType == "image/jpeg" || mimeType == "image/png")
+}
+
+// embedDescription embeds a description and keywords into a JPEG or PNG,
+// replacing any XMP packet and IPTC record they have, and keeping the
+// image data and other metadata as is
+func embedDescription(data []byte, mimeType, description string, keywords []string) ([]byte, error) {
+	packet, err := xmpPacket(description, keywords)
+	if err != nil {
+		return nil, err
+	}
+	switch mimeType {
+	case "image/jpeg":
+		return embedJPEG(data, packet, iptcRecord(description, keywords))
+	case "image/png":
+		return embedPNG(data, packet)
+	}
+	return nil, fmt.Errorf("unable to embed metadata into %s", mimeType)
+}
+
+// xmpPacket returns an XMP packet with the description as dc:description and
+// the IPTC alt text, and the keywords as dc:subject
+func xmpPacket(description string, keywords []string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	buf.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/">`)
+	alt := func(element string) {
+		buf.WriteString("<" + element + `><rdf:Alt><rdf:li xml:lang="x-default">`)
+		xml.EscapeText(&buf, []byte(description))
+		buf.WriteString("</rdf:li></rdf:Alt></" + element + ">")
+	}
+	alt("dc:description")
+	alt("Iptc4xmpCore:AltTextAccessibility")
+	if len(keywords) > 0 {
+		buf.WriteString("<dc:subject><rdf:Bag>")
+		for _, k := range keywords {
+			buf.WriteString("<rdf:li>")
+			xml.EscapeText(&buf, []byte(k))
+			buf.WriteString("</rdf:li>")
+		}
+		buf.WriteString("</rdf:Bag></dc:subject>")
+	}
+	buf.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>\n")
+	buf.WriteString(`<?xpacket end="w"?>`)
+	if buf.Len()+len(xmpHeader) > 0xffff-2 {
+		return nil, fmt.Errorf("XMP packet of %d bytes is too large", buf.Len())
+	}
+	return buf.Bytes(), nil
+}
+
+// iptcRecord returns the IPTC-IIM datasets of a description and keywords,
+// in UTF-8, truncated to the IPTC limits
+func iptcRecord(description string, keywords []string) []byte {
+	var buf bytes.Buffer
+	dataset := func(record, tag byte, value []byte) {
+		buf.Write([]byte{0x1c, record, tag})
+		binary.Write(&buf, binary.BigEndian, uint16(len(value)))
+		buf.Write(value)
+	}
+	dataset(1, 90, []byte("\x1b%G")) // UTF-8
+	dataset(2, 0, []byte{0, 4})      // record version
+	dataset(2, 120, []byte(truncateUTF8(description, maxIPTCCaption)))
+	for _, k := range keywords {
+		dataset(2, 25, []byte(truncateUTF8(k, maxIPTCKeyword)))
+	}
+	return buf.Bytes()
+}
+
+// truncateUTF8 truncates a string to at most n bytes without splitting a
+// character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// jpegSegment is a JPEG marker segment before the image data
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// embedJPEG replaces the XMP APP1 segment and the IPTC resource of the
+// Photoshop APP13 segment of a JPEG, inserting them after its JFIF and Exif
+// segments
+func embedJPEG(data, packet, iptc []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != jpegSOI {
+		return nil, fmt.Errorf("not a JPEG")
+	}
+	var segments []jpegSegment
+	var resources []byte
+	rest := data[2:]
+	for {
+		if len(rest) < 2 || rest[0] != 0xff {
+			return nil, fmt.Errorf("invalid JPEG marker")
+		}
+		marker := rest[1]
+		if marker == 0xff { // fill byte
+			rest = rest[1:]
+			continue
+		}
+		if marker == jpegSOS || marker == jpegEOI {
+			break
+		}
+		if marker == 0x01 || marker >= 0xd0 && marker <= 0xd7 { // no length
+			segments = append(segments, jpegSegment{marker: marker})
+			rest = rest[2:]
+			continue
+		}
+		if len(rest) < 4 {
+			return nil, fmt.Errorf("truncated JPEG segment")
+		}
+		n := int(binary.BigEndian.Uint16(rest[2:]))
+		if n < 2 || 2+n > len(rest) {
+			return nil, fmt.Errorf("truncated JPEG segment")
+		}
+		payload := rest[4 : 2+n]
+		rest = rest[2+n:]
+		switch {
+		case marker == jpegAPP1 && bytes.HasPrefix(payload, []byte(xmpHeader)):
+			continue // replaced
+		case marker == jpegAPP13 && bytes.HasPrefix(payload, []byte(photoshopHeader)):
+			resources = append(resources, withoutResource(payload[len(photoshopHeader):], iptcResourceID)...)
+			continue // merged into the new APP13
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: payload})
+	}
+
+	app13 := append([]byte(photoshopHeader), resources...)
+	app13 = append(app13, photoshopResource(iptcResourceID, iptc)...)
+	if len(app13) > 0xffff-2 {
+		return nil, fmt.Errorf("IPTC segment of %d bytes is too large", len(app13))
+	}
+	ours := []jpegSegment{
+		{marker: jpegAPP1, data: append([]byte(xmpHeader), packet...)},
+		{marker: jpegAPP13, data: app13},
+	}
+	at := 0
+	for at < len(segments) && (segments[at].marker == jpegAPP0 || segments[at].marker == jpegAPP1 && bytes.HasPrefix(segments[at].data, []byte(exifHeader))) {
+		at++
+	}
+	segments = append(segments[:at], append(ours, segments[at:]...)...)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, jpegSOI})
+	for _, s := range segments {
+		buf.Write([]byte{0xff, s.marker})
+		if s.marker == 0x01 || s.marker >= 0xd0 && s.marker <= 0xd7 {
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint16(len(s.data)+2))
+		buf.Write(s.data)
+	}
+	buf.Write(rest)
+	return buf.Bytes(), nil
+}
+
+// photoshopResource encodes a Photoshop image resource block with an empty
+// name
+func photoshopResource(id uint16, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("8BIM")
+	binary.Write(&buf, binary.BigEndian, id)
+	buf.Write([]byte{0, 0}) // empty Pascal name, padded to even
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// withoutResource returns Photoshop image resource blocks without those of
+// an ID, stopping at the first malformed block
+func withoutResource(blocks []byte, id uint16) []byte {
+	var kept []byte
+	for len(blocks) >= 12 && string(blocks[:4]) == "8BIM" {
+		nameLen := int(blocks[6]) + 1
+		nameLen += nameLen % 2
+		if 6+nameLen+4 > len(blocks) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(blocks[6+nameLen:]))
+		end := 6 + nameLen + 4 + size + size%2
+		if end > len(blocks) {
+			break
+		}
+		if binary.BigEndian.Uint16(blocks[4:]) != id {
+			kept = append(kept, blocks[:end]...)
+		}
+		blocks = blocks[end:]
+	}
+	return kept
+}
+
+// embedPNG replaces the XMP iTXt chunk of a PNG, inserting it after the
+// header
+func embedPNG(data, packet []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, fmt.Errorf("not a PNG")
+	}
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	for rest := data[len(pngSignature):]; len(rest) > 0; {
+		if len(rest) < 12 {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		n := binary.BigEndian.Uint32(rest)
+		if uint64(n)+12 > uint64(len(rest)) {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		typ, chunk := string(rest[4:8]), rest[8:8+n]
+		whole := rest[:12+n]
+		rest = rest[12+n:]
+		if typ == "iTXt" && strings.HasPrefix(string(chunk), xmpKeyword+"\x00") {
+			continue // replaced
+		}
+		buf.Write(whole)
+		if typ == "IHDR" {
+			// keyword, no compression, no language tag or translated keyword
+			itxt := append([]byte(xmpKeyword+"\x00\x00\x00\x00\x00"), packet...)
+			writePNGChunk(&buf, "iTXt", itxt)
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestEmbedJPEG(t *testing.T) {
+	original := testJPEG(t, 32, 24)
+	once, err := embedDescription(original, "image/jpeg", "A <red> barn & a tractor", []string{"barn", "farm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// embedding again replaces the metadata rather than adding to it
+	data, err := embedDescription(once, "image/jpeg", "A red barn", []string{"barn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("embedded JPEG doesn't decode: %v", err)
+	}
+	if n := bytes.Count(data, []byte(xmpHeader)); n != 1 {
+		t.Errorf("%d XMP packets, want 1", n)
+	}
+	if n := bytes.Count(data, []byte(photoshopHeader)); n != 1 {
+		t.Errorf("%d Photoshop segments, want 1", n)
+	}
+	for _, want := range []string{
+		`<dc:description><rdf:Alt><rdf:li xml:lang="x-default">A red barn</rdf:li>`,
+		`<dc:subject><rdf:Bag><rdf:li>barn</rdf:li></rdf:Bag></dc:subject>`,
+		"\x1c\x02\x78\x00\x0aA red barn", // Caption-Abstract
+		"\x1c\x02\x19\x00\x04barn",       // Keywords
+	} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("embedded JPEG missing %q", want)
+		}
+	}
+	if bytes.Contains(data, []byte("tractor")) || bytes.Contains(data, []byte("farm")) {
+		t.Error("previous metadata kept")
+	}
+	if !bytes.HasSuffix(data, original[bytes.Index(original, []byte{0xff, jpegSOS}):]) {
+		t.Error("image data changed")
+	}
+}
+
+func TestEmbedPNG(t *testing.T) {
+	once, err := embedDescription(testPNG(t, 8, 8), "image/png", "A <chart>", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := embedDescription(once, "image/png", "A chart", []string{"data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("embedded PNG doesn't decode: %v", err)
+	}
+	if n := bytes.Count(data, []byte(xmpKeyword)); n != 1 {
+		t.Errorf("%d XMP chunks, want 1", n)
+	}
+	if !bytes.Contains(data, []byte(`<rdf:li xml:lang="x-default">A chart</rdf:li>`)) {
+		t.Error("description not embedded")
+	}
+	if _, err := embedDescription([]byte("not a png"), "image/png", "x", nil); err == nil {
+		t.Error("embedded into an invalid PNG")
+	}
+}
+
+func TestUploadEmbedsMetadata(t *testing.T) {
+	f := useFakes(t)
+	prev := embedMetadata
+	t.Cleanup(func() { embedMetadata = prev })
+	embedMetadata = true
+	original := testJPEG(t, 16, 16)
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", original)
+
+	r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+	uploaded := f.storage.objects["test-bucket/a.jpg"]
+	if !bytes.Contains(uploaded, []byte(r.Description)) || bytes.Equal(uploaded, original) {
+		t.Error("description not embedded into the uploaded image")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(uploaded)); err != nil {
+		t.Errorf("uploaded JPEG doesn't decode: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// proxyURL is the HTTP proxy every request is sent through; empty for the
+// HTTPS_PROXY and HTTP_PROXY environment variables, if any
+var proxyURL string
+
+// driveEndpoint, storageEndpoint and vertexEndpoint override the API
+// endpoints, for Private Google Access, VPC Service Controls or emulators;
+// empty for DRIVE_ENDPOINT, STORAGE_ENDPOINT and VERTEX_ENDPOINT, or else
+// the public endpoints
+var driveEndpoint, storageEndpoint, vertexEndpoint string
+
+// locationPlaceholder is replaced in the Vertex AI endpoint with the location
+// a request is sent to, so the run can still fail over between LOCATION
+// regions
+const locationPlaceholder = "{location}"
+
+// the Vertex AI mTLS endpoints, of a location and global
+const (
+	vertexMTLSEndpoint       = "https://{location}-aiplatform.mtls.googleapis.com/"
+	vertexGlobalMTLSEndpoint = "https://aiplatform.mtls.googleapis.com/"
+)
+
+// configureProxy sends every request through the -proxy, by setting the
+// environment variables Go's HTTP transport reads. It must run before the
+// first request, as the transport reads them once.
+func configureProxy() error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid -proxy %q, expected a URL such as http://proxy.example.com:3128", proxyURL)
+	}
+	os.Setenv("HTTPS_PROXY", proxyURL)
+	os.Setenv("HTTP_PROXY", proxyURL)
+	return nil
+}
+
+// driveOptions returns the Drive client options for the -drive-endpoint and
+// -client-cert
+func driveOptions() []option.ClientOption {
+	opts := clientCertOptions()
+	if e := cmp.Or(driveEndpoint, os.Getenv("DRIVE_ENDPOINT")); e != "" {
+		opts = append(opts, option.WithEndpoint(e))
+	}
+	return opts
+}
+
+// storageEndpointOptions returns the Cloud Storage client options for the
+// -storage-endpoint and -client-cert. STORAGE_EMULATOR_HOST is read by the
+// client itself.
+func storageEndpointOptions() []option.ClientOption {
+	opts := clientCertOptions()
+	if e := cmp.Or(storageEndpoint, os.Getenv("STORAGE_ENDPOINT")); e != "" {
+		opts = append(opts, option.WithEndpoint(e))
+	}
+	return opts
+}
+
+// vertexBaseURL returns the Vertex AI base URL of a location from the
+// -vertex-endpoint, the mTLS endpoint with a -client-cert, or empty for the
+// public endpoint
+func vertexBaseURL(location string) string {
+	e := cmp.Or(vertexEndpoint, os.Getenv("VERTEX_ENDPOINT"))
+	if e == "" && clientCert != nil {
+		e = vertexMTLSEndpoint
+		if location == "global" {
+			e = vertexGlobalMTLSEndpoint
+		}
+	}
+	if e == "" {
+		return ""
+	}
+	e = strings.ReplaceAll(e, locationPlaceholder, location)
+	if !strings.HasSuffix(e, "/") {
+		e += "/"
+	}
+	return e
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestVertexBaseURL(t *testing.T) {
+	defer func(prev string) { vertexEndpoint = prev }(vertexEndpoint)
+	t.Setenv("VERTEX_ENDPOINT", "")
+
+	vertexEndpoint = ""
+	if got := vertexBaseURL("us-central1"); got != "" {
+		t.Errorf("without an endpoint = %q, want the public endpoint", got)
+	}
+	vertexEndpoint = "https://{location}-aiplatform.p.googleapis.com"
+	if got := vertexBaseURL("europe-west4"); got != "https://europe-west4-aiplatform.p.googleapis.com/" {
+		t.Errorf("vertexBaseURL = %q", got)
+	}
+	vertexEndpoint = ""
+	t.Setenv("VERTEX_ENDPOINT", "http://localhost:8080/")
+	if got := vertexBaseURL("us-central1"); got != "http://localhost:8080/" {
+		t.Errorf("from VERTEX_ENDPOINT = %q", got)
+	}
+	if c := genaiConfig("us-central1"); c.HTTPOptions.BaseURL != "http://localhost:8080/" {
+		t.Errorf("genaiConfig base URL = %q", c.HTTPOptions.BaseURL)
+	}
+}
+
+func TestEndpointOptions(t *testing.T) {
+	defer func(d, s string) { driveEndpoint, storageEndpoint = d, s }(driveEndpoint, storageEndpoint)
+	t.Setenv("DRIVE_ENDPOINT", "")
+	t.Setenv("STORAGE_ENDPOINT", "")
+
+	driveEndpoint, storageEndpoint = "", ""
+	if len(driveOptions()) != 0 || len(storageEndpointOptions()) != 0 {
+		t.Error("endpoint options set without an endpoint")
+	}
+	driveEndpoint = "https://www.googleapis.com/drive/v3/"
+	t.Setenv("STORAGE_ENDPOINT", "https://storage.p.googleapis.com/storage/v1/")
+	if len(driveOptions()) != 1 || len(storageEndpointOptions()) != 1 {
+		t.Error("endpoint options not set")
+	}
+}
+
+func TestConfigureProxy(t *testing.T) {
+	defer func(prev string) { proxyURL = prev }(proxyURL)
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("HTTP_PROXY", "")
+
+	proxyURL = ""
+	if err := configureProxy(); err != nil || os.Getenv("HTTPS_PROXY") != "" {
+		t.Errorf("without -proxy: %v, HTTPS_PROXY = %q", err, os.Getenv("HTTPS_PROXY"))
+	}
+	proxyURL = "proxy.example.com"
+	if err := configureProxy(); err == nil {
+		t.Error("configureProxy accepted a proxy without a scheme")
+	}
+	proxyURL = "http://proxy.example.com:3128"
+	if err := configureProxy(); err != nil || os.Getenv("HTTPS_PROXY") != proxyURL || os.Getenv("HTTP_PROXY") != proxyURL {
+		t.Errorf("configureProxy = %v, HTTPS_PROXY = %q", err, os.Getenv("HTTPS_PROXY"))
+	}
+}
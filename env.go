@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// envPrefix prefixes the environment variable of each flag
+const envPrefix = "DTG_"
+
+// envName returns the environment variable setting a flag, e.g. DTG_GCS_BUCKET
+// for -gcs-bucket
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applySettings sets the flags not given on the command line from their
+// environment variables, or else the config file's flags, so the precedence
+// is config < environment < command line. The config file is -config, or
+// DTG_CONFIG, and is loaded into cfg with its -profile, or DTG_PROFILE, applied.
+func applySettings(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for _, name := range []string{"config", "profile"} {
+		if v, ok := os.LookupEnv(envName(name)); ok && !given[name] {
+			fs.Set(name, v)
+		}
+	}
+	if configFile != "" {
+		var err error
+		cfg, err = loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+	}
+	if profile != "" {
+		if configFile == "" {
+			return fmt.Errorf("-profile %s needs a -config with profiles", profile)
+		}
+		var err error
+		if cfg, err = cfg.withProfile(profile); err != nil {
+			return err
+		}
+		log.Printf("using config profile %s", profile)
+	}
+	for name := range cfg.Flags {
+		if name == "config" || name == "profile" || fs.Lookup(name) == nil {
+			return fmt.Errorf("config flags: unknown flag %q", name)
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == "config" || f.Name == "profile" {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if e := f.Value.Set(v); e != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), e)
+			}
+			return
+		}
+		if raw, ok := cfg.Flags[f.Name]; ok {
+			if e := f.Value.Set(configValue(raw)); e != nil {
+				err = fmt.Errorf("config flag %s: %v", f.Name, e)
+			}
+		}
+	})
+	return err
+}
+
+// configValue returns a config flag's value as it would be given on the
+// command line: strings unquoted, and numbers and booleans as written
+func configValue(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
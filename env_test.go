@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestApplySettings(t *testing.T) {
+	defer func(prevCfg config, prevFile, prevProfile string) {
+		cfg, configFile, profile = prevCfg, prevFile, prevProfile
+	}(cfg, configFile, profile)
+	configFile, profile = "", ""
+	path := writeConfig(t, `{"flags": {"folder": "config-folder", "gcs-bucket": "config-bucket", "max": 10, "recursive": true}}`)
+	t.Setenv("DTG_CONFIG", path)
+	t.Setenv("DTG_GCS_BUCKET", "env-bucket")
+	t.Setenv("DTG_GCS_PATH", "env-path")
+
+	var folder, bucket, prefix string
+	var max int
+	var recursive bool
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "")
+	fs.StringVar(&profile, "profile", "", "")
+	fs.StringVar(&folder, "folder", "", "")
+	fs.StringVar(&bucket, "gcs-bucket", "", "")
+	fs.StringVar(&prefix, "gcs-path", "", "")
+	fs.IntVar(&max, "max", 0, "")
+	fs.BoolVar(&recursive, "recursive", false, "")
+	if err := fs.Parse([]string{"-gcs-path", "flag-path"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applySettings(fs); err != nil {
+		t.Fatalf("applySettings: %v", err)
+	}
+	if folder != "config-folder" || bucket != "env-bucket" || prefix != "flag-path" || max != 10 || !recursive {
+		t.Errorf("settings = %q, %q, %q, %d, %t, want config < environment < flag", folder, bucket, prefix, max, recursive)
+	}
+
+	t.Setenv("DTG_MAX", "many")
+	if err := applySettings(fs); err == nil {
+		t.Error("applySettings with DTG_MAX=many succeeded, want an error")
+	}
+
+	t.Setenv("DTG_CONFIG", writeConfig(t, `{"flags": {"fodler": "x"}}`))
+	if err := applySettings(fs); err == nil {
+		t.Error("applySettings with an unknown config flag succeeded, want an error")
+	}
+}
+
+func TestApplySettingsProfile(t *testing.T) {
+	defer func(prevCfg config, prevFile, prevProfile string) {
+		cfg, configFile, profile = prevCfg, prevFile, prevProfile
+	}(cfg, configFile, profile)
+	configFile, profile = "", ""
+	path := writeConfig(t, `{
+		"flags": {"folder": "shared-folder", "gcs-bucket": "shared-bucket"},
+		"rules": [{"mime_type": "video/*", "skip": true}],
+		"profiles": {
+			"prod-photos": {"flags": {"gcs-bucket": "prod-bucket"}, "routes": [{"path": "Raw", "bucket": "archive"}]},
+			"staging-docs": {"flags": {"gcs-bucket": "staging-bucket"}, "rules": [{"mime_type": "application/pdf", "model": "gemini-2.5-pro"}]}
+		}
+	}`)
+
+	var folder, bucket string
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "")
+	fs.StringVar(&profile, "profile", "", "")
+	fs.StringVar(&folder, "folder", "", "")
+	fs.StringVar(&bucket, "gcs-bucket", "", "")
+	t.Setenv("DTG_PROFILE", "staging-docs")
+	if err := fs.Parse([]string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applySettings(fs); err != nil {
+		t.Fatalf("applySettings: %v", err)
+	}
+	if folder != "shared-folder" || bucket != "staging-bucket" {
+		t.Errorf("folder, bucket = %q, %q, want the shared folder and the profile's bucket", folder, bucket)
+	}
+	if len(cfg.Rules) != 2 || cfg.Rules[0].Model != "gemini-2.5-pro" || len(cfg.Routes) != 0 || cfg.Profiles != nil {
+		t.Errorf("config = %+v, want the profile's rule before the shared rule", cfg)
+	}
+
+	if err := fs.Parse([]string{"-profile", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applySettings(fs); err == nil || !strings.Contains(err.Error(), "prod-photos, staging-docs") {
+		t.Errorf("applySettings with an unknown profile = %v, want the profiles listed", err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// eventsAddr is where the events command serves, empty for :$PORT, as on
+// Cloud Run, or :8080
+var eventsAddr string
+
+// workflowsCallbackPrefix is the prefix of Cloud Workflows callback URLs,
+// the only URLs results are sent to
+const workflowsCallbackPrefix = "https://workflowexecutions.googleapis.com/"
+
+// driveIDPattern matches a Drive file ID
+var driveIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// callbackClient returns the HTTP client sending results to Cloud Workflows
+// callbacks, authorized with the application default credentials
+var callbackClient = func(ctx context.Context) (*http.Client, error) {
+	return google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+}
+
+// eventsFlags registers the events command's flags
+func eventsFlags() {
+	flag.StringVar(&eventsAddr, "http-addr", eventsAddr, "the address to serve events on, empty for :$PORT or :8080")
+}
+
+// runEvents runs drivetogcs events, serving eventsHandler, so Eventarc
+// triggers and Cloud Workflows can process files one at a time as they are
+// added, such as on Cloud Run
+func runEvents(ctx context.Context, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs events [-http-addr :8080] [flags of the runs]")
+		return exitFailure
+	}
+	closeClients := initClients(ctx)
+	defer closeClients()
+	addr := cmp.Or(eventsAddr, ":"+cmp.Or(os.Getenv("PORT"), "8080"))
+	log.Printf("serving Drive events on %s", addr)
+	srv := &http.Server{Addr: addr, Handler: eventsHandler(ctx), ReadHeaderTimeout: 10 * time.Second}
+	if err := srv.ListenAndServe(); err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// fileEvent is a request to process a Drive file
+type fileEvent struct {
+	// Type is the CloudEvents type, such as
+	// google.workspace.drive.file.v3.created, empty for a direct request
+	Type   string
+	FileID string
+	// CallbackURL is the Cloud Workflows callback to send the result to,
+	// processing the file after responding, empty to respond with it
+	CallbackURL string
+}
+
+// eventPayload is the JSON body of an event: a direct request, as
+// {"file_id": "...", "callback_url": "..."}, the data of a CloudEvent, or
+// a Pub/Sub push message, as Eventarc delivers Workspace events, whose data is
+// one of the others
+type eventPayload struct {
+	FileID      string `json:"file_id"`
+	FileIDCamel string `json:"fileId"`
+	ID          string `json:"id"`
+	File        *struct {
+		ID string `json:"id"`
+	} `json:"file"`
+	CallbackURL string `json:"callback_url"`
+	Message     *struct {
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+	} `json:"message"`
+}
+
+func (p eventPayload) fileID() string {
+	id := cmp.Or(p.FileID, p.FileIDCamel, p.ID)
+	if id == "" && p.File != nil {
+		id = p.File.ID
+	}
+	return id
+}
+
+// parseFileEvent reads the Drive file to process from an event, from its
+// payload or else its CloudEvents subject, such as
+// //drive.googleapis.com/files/<id>
+func parseFileEvent(header http.Header, body []byte) (fileEvent, error) {
+	ev := fileEvent{Type: header.Get("Ce-Type")}
+	subject := header.Get("Ce-Subject")
+	var p eventPayload
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &p); err != nil {
+			return ev, fmt.Errorf("invalid event: %v", err)
+		}
+	}
+	if m := p.Message; m != nil {
+		ev.Type = cmp.Or(m.Attributes["ce-type"], ev.Type)
+		subject = cmp.Or(m.Attributes["ce-subject"], subject)
+		p = eventPayload{}
+		json.Unmarshal(m.Data, &p) // the subject may be all there is
+	}
+	ev.FileID, ev.CallbackURL = p.fileID(), p.CallbackURL
+	if _, id, ok := strings.Cut(subject, "files/"); ok && ev.FileID == "" {
+		ev.FileID = strings.Trim(id, "/")
+	}
+	switch {
+	case ev.FileID == "":
+		return ev, errors.New("no Drive file ID in the event")
+	case !driveIDPattern.MatchString(ev.FileID):
+		return ev, fmt.Errorf("invalid Drive file ID %q", ev.FileID)
+	case ev.CallbackURL != "" && !strings.HasPrefix(ev.CallbackURL, workflowsCallbackPrefix):
+		return ev, fmt.Errorf("callback_url %q is not a Cloud Workflows callback", ev.CallbackURL)
+	}
+	return ev, nil
+}
+
+// removal reports whether an event type is of a file removed, which there
+// is nothing to do for
+func removal(eventType string) bool {
+	return strings.Contains(eventType, "deleted") || strings.Contains(eventType, "removed") || strings.Contains(eventType, "trashed")
+}
+
+// eventResult is what came of an event, the response or callback body
+type eventResult struct {
+	FileID string `json:"file_id"`
+	// Status is processed, skipped or failed
+	Status string        `json:"status"`
+	Reason string        `json:"reason,omitempty"`
+	Record *record       `json:"record,omitempty"`
+	Errors []fileFailure `json:"errors,omitempty"`
+}
+
+// eventsHandler processes the Drive file of each event POSTed to it, as a run
+// would: files of other -mime-types, outside -folder, if set, ignored or
+// skipped by a rule, aren't processed. The result is the response, 500 for a
+// failed file so Eventarc retries it, or is sent to the event's Cloud
+// Workflows callback once processed, processing in the background, with ctx.
+func eventsHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ev, err := parseFileEvent(r.Header, body)
+		if err != nil {
+			log.Printf("event: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if removal(ev.Type) {
+			writeEventResult(w, http.StatusOK, eventResult{FileID: ev.FileID, Status: "skipped", Reason: ev.Type})
+			return
+		}
+		if ev.CallbackURL != "" {
+			writeEventResult(w, http.StatusAccepted, eventResult{FileID: ev.FileID, Status: "accepted"})
+			go func() {
+				if err := sendCallback(ctx, ev.CallbackURL, processEvent(ctx, ev.FileID)); err != nil {
+					log.Printf("event %s: unable to send callback: %v", ev.FileID, err)
+				}
+			}()
+			return
+		}
+		result := processEvent(r.Context(), ev.FileID)
+		code := http.StatusOK
+		if result.Status == "failed" {
+			code = http.StatusInternalServerError
+		}
+		writeEventResult(w, code, result)
+	})
+	return mux
+}
+
+func writeEventResult(w http.ResponseWriter, code int, result eventResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(result)
+}
+
+// eventMu serializes processing events, so the state kept of the files of
+// one can be forgotten once it is processed
+var eventMu sync.Mutex
+
+// processEvent processes a Drive file, as processFiles does in a run
+func processEvent(ctx context.Context, id string) eventResult {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	defer forgetFileState()
+	result := eventResult{FileID: id, Status: "failed"}
+	file, err := getFile(ctx, id)
+	if errors.Is(err, errNotOwned) {
+		return eventResult{FileID: id, Status: "skipped", Reason: err.Error()}
+	}
+	if err != nil {
+		result.Errors = []fileFailure{{DriveID: id, Stage: "get", Error: err.Error()}}
+		log.Printf("event %s: unable to get Drive file: %v", id, err)
+		return result
+	}
+	result.Status = "skipped"
+	if !slices.Contains(mimeTypes, file.MimeType) {
+		result.Reason = "mime type " + file.MimeType
+		return result
+	}
+	if sourceFolderID != "" {
+		in, err := locateInFolder(ctx, *file, sourceFolderID)
+		if err != nil {
+			result.Status = "failed"
+			result.Errors = []fileFailure{{DriveID: id, Stage: "get", Error: err.Error()}}
+			log.Printf("event %s: %v", id, err)
+			return result
+		}
+		if !in {
+			result.Reason = "outside -folder"
+			return result
+		}
+	}
+	failed := stats.failedCount()
+	files := make(chan drive.File, 1)
+	files <- *file
+	close(files)
+	var out collectedRecords
+	processFiles(ctx, files, &out)
+	if len(out.records) == 0 {
+		result.Reason = "ignored, skipped by a rule or in another shard"
+		return result
+	}
+	result.Record = &out.records[0]
+	if result.Errors = stats.failuresSince(failed, id); len(result.Errors) > 0 {
+		result.Status = "failed"
+	} else {
+		result.Status = "processed"
+	}
+	log.Printf("event %s: %s %s", id, result.Status, file.Name)
+	return result
+}
+
+// forgetFileState forgets the state kept of the files processed, by Drive ID
+// or object, and their failures and latencies, once their records are
+// written, so the events server doesn't grow with each event. The folder
+// names and parents, bucket versioning and the names files claimed, which later files
+// mustn't collide with, are kept.
+func forgetFileState() {
+	for _, m := range []*sync.Map{
+		&animations, &editedDescriptions, &faceDetections, &fileLocations, &gcsSources,
+		&groundings, &speechLanguages, &contentHashes, &localLocks, &piiFindings,
+		&staleObjects, &refinedOutputs, &servedRegions, &replicatedObjects, &taggings,
+		&fileTimelines, &objectGenerations, &extractedFiles, &zipArchives,
+	} {
+		m.Clear()
+	}
+	stats.forgetFiles()
+}
+
+// sendCallback POSTs an event's result to its Cloud Workflows callback
+func sendCallback(ctx context.Context, url string, result eventResult) error {
+	client, err := callbackClient(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("callback responded %s", resp.Status)
+	}
+	return nil
+}
+
+// collectedRecords is a recordWriter keeping the records in memory
+type collectedRecords struct {
+	mu      sync.Mutex
+	records []record
+}
+
+func (c *collectedRecords) Write(r record) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records = append(c.records, r)
+	return nil
+}
+
+func (c *collectedRecords) Close() error { return nil }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseFileEvent(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		header   map[string]string
+		body     string
+		want     fileEvent
+		hasError bool
+	}{
+		{name: "direct", body: `{"file_id": "abc"}`, want: fileEvent{FileID: "abc"}},
+		{name: "camel case", body: `{"fileId": "abc"}`, want: fileEvent{FileID: "abc"}},
+		{name: "nested file", body: `{"file": {"id": "abc"}}`, want: fileEvent{FileID: "abc"}},
+		{
+			name:   "cloudevent subject",
+			header: map[string]string{"Ce-Type": "google.workspace.drive.file.v3.created", "Ce-Subject": "//drive.googleapis.com/files/abc"},
+			want:   fileEvent{Type: "google.workspace.drive.file.v3.created", FileID: "abc"},
+		},
+		{
+			name:   "pubsub message",
+			header: map[string]string{"Ce-Type": "google.cloud.pubsub.topic.v1.messagePublished"},
+			body:   `{"message": {"data": "eyJmaWxlIjogeyJpZCI6ICJhYmMifX0=", "attributes": {"ce-type": "google.workspace.drive.file.v3.updated"}}}`,
+			want:   fileEvent{Type: "google.workspace.drive.file.v3.updated", FileID: "abc"},
+		},
+		{
+			name: "pubsub subject",
+			body: `{"message": {"data": "", "attributes": {"ce-type": "google.workspace.drive.file.v3.created", "ce-subject": "//drive.googleapis.com/files/abc"}}}`,
+			want: fileEvent{Type: "google.workspace.drive.file.v3.created", FileID: "abc"},
+		},
+		{
+			name: "workflows callback",
+			body: `{"file_id": "abc", "callback_url": "https://workflowexecutions.googleapis.com/v1/projects/p/locations/l/workflows/w/executions/e/callbacks/c"}`,
+			want: fileEvent{FileID: "abc", CallbackURL: "https://workflowexecutions.googleapis.com/v1/projects/p/locations/l/workflows/w/executions/e/callbacks/c"},
+		},
+		{name: "other callback", body: `{"file_id": "abc", "callback_url": "https://example.com/"}`, hasError: true},
+		{name: "no file", body: `{}`, hasError: true},
+		{name: "invalid file ID", body: `{"file_id": "a'b"}`, hasError: true},
+		{name: "invalid JSON", body: `{`, hasError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tc.header {
+				header.Set(k, v)
+			}
+			got, err := parseFileEvent(header, []byte(tc.body))
+			if tc.hasError {
+				if err == nil {
+					t.Errorf("parseFileEvent = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("parseFileEvent = %+v, %v, want %+v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+// roundTripFunc is an http.RoundTripper calling a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestEventsHandler(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev []string) { mimeTypes = prev }(mimeTypes)
+	mimeTypes = []string{"image/jpeg"}
+	sourceFolderID = "folder1"
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+	f.drive.add("2", "b.pdf", "application/pdf", "folder1", []byte("b"))
+	f.drive.add("3", "c.jpg", "image/jpeg", "folder2", []byte("c"))
+
+	srv := httptest.NewServer(eventsHandler(context.Background()))
+	defer srv.Close()
+	post := func(body string, header map[string]string) (int, eventResult) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result eventResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	code, result := post(`{"file_id": "1"}`, nil)
+	if code != http.StatusOK || result.Status != "processed" || result.Record == nil || result.Record.Description != "A test description." {
+		t.Fatalf("event for a.jpg = %d %+v", code, result)
+	}
+	// nothing is kept of the file once its event is handled
+	for name, m := range map[string]*sync.Map{"fileTimelines": &fileTimelines, "contentHashes": &contentHashes, "objectGenerations": &objectGenerations, "servedRegions": &servedRegions} {
+		m.Range(func(k, v any) bool {
+			t.Errorf("%s has %v after the event", name, k)
+			return true
+		})
+	}
+	if stats.failedCount() != 0 || len(stats.latencies) != 0 {
+		t.Errorf("stats keep %d failures and %d stage latencies after the event", stats.failedCount(), len(stats.latencies))
+	}
+	if _, ok := f.storage.objects["test-bucket/"+result.Record.ObjectPath]; !ok {
+		t.Errorf("a.jpg not uploaded to %s", result.Record.ObjectPath)
+	}
+	if code, result := post(`{"file_id": "2"}`, nil); code != http.StatusOK || result.Status != "skipped" {
+		t.Errorf("event for b.pdf = %d %+v, want skipped", code, result)
+	}
+	if code, result := post(`{"file_id": "3"}`, nil); code != http.StatusOK || result.Status != "skipped" || result.Reason != "outside -folder" {
+		t.Errorf("event for c.jpg = %d %+v, want skipped", code, result)
+	}
+	if code, result := post("", map[string]string{"Ce-Type": "google.workspace.drive.file.v3.deleted", "Ce-Subject": "//drive.googleapis.com/files/1"}); code != http.StatusOK || result.Status != "skipped" {
+		t.Errorf("deleted event = %d %+v, want skipped", code, result)
+	}
+	if code, result := post(`{"file_id": "missing"}`, nil); code != http.StatusInternalServerError || len(result.Errors) != 1 {
+		t.Errorf("event for a missing file = %d %+v, want 500 with its error", code, result)
+	}
+	if n := stats.failedCount(); n != 0 {
+		t.Errorf("stats keep %d failures after the failed event", n)
+	}
+	if code, _ := post(`{}`, nil); code != http.StatusBadRequest {
+		t.Errorf("event without a file = %d, want 400", code)
+	}
+
+	callbacks := make(chan eventResult, 1)
+	defer func(prev func(context.Context) (*http.Client, error)) { callbackClient = prev }(callbackClient)
+	callbackClient = func(ctx context.Context) (*http.Client, error) {
+		return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			var result eventResult
+			json.NewDecoder(r.Body).Decode(&result)
+			callbacks <- result
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+		})}, nil
+	}
+	code, result = post(`{"file_id": "1", "callback_url": "https://workflowexecutions.googleapis.com/v1/callbacks/c"}`, nil)
+	if code != http.StatusAccepted || result.Status != "accepted" {
+		t.Errorf("callback event = %d %+v, want accepted", code, result)
+	}
+	if result := <-callbacks; result.Status != "processed" || result.FileID != "1" {
+		t.Errorf("callback = %+v", result)
+	}
+}
+
+func TestEventsRecursive(t *testing.T) {
+	f := useFakes(t)
+	defer func(types []string, prev bool) { mimeTypes, recursive = types, prev }(mimeTypes, recursive)
+	mimeTypes, recursive = []string{"image/jpeg"}, true
+	sourceFolderID = "folder1"
+	f.drive.add("root", "My Drive", folderMimeType, "", nil)
+	f.drive.files["root"].Parents = nil
+	f.drive.add("folder1", "Photos", folderMimeType, "root", nil)
+	f.drive.add("sub", "2024 Trip", folderMimeType, "folder1", nil)
+	f.drive.add("subsub", "Day 1", folderMimeType, "sub", nil)
+	f.drive.add("other", "Other", folderMimeType, "root", nil)
+	f.drive.add("1", "a.jpg", "image/jpeg", "subsub", []byte("a"))
+	f.drive.add("2", "b.jpg", "image/jpeg", "other", []byte("b"))
+
+	result := processEvent(context.Background(), "1")
+	if result.Status != "processed" || result.Record.RelativePath != "2024 Trip/Day 1" {
+		t.Errorf("event in a subfolder = %+v, want processed under its relative path", result)
+	}
+	if result := processEvent(context.Background(), "2"); result.Status != "skipped" || result.Reason != "outside -folder" {
+		t.Errorf("event outside -folder = %+v, want skipped", result)
+	}
+
+	recursive = false
+	if result := processEvent(context.Background(), "1"); result.Status != "skipped" || result.Reason != "outside -folder" {
+		t.Errorf("event in a subfolder without -recursive = %+v, want skipped", result)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// countFaces asks Gemini for the faces in each image described, recording
+// how many there are
+var countFaces bool
+
+// blurFaces blurs the faces in the serving copy of JPEG and PNG images,
+// keeping the original under -archive-prefix
+var blurFaces bool
+
+// stageFaces is the stats category of face detection and blurring
+const stageFaces = "faces"
+
+// facesKey is the object metadata key of the face count
+const facesKey = "faces"
+
+// faceFunction is the function Gemini calls with the faces in an image
+const faceFunction = "report_faces"
+
+// facePrompt asks for the faces in the image described
+const facePrompt = "Report every human face in this image, however small or partly hidden, by calling " + faceFunction + "."
+
+// faceMargin enlarges blurred face boxes by this fraction on each side, to
+// cover hair and the edges of faces
+const faceMargin = 0.15
+
+// faceBlurCells is how many cells across a face is pixelated into before
+// being smoothed
+const faceBlurCells = 8
+
+// errBlur is returned when the faces in an image could not be blurred
+var errBlur = errors.New("unable to blur faces")
+
+// faceBox is the bounding box of a face, as Gemini reports it: ymin, xmin,
+// ymax and xmax, normalized to 0-1000
+type faceBox [4]int
+
+// faceDetections holds the faces found in this run's images, by Drive file ID
+var faceDetections sync.Map
+
+// facesFound returns the faces found in an image, and whether it was checked
+func facesFound(file drive.File) ([]faceBox, bool) {
+	boxes, ok := faceDetections.Load(file.Id)
+	if !ok {
+		return nil, false
+	}
+	return boxes.([]faceBox), true
+}
+
+// blursFaces reports whether the serving copy of an image has its faces
+// blurred
+func blursFaces(file drive.File) bool {
+	boxes, _ := facesFound(file)
+	return blurFaces && len(boxes) > 0
+}
+
+// faceMetadata returns the object metadata of the faces found in an image
+func faceMetadata(file drive.File) map[string]string {
+	boxes, ok := facesFound(file)
+	if !ok {
+		return nil
+	}
+	return map[string]string{facesKey: strconv.Itoa(len(boxes))}
+}
+
+// faceDeclaration declares the function Gemini calls with the faces in an
+// image
+func faceDeclaration() *genai.FunctionDeclaration {
+	return &genai.FunctionDeclaration{
+		Name:        faceFunction,
+		Description: "Reports the human faces in the image described.",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"faces": {
+					Type:        genai.TypeArray,
+					Description: "every face in the image, empty if there are none",
+					Items: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"box_2d": {
+								Type:        genai.TypeArray,
+								Items:       &genai.Schema{Type: genai.TypeInteger},
+								Description: "the bounding box of the face as [ymin, xmin, ymax, xmax], normalized to 0-1000",
+							},
+						},
+						Required: []string{"box_2d"},
+					},
+				},
+			},
+			Required: []string{"faces"},
+		},
+	}
+}
+
+// detectFaces asks Gemini, in the conversation that described the image, to
+// call faceFunction with the faces in it. Invalid arguments are sent back as
+// the function's error for Gemini to correct, up to tagAttempts times.
+func detectFaces(ctx context.Context, file drive.File, model string, contents []*genai.Content, config *genai.GenerateContentConfig, description string) ([]faceBox, error) {
+	faceConfig := *config
+	faceConfig.Tools = []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{faceDeclaration()}}}
+	faceConfig.ToolConfig = &genai.ToolConfig{FunctionCallingConfig: &genai.FunctionCallingConfig{
+		Mode:                 genai.FunctionCallingConfigModeAny,
+		AllowedFunctionNames: []string{faceFunction},
+	}}
+	contents = append(contents, genai.NewModelContentFromText(description))
+	contents = append(contents, genai.Text(facePrompt)...)
+
+	var err error
+	for range tagAttempts {
+		start := time.Now()
+		var resp *genai.GenerateContentResponse
+		err = withQuotaRetry(ctx, func() (err error) {
+			resp, err = genaiClient.GenerateContent(ctx, model, contents, &faceConfig)
+			return err
+		})
+		observeFile(file.Id, stageFaces, start)
+		if err != nil {
+			return nil, fmt.Errorf("unable to detect faces: %w", err)
+		}
+		stats.addUsage(resp.UsageMetadata)
+		var call *genai.FunctionCall
+		for _, c := range resp.FunctionCalls() {
+			if c.Name == faceFunction {
+				call = c
+				break
+			}
+		}
+		if call == nil {
+			return nil, fmt.Errorf("model did not call %s", faceFunction)
+		}
+		var boxes []faceBox
+		if boxes, err = parseFaceBoxes(call.Args); err == nil {
+			return boxes, nil
+		}
+		log.Printf("%s: invalid faces, asking for a correction: %v", file.Name, err)
+		contents = append(contents,
+			genai.NewModelContentFromParts([]*genai.Part{{FunctionCall: call}}),
+			genai.NewUserContentFromParts([]*genai.Part{genai.NewPartFromFunctionResponse(faceFunction, map[string]any{"error": err.Error()})}),
+		)
+	}
+	return nil, err
+}
+
+// parseFaceBoxes validates the arguments of a faceFunction call, requiring
+// each box to have 4 coordinates from 0 to 1000, with its minimums below its
+// maximums
+func parseFaceBoxes(args map[string]any) ([]faceBox, error) {
+	faces, ok := args["faces"].([]any)
+	if !ok && args["faces"] != nil {
+		return nil, fmt.Errorf("faces must be a list")
+	}
+	boxes := []faceBox{}
+	for i, f := range faces {
+		face, _ := f.(map[string]any)
+		coords, _ := face["box_2d"].([]any)
+		if len(coords) != 4 {
+			return nil, fmt.Errorf("face %d: box_2d must have 4 coordinates", i+1)
+		}
+		var box faceBox
+		for j, c := range coords {
+			v, ok := c.(float64)
+			if !ok || v < 0 || v > 1000 {
+				return nil, fmt.Errorf("face %d: box_2d coordinates must be from 0 to 1000", i+1)
+			}
+			box[j] = int(v)
+		}
+		if box[0] >= box[2] || box[1] >= box[3] {
+			return nil, fmt.Errorf("face %d: box_2d must be [ymin, xmin, ymax, xmax]", i+1)
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, nil
+}
+
+// blurFaceBoxes blurs the faces in a JPEG or PNG image, pixelating each
+// enlarged box and smoothing it so no features can be recovered, returning
+// the encoded serving copy
+func blurFaceBoxes(data []byte, mimeType string, boxes []faceBox) ([]byte, error) {
+	if !watermarkable[mimeType] {
+		return nil, fmt.Errorf("%s images can't be blurred", mimeType)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %v", err)
+	}
+	bounds := src.Bounds()
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, bounds, src, bounds.Min, draw.Src)
+	for _, box := range boxes {
+		w, h := bounds.Dx(), bounds.Dy()
+		y0, x0, y1, x1 := box[0]*h/1000, box[1]*w/1000, box[2]*h/1000, box[3]*w/1000
+		my, mx := int(float64(y1-y0)*faceMargin), int(float64(x1-x0)*faceMargin)
+		r := image.Rect(x0-mx, y0-my, x1+mx, y1+my).Add(bounds.Min).Intersect(bounds)
+		if r.Empty() {
+			continue
+		}
+		cells := image.Rect(0, 0, min(faceBlurCells, r.Dx()), max(min(faceBlurCells, r.Dx())*r.Dy()/r.Dx(), 1))
+		small := image.NewRGBA(cells)
+		draw.ApproxBiLinear.Scale(small, cells, img, r, draw.Src, nil)
+		draw.BiLinear.Scale(img, r, small, cells, draw.Src, nil)
+	}
+	var buf bytes.Buffer
+	switch mimeType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode blurred image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
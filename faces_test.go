@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testCheckerPNG encodes a black and white checkerboard of 1 pixel squares,
+// which blurring turns grey
+func testCheckerPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			if (x+y)%2 == 0 {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBlurFaceBoxes(t *testing.T) {
+	data, err := blurFaceBoxes(testCheckerPNG(t, 200, 100), "image/png", []faceBox{{200, 200, 600, 400}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := func(x, y int) uint8 { return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y }
+	// the center of the face is averaged out, the rest of the image is not
+	if g := gray(60, 40); g < 64 || g > 192 {
+		t.Errorf("face pixel = %d, want blurred to grey", g)
+	}
+	if gray(150, 80) != 255 || gray(151, 80) != 0 {
+		t.Error("pixels outside the face changed")
+	}
+	if _, err := blurFaceBoxes(nil, "image/webp", nil); err == nil {
+		t.Error("blurred an image type that can't be encoded")
+	}
+}
+
+func TestParseFaceBoxes(t *testing.T) {
+	boxes, err := parseFaceBoxes(map[string]any{"faces": []any{
+		map[string]any{"box_2d": []any{10.0, 20.0, 300.0, 400.0}},
+	}})
+	if err != nil || len(boxes) != 1 || boxes[0] != (faceBox{10, 20, 300, 400}) {
+		t.Errorf("parseFaceBoxes = %v, %v", boxes, err)
+	}
+	if boxes, err := parseFaceBoxes(map[string]any{"faces": []any{}}); err != nil || boxes == nil || len(boxes) != 0 {
+		t.Errorf("parseFaceBoxes(no faces) = %v, %v, want an empty list", boxes, err)
+	}
+	for _, bad := range []any{
+		[]any{10.0, 20.0, 300.0},
+		[]any{300.0, 20.0, 10.0, 400.0},
+		[]any{10.0, 20.0, 300.0, 1400.0},
+	} {
+		if _, err := parseFaceBoxes(map[string]any{"faces": []any{map[string]any{"box_2d": bad}}}); err == nil {
+			t.Errorf("accepted box %v", bad)
+		}
+	}
+}
+
+func TestBlurredImagesKeepOriginals(t *testing.T) {
+	f := useFakes(t)
+	prev := blurFaces
+	t.Cleanup(func() { blurFaces = prev })
+	blurFaces = true
+	original := testCheckerPNG(t, 100, 100)
+	f.drive.add("1", "a.png", "image/png", "root", original)
+	f.generator.functionArgs = []map[string]any{{"faces": []any{map[string]any{"box_2d": []any{100.0, 100.0, 500.0, 500.0}}}}}
+
+	r := processFile(context.Background(), *f.drive.files["1"], "a.png")
+	if r.Faces == nil || *r.Faces != 1 || r.OriginalPath != "originals/a.png" {
+		t.Errorf("record faces = %v, original = %q", r.Faces, r.OriginalPath)
+	}
+	served := f.storage.objects["test-bucket/a.png"]
+	if bytes.Equal(served, original) || len(served) == 0 {
+		t.Error("serving copy not blurred")
+	}
+	if !bytes.Equal(f.storage.objects["test-bucket/originals/a.png"], original) {
+		t.Error("original not kept")
+	}
+	if got := f.storage.attrs["test-bucket/a.png"].Metadata[facesKey]; got != "1" {
+		t.Errorf("faces metadata = %q", got)
+	}
+}
+
+func TestCountFacesWithoutBlurring(t *testing.T) {
+	f := useFakes(t)
+	prev := countFaces
+	t.Cleanup(func() { countFaces = prev })
+	countFaces = true
+	original := testCheckerPNG(t, 10, 10)
+	f.drive.add("1", "a.png", "image/png", "root", original)
+	f.generator.functionArgs = []map[string]any{{"faces": []any{}}}
+
+	r := processFile(context.Background(), *f.drive.files["1"], "a.png")
+	if r.Faces == nil || *r.Faces != 0 || r.OriginalPath != "" {
+		t.Errorf("record faces = %v, original = %q", r.Faces, r.OriginalPath)
+	}
+	if !bytes.Equal(f.storage.objects["test-bucket/a.png"], original) || f.storage.attrs["test-bucket/a.png"].Metadata[facesKey] != "0" {
+		t.Error("image not uploaded as is with its face count")
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// fakeDrive is an in-memory driveClient
+type fakeDrive struct {
+	mu       sync.Mutex
+	files    map[string]*drive.File
+	contents map[string][]byte
+	queries  []string
+	// downloads counts the file downloads
+	downloads int
+
+	revisions        map[string][]*drive.Revision
+	revisionContents map[string][]byte // by file and revision ID
+	comments         map[string][]*drive.Comment
+	// user is the authorized user's email address
+	user string
+}
+
+func newFakeDrive() *fakeDrive {
+	return &fakeDrive{
+		files:            map[string]*drive.File{},
+		contents:         map[string][]byte{},
+		revisions:        map[string][]*drive.Revision{},
+		revisionContents: map[string][]byte{},
+		comments:         map[string][]*drive.Comment{},
+	}
+}
+
+func (d *fakeDrive) User(ctx context.Context) (string, error) {
+	return d.user, nil
+}
+
+func (d *fakeDrive) Comments(ctx context.Context, id string) ([]*drive.Comment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.comments[id], nil
+}
+
+// addRevision adds a revision to a file in the fake Drive
+func (d *fakeDrive) addRevision(id, revisionID string, contents []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.revisions[id] = append(d.revisions[id], &drive.Revision{Id: revisionID, MimeType: d.files[id].MimeType})
+	d.revisionContents[id+"/"+revisionID] = contents
+}
+
+func (d *fakeDrive) Revisions(ctx context.Context, id string) ([]*drive.Revision, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.revisions[id], nil
+}
+
+func (d *fakeDrive) DownloadRevision(ctx context.Context, id, revisionID string) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.revisionContents[id+"/"+revisionID]
+	if !ok {
+		return nil, fmt.Errorf("revision %s/%s not found", id, revisionID)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// add adds a file to the fake Drive
+func (d *fakeDrive) add(id, name, mimeType, parent string, contents []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sum := md5.Sum(contents)
+	d.files[id] = &drive.File{Id: id, Name: name, MimeType: mimeType, Parents: []string{parent}, Size: int64(len(contents)), Md5Checksum: hex.EncodeToString(sum[:])}
+	d.contents[id] = contents
+}
+
+var (
+	parentQuery = regexp.MustCompile(`'([^']+)' in parents`)
+	mimeQuery   = regexp.MustCompile(`mimeType = '([^']+)'`)
+	// modifiedQuery matches the modifiedTime clauses of -partition, whose
+	// RFC 3339 UTC times compare as strings
+	modifiedQuery = regexp.MustCompile(`modifiedTime (>=|<) '([^']+)'`)
+)
+
+// List supports the subset of the Drive query language produced by buildQuery
+func (d *fakeDrive) List(ctx context.Context, query string) ([]*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = append(d.queries, query)
+
+	var parent string
+	if m := parentQuery.FindStringSubmatch(query); m != nil {
+		parent = m[1]
+	}
+	var mimeTypes []string
+	for _, m := range mimeQuery.FindAllStringSubmatch(query, -1) {
+		mimeTypes = append(mimeTypes, m[1])
+	}
+
+	var found []*drive.File
+	for _, id := range sortedKeys(d.files) {
+		f := d.files[id]
+		if parent != "" && !slices.Contains(f.Parents, parent) {
+			continue
+		}
+		if len(mimeTypes) > 0 && !slices.Contains(mimeTypes, f.MimeType) {
+			continue
+		}
+		if !slices.ContainsFunc(modifiedQuery.FindAllStringSubmatch(query, -1), func(m []string) bool {
+			return m[1] == ">=" && f.ModifiedTime < m[2] || m[1] == "<" && f.ModifiedTime >= m[2]
+		}) {
+			found = append(found, f)
+		}
+	}
+	return found, nil
+}
+
+func (d *fakeDrive) Get(ctx context.Context, id string, fields string) (*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, ok := d.files[id]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", id)
+	}
+	return f, nil
+}
+
+func (d *fakeDrive) UpdateDescription(ctx context.Context, id, description string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, ok := d.files[id]
+	if !ok {
+		return fmt.Errorf("file %s not found", id)
+	}
+	f.Description = description
+	return nil
+}
+
+func (d *fakeDrive) Download(ctx context.Context, id string) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.contents[id]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", id)
+	}
+	d.downloads++
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// fakeStorage is an in-memory storageClient
+type fakeStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	attrs   map[string]storage.ObjectAttrs
+	uploads int
+
+	generation int64 // of the last write
+
+	buckets     map[string]bool
+	permissions []string // granted on all buckets
+	versioning  bool     // of all buckets
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		objects:     map[string][]byte{},
+		attrs:       map[string]storage.ObjectAttrs{},
+		buckets:     map[string]bool{"test-bucket": true},
+		permissions: doctorPermissions,
+	}
+}
+
+func (s *fakeStorage) Attrs(ctx context.Context, bucket, object string) (*storage.ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs, ok := s.attrs[bucket+"/"+object]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return &attrs, nil
+}
+
+func (s *fakeStorage) Read(ctx context.Context, bucket, object string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[bucket+"/"+object]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return bytes.Clone(b), nil
+}
+
+func (s *fakeStorage) Upload(ctx context.Context, bucket, object string, data []byte, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.upload(bucket, object, data, attrs), nil
+}
+
+// upload writes an object, with s.mu held
+func (s *fakeStorage) upload(bucket, object string, data []byte, attrs storage.ObjectAttrs) *storage.ObjectAttrs {
+	attrs.Bucket = bucket
+	attrs.Name = object
+	attrs.Size = int64(len(data))
+	sum := md5.Sum(data)
+	attrs.MD5 = sum[:]
+	s.generation++
+	attrs.Generation = s.generation
+	s.objects[bucket+"/"+object] = bytes.Clone(data)
+	s.attrs[bucket+"/"+object] = attrs
+	s.uploads++
+	return &attrs
+}
+
+func (s *fakeStorage) UpdateMetadata(ctx context.Context, bucket, object string, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs, ok := s.attrs[bucket+"/"+object]
+	if !ok {
+		return storage.ErrObjectNotExist
+	}
+	attrs.Metadata = maps.Clone(attrs.Metadata)
+	if attrs.Metadata == nil {
+		attrs.Metadata = map[string]string{}
+	}
+	maps.Copy(attrs.Metadata, metadata)
+	s.attrs[bucket+"/"+object] = attrs
+	return nil
+}
+
+func (s *fakeStorage) UploadIfGeneration(ctx context.Context, bucket, object string, data []byte, attrs storage.ObjectAttrs, generation int64) (*storage.ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs[bucket+"/"+object].Generation != generation {
+		return nil, errPreconditionFailed
+	}
+	return s.upload(bucket, object, data, attrs), nil
+}
+
+func (s *fakeStorage) DeleteIfGeneration(ctx context.Context, bucket, object string, generation int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs[bucket+"/"+object].Generation != generation {
+		return errPreconditionFailed
+	}
+	delete(s.objects, bucket+"/"+object)
+	delete(s.attrs, bucket+"/"+object)
+	return nil
+}
+
+func (s *fakeStorage) Copy(ctx context.Context, bucket, src, dst string, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[bucket+"/"+src]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return s.upload(bucket, dst, data, attrs), nil
+}
+
+func (s *fakeStorage) BucketAttrs(ctx context.Context, bucket string) (*storage.BucketAttrs, error) {
+	if !s.buckets[bucket] {
+		return nil, storage.ErrBucketNotExist
+	}
+	return &storage.BucketAttrs{Name: bucket, Location: "US-CENTRAL1", VersioningEnabled: s.versioning}, nil
+}
+
+func (s *fakeStorage) List(ctx context.Context, bucket, prefix string) ([]*storage.ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []*storage.ObjectAttrs
+	for _, key := range slices.Sorted(maps.Keys(s.attrs)) {
+		if strings.HasPrefix(key, bucket+"/"+prefix) {
+			attrs := s.attrs[key]
+			objects = append(objects, &attrs)
+		}
+	}
+	return objects, nil
+}
+
+func (s *fakeStorage) TestPermissions(ctx context.Context, bucket string, permissions []string) ([]string, error) {
+	var granted []string
+	for _, p := range permissions {
+		if slices.Contains(s.permissions, p) {
+			granted = append(granted, p)
+		}
+	}
+	return granted, nil
+}
+
+func (s *fakeStorage) SignedURL(bucket, object string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("https://signed.example.com/%s/%s?ttl=%s", bucket, object, ttl), nil
+}
+
+// fakeGenerator is a generator returning a canned response
+type fakeGenerator struct {
+	mu       sync.Mutex
+	response string
+	err      error
+	calls    int
+	models   []string
+	streams  int
+	stall    bool             // stop streaming after the first chunk
+	contents []*genai.Content // of the last request
+	config   *genai.GenerateContentConfig
+	// grounding is returned with the response
+	grounding *genai.GroundingMetadata
+	// functionArgs are the arguments of the function calls returned, in
+	// turn, to requests with a tool config
+	functionArgs []map[string]any
+}
+
+func (g *fakeGenerator) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls++
+	g.models = append(g.models, model)
+	g.contents = contents
+	g.config = config
+	if g.err != nil {
+		return nil, g.err
+	}
+	if config != nil && config.ToolConfig != nil && len(g.functionArgs) > 0 {
+		name := config.ToolConfig.FunctionCallingConfig.AllowedFunctionNames[0]
+		args := g.functionArgs[0]
+		g.functionArgs = g.functionArgs[1:]
+		return &genai.GenerateContentResponse{
+			Candidates: []*genai.Candidate{
+				{Content: genai.NewModelContentFromParts([]*genai.Part{genai.NewPartFromFunctionCall(name, args)})},
+			},
+		}, nil
+	}
+	total := int32(10)
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{Content: genai.NewModelContentFromText(g.response), GroundingMetadata: g.grounding},
+		},
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{TotalTokenCount: total},
+	}, nil
+}
+
+// GenerateContentStream streams the response a word at a time
+func (g *fakeGenerator) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	g.mu.Lock()
+	g.streams++
+	response, err, stall := g.response, g.err, g.stall
+	g.mu.Unlock()
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		words := strings.SplitAfter(response, " ")
+		for i, word := range words {
+			resp := &genai.GenerateContentResponse{
+				Candidates: []*genai.Candidate{{Content: genai.NewModelContentFromText(word)}},
+			}
+			if i == len(words)-1 {
+				resp.UsageMetadata = &genai.GenerateContentResponseUsageMetadata{TotalTokenCount: 10}
+			}
+			if !yield(resp, nil) {
+				return
+			}
+			if stall {
+				<-ctx.Done()
+				yield(nil, ctx.Err())
+				return
+			}
+		}
+	}
+}
+
+// fakes bundles the fake clients installed for a test
+type fakes struct {
+	drive     *fakeDrive
+	storage   *fakeStorage
+	generator *fakeGenerator
+}
+
+// useFakes installs fake clients and a temporary local folder, restoring the
+// package state when the test completes
+func useFakes(t *testing.T) *fakes {
+	t.Helper()
+	f := &fakes{
+		drive:     newFakeDrive(),
+		storage:   newFakeStorage(),
+		generator: &fakeGenerator{response: "A test description."},
+	}
+
+	prevDrive, prevStorage, prevGenerator, prevStats := driveSrv, storageSrv, genaiClient, stats
+	prevLocal, prevBucket, prevPath := localFolderName, gcsBucket, gcsFolderPath
+	prevFolder, prevDescribe, prevAlways := sourceFolderID, createDescription, alwaysUploadToGCS
+	prevRunID := runID
+	t.Cleanup(func() {
+		driveSrv, storageSrv, genaiClient, stats = prevDrive, prevStorage, prevGenerator, prevStats
+		localFolderName, gcsBucket, gcsFolderPath = prevLocal, prevBucket, prevPath
+		sourceFolderID, createDescription, alwaysUploadToGCS = prevFolder, prevDescribe, prevAlways
+		runID = prevRunID
+		folderNames.Clear()
+		folderParents.Clear()
+		fileLocations.Clear()
+		contentHashes.Clear()
+		editedDescriptions.Clear()
+		servedRegions.Clear()
+		refinedOutputs.Clear()
+		groundings.Clear()
+		taggings.Clear()
+		gcsSources.Clear()
+		animations.Clear()
+		extractedFiles.Clear()
+		zipArchives.Clear()
+		staleObjects.Clear()
+		piiFindings.Clear()
+		faceDetections.Clear()
+		speechLanguages.Clear()
+		fileTimelines.Clear()
+		bucketVersioning.Clear()
+		objectGenerations.Clear()
+		replicatedObjects.Clear()
+		localNames, objectNames = newNameRegistry(true, collisionDriveID), newNameRegistry(false, onCollision)
+	})
+
+	driveSrv, storageSrv, genaiClient = f.drive, f.storage, f.generator
+	stats = newRunStats()
+	localFolderName = t.TempDir()
+	gcsBucket = "test-bucket"
+	gcsFolderPath = ""
+	sourceFolderID = ""
+	createDescription = true
+	alwaysUploadToGCS = false
+	runID = "test-run"
+	return f
+}
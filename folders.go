@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+var recursive bool
+
+// fileLocation is where a file was found while listing
+type fileLocation struct {
+	folderID     string
+	relativePath string   // folder path relative to the source folder, slash separated
+	ancestors    []string // folder IDs from the source folder down to folderID
+}
+
+// fileLocations maps Drive file IDs to where they were found
+var fileLocations sync.Map
+
+// listFilesRecursive lists all the files in a Drive folder and its subfolders,
+// recording each file's folder path relative to folderID
+func listFilesRecursive(ctx context.Context, folderID string, mimeTypes []string) ([]drive.File, error) {
+	type pending struct {
+		id, relativePath string
+		ancestors        []string
+	}
+	queue := []pending{{id: folderID, ancestors: []string{folderID}}}
+	visited := map[string]bool{}
+
+	found := []drive.File{}
+	for len(queue) > 0 {
+		folder := queue[0]
+		queue = queue[1:]
+		if visited[folder.id] {
+			continue
+		}
+		visited[folder.id] = true
+
+		files, err := listFiles(ctx, folder.id, mimeTypes)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			fileLocations.Store(f.Id, fileLocation{folderID: folder.id, relativePath: folder.relativePath, ancestors: folder.ancestors})
+		}
+		found = append(found, files...)
+
+		subfolders, err := driveSrv.List(ctx, fmt.Sprintf("'%s' in parents and mimeType = '%s' and trashed = false", folder.id, folderMimeType))
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range subfolders {
+			folderNames.Store(sub.Id, sub.Name)
+			queue = append(queue, pending{
+				id:           sub.Id,
+				relativePath: path.Join(folder.relativePath, sanitizeObjectName(sub.Name)),
+				ancestors:    append(slices.Clip(folder.ancestors), sub.Id),
+			})
+		}
+	}
+	log.Printf("%s has %d files in %d folders", folderID, len(found), len(visited))
+	return found, nil
+}
+
+// folderParents caches the parent IDs of the Drive folders looked up by
+// locateInFolder
+var folderParents sync.Map
+
+// locateInFolder reports whether a file is in a folder or, with -recursive,
+// in its subfolders, found by walking up the file's ancestors, recording
+// where it is as listFilesRecursive does
+func locateInFolder(ctx context.Context, file drive.File, folderID string) (bool, error) {
+	if slices.Contains(file.Parents, folderID) {
+		return true, nil
+	}
+	if !recursive || len(file.Parents) == 0 {
+		return false, nil
+	}
+	// the folders from the file's up, until one is in folderID
+	ancestors := []string{file.Parents[0]}
+	for {
+		id := ancestors[len(ancestors)-1]
+		parents, err := parentsOf(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if slices.Contains(parents, folderID) {
+			break
+		}
+		if len(parents) == 0 || slices.Contains(ancestors, parents[0]) {
+			return false, nil
+		}
+		ancestors = append(ancestors, parents[0])
+	}
+	slices.Reverse(ancestors)
+	var relativePath string
+	for _, id := range ancestors {
+		relativePath = path.Join(relativePath, sanitizeObjectName(getFolderName(id)))
+	}
+	fileLocations.Store(file.Id, fileLocation{
+		folderID:     file.Parents[0],
+		relativePath: relativePath,
+		ancestors:    append([]string{folderID}, ancestors...),
+	})
+	return true, nil
+}
+
+// parentsOf returns the parent IDs of a Drive folder, caching them and its
+// name
+func parentsOf(ctx context.Context, folderID string) ([]string, error) {
+	if parents, ok := folderParents.Load(folderID); ok {
+		return parents.([]string), nil
+	}
+	folder, err := driveSrv.Get(ctx, folderID, "name,parents")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get folder %s: %v", folderID, err)
+	}
+	folderNames.Store(folderID, folder.Name)
+	folderParents.Store(folderID, folder.Parents)
+	return folder.Parents, nil
+}
+
+// relativePath returns the folder path of a file relative to the source folder
+func relativePath(file drive.File) string {
+	if loc, ok := fileLocations.Load(file.Id); ok {
+		return loc.(fileLocation).relativePath
+	}
+	return ""
+}
+
+// fileAncestors returns the IDs of the Drive folders containing a file; in
+// recursive mode these include every folder between it and the source folder
+func fileAncestors(file drive.File) []string {
+	if loc, ok := fileLocations.Load(file.Id); ok && len(loc.(fileLocation).ancestors) > 0 {
+		return loc.(fileLocation).ancestors
+	}
+	return file.Parents
+}
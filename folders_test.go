@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestListFilesRecursiveMirrorsFolders(t *testing.T) {
+	f := useFakes(t)
+	sourceFolderID = "root"
+	gcsFolderPath = "media"
+	f.drive.add("sub", "Summer/2025", folderMimeType, "root", nil)
+	f.drive.add("subsub", "Beach", folderMimeType, "sub", nil)
+	f.drive.add("1", "a.jpg", "image/jpeg", "root", []byte("a"))
+	f.drive.add("2", "a.jpg", "image/jpeg", "sub", []byte("b"))
+	f.drive.add("3", "c.png", "image/png", "subsub", []byte("c"))
+
+	files, err := listFilesRecursive(context.Background(), "root", []string{"image/jpeg", "image/png"})
+	if err != nil {
+		t.Fatalf("listFilesRecursive() error = %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("listFilesRecursive() = %d files, want 3", len(files))
+	}
+
+	want := map[string]struct{ folder, relative, object string }{
+		"1": {"root", "", "media/a.jpg"},
+		"2": {"sub", "Summer_2025", "media/Summer_2025/a.jpg"},
+		"3": {"subsub", "Summer_2025/Beach", "media/Summer_2025/Beach/c.png"},
+	}
+	for _, file := range files {
+		w := want[file.Id]
+		if got := fileFolderID(file); got != w.folder {
+			t.Errorf("fileFolderID(%s) = %q, want %q", file.Id, got, w.folder)
+		}
+		if got := relativePath(file); got != w.relative {
+			t.Errorf("relativePath(%s) = %q, want %q", file.Id, got, w.relative)
+		}
+		name, err := objectName(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := objectPath(gcsFolderPath, name); got != w.object {
+			t.Errorf("object path of %s = %q, want %q", file.Id, got, w.object)
+		}
+	}
+	if got := getFolderName("subsub"); got != "Beach" {
+		t.Errorf("getFolderName() = %q, want Beach", got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// describeFromGCS describes files whose object was already uploaded, with
+// the same contents, from their gs:// URI, without downloading them from Drive
+var describeFromGCS bool
+
+// gcsSources holds the gs:// URI that each of this run's files is described
+// from instead of its contents, by Drive file ID
+var gcsSources sync.Map
+
+// gcsSource returns the gs:// URI of a file's object when nothing needs its
+// contents locally: the object exists with the Drive file's MD5 checksum and
+// is not uploaded again, and the file is neither scanned, watermarked, passed
+// to a pre-upload hook, content addressed nor has its faces blurred
+func gcsSource(ctx context.Context, file drive.File) (string, bool) {
+	if !describeFromGCS || !describableFromObject(file) {
+		return "", false
+	}
+	name, err := objectName(file)
+	if err != nil {
+		return "", false
+	}
+	dest := routeFor(file)
+	object := objectPath(dest.Prefix, name)
+	attrs, err := storageSrv.Attrs(ctx, dest.Bucket, object)
+	if err != nil || hex.EncodeToString(attrs.MD5) != file.Md5Checksum {
+		return "", false
+	}
+	return fmt.Sprintf("gs://%s/%s", dest.Bucket, object), true
+}
+
+// describableFromObject reports whether a file can be described from its
+// object, as nothing else needs its contents locally, such as the replicas
+func describableFromObject(file drive.File) bool {
+	if backend == backendGeminiAPI || alwaysUploadToGCS || file.Md5Checksum == "" || len(replicaBuckets) > 0 {
+		return false
+	}
+	return objectLayout == layoutPath && clamdAddress == "" && preUploadHook == "" && !watermarks(file.MimeType) && !blurFaces
+}
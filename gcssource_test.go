@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDescribeFromGCS(t *testing.T) {
+	f := useFakes(t)
+	describeFromGCS = true
+	defer func() { describeFromGCS = false }()
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+	ctx := context.Background()
+
+	processFile(ctx, *f.drive.files["a"], "a.jpg")
+	if f.drive.downloads != 1 || f.storage.uploads != 1 {
+		t.Fatalf("downloads, uploads = %d, %d, want the first run to download and upload", f.drive.downloads, f.storage.uploads)
+	}
+
+	// a rerun describes the uploaded object without downloading it
+	r := processFile(ctx, *f.drive.files["a"], "a.jpg")
+	if f.drive.downloads != 1 || f.storage.uploads != 1 || r.Description != f.generator.response {
+		t.Errorf("downloads, uploads = %d, %d, description %q, want the object described", f.drive.downloads, f.storage.uploads, r.Description)
+	}
+	part := f.generator.contents[0].Parts[0]
+	if part.FileData == nil || part.FileData.FileURI != "gs://test-bucket/a.jpg" || part.InlineData != nil {
+		t.Errorf("part = %+v, want the object's gs:// URI", part)
+	}
+
+	// a file written to replica buckets is downloaded
+	gcsSources.Clear()
+	replicaBuckets = []string{"replica-bucket"}
+	processFile(ctx, *f.drive.files["a"], "a.jpg")
+	replicaBuckets = nil
+	if f.drive.downloads != 2 {
+		t.Errorf("downloads = %d, want the file downloaded for its replicas", f.drive.downloads)
+	}
+
+	// a changed file is downloaded
+	gcsSources.Clear()
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("changed"))
+	processFile(ctx, *f.drive.files["a"], "a.jpg")
+	if f.drive.downloads != 3 {
+		t.Errorf("downloads = %d, want the changed file downloaded", f.drive.downloads)
+	}
+
+	// a failed description downloads the file to quarantine it
+	gcsSources.Clear()
+	f.drive.add("b", "b.jpg", "image/jpeg", "root", []byte("b"))
+	f.storage.Upload(ctx, "test-bucket", "b.jpg", []byte("b"), objectAttrs(*f.drive.files["b"]))
+	f.generator.err = errors.New("blocked")
+	r = processFile(ctx, *f.drive.files["b"], "b.jpg")
+	if f.drive.downloads != 4 || !r.NeedsReview {
+		t.Errorf("downloads = %d, record = %+v, want the file downloaded and quarantined", f.drive.downloads, r)
+	}
+	if _, ok := f.storage.attrs["test-bucket/needs-review/b.jpg"]; !ok {
+		t.Errorf("objects = %v, want b.jpg quarantined", sortedKeys(f.storage.attrs))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"google.golang.org/genai"
+)
+
+// searchGrounding grounds descriptions with Google Search, so landmarks,
+// products and artworks can be named from verified sources
+var searchGrounding bool
+
+// citation is a web source a grounded description was based on
+type citation struct {
+	Title string `json:"title,omitempty"`
+	URI   string `json:"uri"`
+	// Segments are the parts of the description the source supports
+	Segments []string `json:"segments,omitempty"`
+}
+
+// grounding is the Google Search grounding of a description
+type grounding struct {
+	Citations     []citation
+	SearchQueries []string
+}
+
+// groundings holds the grounding of this run's descriptions, by Drive file ID
+var groundings sync.Map
+
+// groundingOf returns the web sources and search queries a response was
+// grounded with, if any
+func groundingOf(resp *genai.GenerateContentResponse) (grounding, bool) {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].GroundingMetadata == nil {
+		return grounding{}, false
+	}
+	m := resp.Candidates[0].GroundingMetadata
+	g := grounding{SearchQueries: m.WebSearchQueries}
+	index := map[int]int{} // chunk index to citation index
+	for i, chunk := range m.GroundingChunks {
+		if chunk == nil || chunk.Web == nil || chunk.Web.URI == "" {
+			continue
+		}
+		index[i] = len(g.Citations)
+		g.Citations = append(g.Citations, citation{Title: chunk.Web.Title, URI: chunk.Web.URI})
+	}
+	for _, support := range m.GroundingSupports {
+		if support == nil || support.Segment == nil || support.Segment.Text == "" {
+			continue
+		}
+		for _, i := range support.GroundingChunkIndices {
+			if c, ok := index[int(i)]; ok {
+				g.Citations[c].Segments = append(g.Citations[c].Segments, support.Segment.Text)
+			}
+		}
+	}
+	return g, len(g.Citations) > 0 || len(g.SearchQueries) > 0
+}
+
+// formatCitations formats citations for a CSV column as their URIs
+// separated by semicolons
+func formatCitations(citations []citation) string {
+	uris := make([]string, len(citations))
+	for i, c := range citations {
+		uris[i] = c.URI
+	}
+	return strings.Join(uris, ";")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestSearchGrounding(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev bool) { searchGrounding = prev }(searchGrounding)
+	if config := generateConfig(); len(config.Tools) != 0 {
+		t.Errorf("tools = %v, want none", config.Tools)
+	}
+	searchGrounding = true
+	f.generator.response = "The Eiffel Tower at dusk."
+	f.generator.grounding = &genai.GroundingMetadata{
+		WebSearchQueries: []string{"iron lattice tower paris"},
+		GroundingChunks: []*genai.GroundingChunk{
+			{Web: &genai.GroundingChunkWeb{Title: "Eiffel Tower", URI: "https://example.com/eiffel"}},
+			{RetrievedContext: &genai.GroundingChunkRetrievedContext{}},
+			{Web: &genai.GroundingChunkWeb{Title: "Paris", URI: "https://example.com/paris"}},
+		},
+		GroundingSupports: []*genai.GroundingSupport{
+			{Segment: &genai.Segment{Text: "The Eiffel Tower"}, GroundingChunkIndices: []int32{0, 1}},
+		},
+	}
+	f.drive.add("a", "tower.jpg", "image/jpeg", "root", []byte("a"))
+
+	r := processFile(context.Background(), *f.drive.files["a"], "tower.jpg")
+	if tools := f.generator.config.Tools; len(tools) != 1 || tools[0].GoogleSearch == nil {
+		t.Errorf("tools = %v, want Google Search", tools)
+	}
+	if len(r.Citations) != 2 || r.Citations[0].URI != "https://example.com/eiffel" || len(r.Citations[0].Segments) != 1 || len(r.Citations[1].Segments) != 0 {
+		t.Errorf("citations = %+v", r.Citations)
+	}
+	if len(r.SearchQueries) != 1 {
+		t.Errorf("search queries = %v", r.SearchQueries)
+	}
+	if got, want := columns["citations"](r), "https://example.com/eiffel;https://example.com/paris"; got != want {
+		t.Errorf("citations column = %q, want %q", got, want)
+	}
+
+	if _, ok := groundingOf(&genai.GenerateContentResponse{Candidates: []*genai.Candidate{{}}}); ok {
+		t.Error("groundingOf without metadata = true")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// hook commands, run with the shell for each file
+var (
+	preUploadHook    string
+	postDescribeHook string
+	postFileHook     string
+)
+
+// hook names, also used as the stats categories of hook failures
+const (
+	hookPreUpload    = "pre-upload"
+	hookPostDescribe = "post-describe"
+	hookPostFile     = "post-file"
+)
+
+// hookTimeout bounds each hook command
+const hookTimeout = 10 * time.Minute
+
+// hookEvent is the JSON written to a hook command's stdin
+type hookEvent struct {
+	Hook      string `json:"hook"`
+	RunID     string `json:"run_id"`
+	DriveID   string `json:"drive_id"`
+	Name      string `json:"name"`
+	MimeType  string `json:"mime_type"`
+	LocalPath string `json:"local_path,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	// ObjectPath is where the file is, or is about to be, uploaded
+	ObjectPath  string  `json:"object_path,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Record      *record `json:"record,omitempty"`
+}
+
+// hookError is returned when a hook command fails
+type hookError struct {
+	hook string
+	err  error
+}
+
+func (e *hookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.hook, e.err)
+}
+
+func (e *hookError) Unwrap() error {
+	return e.err
+}
+
+// newHookEvent returns the event for a hook on a file
+func newHookEvent(hook string, file drive.File) hookEvent {
+	return hookEvent{Hook: hook, RunID: runID, DriveID: file.Id, Name: file.Name, MimeType: file.MimeType}
+}
+
+// runHook runs a hook command with the event as JSON on stdin, returning its
+// stdout; a non-zero exit status is an error. The hook's stderr is passed
+// through to the log.
+func runHook(ctx context.Context, command string, event hookEvent) (string, error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal %s hook event: %v", event.Hook, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	start := time.Now()
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "DTG_HOOK="+event.Hook, "DTG_RUN_ID="+runID)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err = cmd.Run()
+	observeFile(event.DriveID, event.Hook, start)
+	if err != nil {
+		return "", &hookError{hook: event.Hook, err: err}
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// shellCommand returns a command running command with the platform shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
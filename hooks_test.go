@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands use sh")
+	}
+	f := useFakes(t)
+	prevPre, prevDescribe, prevFile := preUploadHook, postDescribeHook, postFileHook
+	defer func() { preUploadHook, postDescribeHook, postFileHook = prevPre, prevDescribe, prevFile }()
+	events := filepath.Join(t.TempDir(), "events.jsonl")
+	preUploadHook = `in=$(cat); case "$in" in *bad.jpg*) exit 1;; esac; echo "$in" >> ` + events
+	postDescribeHook = `echo "Reviewed: $DTG_HOOK"`
+	postFileHook = `cat >> ` + events + `; echo >> ` + events
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+	f.drive.add("2", "bad.jpg", "image/jpeg", "folder1", []byte("b"))
+
+	r := processFile(context.Background(), drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}, "a.jpg")
+	if r.Description != "Reviewed: post-describe" {
+		t.Errorf("description = %q, want post-describe hook output", r.Description)
+	}
+	if _, ok := f.storage.objects["test-bucket/a.jpg"]; !ok {
+		t.Error("a.jpg not uploaded")
+	}
+
+	r = processFile(context.Background(), drive.File{Id: "2", Name: "bad.jpg", MimeType: "image/jpeg"}, "bad.jpg")
+	if _, ok := f.storage.objects["test-bucket/bad.jpg"]; ok {
+		t.Error("bad.jpg uploaded, want skipped by pre-upload hook")
+	}
+	if !strings.HasPrefix(r.Description, "Error: pre-upload hook failed") {
+		t.Errorf("description = %q, want pre-upload hook error", r.Description)
+	}
+	if failed := stats.summary().FailedFiles; len(failed) != 1 || failed[0].Stage != hookPreUpload {
+		t.Errorf("failed files = %+v, want bad.jpg at pre-upload", failed)
+	}
+
+	b, err := os.ReadFile(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"hook":"pre-upload"`, `"local_path":"`, `"object_path":"a.jpg"`, `"hook":"post-file"`, `"record":{`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("hook events missing %s:\n%s", want, b)
+		}
+	}
+}
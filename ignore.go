@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// defaultIgnoreFile is the ignore file read if it exists
+const defaultIgnoreFile = ".dtgignore"
+
+// ignoreFile is the -ignore file of files to skip
+var ignoreFile string = defaultIgnoreFile
+
+// ignorePatterns are the patterns read from ignoreFile
+var ignorePatterns []string
+
+// readIgnoreFile reads an ignore file, one pattern per line, skipping blank
+// lines and # comments. A missing file is no patterns, unless required.
+func readIgnoreFile(name string, required bool) ([]string, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open ignore file: %w", err)
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(strings.ToLower(line), ""); err != nil {
+			return nil, fmt.Errorf("ignore file %s: invalid pattern %q", name, line)
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read ignore file %s: %v", name, err)
+	}
+	return patterns, nil
+}
+
+// ignored returns the ignore pattern matching a file: its Drive ID, a case
+// insensitive glob of its name such as *.psd, or a glob containing a slash of
+// its path relative to the source folder in recursive mode, such as Raw/*
+func ignored(file drive.File) (string, bool) {
+	name := strings.ToLower(file.Name)
+	rel := strings.ToLower(path.Join(relativePath(file), file.Name))
+	for _, p := range ignorePatterns {
+		if p == file.Id {
+			return p, true
+		}
+		target := name
+		if strings.Contains(p, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(strings.ToLower(p), target); ok {
+			return p, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), ".dtgignore")
+	contents := "# noise\n*.PSD\nThumbs.db\n\nRaw/*\n1AbCdEfGhIjKlMnOpQrStUvWxYz\n"
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := readIgnoreFile(name, true)
+	if err != nil || len(patterns) != 4 {
+		t.Fatalf("readIgnoreFile = %q, %v, want 4 patterns", patterns, err)
+	}
+	if _, err := readIgnoreFile(filepath.Join(t.TempDir(), "missing"), false); err != nil {
+		t.Errorf("readIgnoreFile(missing, optional) = %v", err)
+	}
+	if _, err := readIgnoreFile(filepath.Join(t.TempDir(), "missing"), true); err == nil {
+		t.Error("readIgnoreFile(missing, required) succeeded")
+	}
+
+	f := useFakes(t)
+	defer func() { ignorePatterns = nil }()
+	ignorePatterns = patterns
+	f.drive.add("a", "layers.psd", "image/png", "root", []byte("a"))
+	f.drive.add("b", "thumbs.db", "image/png", "root", []byte("b"))
+	f.drive.add("1AbCdEfGhIjKlMnOpQrStUvWxYz", "secret.png", "image/png", "root", []byte("c"))
+	f.drive.add("d", "keep.png", "image/png", "root", []byte("d"))
+	f.drive.add("raw", "shot.png", "image/png", "sub", []byte("e"))
+	f.drive.add("sub", "Raw", folderMimeType, "root", nil)
+	files, err := listFilesRecursive(context.Background(), "root", []string{"image/png"})
+	if err != nil || len(files) != 5 {
+		t.Fatalf("listFilesRecursive = %d files, %v, want 5", len(files), err)
+	}
+	for _, file := range files {
+		pattern, ok := ignored(file)
+		if want := file.Id != "d"; ok != want {
+			t.Errorf("ignored(%s) = %q, %t, want %t", file.Name, pattern, ok, want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/genai"
+)
+
+// emulator is a stub HTTP server standing in for the Drive, Cloud Storage and
+// Gemini APIs, used with the real API clients
+type emulator struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	drive    *fakeDrive
+	objects  map[string][]byte
+	pageSize int
+	pages    int
+	failures map[string]int // remaining failures to inject, by request path
+	requests map[string]int // requests received, by method and path
+}
+
+func newEmulator(t *testing.T) *emulator {
+	t.Helper()
+	e := &emulator{
+		drive:    newFakeDrive(),
+		objects:  map[string][]byte{},
+		pageSize: 2,
+		failures: map[string]int{},
+		requests: map[string]int{},
+	}
+	e.Server = httptest.NewServer(http.HandlerFunc(e.serveHTTP))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// failNext makes the next n requests to path fail with a 503
+func (e *emulator) failNext(path string, n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures[path] = n
+}
+
+func (e *emulator) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	e.requests[r.Method+" "+r.URL.Path]++
+	if e.failures[r.URL.Path] > 0 {
+		e.failures[r.URL.Path]--
+		e.mu.Unlock()
+		http.Error(w, `{"error": {"code": 503, "message": "try again"}}`, http.StatusServiceUnavailable)
+		return
+	}
+	e.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/drive/v3/files"):
+		e.serveDrive(w, r)
+	case strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/"):
+		e.serveUpload(w, r)
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/"):
+		e.serveObject(w, r)
+	case strings.HasSuffix(r.URL.Path, ":generateContent"):
+		e.serveGenerate(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *emulator) serveDrive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/drive/v3/files"), "/")
+	if id == "" {
+		files, _ := e.drive.List(ctx, r.URL.Query().Get("q"))
+		start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		end := min(start+e.pageSize, len(files))
+		page := drive.FileList{Files: files[start:end]}
+		if end < len(files) {
+			page.NextPageToken = strconv.Itoa(end)
+		}
+		e.mu.Lock()
+		e.pages++
+		e.mu.Unlock()
+		writeJSON(w, page)
+		return
+	}
+	if r.URL.Query().Get("alt") == "media" {
+		body, err := e.drive.Download(ctx, id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer body.Close()
+		io.Copy(w, body)
+		return
+	}
+	f, err := e.drive.Get(ctx, id, "")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, f)
+}
+
+var objectPathPattern = regexp.MustCompile(`^/storage/v1/b/([^/]+)/o/(.+)$`)
+
+func (e *emulator) serveObject(w http.ResponseWriter, r *http.Request) {
+	m := objectPathPattern.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	e.mu.Lock()
+	data, ok := e.objects[m[1]+"/"+m[2]]
+	e.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]any{"error": map[string]any{"code": 404, "message": "No such object"}})
+		return
+	}
+	writeJSON(w, map[string]any{"bucket": m[1], "name": m[2], "size": strconv.Itoa(len(data))})
+}
+
+func (e *emulator) serveUpload(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/upload/storage/v1/b/"), "/o")
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	var attrs struct {
+		Name string `json:"name"`
+	}
+	part, err := reader.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&attrs)
+	}
+	if err == nil {
+		part, err = reader.NextPart()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(part)
+
+	e.mu.Lock()
+	e.objects[bucket+"/"+attrs.Name] = data
+	e.mu.Unlock()
+	writeJSON(w, map[string]any{"bucket": bucket, "name": attrs.Name, "size": strconv.Itoa(len(data))})
+}
+
+func (e *emulator) serveGenerate(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"candidates": []any{
+			map[string]any{"content": map[string]any{"role": "model", "parts": []any{map[string]any{"text": "Described by the emulator."}}}},
+		},
+		"usageMetadata": map[string]any{"promptTokenCount": 5, "candidatesTokenCount": 2, "totalTokenCount": 7},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// useEmulator installs real Drive, Cloud Storage and genai clients pointed at
+// a new emulator
+func useEmulator(t *testing.T) *emulator {
+	t.Helper()
+	useFakes(t)
+	e := newEmulator(t)
+	ctx := context.Background()
+
+	srv, err := drive.NewService(ctx, option.WithEndpoint(e.URL+"/drive/v3/"), option.WithHTTPClient(e.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	driveSrv = &driveService{srv: srv}
+
+	gcsClient, err := storage.NewClient(ctx, option.WithEndpoint(e.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gcsClient.Close() })
+	storageSrv = &gcsStorage{client: gcsClient}
+
+	gc, err := genai.NewClient(ctx, &genai.ClientConfig{
+		Project:     "test-project",
+		Location:    "us-central1",
+		Backend:     genai.BackendVertexAI,
+		Credentials: &google.Credentials{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test"})},
+		HTTPClient:  e.Client(),
+		HTTPOptions: genai.HTTPOptions{BaseURL: e.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genaiClient = gc.Models
+	return e
+}
+
+func TestIntegrationPipeline(t *testing.T) {
+	e := useEmulator(t)
+	ctx := context.Background()
+	sourceFolderID = "folder1"
+	gcsFolderPath = "media"
+	e.drive.add("folder1", "Folder One", "application/vnd.google-apps.folder", "root", nil)
+	for i := 1; i <= 5; i++ {
+		e.drive.add(fmt.Sprintf("img%d", i), fmt.Sprintf("img%d.jpg", i), "image/jpeg", "folder1", []byte(fmt.Sprintf("bytes %d", i)))
+	}
+	e.drive.add("doc", "notes.pdf", "application/pdf", "folder1", []byte("pdf"))
+	// an object that already exists is not uploaded again
+	e.objects["test-bucket/media/img1.jpg"] = []byte("existing")
+	// the first existence check fails and is retried by the storage client
+	e.failNext("/storage/v1/b/test-bucket/o/media/img2.jpg", 1)
+
+	files, err := listFiles(ctx, sourceFolderID, []string{"image/jpeg"})
+	if err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	if len(files) != 5 || e.pages != 3 {
+		t.Fatalf("listFiles() = %d files in %d pages, want 5 files in 3 pages", len(files), e.pages)
+	}
+
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	output, err := newCSVRecordWriter(path, []string{"drive_id", "folder_name", "gcs_uri", "description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan drive.File)
+	go func() {
+		defer close(ch)
+		for _, f := range files {
+			ch <- f
+		}
+	}()
+	processFiles(ctx, ch, output)
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readCSV(t, path)
+	if len(rows) != 6 {
+		t.Fatalf("CSV has %d rows, want header and 5 records", len(rows))
+	}
+	var ids []string
+	for _, row := range rows[1:] {
+		ids = append(ids, row[0])
+		if row[1] != "Folder One" || row[3] != "Described by the emulator." {
+			t.Errorf("CSV row = %v", row)
+		}
+	}
+	slices.Sort(ids)
+	if strings.Join(ids, ",") != "img1,img2,img3,img4,img5" {
+		t.Errorf("CSV ids = %v", ids)
+	}
+	if got := string(e.objects["test-bucket/media/img1.jpg"]); got != "existing" {
+		t.Errorf("existing object overwritten with %q", got)
+	}
+	if got := string(e.objects["test-bucket/media/img2.jpg"]); got != "bytes 2" {
+		t.Errorf("retried object = %q, want %q", got, "bytes 2")
+	}
+	if got := e.requests["GET /storage/v1/b/test-bucket/o/media/img2.jpg"]; got != 2 {
+		t.Errorf("existence check made %d requests, want 2 with a retry", got)
+	}
+
+	summary := stats.summary()
+	if summary.Files != 5 || summary.TotalTokens != 35 || len(summary.Failures) != 0 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// The inventory status of an object, or of a catalog entry without one
+const (
+	inventoryCataloged   = "cataloged"   // a catalog entry's object, original or revision
+	inventorySidecar     = "sidecar"     // the sidecar of a cataloged object
+	inventoryUncataloged = "uncataloged" // not in the catalog
+	inventoryMissing     = "missing"     // in the catalog, but not in the bucket
+)
+
+// inventoryColumns are the columns of the inventory CSV
+var inventoryColumns = []string{"status", "bucket", "object", "size", "content_type", "storage_class", "updated", "drive_id", "run_id", "review_status"}
+
+// The -catalog and -out of the inventory command
+var (
+	inventoryCatalog string = catalogFile
+	inventoryOut     string = "inventory.csv"
+)
+
+// inventoryFlags registers the inventory command's flags
+func inventoryFlags() {
+	flag.StringVar(&inventoryCatalog, "catalog", inventoryCatalog, "the CSV catalog to cross-reference, empty for none")
+	flag.StringVar(&inventoryOut, "out", inventoryOut, "path to write the inventory CSV")
+}
+
+// runInventory runs drivetogcs inventory gs://bucket/prefix, listing the
+// objects under a prefix and cross-referencing them against the catalog
+func runInventory(ctx context.Context, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs inventory [-catalog descriptions.csv] [-out inventory.csv] gs://bucket/prefix")
+		return exitFailure
+	}
+	bucket, prefix, err := parseGCSURI(args[0])
+	if err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+
+	opts, err := storageOptions(ctx)
+	if err != nil {
+		log.Printf("unable to read -storage-credentials: %v", err)
+		return exitFailure
+	}
+	gcsClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		log.Printf("unable to create storage client: %v", err)
+		return exitFailure
+	}
+	defer gcsClient.Close()
+	storageSrv = &gcsStorage{client: gcsClient}
+
+	counts, err := writeInventory(ctx, bucket, prefix, inventoryCatalog, inventoryOut)
+	if err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	log.Printf("inventory of gs://%s/%s written to %s: %d cataloged, %d sidecars, %d uncataloged, %d missing",
+		bucket, prefix, inventoryOut, counts[inventoryCataloged], counts[inventorySidecar], counts[inventoryUncataloged], counts[inventoryMissing])
+	if counts[inventoryMissing] > 0 {
+		return exitPartial
+	}
+	return exitSuccess
+}
+
+// parseGCSURI splits gs://bucket/prefix into its bucket and prefix
+func parseGCSURI(uri string) (string, string, error) {
+	rest, ok := strings.CutPrefix(uri, "gs://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return "", "", fmt.Errorf("invalid Cloud Storage URI %q, expected gs://bucket/prefix", uri)
+	}
+	return bucket, prefix, nil
+}
+
+// writeInventory writes the inventory CSV of the objects under a prefix,
+// followed by the catalog entries under it without an object, returning the
+// count of each status
+func writeInventory(ctx context.Context, bucket, prefix, catalogPath, out string) (map[string]int, error) {
+	objects, err := storageSrv.List(ctx, bucket, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list gs://%s/%s: %w", bucket, prefix, err)
+	}
+
+	// the catalog's objects in the bucket, and the Drive file of each
+	cataloged := map[string]string{}
+	if catalogPath != "" {
+		t, err := readCatalog(catalogPath)
+		if err != nil {
+			return nil, err
+		}
+		if !t.has("object_path") {
+			return nil, fmt.Errorf("catalog %s has no object_path column", catalogPath)
+		}
+		for _, row := range t.rows {
+			if b := t.get(row, "bucket"); b != "" && b != bucket {
+				continue
+			}
+			id := t.get(row, "drive_id")
+			paths := append([]string{t.get(row, "object_path"), t.get(row, "original_path")}, strings.Split(t.get(row, "revisions"), ";")...)
+			for _, p := range paths {
+				if p != "" {
+					cataloged[p] = id
+				}
+			}
+		}
+	}
+
+	f, err := createFile(out)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create inventory: %v", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write(inventoryColumns)
+
+	counts := map[string]int{}
+	found := map[string]bool{}
+	for _, o := range objects {
+		found[o.Name] = true
+		status := inventoryUncataloged
+		id, ok := cataloged[o.Name]
+		if ok {
+			status = inventoryCataloged
+		} else if object, isSidecar := strings.CutSuffix(o.Name, ".json"); isSidecar {
+			if id, ok = cataloged[object]; ok {
+				status = inventorySidecar
+			}
+		}
+		counts[status]++
+		w.Write([]string{status, bucket, o.Name, fmt.Sprint(o.Size), o.ContentType, o.StorageClass, o.Updated.UTC().Format(time.RFC3339),
+			id, o.Metadata[runIDKey], o.Metadata[reviewStatusKey]})
+	}
+	for _, object := range sortedKeys(cataloged) {
+		if found[object] || !strings.HasPrefix(object, prefix) {
+			continue
+		}
+		counts[inventoryMissing]++
+		w.Write([]string{inventoryMissing, bucket, object, "", "", "", "", cataloged[object], "", ""})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("unable to write inventory: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("unable to write inventory: %v", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestWriteInventory(t *testing.T) {
+	f := useFakes(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	catalog := filepath.Join(dir, "descriptions.csv")
+	contents := "drive_id,bucket,object_path,revisions\n" +
+		"1,test-bucket,media/a.jpg,media/a.jpg/revisions/r1\n" +
+		"2,test-bucket,media/b.jpg,\n" +
+		"3,other-bucket,media/c.jpg,\n" +
+		"4,test-bucket,elsewhere/d.jpg,\n"
+	if err := os.WriteFile(catalog, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"media/a.jpg", "media/a.jpg.json", "media/a.jpg/revisions/r1", "media/stray.jpg"} {
+		f.storage.Upload(ctx, "test-bucket", name, []byte("x"), storage.ObjectAttrs{Metadata: map[string]string{runIDKey: "run-1"}})
+	}
+
+	out := filepath.Join(dir, "inventory.csv")
+	counts, err := writeInventory(ctx, "test-bucket", "media/", catalog, out)
+	if err != nil {
+		t.Fatalf("writeInventory: %v", err)
+	}
+	if counts[inventoryCataloged] != 2 || counts[inventorySidecar] != 1 || counts[inventoryUncataloged] != 1 || counts[inventoryMissing] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	table, err := readCatalog(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"media/a.jpg":              inventoryCataloged,
+		"media/a.jpg.json":         inventorySidecar,
+		"media/a.jpg/revisions/r1": inventoryCataloged,
+		"media/stray.jpg":          inventoryUncataloged,
+		"media/b.jpg":              inventoryMissing,
+	}
+	if len(table.rows) != len(want) {
+		t.Errorf("inventory has %d rows, want %d", len(table.rows), len(want))
+	}
+	for _, row := range table.rows {
+		object := table.get(row, "object")
+		if got := table.get(row, "status"); got != want[object] {
+			t.Errorf("%s status = %q, want %q", object, got, want[object])
+		}
+		if object == "media/a.jpg" && (table.get(row, "drive_id") != "1" || table.get(row, "run_id") != "run-1" || table.get(row, "size") != "1") {
+			t.Errorf("media/a.jpg row = %q", row)
+		}
+	}
+}
+
+func TestParseGCSURI(t *testing.T) {
+	if bucket, prefix, err := parseGCSURI("gs://my-media/photos/2024"); err != nil || bucket != "my-media" || prefix != "photos/2024" {
+		t.Errorf("parseGCSURI = %q, %q, %v", bucket, prefix, err)
+	}
+	if bucket, prefix, err := parseGCSURI("gs://my-media"); err != nil || bucket != "my-media" || prefix != "" {
+		t.Errorf("parseGCSURI(bucket) = %q, %q, %v", bucket, prefix, err)
+	}
+	for _, uri := range []string{"my-media/photos", "gs://", "s3://my-media"} {
+		if _, _, err := parseGCSURI(uri); err == nil {
+			t.Errorf("parseGCSURI(%q) succeeded", uri)
+		}
+	}
+}
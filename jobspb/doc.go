@@ -0,0 +1,5 @@
+// Package jobspb is the gRPC API of drivetogcs serve, generated from
+// jobs.proto.
+package jobspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative jobs.proto
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The flags of the k8s-jobs command
+var (
+	kubeShards         int
+	kubeImage          string
+	kubeJobName        string = "drivetogcs"
+	kubeNamespace      string
+	kubeServiceAccount string
+	kubeSecret         string
+	kubeMemory         string
+	kubeBackoffLimit   int = 3
+)
+
+// kubeFlags are the k8s-jobs command's own flags, not passed on to the runs
+var kubeFlags = []string{"shards", "image", "job-name", "namespace", "k8s-service-account", "k8s-secret", "k8s-memory", "k8s-backoff-limit"}
+
+// kubeNamePattern matches a Job name prefix, leaving room for the shard
+// suffix within the 63 characters of a label value
+var kubeNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,40}[a-z0-9])?$`)
+
+// kubeJobsFlags registers the k8s-jobs command's flags
+func kubeJobsFlags() {
+	flag.IntVar(&kubeShards, "shards", kubeShards, "the shards to split the migration into, one Job each")
+	flag.StringVar(&kubeImage, "image", kubeImage, "the drivetogcs container image the Jobs run")
+	flag.StringVar(&kubeJobName, "job-name", kubeJobName, "the prefix of the Job names, followed by the shard")
+	flag.StringVar(&kubeNamespace, "namespace", kubeNamespace, "the namespace of the Jobs, empty for the current one")
+	flag.StringVar(&kubeServiceAccount, "k8s-service-account", kubeServiceAccount, "the Kubernetes service account the Jobs run as, such as one bound to a Google service account with Workload Identity")
+	flag.StringVar(&kubeSecret, "k8s-secret", kubeSecret, "a Secret whose keys are set as environment variables of the runs, such as GOOGLE_CREDENTIALS")
+	flag.StringVar(&kubeMemory, "k8s-memory", kubeMemory, "the memory request and limit of each run, such as 2Gi, which the runs keep under with -memory-limit auto")
+	flag.IntVar(&kubeBackoffLimit, "k8s-backoff-limit", kubeBackoffLimit, "the times a failed shard is retried")
+}
+
+// kubeJob is a Kubernetes batch/v1 Job, with the fields the manifests set
+type kubeJob struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   kubeMetadata `json:"metadata"`
+	Spec       kubeJobSpec  `json:"spec"`
+}
+
+type kubeMetadata struct {
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels"`
+}
+
+type kubeJobSpec struct {
+	BackoffLimit int             `json:"backoffLimit"`
+	Template     kubePodTemplate `json:"template"`
+}
+
+type kubePodTemplate struct {
+	Metadata kubeMetadata `json:"metadata"`
+	Spec     kubePodSpec  `json:"spec"`
+}
+
+type kubePodSpec struct {
+	RestartPolicy      string          `json:"restartPolicy"`
+	ServiceAccountName string          `json:"serviceAccountName,omitempty"`
+	Containers         []kubeContainer `json:"containers"`
+}
+
+type kubeContainer struct {
+	Name      string         `json:"name"`
+	Image     string         `json:"image"`
+	Args      []string       `json:"args"`
+	EnvFrom   []kubeEnvFrom  `json:"envFrom,omitempty"`
+	Resources *kubeResources `json:"resources,omitempty"`
+}
+
+type kubeEnvFrom struct {
+	SecretRef struct {
+		Name string `json:"name"`
+	} `json:"secretRef"`
+}
+
+type kubeResources struct {
+	Requests map[string]string `json:"requests"`
+	Limits   map[string]string `json:"limits"`
+}
+
+// runKubeJobs runs drivetogcs k8s-jobs -shards n -image image [flags],
+// printing a Kubernetes Job per shard of the migration the flags describe, to
+// kubectl apply, so a large migration is split across a cluster
+func runKubeJobs(ctx context.Context, args []string) int {
+	if len(args) > 0 || kubeShards < 1 || kubeImage == "" {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs k8s-jobs -shards n -image image [-job-name drivetogcs] [-namespace ns] [-k8s-service-account sa] [-k8s-secret secret] [-k8s-memory 2Gi] -folder id [flags of the runs]")
+		return exitFailure
+	}
+	if err := checkKubeFlags(); err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	list := struct {
+		APIVersion string    `json:"apiVersion"`
+		Kind       string    `json:"kind"`
+		Items      []kubeJob `json:"items"`
+	}{"v1", "List", kubeJobs(kubeArgs(flag.CommandLine))}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err := out.Encode(list); err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	log.Printf("%d Jobs of run %s; create them with kubectl apply -f", kubeShards, runID)
+	return exitSuccess
+}
+
+// checkKubeFlags checks the flags describe a migration Jobs can run
+func checkKubeFlags() error {
+	switch {
+	case !kubeNamePattern.MatchString(kubeJobName):
+		return fmt.Errorf("invalid -job-name %q, expected up to 42 lowercase letters, digits and hyphens", kubeJobName)
+	case sourceFolderID == "" && manifestFile == "":
+		return errors.New("please provide a Drive folder with -folder, or a -manifest in the image")
+	case readStdin:
+		return errors.New("-stdin can't be read by Jobs")
+	case shardSpec != "":
+		return errors.New("-shard is set on each Job from -shards")
+	}
+	return nil
+}
+
+// kubeArgs returns the flags set on the command line to pass on to the runs,
+// with the run ID, so the shards are one run, and the project and locations,
+// which may have come from the environment
+func kubeArgs(fs *flag.FlagSet) []string {
+	var args []string
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		switch {
+		case f.Name == "refine", slices.Contains(kubeFlags, f.Name):
+		default:
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	// -refine repeats, and its values are kept in refinements
+	for _, r := range refinements {
+		args = append(args, "-refine="+r.Name+"="+r.Prompt)
+	}
+	if !set["run-id"] {
+		args = append(args, "-run-id="+runID)
+	}
+	if !set["project"] && projectID != "" {
+		args = append(args, "-project="+projectID)
+	}
+	if !set["location"] && len(locations) > 0 {
+		args = append(args, "-location="+strings.Join(locations, ","))
+	}
+	if !set["yes"] {
+		args = append(args, "-yes") // no one confirms a Job
+	}
+	if kubeMemory != "" && !set["memory-limit"] {
+		args = append(args, "-memory-limit=auto")
+	}
+	return args
+}
+
+// kubeJobs returns a Job per shard, each running the args with its -shard
+func kubeJobs(args []string) []kubeJob {
+	var jobs []kubeJob
+	for i := range kubeShards {
+		labels := map[string]string{
+			"app.kubernetes.io/name": "drivetogcs",
+			"drivetogcs/run-id":      runID,
+			"drivetogcs/shard":       fmt.Sprintf("%d-of-%d", i, kubeShards),
+		}
+		c := kubeContainer{
+			Name:  "drivetogcs",
+			Image: kubeImage,
+			Args:  append(append([]string(nil), args...), fmt.Sprintf("-shard=%d/%d", i, kubeShards)),
+		}
+		if kubeSecret != "" {
+			var env kubeEnvFrom
+			env.SecretRef.Name = kubeSecret
+			c.EnvFrom = []kubeEnvFrom{env}
+		}
+		if kubeMemory != "" {
+			c.Resources = &kubeResources{
+				Requests: map[string]string{"memory": kubeMemory},
+				Limits:   map[string]string{"memory": kubeMemory},
+			}
+		}
+		jobs = append(jobs, kubeJob{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Metadata: kubeMetadata{
+				Name:      fmt.Sprintf("%s-%d-of-%d", kubeJobName, i, kubeShards),
+				Namespace: kubeNamespace,
+				Labels:    labels,
+			},
+			Spec: kubeJobSpec{
+				BackoffLimit: kubeBackoffLimit,
+				Template: kubePodTemplate{
+					Metadata: kubeMetadata{Labels: labels},
+					Spec: kubePodSpec{
+						RestartPolicy:      "Never",
+						ServiceAccountName: kubeServiceAccount,
+						Containers:         []kubeContainer{c},
+					},
+				},
+			},
+		})
+	}
+	return jobs
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestKubeArgs(t *testing.T) {
+	defer func(id, project string, locs []string, refs []refinement) {
+		runID, projectID, locations, refinements = id, project, locs, refs
+	}(runID, projectID, locations, refinements)
+	runID, projectID, locations = "run-1", "my-project", []string{"us-central1", "europe-west4"}
+	refinements = []refinement{{Name: "keywords", Prompt: "Now 5 keywords"}}
+	defer func(m string) { kubeMemory = m }(kubeMemory)
+	kubeMemory = "2Gi"
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("folder", "", "")
+	fs.Bool("recursive", false, "")
+	fs.String("location", "", "")
+	fs.Int("shards", 0, "")
+	fs.String("image", "", "")
+	fs.Func("refine", "", func(string) error { return nil })
+	if err := fs.Parse([]string{"-folder", "abc", "-recursive", "-location=us-east1", "-shards", "4", "-image", "img", "-refine", "keywords=Now 5 keywords"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-folder=abc", "-location=us-east1", "-recursive=true", "-refine=keywords=Now 5 keywords", "-run-id=run-1", "-project=my-project", "-yes", "-memory-limit=auto"}
+	if got := kubeArgs(fs); !slices.Equal(got, want) {
+		t.Errorf("kubeArgs = %q, want %q", got, want)
+	}
+}
+
+func TestKubeJobs(t *testing.T) {
+	defer func(id string, shards int, image, secret, account, memory string) {
+		runID, kubeShards, kubeImage, kubeSecret, kubeServiceAccount, kubeMemory = id, shards, image, secret, account, memory
+	}(runID, kubeShards, kubeImage, kubeSecret, kubeServiceAccount, kubeMemory)
+	runID, kubeShards, kubeImage, kubeSecret, kubeServiceAccount, kubeMemory = "run-1", 3, "img", "creds", "migrator", ""
+
+	jobs := kubeJobs([]string{"-folder=abc"})
+	if len(jobs) != 3 {
+		t.Fatalf("kubeJobs = %d Jobs, want 3", len(jobs))
+	}
+	j := jobs[2]
+	if j.Kind != "Job" || j.Metadata.Name != "drivetogcs-2-of-3" || j.Metadata.Labels["drivetogcs/run-id"] != "run-1" || j.Metadata.Labels["drivetogcs/shard"] != "2-of-3" {
+		t.Errorf("Job = %+v", j)
+	}
+	pod := j.Spec.Template.Spec
+	if pod.RestartPolicy != "Never" || pod.ServiceAccountName != "migrator" || len(pod.Containers) != 1 {
+		t.Fatalf("pod = %+v", pod)
+	}
+	c := pod.Containers[0]
+	if c.Image != "img" || !slices.Equal(c.Args, []string{"-folder=abc", "-shard=2/3"}) || len(c.EnvFrom) != 1 || c.EnvFrom[0].SecretRef.Name != "creds" || c.Resources != nil {
+		t.Errorf("container = %+v", c)
+	}
+	if !slices.Equal(jobs[0].Spec.Template.Spec.Containers[0].Args, []string{"-folder=abc", "-shard=0/3"}) {
+		t.Errorf("shard 0 args = %q", jobs[0].Spec.Template.Spec.Containers[0].Args)
+	}
+}
+
+func TestCheckKubeFlags(t *testing.T) {
+	defer func(folder, shard, name string, stdin bool) {
+		sourceFolderID, shardSpec, kubeJobName, readStdin = folder, shard, name, stdin
+	}(sourceFolderID, shardSpec, kubeJobName, readStdin)
+	sourceFolderID, shardSpec, kubeJobName, readStdin = "abc", "", "migration", false
+	if err := checkKubeFlags(); err != nil {
+		t.Errorf("checkKubeFlags = %v", err)
+	}
+	shardSpec = "0/2"
+	if err := checkKubeFlags(); err == nil {
+		t.Error("checkKubeFlags with -shard = nil, want error")
+	}
+	shardSpec, kubeJobName = "", "Migration_1"
+	if err := checkKubeFlags(); err == nil {
+		t.Error("checkKubeFlags with an invalid -job-name = nil, want error")
+	}
+}
+
+func TestKubeJobsLockPerShard(t *testing.T) {
+	defer func(id string, shards int, image, folder string, index, count int) {
+		runID, kubeShards, kubeImage, sourceFolderID, shardIndex, shardCount = id, shards, image, folder, index, count
+	}(runID, kubeShards, kubeImage, sourceFolderID, shardIndex, shardCount)
+	runID, kubeShards, kubeImage, sourceFolderID = "run-1", 3, "img", "abc"
+
+	locks := map[string]bool{}
+	for _, j := range kubeJobs([]string{"-folder=abc", "-lock"}) {
+		args := j.Spec.Template.Spec.Containers[0].Args
+		if !slices.Contains(args, "-lock") {
+			t.Fatalf("args = %q, want -lock", args)
+		}
+		// each Job takes the lock of its -shard
+		var err error
+		if shardIndex, shardCount, err = parseShard(strings.TrimPrefix(args[len(args)-1], "-shard=")); err != nil {
+			t.Fatal(err)
+		}
+		locks[lockObject()] = true
+	}
+	if len(locks) != 3 {
+		t.Errorf("lock objects = %v, want one per shard", locks)
+	}
+}
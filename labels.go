@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// labelRule attaches static labels, such as campaign=spring2025, to the
+// files in a Drive folder, or a folder path in recursive mode
+type labelRule struct {
+	// FolderID matches files in the Drive folder, or its subfolders in recursive mode
+	FolderID string `json:"folder_id,omitempty"`
+	// Path matches files whose folder path relative to the source folder is, or
+	// is within, this slash separated path, in recursive mode
+	Path string `json:"path,omitempty"`
+
+	Labels map[string]string `json:"labels"`
+}
+
+// labelKey is a valid label name, usable as object metadata
+var labelKey = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// validate checks a label rule, cleaning its path
+func (l *labelRule) validate() error {
+	if l.FolderID == "" && l.Path == "" {
+		return fmt.Errorf("folder_id or path is required")
+	}
+	if len(l.Labels) == 0 {
+		return fmt.Errorf("labels are required")
+	}
+	for key := range l.Labels {
+		if !labelKey.MatchString(key) {
+			return fmt.Errorf("label %q must be lowercase letters, digits, dashes and underscores", key)
+		}
+	}
+	l.Path = strings.Trim(l.Path, "/")
+	return nil
+}
+
+// matches reports whether the label rule applies to a file
+func (l labelRule) matches(file drive.File) bool {
+	return route{FolderID: l.FolderID, Path: l.Path}.matches(file)
+}
+
+// labelsFor returns the labels of a file from every matching label rule; a
+// label set by more than one rule has the first rule's value
+func labelsFor(file drive.File) map[string]string {
+	var labels map[string]string
+	for _, l := range cfg.Labels {
+		if !l.matches(file) {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for key, value := range l.Labels {
+			if _, ok := labels[key]; !ok {
+				labels[key] = value
+			}
+		}
+	}
+	return labels
+}
+
+// formatLabels formats labels for a CSV column as key=value pairs, sorted by
+// key and separated by semicolons
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	f := useFakes(t)
+	prev, prevPrompt := cfg, customPromptLocation
+	defer func() { cfg, customPromptLocation = prev, prevPrompt }()
+	c, err := loadConfig(writeConfig(t, `{"labels": [
+		{"path": "/Campaigns/Spring/", "labels": {"campaign": "spring2025", "team": "brand"}},
+		{"folder_id": "root", "labels": {"team": "marketing", "source": "drive"}}
+	]}`))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	cfg = c
+	customPromptLocation = filepath.Join(t.TempDir(), "labels.tpl")
+	os.WriteFile(customPromptLocation, []byte("Describe this {{.Labels.campaign}} asset for {{.Labels.team}}.{{.Labels.missing}}"), 0644)
+	sourceFolderID = "root"
+	f.drive.add("campaigns", "Campaigns", folderMimeType, "root", nil)
+	f.drive.add("spring", "Spring", folderMimeType, "campaigns", nil)
+	f.drive.add("a", "a.jpg", "image/jpeg", "spring", []byte("a"))
+	f.drive.add("b", "b.jpg", "image/jpeg", "root", []byte("b"))
+	files, err := listFilesRecursive(context.Background(), "root", []string{"image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spring := files[0]
+	if spring.Id != "a" {
+		spring = files[1]
+	}
+
+	// the first matching rule's value wins
+	want := "campaign=spring2025;source=drive;team=brand"
+	if got := formatLabels(labelsFor(spring)); got != want {
+		t.Errorf("labels = %q, want %q", got, want)
+	}
+	prompt, err := renderPrompt(spring, customPromptLocation)
+	if want := "Describe this spring2025 asset for brand."; err != nil || prompt != want {
+		t.Errorf("renderPrompt = %q, %v, want %q", prompt, err, want)
+	}
+
+	r := processFile(context.Background(), spring, "a.jpg")
+	if columns["labels"](r) != want {
+		t.Errorf("labels column = %q, want %q", columns["labels"](r), want)
+	}
+	attrs := f.storage.attrs["test-bucket/Campaigns/Spring/a.jpg"]
+	if attrs.Metadata["campaign"] != "spring2025" || attrs.Metadata["team"] != "brand" || attrs.Metadata["drive-file-id"] != "a" {
+		t.Errorf("metadata = %v, want the labels", attrs.Metadata)
+	}
+
+	for _, contents := range []string{
+		`{"labels": [{"labels": {"campaign": "x"}}]}`,
+		`{"labels": [{"path": "Raw"}]}`,
+		`{"labels": [{"path": "Raw", "labels": {"Campaign": "x"}}]}`,
+	} {
+		if _, err := loadConfig(writeConfig(t, contents)); err == nil {
+			t.Errorf("loadConfig(%s) = nil error, want error", contents)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// detectSpeechLanguage asks Gemini for the languages spoken in each audio
+// and video file before describing it, selecting a prompt in that language
+var detectSpeechLanguage bool
+
+// stageLanguage is the stats category of spoken language detection
+const stageLanguage = "language"
+
+// languagesKey is the object metadata key of the spoken languages
+const languagesKey = "spoken-languages"
+
+// languageFunction is the function Gemini calls with the spoken languages
+const languageFunction = "report_spoken_languages"
+
+// languagePrompt asks for the languages spoken in the file
+const languagePrompt = "Identify the languages spoken in this recording and report them by calling " + languageFunction + "."
+
+// languageTag matches BCP 47 language tags such as en, pt-BR or zh-Hant
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// speechLanguages holds the spoken languages of this run's audio and video
+// files, most spoken first, by Drive file ID
+var speechLanguages sync.Map
+
+// hasSpeech reports whether a MIME type may have spoken language
+func hasSpeech(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/")
+}
+
+// spokenLanguages returns the languages spoken in a file, most spoken first
+func spokenLanguages(file drive.File) []string {
+	if langs, ok := speechLanguages.Load(file.Id); ok {
+		return langs.([]string)
+	}
+	return nil
+}
+
+// languageMetadata returns the object metadata of the languages spoken in a
+// file
+func languageMetadata(file drive.File) map[string]string {
+	langs := spokenLanguages(file)
+	if len(langs) == 0 {
+		return nil
+	}
+	return map[string]string{languagesKey: strings.Join(langs, ",")}
+}
+
+// localizedPrompt returns the prompt template for a language, the template
+// with the language before its extension, such as video.es.tpl for
+// video.tpl, if there is one, or else the template itself
+func localizedPrompt(promptPath, lang string) string {
+	if promptPath == "" || lang == "" {
+		return promptPath
+	}
+	ext := filepath.Ext(promptPath)
+	for _, tag := range []string{lang, strings.SplitN(lang, "-", 2)[0]} {
+		localized := strings.TrimSuffix(promptPath, ext) + "." + tag + ext
+		if _, err := os.Stat(localized); err == nil {
+			return localized
+		}
+	}
+	return promptPath
+}
+
+// languageDeclaration declares the function Gemini calls with the spoken
+// languages
+func languageDeclaration() *genai.FunctionDeclaration {
+	return &genai.FunctionDeclaration{
+		Name:        languageFunction,
+		Description: "Reports the languages spoken in the recording.",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"languages": {
+					Type:        genai.TypeArray,
+					Items:       &genai.Schema{Type: genai.TypeString},
+					Description: "BCP 47 language tags of the languages spoken, such as en or pt-BR, most spoken first, empty if there is no speech",
+				},
+			},
+			Required: []string{"languages"},
+		},
+	}
+}
+
+// detectLanguages asks Gemini to call languageFunction with the languages
+// spoken in a file. Invalid arguments are sent back as the function's error
+// for Gemini to correct, up to tagAttempts times.
+func detectLanguages(ctx context.Context, file drive.File, model string, part *genai.Part) ([]string, error) {
+	config := generateConfig()
+	config.Tools = []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{languageDeclaration()}}}
+	config.ToolConfig = &genai.ToolConfig{FunctionCallingConfig: &genai.FunctionCallingConfig{
+		Mode:                 genai.FunctionCallingConfigModeAny,
+		AllowedFunctionNames: []string{languageFunction},
+	}}
+	contents := []*genai.Content{genai.NewUserContentFromParts([]*genai.Part{part})}
+	contents = append(contents, genai.Text(languagePrompt)...)
+
+	var err error
+	for range tagAttempts {
+		start := time.Now()
+		var resp *genai.GenerateContentResponse
+		err = withQuotaRetry(ctx, func() (err error) {
+			resp, err = genaiClient.GenerateContent(ctx, model, contents, config)
+			return err
+		})
+		observeFile(file.Id, stageLanguage, start)
+		if err != nil {
+			return nil, fmt.Errorf("unable to detect spoken languages: %w", err)
+		}
+		stats.addUsage(resp.UsageMetadata)
+		var call *genai.FunctionCall
+		for _, c := range resp.FunctionCalls() {
+			if c.Name == languageFunction {
+				call = c
+				break
+			}
+		}
+		if call == nil {
+			return nil, fmt.Errorf("model did not call %s", languageFunction)
+		}
+		var langs []string
+		if langs, err = parseLanguages(call.Args); err == nil {
+			return langs, nil
+		}
+		log.Printf("%s: invalid spoken languages, asking for a correction: %v", file.Name, err)
+		contents = append(contents,
+			genai.NewModelContentFromParts([]*genai.Part{{FunctionCall: call}}),
+			genai.NewUserContentFromParts([]*genai.Part{genai.NewPartFromFunctionResponse(languageFunction, map[string]any{"error": err.Error()})}),
+		)
+	}
+	return nil, err
+}
+
+// parseLanguages validates the arguments of a languageFunction call,
+// requiring BCP 47 language tags, with the language subtag lowercased and
+// without duplicates
+func parseLanguages(args map[string]any) ([]string, error) {
+	raw, _ := args["languages"].([]any)
+	var langs []string
+	for _, l := range raw {
+		s, _ := l.(string)
+		s = strings.ReplaceAll(strings.TrimSpace(s), "_", "-")
+		if lang, rest, ok := strings.Cut(s, "-"); ok {
+			s = strings.ToLower(lang) + "-" + rest
+		} else {
+			s = strings.ToLower(s)
+		}
+		if !languageTag.MatchString(s) {
+			return nil, fmt.Errorf("%q is not a BCP 47 language tag, such as en or pt-BR", l)
+		}
+		if !containsFold(langs, s) {
+			langs = append(langs, s)
+		}
+	}
+	return langs, nil
+}
+
+// containsFold reports whether a list has a string, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
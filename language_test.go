@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseLanguages(t *testing.T) {
+	langs, err := parseLanguages(map[string]any{"languages": []any{"EN", "pt_BR", "en", "zh-Hant"}})
+	if err != nil || !slices.Equal(langs, []string{"en", "pt-BR", "zh-Hant"}) {
+		t.Errorf("parseLanguages = %v, %v", langs, err)
+	}
+	if _, err := parseLanguages(map[string]any{"languages": []any{"English"}}); err == nil {
+		t.Error("accepted a language name")
+	}
+}
+
+func TestLocalizedPrompt(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"video.tpl", "video.es.tpl"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prompt := filepath.Join(dir, "video.tpl")
+	for lang, want := range map[string]string{"es": "video.es.tpl", "es-MX": "video.es.tpl", "fr": "video.tpl", "": "video.tpl"} {
+		if got := localizedPrompt(prompt, lang); filepath.Base(got) != want {
+			t.Errorf("localizedPrompt(%q) = %s, want %s", lang, got, want)
+		}
+	}
+	if got := localizedPrompt("", "es"); got != "" {
+		t.Errorf("localizedPrompt of the built in prompt = %q", got)
+	}
+}
+
+func TestSpeechLanguageSelectsPrompt(t *testing.T) {
+	f := useFakes(t)
+	prevDetect, prevPrompt := detectSpeechLanguage, customPromptLocation
+	t.Cleanup(func() { detectSpeechLanguage, customPromptLocation = prevDetect, prevPrompt })
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "video.tpl"), []byte("Describe this video."), 0o644)
+	os.WriteFile(filepath.Join(dir, "video.es.tpl"), []byte("Describe este video en {{.Language}}{{range .Languages}} {{.}}{{end}}."), 0o644)
+	detectSpeechLanguage, customPromptLocation = true, filepath.Join(dir, "video.tpl")
+	f.generator.functionArgs = []map[string]any{{"languages": []any{"es-MX", "en"}}}
+	f.drive.add("v", "clip.mp4", "video/mp4", "root", []byte("mp4"))
+
+	r := processFile(context.Background(), *f.drive.files["v"], "clip.mp4")
+	if !slices.Equal(r.Languages, []string{"es-MX", "en"}) || !strings.HasPrefix(r.Prompt, "video.es.tpl@") {
+		t.Errorf("record languages = %v, prompt = %q", r.Languages, r.Prompt)
+	}
+	if prompt := f.generator.contents[1].Parts[0].Text; prompt != "Describe este video en es-MX es-MX en." {
+		t.Errorf("prompt = %q", prompt)
+	}
+	if got := f.storage.attrs["test-bucket/clip.mp4"].Metadata[languagesKey]; got != "es-MX,en" {
+		t.Errorf("metadata = %q", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// object layouts
+const (
+	layoutPath   = "path"   // objects are named after their Drive file names
+	layoutSHA256 = "sha256" // objects are named sha256/<hash> of their contents
+)
+
+var layouts = []string{layoutPath, layoutSHA256}
+
+var objectLayout string = layoutPath
+
+// contentIndexFile is where the name to hash index is written, with the sha256 layout
+var contentIndexFile string = "content-index.csv"
+
+// originalNameKey is the object metadata key holding the name of a content
+// addressed object
+const originalNameKey = "original-name"
+
+// contentHashes maps Drive file IDs to the SHA-256 of their contents, with the
+// sha256 layout
+var contentHashes sync.Map
+
+// hashContent records the SHA-256 of a file's contents, with the sha256 layout
+func hashContent(file drive.File, data []byte) {
+	if objectLayout != layoutSHA256 {
+		return
+	}
+	sum := sha256.Sum256(data)
+	contentHashes.Store(file.Id, hex.EncodeToString(sum[:]))
+}
+
+// contentName returns the object name of a file in the object layout, given
+// its name in the path layout
+func contentName(file drive.File, name string) string {
+	if objectLayout != layoutSHA256 {
+		return name
+	}
+	if hash, ok := contentHashes.Load(file.Id); ok {
+		return path.Join("sha256", hash.(string))
+	}
+	return name
+}
+
+// contentEntry is a row of the content index
+type contentEntry struct {
+	name, hash, objectPath, driveID string
+}
+
+// contentIndex collects the name to hash index of content addressed objects
+type contentIndex struct {
+	mu      sync.Mutex
+	entries []contentEntry
+}
+
+var contents = &contentIndex{}
+
+func (c *contentIndex) add(name, hash, objectPath, driveID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, contentEntry{name: name, hash: hash, objectPath: objectPath, driveID: driveID})
+}
+
+// write writes the index as a CSV, in name order
+func (c *contentIndex) write(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sort.Slice(c.entries, func(i, j int) bool { return c.entries[i].name < c.entries[j].name })
+
+	f, err := createPending(path)
+	if err != nil {
+		return fmt.Errorf("unable to create content index: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"name", "sha256", "object_path", "drive_id"})
+	for _, e := range c.entries {
+		w.Write([]string{e.name, e.hash, e.objectPath, e.driveID})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.abort()
+		return fmt.Errorf("unable to write content index: %v", err)
+	}
+	return f.commit()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestContentAddressedLayout(t *testing.T) {
+	f := useFakes(t)
+	prevLayout, prevContents := objectLayout, contents
+	objectLayout, contents = layoutSHA256, &contentIndex{}
+	defer func() { objectLayout, contents = prevLayout, prevContents }()
+	gcsFolderPath = "media"
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("same"))
+	f.drive.add("2", "copy of a.jpg", "image/jpeg", "folder1", []byte("same"))
+
+	// sha256 of "same"
+	const hash = "0967115f2813a3541eaef77de9d9d5773f1c0c04314b0bbfe4ff3b3b1c55b5d5"
+	for _, file := range []drive.File{
+		{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"},
+		{Id: "2", Name: "copy of a.jpg", MimeType: "image/jpeg"},
+	} {
+		name, err := objectName(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := processFile(context.Background(), file, name)
+		if r.ObjectPath != "media/sha256/"+hash {
+			t.Errorf("%s object path = %q, want content addressed", file.Name, r.ObjectPath)
+		}
+	}
+	if f.storage.uploads != 1 {
+		t.Errorf("uploads = %d, want identical contents deduplicated", f.storage.uploads)
+	}
+	if got := f.storage.attrs["test-bucket/media/sha256/"+hash].Metadata[originalNameKey]; got != "media/a.jpg" && got != "media/copy of a.jpg" {
+		t.Errorf("%s = %q", originalNameKey, got)
+	}
+
+	path := filepath.Join(t.TempDir(), "content-index.csv")
+	if err := contents.write(path); err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, path)
+	if len(rows) != 3 || rows[1][0] != "media/a.jpg" || rows[1][1] != hash || rows[2][0] != "media/copy of a.jpg" || rows[2][2] != "media/sha256/"+hash {
+		t.Errorf("content index = %v", rows)
+	}
+}
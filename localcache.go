@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// localLocks holds a mutex for each local file path being written, so files
+// processed concurrently never write the same path at once
+var localLocks sync.Map
+
+// lockPath locks a local file path, returning the function unlocking it
+func lockPath(path string) func() {
+	mu, _ := localLocks.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// writeFileAtomic writes a local file with -file-mode permissions, less the
+// umask, to a uniquely named temporary file renamed into place, so a reader
+// or a crash never sees it partly written
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicMode(path, data, fileMode)
+}
+
+// writeFileAtomicMode is writeFileAtomic with other permissions, such as
+// -token-mode
+func writeFileAtomicMode(path string, data []byte, mode os.FileMode) error {
+	tmp := path + "." + strconv.FormatUint(rand.Uint64(), 36) + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to write %s: %v", path, err)
+	}
+	return nil
+}
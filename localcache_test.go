@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestGetFileBytesConcurrent(t *testing.T) {
+	f := useFakes(t)
+	data := bytes.Repeat([]byte("abcdefgh"), 1<<16)
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", data)
+	file := *f.drive.files["a"]
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := getFileBytes(context.Background(), file); err != nil {
+				t.Errorf("getFileBytes: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	path := filepath.Join(localFolderName, localName(file))
+	if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("local file is %d bytes, %v, want %d", len(b), err, len(data))
+	}
+	entries, _ := os.ReadDir(localFolderName)
+	if len(entries) != 1 {
+		t.Errorf("local files = %v, want no temporary files left", entries)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	if err := writeFileAtomic(path, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "b" {
+		t.Errorf("contents = %q, want b", b)
+	}
+	if err := writeFileAtomic(filepath.Join(path, "missing", "b.jpg"), nil); err == nil {
+		t.Error("writeFileAtomic into a missing folder succeeded")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// useLock holds a lease on a lock object in the bucket for the duration of the
+// run, so overlapping runs of the same source don't process files twice
+var useLock bool
+var lockTTL time.Duration = 5 * time.Minute
+
+// lease object metadata keys
+const (
+	leaseHolderKey  = "lease-holder"
+	leaseExpiresKey = "lease-expires"
+)
+
+// lease is a time limited lock held on a Cloud Storage object; it is taken and
+// renewed with generation preconditions, so only one holder succeeds
+type lease struct {
+	mu         sync.Mutex
+	bucket     string
+	object     string
+	ttl        time.Duration
+	generation int64
+}
+
+// lockObject returns the lock object path for the run's source, and shard,
+// so the shards of a run on several machines each hold their own lock
+func lockObject() string {
+	name := "default"
+	switch {
+	case sourceFolderID != "":
+		name = sourceFolderID
+	case manifestFile != "":
+		name = filepath.Base(manifestFile)
+	case readStdin:
+		name = "stdin"
+	}
+	name = sanitizeObjectName(name)
+	if shardCount > 1 {
+		name += fmt.Sprintf(".shard-%d-of-%d", shardIndex, shardCount)
+	}
+	return path.Join(".drivetogcs", "locks", name+".lock")
+}
+
+// acquireLease takes the lease on object, if it is free or expired
+func acquireLease(ctx context.Context, bucket, object string, ttl time.Duration) (*lease, error) {
+	l := &lease{bucket: bucket, object: object, ttl: ttl}
+	attrs, err := storageSrv.Attrs(ctx, bucket, object)
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist):
+		// free
+	case err != nil:
+		return nil, fmt.Errorf("unable to check lock %s: %w", object, err)
+	default:
+		expires, _ := time.Parse(time.RFC3339, attrs.Metadata[leaseExpiresKey])
+		if time.Now().Before(expires) {
+			return nil, fmt.Errorf("lock %s is held by run %s until %s", object, attrs.Metadata[leaseHolderKey], expires.Format(time.RFC3339))
+		}
+		log.Printf("taking over expired lock %s from run %s", object, attrs.Metadata[leaseHolderKey])
+		l.generation = attrs.Generation
+	}
+	if err := l.renew(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("acquired lock gs://%s/%s", bucket, object)
+	return l, nil
+}
+
+// renew extends the lease by its ttl
+func (l *lease) renew(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	attrs := storage.ObjectAttrs{
+		ContentType: "text/plain",
+		Metadata: map[string]string{
+			leaseHolderKey:  runID,
+			leaseExpiresKey: time.Now().Add(l.ttl).UTC().Format(time.RFC3339),
+		},
+	}
+	written, err := storageSrv.UploadIfGeneration(ctx, l.bucket, l.object, []byte(runID), attrs, l.generation)
+	if errors.Is(err, errPreconditionFailed) {
+		return fmt.Errorf("lock %s was taken by another run", l.object)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write lock %s: %w", l.object, err)
+	}
+	l.generation = written.Generation
+	return nil
+}
+
+// keepAlive renews the lease until ctx is done, calling cancel if it is lost
+func (l *lease) keepAlive(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.renew(ctx); err != nil {
+				log.Printf("lost lock, stopping: %v", err)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// release deletes the lock object, if the lease is still held
+func (l *lease) release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := storageSrv.DeleteIfGeneration(ctx, l.bucket, l.object, l.generation); err != nil {
+		return fmt.Errorf("unable to release lock %s: %w", l.object, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestLease(t *testing.T) {
+	f := useFakes(t)
+	ctx := context.Background()
+
+	l, err := acquireLease(ctx, "test-bucket", "locks/a.lock", time.Minute)
+	if err != nil {
+		t.Fatalf("acquireLease() error = %v", err)
+	}
+	if got := f.storage.attrs["test-bucket/locks/a.lock"].Metadata[leaseHolderKey]; got != "test-run" {
+		t.Errorf("lease holder = %q, want test-run", got)
+	}
+	if _, err := acquireLease(ctx, "test-bucket", "locks/a.lock", time.Minute); err == nil || !strings.Contains(err.Error(), "held by run test-run") {
+		t.Errorf("second acquireLease() error = %v, want held", err)
+	}
+	if err := l.renew(ctx); err != nil {
+		t.Errorf("renew() error = %v", err)
+	}
+	if err := l.release(ctx); err != nil {
+		t.Errorf("release() error = %v", err)
+	}
+	if _, ok := f.storage.attrs["test-bucket/locks/a.lock"]; ok {
+		t.Error("lock object not deleted on release")
+	}
+}
+
+func TestLeaseTakesOverExpiredLock(t *testing.T) {
+	f := useFakes(t)
+	ctx := context.Background()
+	f.storage.Upload(ctx, "test-bucket", "locks/a.lock", nil, storage.ObjectAttrs{Metadata: map[string]string{
+		leaseHolderKey:  "crashed",
+		leaseExpiresKey: time.Now().Add(-time.Minute).Format(time.RFC3339),
+	}})
+
+	l, err := acquireLease(ctx, "test-bucket", "locks/a.lock", time.Minute)
+	if err != nil {
+		t.Fatalf("acquireLease() error = %v, want expired lock taken over", err)
+	}
+	// the crashed run's stale lease can no longer renew
+	stale := &lease{bucket: "test-bucket", object: "locks/a.lock", ttl: time.Minute, generation: l.generation - 1}
+	if err := stale.renew(ctx); err == nil {
+		t.Error("stale renew() = nil error, want taken by another run")
+	}
+}
+
+func TestLockObjectPerShard(t *testing.T) {
+	defer func(folder string, index, count int) {
+		sourceFolderID, shardIndex, shardCount = folder, index, count
+	}(sourceFolderID, shardIndex, shardCount)
+	sourceFolderID, shardIndex, shardCount = "abc", 0, 0
+	if got := lockObject(); got != ".drivetogcs/locks/abc.lock" {
+		t.Errorf("lockObject() = %q, want .drivetogcs/locks/abc.lock", got)
+	}
+	shardIndex, shardCount = 0, 2
+	first := lockObject()
+	shardIndex = 1
+	second := lockObject()
+	if first != ".drivetogcs/locks/abc.shard-0-of-2.lock" || second != ".drivetogcs/locks/abc.shard-1-of-2.lock" {
+		t.Errorf("lockObject() of shards = %q, %q, want one per shard", first, second)
+	}
+}
@@ -2,32 +2,32 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"embed"
 	_ "embed"
-	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
-	"html/template"
 	"io"
 	"log"
-	"net/http"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 	"google.golang.org/genai"
-
-	"google.golang.org/api/googleapi"
 )
 
 var sourceFolderID string
+var manifestFile string
+var readStdin bool
 var localFolderName string = "local"
 var maxFiles int
 
@@ -40,148 +40,1017 @@ var manualAuth bool
 var gcsBucket string
 var gcsFolderPath string
 var alwaysUploadToGCS bool
+var signedURLTTL time.Duration
 
 var createDescription bool
 var customPromptLocation string
 
+var mimeTypesList string = "image/jpeg,image/png"
 var mimeTypes []string
 
+var summaryFile string = "run-summary.json"
+var outputFormat string = "csv"
+var columnsList string = strings.Join(defaultColumns, ",")
+var csvColumns []string
+
 //go:embed prompts/*.tpl
 var promptTemplates embed.FS
 
 var (
-	driveSrv    *drive.Service
-	genaiClient *genai.Client
+	driveSrv    driveClient
+	storageSrv  storageClient
+	genaiClient generator
+	stats       *runStats
 )
 
 func init() {
 	flag.StringVar(&sourceFolderID, "folder", sourceFolderID, "source Drive folder ID")
+	flag.BoolVar(&recursive, "recursive", recursive, "include files in subfolders, mirroring the Drive folder hierarchy under the GCS path")
+	flag.StringVar(&manifestFile, "manifest", manifestFile, "file listing Drive file IDs to process, one per line or a CSV with a drive_id column, instead of a folder")
+	flag.BoolVar(&readStdin, "stdin", readStdin, "read Drive file IDs from stdin, one per line, processing them as they arrive")
 	flag.StringVar(&localFolderName, "local", localFolderName, "local folder name")
+	flag.StringVar(&logFormat, "log-format", logFormat, "log format: text, json for Cloud Logging structured entries, or auto for json on Cloud Run, Cloud Functions and App Engine")
+	flag.StringVar(&runID, "run-id", runID, "ID stamped into object metadata and catalog records, to rerun an earlier run; defaults to a new UUID")
+	flag.StringVar(&shardSpec, "shard", shardSpec, "process only shard i/n of the files, partitioned by Drive file ID, e.g. 0/4, so several machines can split a folder")
+	flag.BoolVar(&useLock, "lock", useLock, "hold a lease on a lock object in the bucket while running, so overlapping runs of the same source exit instead of processing files twice")
+	flag.DurationVar(&lockTTL, "lock-ttl", lockTTL, "duration of the -lock lease, renewed while running; a lock left by a crashed run expires after this")
+	flag.StringVar(&maxBytesFlag, "max-bytes", maxBytesFlag, "stop starting files once this many bytes, e.g. 500GB, have been transferred, writing the rest to -checkpoint")
+	flag.StringVar(&transferOverFlag, "transfer-over", transferOverFlag, "when the files total this size or more, e.g. 1TB, have Storage Transfer Service copy their bytes from Drive, with -public-folder, rather than through this machine")
+	flag.StringVar(&partitionBy, "partition", partitionBy, "list and process the -folder a window of modified time at a time, year, month, week or day, for folders too large to list at once; empty to list it at once")
+	flag.StringVar(&partitionSince, "partition-since", partitionSince, "start of the first -partition window, YYYY-MM-DD; files modified before it are listed together, first")
+	flag.StringVar(&partitionState, "partition-state", partitionState, "path to record the -partition windows processed in, so a rerun carries on from the first window not done")
+	flag.StringVar(&checkpointFile, "checkpoint", checkpointFile, "path to write the files left by -max-bytes, or not started while paused outside -active-hours, as a manifest to resume from")
+	flag.StringVar(&activeHoursSpec, "active-hours", activeHoursSpec, "daily window of local time in which files are started, e.g. 22:00-06:00; outside it the run pauses until it opens, empty to run at any time")
 	flag.IntVar(&maxFiles, "max", maxFiles, "max files to process, useful for processing a small batch")
-
+	flag.BoolVar(&assumeYes, "yes", assumeYes, "run without confirming runs over -confirm-over-files or -confirm-over-cost")
+	flag.IntVar(&confirmOverFiles, "confirm-over-files", confirmOverFiles, "ask to confirm runs of more than this many files, 0 to never ask")
+	flag.Float64Var(&confirmOverCost, "confirm-over-cost", confirmOverCost, "ask to confirm runs estimated to cost more than this many US dollars of Gemini usage, 0 to never ask")
+	flag.IntVar(&maxConcurrency, "concurrency", maxConcurrency, "max files to process at once, 0 for no limit; quota errors lower it for a cool-down window")
+	flag.StringVar(&memoryLimitFlag, "memory-limit", memoryLimitFlag, "memory to keep the process under, e.g. 2GiB, or auto for the container's limit; the files processed at once are halved near it")
+	flag.IntVar(&quotaRetries, "quota-retries", quotaRetries, "times to retry a Drive download or Gemini request failing on quota, after cooling down for its Retry-After")
+	flag.DurationVar(&quotaCooldown, "quota-cooldown", quotaCooldown, "cool-down after a quota error without a Retry-After")
+
+	flag.StringVar(&configFile, "config", configFile, "JSON config file with flags, and routing rules sending Drive folders to other buckets, prefixes or storage classes")
+	flag.StringVar(&ignoreFile, "ignore", ignoreFile, "file of Drive file IDs and name globs, such as *.psd, of files to skip, one per line, empty for none")
+	flag.StringVar(&profile, "profile", profile, "profile of the -config file to use, with its own flags, routes and rules")
+	flag.StringVar(&projectFlag, "project", projectFlag, "Google Cloud project ID, for Vertex AI and the default bucket; defaults to PROJECT_ID")
+	flag.StringVar(&locationFlag, "location", locationFlag, "Vertex AI region, or comma-separated regions to fail over between; defaults to LOCATION, or us-central1")
 	flag.StringVar(&gcsBucket, "gcs-bucket", "", "GCS bucket")
 	flag.StringVar(&gcsFolderPath, "gcs-path", "", "GCS path")
 	flag.BoolVar(&alwaysUploadToGCS, "always-upload", false, "always upload to GCS")
+	flag.BoolVar(&failOnChangedContent, "fail-on-changed-content", failOnChangedContent, "fail a file whose object already exists with different content, rather than keeping it, or creating a new generation with -always-upload")
+	flag.StringVar(&replicaBucketsList, "replica-buckets", replicaBucketsList, "comma separated buckets, usually in other regions, every object is also written to, in parallel, after -gcs-bucket")
+	flag.StringVar(&cacheControl, "cache-control", cacheControl, "Cache-Control header to set on uploaded objects, e.g. \"public, max-age=3600\"")
+	flag.BoolVar(&makePublic, "public", makePublic, "make uploaded objects publicly readable")
+	flag.DurationVar(&signedURLTTL, "signed-url-ttl", signedURLTTL, "generate V4 signed URLs valid for this duration (max 168h) for each object in the catalog, 0 to disable")
+	flag.BoolVar(&migrateRevisions, "revisions", migrateRevisions, "also upload all Drive revisions of each file, under <object>/revisions/<revisionId>")
+	flag.IntVar(&verifySample, "verify-sample", verifySample, "after the run, download this many randomly sampled uploaded objects again and compare them with their Drive originals, reporting the result in the run summary")
+	flag.StringVar(&objectLayout, "layout", objectLayout, "object layout: path, named after the Drive folders and files, or sha256, content addressed as sha256/<hash> for dedup and immutable references")
+	flag.StringVar(&contentIndexFile, "content-index", contentIndexFile, "path to write the name to hash index CSV with -layout sha256, empty to skip writing")
+	flag.StringVar(&onCollision, "on-collision", onCollision, "how to handle Drive files with the same name: id (suffix with the Drive ID), number, error or skip")
+	flag.StringVar(&replacementChar, "replace-char", replacementChar, "replacement for characters that are invalid in local file or object names")
 
 	flag.BoolVar(&createDescription, "describe", true, "describe the asset using Gemini")
+	flag.BoolVar(&describeOnly, "describe-only", describeOnly, "describe the files and write only the catalog, without uploading them or anything else to Cloud Storage, for an inventory of a Drive folder with AI descriptions")
+	flag.StringVar(&model, "model", model, "Gemini model describing files, unless a config rule sets another")
+	flag.StringVar(&backend, "backend", backend, "Gemini backend: vertex, or geminiapi for the Gemini Developer API with GEMINI_API_KEY, without a project")
 	flag.StringVar(&customPromptLocation, "prompt", "", "a custom prompt template to use")
+	flag.StringVar(&systemInstruction, "system-instruction", "", "system instruction, such as brand and tone guidance, given to Gemini for every description")
+	flag.StringVar(&systemInstructionFile, "system-instruction-file", "", "file holding the system instruction")
+	flag.BoolVar(&searchGrounding, "search-grounding", false, "ground descriptions with Google Search, recording the sources cited in the sidecar and citations column")
+	flag.BoolVar(&tagFiles, "tags", false, "also tag each file with Gemini function calling, writing normalized tags and a category to the tags and category columns")
+	flag.StringVar(&tagCategoriesList, "tag-categories", "", "comma-separated list of the categories files can be tagged with, any if empty")
+	flag.IntVar(&maxTags, "max-tags", maxTags, "most tags kept for each file")
+	flag.Func("refine", "a follow up prompt, name=prompt, sent after each description in the same conversation, whose response is written to the name column; repeat for more turns", addRefinement)
+	flag.StringVar(&animationMode, "animation", animationMode, "how animated GIFs and PNGs are described: frame, the middle frame, contact-sheet, a grid of frames across the animation, or first, the first frame")
+	flag.BoolVar(&describeFromGCS, "describe-from-gcs", false, "describe files already uploaded with the same MD5 checksum from their gs:// URI, without downloading them from Drive")
+	flag.StringVar(&auditLogPath, "audit-log", auditLogPath, "local JSON Lines file to append every Gemini request and response to, or a gs:// folder to upload each run's as <run-id>.jsonl, empty to skip")
+	flag.BoolVar(&auditRedact, "audit-redact", auditRedact, "record the prompts and responses in the -audit-log by their SHA-256 hashes and lengths instead of their text")
+	flag.StringVar(&piiAction, "pii", piiAction, "look for personal information such as faces and ID documents in each file described, and flag, skip, restrict (upload to -pii-bucket) or redact (from the description) the files with any; empty to not look")
+	flag.StringVar(&piiBucket, "pii-bucket", piiBucket, "restricted GCS bucket to upload files with personal information to with -pii restrict")
+	flag.BoolVar(&detectSpeechLanguage, "speech-language", detectSpeechLanguage, "ask Gemini for the languages spoken in each audio and video file before describing it, describing it with the prompt for the main language, such as video.es.tpl for video.tpl, if there is one")
+	flag.BoolVar(&countFaces, "faces", countFaces, "ask Gemini for the faces in each image described, recording how many there are")
+	flag.BoolVar(&blurFaces, "blur-faces", blurFaces, "blur the faces Gemini finds in the serving copy of JPEG and PNG images, keeping the original under -archive-prefix")
+	flag.BoolVar(&redescribeIfPromptChanged, "redescribe-if-prompt-changed", false, "only describe again the uploaded files whose sidecar records a description under another prompt template or system instruction, from their objects, without transferring them again; requires -sidecar")
+	flag.BoolVar(&embedMetadata, "embed-metadata", false, "embed the description and tags into the XMP, and IPTC for JPEGs, of uploaded JPEG and PNG images")
+	flag.BoolVar(&expandZips, "expand-zips", false, "also extract the files of zip archives whose types are in -mime-types, describing and uploading each under a folder named after the archive")
+	flag.IntVar(&maxSide, "max-side", 0, "downscale images to at most this many pixels on their longest side before describing them, 0 for full size; originals are uploaded")
+	flag.StringVar(&maxInlineFlag, "max-inline", maxInlineFlag, "largest file to send to Gemini inline; larger images are downscaled to fit, other files are read from Cloud Storage")
+	flag.StringVar(&streamOverFlag, "stream-over", streamOverFlag, "stream the descriptions of files of at least this size, e.g. 10MB, writing the output to a local .partial.txt file as it arrives")
+	flag.DurationVar(&stallTimeout, "stall-timeout", stallTimeout, "fail a streamed description when the model sends no output for this long")
+	flag.DurationVar(&describeTimeout, "describe-timeout", describeTimeout, "time limit to generate each description, 0 for no limit")
+
+	flag.StringVar(&watermarkText, "watermark-text", watermarkText, "copyright text to draw onto JPEG and PNG images before uploading, keeping the original under -archive-prefix")
+	flag.StringVar(&watermarkImage, "watermark-image", watermarkImage, "PNG to draw onto JPEG and PNG images before uploading, keeping the original under -archive-prefix")
+	flag.Float64Var(&watermarkOpacity, "watermark-opacity", watermarkOpacity, "opacity of the watermark, from 0 to 1")
+	flag.StringVar(&archivePrefix, "archive-prefix", archivePrefix, "folder within the GCS path to upload the originals of watermarked images under")
+	flag.StringVar(&clamdAddress, "clamd", clamdAddress, "ClamAV daemon address, host:port or unix socket path, to scan files with before they are described and uploaded; infected files are skipped")
+	flag.StringVar(&preUploadHook, "hook-pre-upload", preUploadHook, "shell command run before each upload with the file's JSON on stdin; a non-zero exit skips the upload")
+	flag.StringVar(&postDescribeHook, "hook-post-describe", postDescribeHook, "shell command run after each description with the file's JSON on stdin; non-empty output replaces the description")
+	flag.StringVar(&postFileHook, "hook-post-file", postFileHook, "shell command run after each file with its catalog record JSON on stdin")
+
+	flag.StringVar(&tokenFile, "token-file", tokenFile, "file caching the OAuth token")
+	flag.StringVar(&tokenEncryption, "token-encryption", tokenEncryption, "encryption of the cached OAuth token: none, passphrase with TOKEN_PASSPHRASE, or keyring for a key in the OS keychain")
+	flag.StringVar(&driveAccount, "account", driveAccount, "Drive account, user@domain, to run as, with its own token-<account>.json beside -token-file")
+	flag.StringVar(&tokenModeFlag, "token-mode", tokenModeFlag, "permissions of the token file, in octal")
+	flag.StringVar(&fileModeFlag, "file-mode", fileModeFlag, "permissions of local files written, such as downloads and catalogs, in octal")
+	flag.StringVar(&dirModeFlag, "dir-mode", dirModeFlag, "permissions of the -local folder, in octal")
+	flag.StringVar(&umaskFlag, "umask", umaskFlag, "process umask, in octal, e.g. 077, empty to keep the inherited one")
+	flag.BoolVar(&manualAuth, "no-launch-browser", false, "manual authentication - prevents the command from automatically opening a web browser; the same as -auth-mode manual")
+	flag.BoolVar(&reauthenticate, "reauth", reauthenticate, "authorize again with -auth-mode when the refresh token is revoked or expires mid-run, pausing Drive calls until done, rather than writing the files not started to -checkpoint")
+	flag.StringVar(&driveCredentials, "drive-credentials", driveCredentials, "credentials JSON for Drive: an OAuth client, a service account key, acting as -account by domain-wide delegation, or an authorized_user file; defaults to GOOGLE_CREDENTIALS")
+	flag.BoolVar(&publicDrive, "public-folder", publicDrive, "read a publicly shared Drive folder with the API key in DRIVE_API_KEY rather than OAuth, without a consent flow")
+	flag.StringVar(&proxyURL, "proxy", proxyURL, "HTTP proxy URL every request is sent through; defaults to HTTPS_PROXY")
+	flag.StringVar(&driveEndpoint, "drive-endpoint", driveEndpoint, "Drive API endpoint, for Private Google Access or VPC Service Controls; defaults to DRIVE_ENDPOINT, or the public endpoint")
+	flag.StringVar(&storageEndpoint, "storage-endpoint", storageEndpoint, "Cloud Storage endpoint, such as https://storage.p.googleapis.com/storage/v1/; defaults to STORAGE_ENDPOINT, or the public endpoint")
+	flag.StringVar(&vertexEndpoint, "vertex-endpoint", vertexEndpoint, "Vertex AI endpoint, with {location} replaced by the location, such as https://{location}-aiplatform.p.googleapis.com/; defaults to VERTEX_ENDPOINT, or the public endpoint")
+	flag.StringVar(&googleVIP, "google-vip", googleVIP, "send *.googleapis.com requests to a Private Google Access virtual IP, for VPCs without its DNS zones: none, private or restricted, for VPC Service Controls perimeters")
+	flag.StringVar(&clientCertFile, "client-cert", clientCertFile, "PEM client certificate presented to Google APIs, using their mTLS endpoints, for certificate-based access")
+	flag.StringVar(&clientKeyFile, "client-key", clientKeyFile, "PEM private key of -client-cert")
+	flag.StringVar(&storageCredentials, "storage-credentials", storageCredentials, "service account key or authorized_user JSON for Cloud Storage; defaults to Application Default Credentials")
+	flag.StringVar(&vertexCredentials, "vertex-credentials", vertexCredentials, "service account key or authorized_user JSON for Vertex AI; defaults to Application Default Credentials")
+	flag.StringVar(&authMode, "auth-mode", authMode, "authorization flow when there is no -token-file: browser, manual to paste the code back, or device to enter a code on another device")
+
+	flag.BoolVar(&writeSidecars, "sidecar", writeSidecars, "upload a JSON sidecar with the catalog entry next to each object, as <object>.json")
+	flag.BoolVar(&overwriteEdits, "overwrite-edits", overwriteEdits, "regenerate descriptions edited since they were generated, with review import or in Drive, instead of keeping the edits")
+	flag.BoolVar(&exportPermissions, "export-permissions", exportPermissions, "export each file's Drive owners, sharing state and permissions to the catalog and sidecar")
+	flag.BoolVar(&exportMediaMetadata, "export-media-metadata", exportMediaMetadata, "export the camera, exposure and location metadata Drive extracted from each image to the catalog and sidecar")
+	flag.BoolVar(&exportComments, "export-comments", exportComments, "export each file's Drive comments and replies to the catalog and sidecar")
+	flag.StringVar(&reviewPrefix, "review-prefix", reviewPrefix, "folder within the GCS path to upload files whose description failed under, empty to leave them in place labeled with review-status metadata")
+	flag.StringVar(&timelineFile, "timeline", timelineFile, "path to write an HTML Gantt chart of each file's download, describe and upload stages to, empty to skip writing")
+	flag.StringVar(&reviewQueueFile, "review-queue", reviewQueueFile, "path to write the CSV of files whose description failed, empty to skip writing")
+	flag.StringVar(&outputFormat, "output-format", outputFormat, "catalog output format: csv, markdown, or a DAM or CMS import file: aem, wordpress or contentful")
+	flag.StringVar(&damFile, "dam-file", damFile, "path to write the aem, wordpress or contentful import file to, defaulting to aem-metadata.csv, wordpress-media.xml or contentful-assets.json")
+	flag.StringVar(&aemRoot, "aem-root", aemRoot, "AEM DAM folder the asset paths of -output-format aem are under")
+	flag.StringVar(&contentfulLocale, "contentful-locale", contentfulLocale, "locale of the asset fields of -output-format contentful")
+	flag.StringVar(&statusFile, "status-file", statusFile, "path to write the machine-readable run status JSON, empty to skip writing")
+	flag.StringVar(&notifyURL, "notify-url", notifyURL, "webhook URL to POST the run status to when the run finishes or fails")
+	flag.StringVar(&notifyFormat, "notify-format", notifyFormat, "webhook payload format: json, slack or chat; defaults to slack or chat for their webhook URLs, json otherwise")
+	flag.StringVar(&emailTo, "email-to", emailTo, "comma-separated addresses to email the run summary and failed files to, with -smtp-server or SENDGRID_API_KEY")
+	flag.StringVar(&emailFrom, "email-from", emailFrom, "sender address of the summary email")
+	flag.StringVar(&smtpServer, "smtp-server", smtpServer, "SMTP server host:port to send the summary email with, authenticating with SMTP_USERNAME and SMTP_PASSWORD; uses SendGrid if empty")
+	flag.StringVar(&summaryFile, "summary", summaryFile, "path to write the end of run summary JSON, empty to skip writing")
+	flag.StringVar(&debugAddr, "debug-addr", debugAddr, "address to serve /debug/pprof and /healthz on during the run, such as localhost:6060 or unix:/path/to.sock, empty to not serve them; also served by the serve, events and mcp commands")
+	flag.StringVar(&controlAddr, "control", controlAddr, "unix:/path/to.sock or localhost address, such as localhost:6061, to serve an endpoint pausing, resuming and changing the concurrency of the run on, empty to not serve it")
+	flag.StringVar(&cpuProfile, "profile-cpu", cpuProfile, "path to write a CPU profile of the run to")
+	flag.StringVar(&memProfile, "profile-mem", memProfile, "path to write a heap profile at the end of the run to")
+
+	flag.StringVar(&ownedBy, "owned-by", ownedBy, "only process files owned by: me, an email address, or anyone")
+	flag.StringVar(&mimeTypesList, "mime-types", mimeTypesList, "Comma-separated list of MIME types")
+	flag.IntVar(&flushEvery, "flush-every", flushEvery, "flush the catalog to disk every this many records, 0 to flush only at the end")
+	flag.StringVar(&columnsList, "columns", columnsList, "Comma-separated list of CSV output columns")
+}
 
-	flag.BoolVar(&manualAuth, "no-launch-browser", false, "manual authentication - prevents the command from automatically opening a web browser")
+// parseFlags parses the command line flags and derived settings
+func parseFlags() {
+	flag.Parse()
 
-	mimeTypesFlag := flag.String("mime-types", "image/jpeg,image/png", "Comma-separated list of MIME types")
+	if err := applySettings(flag.CommandLine); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+	if err := configureProxy(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+	if err := resolveProject(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+	if !slices.Contains(googleVIPs, googleVIP) {
+		fatal(exitFailure, "unknown -google-vip %q, expected one of %s", googleVIP, strings.Join(googleVIPs, ", "))
+	}
+	if err := configureTransport(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
 
-	flag.Parse()
+	var err error
+	csvColumns, err = parseColumns(columnsList)
+	if err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+
+	if createDescription {
+		if err := validatePrompts(); err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+	}
+	if err := loadSystemInstruction(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+	if verifySample < 0 {
+		fatal(exitFailure, "-verify-sample must not be negative")
+	}
+	if flushEvery < 0 {
+		fatal(exitFailure, "-flush-every must not be negative")
+	}
+	if maxTags < 1 {
+		fatal(exitFailure, "-max-tags must be at least 1")
+	}
+	parseTagCategories()
+
+	if err := parseModes(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+
+	if ignoreFile != "" {
+		// only the default ignore file may be missing
+		ignorePatterns, err = readIgnoreFile(ignoreFile, ignoreFile != defaultIgnoreFile)
+		if err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+	}
+
+	if !slices.Contains(append(notifyFormats, ""), notifyFormat) {
+		fatal(exitFailure, "unknown -notify-format %q, expected one of %s", notifyFormat, strings.Join(notifyFormats, ", "))
+	}
+
+	if !slices.Contains(backends, backend) {
+		fatal(exitFailure, "unknown -backend %q, expected one of %s", backend, strings.Join(backends, ", "))
+	}
+
+	if activeHoursSpec != "" {
+		if activeHours, err = parseActiveHours(activeHoursSpec); err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+	}
+	if maxBytesFlag != "" {
+		maxBytes, err = parseBytes(maxBytesFlag)
+		if err != nil {
+			fatal(exitFailure, "-max-bytes: %v", err)
+		}
+	}
+	if err := checkPartitionFlags(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+	if transferOverFlag != "" {
+		if transferOver, err = parseBytes(transferOverFlag); err != nil {
+			fatal(exitFailure, "-transfer-over: %v", err)
+		}
+		if !publicDrive {
+			fatal(exitFailure, "-transfer-over requires -public-folder, as Storage Transfer Service fetches the files with the API key, not OAuth")
+		}
+	}
+
+	shardIndex, shardCount, err = parseShard(shardSpec)
+	if err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+
+	maxInlineBytes, err = parseBytes(maxInlineFlag)
+	if err != nil {
+		fatal(exitFailure, "-max-inline: %v", err)
+	}
+	if confirmOverFiles < 0 || confirmOverCost < 0 {
+		fatal(exitFailure, "-confirm-over-files and -confirm-over-cost must not be negative")
+	}
+	if maxSide < 0 {
+		fatal(exitFailure, "-max-side must not be negative")
+	}
+	if streamOverFlag != "" {
+		streamOver, err = parseBytes(streamOverFlag)
+		if err != nil {
+			fatal(exitFailure, "-stream-over: %v", err)
+		}
+	}
+	if stallTimeout <= 0 || describeTimeout < 0 {
+		fatal(exitFailure, "-stall-timeout must be positive and -describe-timeout must not be negative")
+	}
+
+	if maxConcurrency < 0 || quotaRetries < 0 {
+		fatal(exitFailure, "-concurrency and -quota-retries must not be negative")
+	}
+	throttler = newThrottle(maxConcurrency)
+	if memoryLimitFlag != "" {
+		memoryLimit, err = parseMemoryLimit(memoryLimitFlag)
+		if err != nil {
+			fatal(exitFailure, "-memory-limit: %v", err)
+		}
+	}
+
+	if lockTTL < 3*time.Second {
+		fatal(exitFailure, "-lock-ttl must be at least 3s")
+	}
+
+	if watermarkOpacity < 0 || watermarkOpacity > 1 {
+		fatal(exitFailure, "-watermark-opacity must be between 0 and 1")
+	}
+	if (watermarkText != "" || watermarkImage != "") && archivePrefix == "" {
+		fatal(exitFailure, "-archive-prefix is required to keep the originals of watermarked images")
+	}
+	if blurFaces && archivePrefix == "" {
+		fatal(exitFailure, "-archive-prefix is required to keep the originals of images with blurred faces")
+	}
+
+	if signedURLTTL < 0 || signedURLTTL > maxSignedURLTTL {
+		fatal(exitFailure, "-signed-url-ttl must be between 0 and %s", maxSignedURLTTL)
+	}
+
+	if !validOwnedBy(ownedBy) {
+		fatal(exitFailure, "invalid -owned-by %q, expected me, anyone or an email address", ownedBy)
+	}
+
+	if !slices.Contains(animationModes, animationMode) {
+		fatal(exitFailure, "unknown -animation %q, expected one of %s", animationMode, strings.Join(animationModes, ", "))
+	}
+	if !slices.Contains(layouts, objectLayout) {
+		fatal(exitFailure, "unknown -layout %q, expected one of %s", objectLayout, strings.Join(layouts, ", "))
+	}
+	if piiAction != "" && !slices.Contains(piiActions, piiAction) {
+		fatal(exitFailure, "unknown -pii %q, expected one of %s", piiAction, strings.Join(piiActions, ", "))
+	}
+	if piiAction == piiRestrict && piiBucket == "" {
+		fatal(exitFailure, "-pii restrict requires -pii-bucket")
+	}
+	if redescribeIfPromptChanged && (!writeSidecars || objectLayout != layoutPath) {
+		fatal(exitFailure, "-redescribe-if-prompt-changed requires -sidecar and -layout path, to find the prompt each file was described under")
+	}
 
-	mimeTypes = strings.Split(*mimeTypesFlag, ",")
+	if !slices.Contains(authModes, authMode) {
+		fatal(exitFailure, "unknown -auth-mode %q, expected one of %s", authMode, strings.Join(authModes, ", "))
+	}
+	if manualAuth && authMode == authBrowser {
+		authMode = authManual
+	}
+	if !slices.Contains(tokenEncryptions, tokenEncryption) {
+		fatal(exitFailure, "unknown -token-encryption %q, expected one of %s", tokenEncryption, strings.Join(tokenEncryptions, ", "))
+	}
+	tokenFile, err = accountTokenFile(tokenFile, driveAccount)
+	if err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+	if err := checkPublicFlags(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+	if err := checkDescribeOnlyFlags(); err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+
+	if !slices.Contains(collisionStrategies, onCollision) {
+		fatal(exitFailure, "unknown -on-collision strategy %q, expected one of %s", onCollision, strings.Join(collisionStrategies, ", "))
+	}
+	objectNames = newNameRegistry(false, onCollision)
+
+	mimeTypes = withZipMimeTypes(expandMimeTypes(strings.Split(mimeTypesList, ",")))
 	log.Printf("mime-types: %s", mimeTypes)
+
+	if runID == "" {
+		runID = newRunID()
+	}
+	if !slices.Contains(logFormats, logFormat) {
+		fatal(exitFailure, "unknown -log-format %q, expected one of %s", logFormat, strings.Join(logFormats, ", "))
+	}
+	configureLogging()
+	log.Printf("run ID: %s", runID)
+}
+
+// subcommands run instead of a migration, as drivetogcs <command> [flags] [args]
+var subcommands = map[string]func(ctx context.Context, args []string) int{
+	"doctor":    func(ctx context.Context, args []string) int { return runDoctor(ctx) },
+	"review":    runReview,
+	"inventory": runInventory,
+	"try":       runTry,
+	"bootstrap": runBootstrap,
+	"serve":     runServe,
+	"k8s-jobs":  runKubeJobs,
+	"events":    runEvents,
+	"search":    runSearch,
+	"mcp":       runMCP,
+	"publish":   runPublish,
 }
 
+// subcommandFlags register the flags of subcommands taking flags alongside
+// the global ones
+var subcommandFlags = map[string]func(){
+	"inventory": inventoryFlags,
+	"try":       tryFlags,
+	"bootstrap": bootstrapFlags,
+	"serve":     serveFlags,
+	"k8s-jobs":  kubeJobsFlags,
+	"events":    eventsFlags,
+	"search":    searchFlags,
+	"mcp":       mcpFlags,
+	"publish":   publishFlags,
+}
+
+// serverCommands are the subcommands serving until stopped, which serve
+// -debug-addr as a run does
+var serverCommands = []string{"serve", "events", "mcp"}
+
 func main() {
-	// prerequisites
-	// Get the Project ID from the environment
-	projectID = os.Getenv("PROJECT_ID")
-	if projectID == "" {
-		log.Fatalf("Please provide PROJECT_ID environment variable, e.g. export PROJECT_ID=$(gcloud config get-value core/project)")
+	stats = newRunStats()
+	var command string
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		if register := subcommandFlags[command]; register != nil {
+			register()
+		}
 	}
-	// Get the Google Cloud region location from the environment
-	location = os.Getenv("LOCATION")
-	if location == "" {
-		location = "us-central1"
+	parseFlags()
+	if command != "" {
+		if debugAddr != "" && slices.Contains(serverCommands, command) {
+			if err := serveDebug(); err != nil {
+				fatal(exitFailure, "%v", err)
+			}
+		}
+		os.Exit(subcommands[command](context.Background(), flag.Args()))
 	}
 
-	// Get the Google credentials from the environment variable
-	credentials := os.Getenv("GOOGLE_CREDENTIALS")
-	if credentials == "" {
-		panic("GOOGLE_CREDENTIALS not set")
+	// other guards
+	if sourceFolderID == "" && manifestFile == "" && !readStdin {
+		fatal(exitFailure, "Please provide a Drive folder with -folder, or Drive file IDs with -manifest or -stdin")
 	}
 
-	// other guards
-	// set target GCS bucket as gs://PROJECT_ID-media
-	if gcsBucket == "" {
-		gcsBucket = fmt.Sprintf("%s-media", projectID)
+	if debugAddr != "" {
+		if err := serveDebug(); err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+	}
+	stopControl := func() {}
+	if controlAddr != "" {
+		var err error
+		if stopControl, err = serveControl(); err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+	}
+	stopProfiling, err := startProfiling()
+	if err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if memoryLimit > 0 {
+		go watchMemory(ctx)
+	}
+
+	closeClients := initClients(ctx)
+	defer closeClients()
+
+	var runLease *lease
+	if useLock {
+		runLease, err = acquireLease(ctx, gcsBucket, lockObject(), lockTTL)
+		if err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+		go runLease.keepAlive(ctx, cancel)
+	}
+
+	output, err := newRecordWriter(outputFormat)
+	if err != nil {
+		fatal(exitFailure, "%v", err)
+	}
+
+	if partitionBy != "" {
+		currentPlan = newRunPlan(nil)
+		currentPlan.print()
+		if err := processPartitions(ctx, output); err != nil {
+			stats.failErr("partition", err)
+			log.Printf("%v", err)
+		}
+	} else {
+		files := make(chan drive.File)
+		go func() {
+			defer close(files)
+			if readStdin {
+				currentPlan = newRunPlan(nil)
+				currentPlan.print()
+				log.Println("reading Drive file IDs from stdin")
+				streamFiles(ctx, os.Stdin, files)
+				return
+			}
+
+			//mimeTypes := []string{"image/jpeg", "image/png", "image/webp"}
+			var fileList []drive.File
+			if manifestFile != "" {
+				ids, err := readManifest(manifestFile)
+				if err != nil {
+					fatal(exitFailure, "%v", err)
+				}
+				fileList = getFiles(ctx, ids)
+			} else {
+				var err error
+				if recursive {
+					fileList, err = listFilesRecursive(ctx, sourceFolderID, mimeTypes)
+				} else {
+					fileList, err = listFiles(ctx, sourceFolderID, mimeTypes)
+				}
+				if err != nil {
+					fatalErr(err, "error occurred while listing files: %v", err)
+				}
+			}
+			if maxFiles != 0 {
+				log.Printf("Files %d (max: %d)", len(fileList), maxFiles)
+			} else {
+				log.Printf("Files %d", len(fileList))
+			}
+			currentPlan = newRunPlan(fileList)
+			currentPlan.print()
+			if err := confirmRun(fileList); err != nil {
+				fatal(exitFailure, "%v", err)
+			}
+			if err := delegateTransfer(ctx, fileList); err != nil {
+				fatal(exitFailure, "%v", err)
+			}
+			for _, file := range fileList {
+				files <- file
+			}
+		}()
+
+		var started <-chan drive.File = files
+		if activeHours != nil {
+			started = gateActiveHours(ctx, files)
+		}
+		processFiles(ctx, started, output)
+	}
+
+	if runLease != nil {
+		if err := runLease.release(context.Background()); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	if err := output.Close(); err != nil {
+		log.Printf("failed to write %s output: %v", outputFormat, err)
+	} else {
+		log.Printf("%s output written successfully.", outputFormat)
+	}
+
+	if objectLayout == layoutSHA256 && contentIndexFile != "" {
+		if err := contents.write(contentIndexFile); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	if reviewQueueFile != "" {
+		if err := reviews.write(reviewQueueFile); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	if timelineFile != "" {
+		if err := timelines.write(timelineFile); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	var verification *verificationReport
+	if verifySample > 0 {
+		verification = verifications.verify(ctx)
+	}
+
+	summary := stats.summary()
+	summary.Verification = verification
+	summary.Plan = currentPlan
+	summary.print()
+	if summaryFile != "" {
+		if err := writeSummary(summaryFile, summary); err != nil {
+			log.Printf("%v", err)
+		}
 	}
 
-	ctx := context.Background()
+	stopControl()
+	stopProfiling()
+	code := exitCode(summary)
+	reportStatus(code, "", &summary)
+	os.Exit(code)
+}
+
+// initClients reads the environment and creates the Drive, Cloud Storage and
+// genai clients, returning a function to close them
+func initClients(ctx context.Context) func() {
+	closeClients := initCloudClients(ctx)
+	initDrive(ctx)
+	return closeClients
+}
+
+// initDrive creates the Drive client from the -drive-credentials, or the
+// GOOGLE_CREDENTIALS OAuth client
+func initDrive(ctx context.Context) {
+	if publicDrive {
+		srv, err := newPublicDrive(ctx)
+		if err != nil {
+			fatalErr(err, "Unable to create Drive service: %v", err)
+		}
+		driveSrv = srv
+		return
+	}
+
+	// Get the Google credentials from the flag or environment variable
+	credentials := driveCredentialsFile()
+	if credentials == "" {
+		fatal(exitFailure, "Please provide GOOGLE_CREDENTIALS environment variable, the path to the OAuth2 client credentials JSON, or -drive-credentials")
+	}
 
 	// Initialize Drive Service
 	b, err := os.ReadFile(credentials)
 	if err != nil {
-		log.Fatalf("cannot find credentials file %s: %v", credentials, err)
+		fatal(exitFailure, "cannot find credentials file %s: %v", credentials, err)
 	}
-	config, err := google.ConfigFromJSON(b, "https://www.googleapis.com/auth/drive")
+	client, _, err := driveHTTPClient(ctx, b)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		fatal(exitAuth, "Unable to parse credentials file %s: %v", credentials, err)
 	}
-	client := getClient(config, manualAuth)
 
-	driveSrv, err = drive.NewService(ctx, option.WithHTTPClient(client))
+	srv, err := drive.NewService(ctx, append(driveOptions(), option.WithHTTPClient(client))...)
 	if err != nil {
-		log.Fatalf("Unable to create Drive service: %v", err)
+		fatalErr(err, "Unable to create Drive service: %v", err)
+	}
+	driveSrv = &driveService{srv: srv}
+	if err := checkAccount(ctx); err != nil {
+		fatalErr(err, "%v", err)
 	}
+}
 
-	// Initialize genai Client
-	genaiClient, err = createGenaiClient(ctx)
-	if err != nil {
-		log.Fatalf("Unable to create genai client: %v", err)
+// initCloudClients checks the project the run needs, if any, is set and
+// creates the Cloud Storage and genai clients, returning a function to close them
+func initCloudClients(ctx context.Context) func() {
+	// prerequisites
+	describes := describesFiles()
+	if projectID == "" && (backend == backendVertex && describes || gcsBucket == "" && !describeOnly || transferOver > 0) {
+		fatal(exitFailure, "Please provide a project with -project or the PROJECT_ID environment variable, e.g. export PROJECT_ID=$(gcloud config get-value core/project)")
+	}
+	if backend == backendGeminiAPI && describes && geminiAPIKey() == "" {
+		fatal(exitFailure, "Please provide GEMINI_API_KEY environment variable, a Gemini Developer API key from https://aistudio.google.com/apikey")
 	}
+	var err error
+	var gcsClient *storage.Client
+	if !describeOnly {
+		// set target GCS bucket as gs://PROJECT_ID-media
+		if gcsBucket == "" {
+			gcsBucket = fmt.Sprintf("%s-media", projectID)
+		}
+		if replicaBuckets, err = parseReplicaBuckets(replicaBucketsList, gcsBucket); err != nil {
+			fatal(exitFailure, "%v", err)
+		}
 
-	//mimeTypes := []string{"image/jpeg", "image/png", "image/webp"}
-	fileList := listFiles(ctx, sourceFolderID, mimeTypes)
-	if maxFiles != 0 {
-		log.Printf("Files %d (max: %d)", len(fileList), maxFiles)
-	} else {
-		log.Printf("Files %d", len(fileList))
+		// Initialize Cloud Storage client
+		opts, err := storageOptions(ctx)
+		if err != nil {
+			fatal(exitAuth, "Unable to read -storage-credentials: %v", err)
+		}
+		gcsClient, err = storage.NewClient(ctx, opts...)
+		if err != nil {
+			fatalErr(err, "Unable to create storage client: %v", err)
+		}
+		storageSrv = &gcsStorage{client: gcsClient}
 	}
 
-	var wg sync.WaitGroup
+	// transfer only: nothing is described, so Vertex AI isn't needed
+	if !describes {
+		log.Println("transfer only: files are not described, no Gemini client is created")
+		return closeCloudClients(gcsClient, nil)
+	}
 
-	csvFile, err := os.Create("descriptions.csv")
+	// Initialize genai Client
+	if err := loadVertexCredentials(ctx); err != nil {
+		fatal(exitAuth, "Unable to read -vertex-credentials: %v", err)
+	}
+	gc, err := createGenaiClient(ctx)
 	if err != nil {
-		log.Fatalf("failed to create CSV file: %v", err)
+		fatalErr(err, "Unable to create genai client: %v", err)
 	}
-	defer csvFile.Close()
+	genaiClient = gc
 
-	csvWriter := csv.NewWriter(csvFile)
-	defer csvWriter.Flush() // Ensure all buffered data is written
+	// record every Gemini request and response
+	var audit *auditLog
+	if auditLogPath != "" {
+		audit, err = openAuditLog()
+		if err != nil {
+			fatal(exitFailure, "%v", err)
+		}
+		genaiClient = &auditedGenerator{generator: gc, log: audit}
+	}
+	return closeCloudClients(gcsClient, audit)
+}
 
-	fileCount := len(fileList)
-	if maxFiles > 0 && maxFiles < fileCount {
-		fileCount = maxFiles
+// closeCloudClients returns a function closing the Cloud Storage client and
+// the audit log, either of which may be nil
+func closeCloudClients(gcsClient *storage.Client, audit *auditLog) func() {
+	return func() {
+		if audit != nil {
+			if err := audit.close(context.Background()); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+		if gcsClient != nil {
+			gcsClient.Close()
+		}
 	}
+}
 
-	for i := 0; i < fileCount; i++ {
-		file := fileList[i]
+// processFiles describes each file received, up to maxFiles and the maxBytes
+// budget, writing a record for each to output
+func processFiles(ctx context.Context, files <-chan drive.File, output recordWriter) {
+	var wg sync.WaitGroup
+
+	count := 0
+	var started int64 // Drive bytes of the files started, for the -max-bytes budget
+	var remaining []drive.File
+	var unauthorized []drive.File // files not started once the token was revoked
+	for file := range files {
+		if maxFiles > 0 && count >= maxFiles {
+			continue // drain the remaining files
+		}
+		if tokenRevoked.Load() {
+			unauthorized = append(unauthorized, file)
+			continue
+		}
+		if pattern, ok := ignored(file); ok {
+			stats.skip("ignored")
+			log.Printf("skipping %s (%s): matches %s in %s", file.Name, file.Id, pattern, ignoreFile)
+			continue
+		}
+		if r := ruleFor(file); r != nil && r.Skip {
+			stats.skip("rule")
+			log.Printf("skipping %s (%s): matches a config rule", file.Name, file.Id)
+			continue
+		}
+		// names are claimed for every file, so all shards resolve collisions alike
+		name, err := objectName(file)
+		if !inShard(file) {
+			continue
+		}
+		if maxBytes > 0 && started >= maxBytes {
+			remaining = append(remaining, file)
+			continue
+		}
+		if errors.Is(err, errNameCollision) && onCollision == collisionSkip {
+			stats.skip("collision")
+			log.Printf("skipping %s (%s): %v", file.Name, file.Id, err)
+			continue
+		}
+		if errors.Is(err, errNameCollision) {
+			// failed before it is downloaded and described, which it can't be uploaded for
+			stats.fail(stageCollision)
+			stats.failFile(file, stageCollision, err)
+			log.Printf("%s (%s): %v", file.Name, file.Id, err)
+			continue
+		}
+		started += file.Size
+		count++
+		throttler.acquire()
 		wg.Add(1)
 		go func(file drive.File) {
 			defer wg.Done()
-			description, size, err := describe(ctx, file)
-			if err != nil {
-				description = fmt.Sprintf("Error: %v", err) // Store error in description
-			}
-			record := []string{
-				file.Name,
-				fmt.Sprintf("%d", size),
-				file.MimeType,
-				file.Id,
-				description,
+			defer throttler.release()
+			if redescribeIfPromptChanged {
+				stale, reason, err := describedUnderOlderPrompt(ctx, file, name)
+				if err != nil {
+					stats.failErr("redescribe", err)
+					stats.failFile(file, "redescribe", err)
+					log.Printf("%s: %v", file.Name, err)
+					return
+				}
+				if !stale {
+					stats.skip(reason)
+					log.Printf("skipping %s (%s): %s", file.Name, file.Id, reason)
+					return
+				}
+				defer staleObjects.Delete(file.Id)
 			}
-			if err := csvWriter.Write(record); err != nil {
-				log.Printf("failed to write to CSV: %v", err)
+			r := processFile(ctx, file, name)
+			if err := output.Write(r); err != nil {
+				stats.fail("output")
+				log.Printf("failed to write record: %v", err)
 			}
-
-			if err != nil {
-				log.Printf("unable to describe: %v", err)
+			if expandZips && isZip(file.MimeType) {
+				processZip(ctx, file, output)
 			}
-			log.Printf("%s (%s) %s = %s", file.Name, file.MimeType, file.Id, description)
 		}(file)
 	}
 	wg.Wait()
 
-	log.Println("CSV file written successfully.")
+	if len(unauthorized) > 0 {
+		stats.skipN("unauthorized", len(unauthorized))
+		log.Printf("the OAuth token was revoked, %d files not started", len(unauthorized))
+		remaining = append(unauthorized, remaining...)
+	}
+	if len(remaining) > 0 {
+		if n := len(remaining) - len(unauthorized); n > 0 {
+			stats.skipN("budget", n)
+			log.Printf("byte budget of %d reached after %d bytes, %d files remaining", maxBytes, started, n)
+		}
+		if checkpointFile != "" {
+			if err := writeCheckpoint(checkpointFile, remaining); err != nil {
+				log.Printf("%v", err)
+			} else {
+				log.Printf("resume with -manifest %s", checkpointFile)
+			}
+		}
+	}
+}
+
+// processFile describes and uploads a file, along with any additional exports,
+// returning its catalog record
+func processFile(ctx context.Context, file drive.File, name string) record {
+	ctx = withAuditFile(ctx, file)
+	description, size, err := describe(ctx, file)
+	quarantined := needsReview(err) && !redescribing(file) // a stale object is left in place
+	if err != nil {
+		log.Printf("unable to describe: %v", err)
+		description = fmt.Sprintf("Error: %v", err) // Store error in description
+		stats.failFile(file, failureStage(err), err)
+	}
+	originalName := name
+	if name != "" {
+		name = contentName(file, name)
+	}
+	if quarantined && name != "" {
+		name = reviewName(name)
+	}
+	var ierr *infectedError
+	var herr *hookError
+	var perr *piiError
+	if describeOnly || errors.As(err, &ierr) || errors.As(err, &herr) && herr.hook == hookPreUpload || errors.Is(err, errWatermark) || errors.Is(err, errBlur) || errors.As(err, &perr) {
+		name = "" // not uploaded, so there is no object for the later stages
+	}
+	log.Printf("%s (%s) %s = %s", file.Name, file.MimeType, file.Id, description)
+
+	folderID := fileFolderID(file)
+	dest := routeFor(file)
+	if describeOnly {
+		dest.Bucket = ""
+	}
+	var path string
+	if name != "" {
+		path = objectPath(dest.Prefix, name)
+	}
+	r := record{
+		Name:         file.Name,
+		Size:         size,
+		MimeType:     file.MimeType,
+		ID:           file.Id,
+		RunID:        runID,
+		FolderID:     folderID,
+		FolderName:   getFolderName(folderID),
+		RelativePath: relativePath(file),
+		Bucket:       dest.Bucket,
+		ObjectPath:   path,
+		Description:  description,
+		NeedsReview:  quarantined,
+		Labels:       labelsFor(file),
+		PII:          piiFound(file),
+		Languages:    spokenLanguages(file),
+	}
+	if boxes, ok := facesFound(file); ok {
+		n := len(boxes)
+		r.Faces = &n
+	}
+	if info, ok := animations.Load(file.Id); ok {
+		a := info.(animationInfo)
+		r.Animation = &a
+	}
+	if edit, ok := editedDescriptions.Load(file.Id); ok && err == nil {
+		r.EditedAt = edit.(descriptionEdit).EditedAt
+		r.ReviewStatus = reviewPending
+	} else if settings := describeSettingsFor(file); err == nil && settings.Describe {
+		r.Model, r.Prompt = settings.Model, promptID(settings.Prompt)
+		r.DescribedAt = time.Now().UTC().Format(time.RFC3339)
+		r.ReviewStatus = reviewPending
+		if region, ok := servedRegions.Load(file.Id); ok {
+			r.Region = region.(string)
+		}
+		if outputs, ok := refinedOutputs.Load(file.Id); ok {
+			r.Refinements = outputs.(map[string]string)
+		}
+		if tags, ok := taggings.Load(file.Id); ok {
+			r.Tags, r.Category = tags.(fileTags).Tags, tags.(fileTags).Category
+		}
+		if g, ok := groundings.Load(file.Id); ok {
+			r.Citations, r.SearchQueries = g.(grounding).Citations, g.(grounding).SearchQueries
+		}
+	}
+	if name != "" && (watermarks(file.MimeType) || blursFaces(file)) {
+		r.OriginalPath = objectPath(dest.Prefix, archiveName(name))
+	}
+	if verifySample > 0 && err == nil && r.ObjectPath != "" && !extracted(file) {
+		// watermarked and blurred images are compared by their originals
+		verifications.add(file, r.Bucket, cmp.Or(r.OriginalPath, r.ObjectPath))
+	}
+	if hash, ok := contentHashes.Load(file.Id); ok && path != "" {
+		contents.add(objectPath(dest.Prefix, originalName), hash.(string), path, file.Id)
+	}
+	if signedURLTTL > 0 && r.ObjectPath != "" {
+		signedURL, err := storageSrv.SignedURL(r.Bucket, r.ObjectPath, signedURLTTL)
+		r.SignedURL = signedURL
+		if err != nil {
+			stats.failErr("signed-url", err)
+			stats.failFile(file, "signed-url", err)
+			log.Printf("%s: unable to sign URL: %v", file.Name, err)
+		}
+	}
+	if migrateRevisions && r.ObjectPath != "" && !extracted(file) && !redescribing(file) {
+		start := time.Now()
+		revisions, err := uploadRevisions(ctx, file.Id, r.Bucket, r.ObjectPath)
+		observeFile(file.Id, "revisions", start)
+		r.Revisions = revisions
+		if err != nil {
+			stats.failErr("revisions", err)
+			stats.failFile(file, "revisions", err)
+			log.Printf("%s: %v", file.Name, err)
+		}
+	}
+	if exportPermissions && !extracted(file) {
+		if err := addPermissions(ctx, &r); err != nil {
+			stats.failErr("permissions", err)
+			stats.failFile(file, "permissions", err)
+			log.Printf("%s: %v", file.Name, err)
+		}
+	}
+	if exportMediaMetadata && !extracted(file) {
+		if err := addMediaMetadata(ctx, &r); err != nil {
+			stats.failErr("media-metadata", err)
+			stats.failFile(file, "media-metadata", err)
+			log.Printf("%s: %v", file.Name, err)
+		}
+	}
+	if exportComments && !extracted(file) {
+		if err := addComments(ctx, &r); err != nil {
+			stats.failErr("comments", err)
+			stats.failFile(file, "comments", err)
+			log.Printf("%s: %v", file.Name, err)
+		}
+	}
+	r.Timeline = timelineOf(file.Id)
+	if r.ObjectPath != "" {
+		r.Generation = generationOf(r.Bucket, r.ObjectPath)
+		r.Replicas = replicasOf(file.Id, r.ObjectPath)
+	}
+	if timelineFile != "" {
+		timelines.add(r)
+	}
+	if writeSidecars && r.ObjectPath != "" {
+		if err := addDescriptionVersions(ctx, &r); err != nil {
+			stats.failErr("versions", err)
+			stats.failFile(file, "versions", err)
+			log.Printf("%s: %v", file.Name, err)
+		}
+		if err := writeSidecar(ctx, r); err != nil {
+			stats.failErr("sidecar", err)
+			stats.failFile(file, "sidecar", err)
+			log.Printf("%s: %v", file.Name, err)
+		}
+	}
+	if quarantined {
+		reviews.add(r)
+	}
+	if postFileHook != "" {
+		event := newHookEvent(hookPostFile, file)
+		event.Bucket, event.ObjectPath, event.Description = r.Bucket, r.ObjectPath, r.Description
+		event.Record = &r
+		if _, err := runHook(ctx, postFileHook, event); err != nil {
+			stats.failErr(hookPostFile, err)
+			stats.failFile(file, hookPostFile, err)
+			log.Printf("%v", err)
+		}
+	}
+	return r
+}
+
+// failureStage returns the stage of a file failure, if it can be told from err
+func failureStage(err error) string {
+	var herr *hookError
+	var ierr *infectedError
+	var perr *piiError
+	switch {
+	case needsReview(err):
+		return stageDescribe
+	case errors.As(err, &perr):
+		return stagePII
+	case errors.As(err, &herr):
+		return herr.hook
+	case errors.As(err, &ierr):
+		return stageScan
+	case errors.Is(err, errWatermark):
+		return stageWatermark
+	case errors.Is(err, errBlur):
+		return stageFaces
+	case errors.Is(err, errNameCollision):
+		return stageCollision
+	}
+	return ""
 }
 
 // listFiles lists all the files in a Drive folder
-func listFiles(ctx context.Context, folderID string, mimeTypes []string) []drive.File {
+func listFiles(ctx context.Context, folderID string, mimeTypes []string) ([]drive.File, error) {
+	query := buildQuery(folderID, mimeTypes)
+
+	files, err := driveSrv.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%s has %d files matching %s", folderID, len(files), query)
+
+	found := []drive.File{}
+	for _, f := range files {
+		if f != nil {
+			found = append(found, *f)
+		}
+	}
+	return found, nil
+}
+
+// buildQuery builds the Drive search query for files of the mime types in a folder
+func buildQuery(folderID string, mimeTypes []string) string {
 	// ref https://developers.google.com/drive/api/guides/search-files
 	//query := "mimeType = 'image/jpeg'"
 	//query := "name contains '.jpg'"
@@ -190,121 +1059,420 @@ func listFiles(ctx context.Context, folderID string, mimeTypes []string) []drive
 	// Build the mimeType portion of the query.
 	mimeQueryParts := make([]string, len(mimeTypes))
 	for i, mimeType := range mimeTypes {
-		mimeQueryParts[i] = fmt.Sprintf("mimeType = '%s'", mimeType)
+		mimeQueryParts[i] = fmt.Sprintf("mimeType = '%s'", strings.TrimSpace(mimeType))
 	}
 	mimeQuery := strings.Join(mimeQueryParts, " or ")
 
 	// Build the full query.
 	query := fmt.Sprintf("'%s' in parents and (%s)", folderID, mimeQuery)
+	if owners := ownerQuery(); owners != "" {
+		query += " and " + owners
+	}
+	if modifiedWindow != "" {
+		query += " and " + modifiedWindow
+	}
+	return query
+}
 
-	fileList, err := driveSrv.Files.List().
-		PageSize(1000).
-		Q(query).
-		Do()
-	if err != nil {
-		log.Fatalf("error occurred while listing files: %v", err)
+// fileFolderID returns the Drive folder a file was found in
+func fileFolderID(file drive.File) string {
+	if loc, ok := fileLocations.Load(file.Id); ok && loc.(fileLocation).folderID != "" {
+		return loc.(fileLocation).folderID
+	}
+	if sourceFolderID != "" {
+		return sourceFolderID
+	}
+	if len(file.Parents) > 0 {
+		return file.Parents[0]
 	}
-	log.Printf("%s has %d files matching %s", folderID, len(fileList.Files), query)
+	return ""
+}
 
-	found := []drive.File{}
-	for _, f := range fileList.Files {
-		if f != nil {
-			found = append(found, *f)
-		}
+var folderNames sync.Map
+
+// getFolderName returns the name of a Drive folder, or an empty string if it cannot be retrieved
+func getFolderName(folderID string) string {
+	if folderID == "" {
+		return ""
 	}
-	return found
+	if name, ok := folderNames.Load(folderID); ok {
+		return name.(string)
+	}
+	folder, err := driveSrv.Get(context.Background(), folderID, "name")
+	if err != nil {
+		log.Printf("unable to get folder name for %s: %v", folderID, err)
+		return ""
+	}
+	folderNames.Store(folderID, folder.Name)
+	return folder.Name
 }
 
 // describe describes an image given an image file from drive
 func describe(ctx context.Context, imageFile drive.File) (string, int, error) {
-	// obtain file
-	fileBytes, err := getFileBytes(imageFile)
+	// obtain file, unless it is described from its object
+	start := time.Now()
+	var fileBytes []byte
+	var byteCount int
+	var err error
+	source, fromGCS := gcsSource(ctx, imageFile)
+	if redescribing(imageFile) {
+		log.Printf("%s was described under an older prompt, describing it again from its object", imageFile.Name)
+		fileBytes, err = readStaleObject(ctx, imageFile)
+		observeFile(imageFile.Id, stageDownload, start)
+		if err != nil {
+			stats.failErr(stageDownload, err)
+			return "", 0, err
+		}
+		byteCount = len(fileBytes)
+		recordAnimation(imageFile, fileBytes)
+	} else if fromGCS {
+		log.Printf("%s is uploaded already as %s, describing it without downloading", imageFile.Name, source)
+		gcsSources.Store(imageFile.Id, source)
+		byteCount = int(imageFile.Size)
+	} else {
+		fileBytes, err = getFileBytes(ctx, imageFile)
+		observeFile(imageFile.Id, stageDownload, start)
+		if err != nil {
+			stats.failErr(stageDownload, err)
+			return "", 0, err
+		}
+		log.Printf("Obtained file bytes %s (%d)", imageFile.Name, len(fileBytes))
+		byteCount = len(fileBytes)
+		recordAnimation(imageFile, fileBytes)
+	}
+	stats.addFile(byteCount)
+	keepArchive(imageFile, fileBytes)
+
+	// scan for viruses before the file is described or uploaded
+	if clamdAddress != "" {
+		start = time.Now()
+		err := scanClamd(ctx, clamdAddress, fileBytes)
+		observeFile(imageFile.Id, stageScan, start)
+		var ierr *infectedError
+		if errors.As(err, &ierr) {
+			stats.skip("infected")
+			log.Printf("%s (%s) is %v, skipping", imageFile.Name, imageFile.Id, err)
+			localPath := filepath.Join(localFolderName, localName(imageFile))
+			unlock := lockPath(localPath)
+			if err := os.Remove(localPath); err != nil {
+				log.Printf("unable to remove infected local file: %v", err)
+			}
+			unlock()
+			return "", byteCount, err
+		}
+		if err != nil {
+			stats.failErr(stageScan, err)
+			return "", byteCount, err
+		}
+	}
+
+	hashContent(imageFile, fileBytes)
+
+	// Describe using Gemini multimodal
+	settings := describeSettingsFor(imageFile)
+	var descriptionText string
+	var describeErr error
+	edited := false
+	if settings.Describe && writeSidecars && !overwriteEdits {
+		// keep descriptions edited since they were generated
+		var edit descriptionEdit
+		edit, edited, err = editedDescription(ctx, imageFile)
+		if err != nil {
+			log.Printf("%s: %v", imageFile.Name, err)
+		}
+		if edited {
+			log.Printf("keeping the edited description of %s (%s)", imageFile.Name, imageFile.Id)
+			editedDescriptions.Store(imageFile.Id, edit)
+			descriptionText = edit.Description
+		}
+	}
+	if !edited {
+		descriptionText, describeErr = generateDescription(ctx, imageFile, fileBytes, settings)
+	}
+	if describeErr == nil && settings.Describe && !edited && postDescribeHook != "" {
+		event := newHookEvent(hookPostDescribe, imageFile)
+		event.Description = descriptionText
+		out, err := runHook(ctx, postDescribeHook, event)
+		if err != nil {
+			stats.failErr(hookPostDescribe, err)
+			stats.failFile(imageFile, hookPostDescribe, err)
+			log.Printf("%v", err)
+		} else if out != "" {
+			descriptionText = out
+		}
+	}
+	if categories := piiFound(imageFile); len(categories) > 0 && piiAction == piiSkip {
+		stats.skip("pii")
+		log.Printf("%s (%s) contains personal information, skipping", imageFile.Name, imageFile.Id)
+		return "", byteCount, &piiError{categories: categories}
+	}
+	if redescribing(imageFile) {
+		return descriptionText, byteCount, describeErr // the object is left as is
+	}
+	if describeOnly {
+		return descriptionText, byteCount, describeErr
+	}
+	if fromGCS {
+		if !needsReview(describeErr) {
+			return descriptionText, byteCount, describeErr
+		}
+		// download the file to quarantine it for review
+		start = time.Now()
+		fileBytes, err = getFileBytes(ctx, imageFile)
+		observeFile(imageFile.Id, stageDownload, start)
+		if err != nil {
+			stats.failErr(stageDownload, err)
+			return "", byteCount, err
+		}
+	}
+
+	// upload file to Google Cloud Storage, quarantining it for review if the
+	// description failed
+	start = time.Now()
+	name, err := objectName(imageFile)
 	if err != nil {
+		stats.fail(stageCollision)
 		return "", 0, err
 	}
-	log.Printf("Obtained file bytes %s (%d)", imageFile.Name, len(fileBytes))
+	dest := routeFor(imageFile)
+	attrs := objectAttrs(imageFile)
+	if info, ok := animations.Load(imageFile.Id); ok {
+		maps.Copy(attrs.Metadata, animationMetadata(info.(animationInfo)))
+	}
+	if stored := contentName(imageFile, name); stored != name {
+		attrs.Metadata[originalNameKey] = objectPath(dest.Prefix, name)
+		name = stored
+	}
+	if needsReview(describeErr) {
+		name = reviewName(name)
+		attrs.Metadata[reviewStatusKey] = "needs-review"
+	} else if describeErr == nil && settings.Describe {
+		attrs.Metadata[reviewStatusKey] = reviewPending
+	}
+	if preUploadHook != "" {
+		event := newHookEvent(hookPreUpload, imageFile)
+		event.LocalPath = filepath.Join(localFolderName, localName(imageFile))
+		event.Bucket = dest.Bucket
+		event.ObjectPath = objectPath(dest.Prefix, name)
+		if _, err := runHook(ctx, preUploadHook, event); err != nil {
+			stats.failErr(hookPreUpload, err)
+			return "", byteCount, err
+		}
+	}
 
-	// upload file to Google Cloud Storage
-	err = uploadFileToGCS(ctx, gcsBucket, gcsFolderPath, imageFile.Name, fileBytes, alwaysUploadToGCS)
+	// blur faces in and watermark the serving copy, keeping the original
+	// under the archive prefix
+	uploadBytes := fileBytes
+	if blursFaces(imageFile) {
+		start = time.Now()
+		boxes, _ := facesFound(imageFile)
+		uploadBytes, err = blurFaceBoxes(uploadBytes, imageFile.MimeType, boxes)
+		observeFile(imageFile.Id, stageFaces, start)
+		if err != nil {
+			stats.failErr(stageFaces, err)
+			return "", byteCount, fmt.Errorf("%w: %v", errBlur, err)
+		}
+	}
+	if watermarks(imageFile.MimeType) {
+		start = time.Now()
+		uploadBytes, err = watermark(uploadBytes, imageFile.MimeType)
+		observeFile(imageFile.Id, stageWatermark, start)
+		if err != nil {
+			stats.failErr(stageWatermark, err)
+			return "", byteCount, fmt.Errorf("%w: %v", errWatermark, err)
+		}
+	}
+	if watermarks(imageFile.MimeType) || blursFaces(imageFile) {
+		archived := objectPath(dest.Prefix, archiveName(name))
+		start = time.Now()
+		err = uploadFileToGCS(ctx, dest.Bucket, "", archived, fileBytes, attrs, alwaysUploadToGCS)
+		observeFile(imageFile.Id, stageUpload, start)
+		if err != nil {
+			stats.failErr(stageUpload, err)
+			log.Printf("Unable to upload original to GCS: %v", err)
+		}
+		attrs.Metadata = maps.Clone(attrs.Metadata)
+		attrs.Metadata[originalObjectKey] = archived
+	}
+	if describeErr == nil && settings.Describe && embeds(imageFile.MimeType) {
+		var keywords []string
+		if tags, ok := taggings.Load(imageFile.Id); ok {
+			keywords = tags.(fileTags).Tags
+		}
+		embedded, err := embedDescription(uploadBytes, imageFile.MimeType, descriptionText, keywords)
+		if err != nil {
+			stats.failErr(stageEmbed, err)
+			stats.failFile(imageFile, stageEmbed, err)
+			log.Printf("%s: unable to embed metadata: %v", imageFile.Name, err)
+		} else {
+			uploadBytes = embedded
+		}
+	}
+
+	start = time.Now()
+	err = uploadFileToGCS(ctx, dest.Bucket, dest.Prefix, name, uploadBytes, attrs, alwaysUploadToGCS)
+	observeFile(imageFile.Id, stageUpload, start)
 	if err != nil {
+		stats.failErr(stageUpload, err)
 		log.Printf("Unable to upload to GCS: %v", err)
+	} else if len(replicaBuckets) > 0 {
+		start = time.Now()
+		replicate(ctx, imageFile, dest.Prefix, name, uploadBytes, attrs)
+		observeFile(imageFile.Id, "replicate", start)
 	}
-	byteCount := len(fileBytes)
 
-	// Describe using Gemini multimodal
-	var descriptionText string
+	return descriptionText, byteCount, describeErr
+}
 
-	if createDescription {
-		log.Printf("Describing %s ...", imageFile.Name)
+// generateDescription describes a file's contents with Gemini, returning a
+// describeError if the model fails
+func generateDescription(ctx context.Context, imageFile drive.File, fileBytes []byte, settings describeSettings) (string, error) {
+	if !settings.Describe {
+		return "Description skipped", nil
+	}
+	log.Printf("Describing %s ...", imageFile.Name)
 
-		var tmpl *template.Template
+	part, cleanup, err := describePart(ctx, imageFile, fileBytes)
+	if err != nil {
+		stats.failErr(stageDescribe, err)
+		return "", &describeError{err: err}
+	}
+	defer cleanup()
 
-		if customPromptLocation != "" {
-			var err error
-			tmpl, err = template.ParseFiles(customPromptLocation)
-			if err != nil {
-				return "", 0, fmt.Errorf("failed to parse custom template: %w", err)
-			}
-		} else {
-			tmpl = template.Must(
-				template.New("describe_media.tpl").ParseFS(promptTemplates, "prompts/describe_media.tpl"),
-			)
-		}
-		data := struct {
-			ImageName string
-		}{
-			imageFile.Name,
-		}
-		buf := new(bytes.Buffer)
-		err = tmpl.Execute(buf, data)
+	// the spoken languages select the prompt, so they are detected first
+	if detectSpeechLanguage && hasSpeech(imageFile.MimeType) {
+		langs, err := detectLanguages(ctx, imageFile, settings.Model, part)
 		if err != nil {
-			return "", 0, err
+			stats.failErr(stageLanguage, err)
+			stats.failFile(imageFile, stageLanguage, err)
+			log.Printf("%s: %v", imageFile.Name, err)
+		} else if len(langs) > 0 {
+			log.Printf("%s speaks %s", imageFile.Name, strings.Join(langs, ", "))
+			speechLanguages.Store(imageFile.Id, langs)
+			settings = describeSettingsFor(imageFile)
 		}
-		prompt := buf.String()
+	}
 
-		contents := []*genai.Content{}
-		contents = append(contents, genai.NewUserContentFromBytes(fileBytes, imageFile.MimeType))
-		contents = append(contents, genai.Text(prompt)...)
+	prompt, err := renderPrompt(imageFile, settings.Prompt)
+	if err != nil {
+		stats.fail("prompt")
+		return "", err
+	}
+	contents := []*genai.Content{}
+	contents = append(contents, genai.NewUserContentFromParts([]*genai.Part{part}))
+	contents = append(contents, genai.Text(prompt)...)
+	if info, ok := animations.Load(imageFile.Id); ok {
+		contents = append(contents, genai.Text(animationNote(info.(animationInfo)))...)
+	}
 
-		config := &genai.GenerateContentConfig{}
-		description, err := genaiClient.Models.GenerateContent(
-			ctx, model,
+	config := generateConfig()
+	start := time.Now()
+	var description *genai.GenerateContentResponse
+	if describeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, describeTimeout)
+		defer cancel()
+	}
+	var region string
+	ctx = withServedRegion(ctx, &region)
+	err = withQuotaRetry(ctx, func() (err error) {
+		if streams(imageFile) {
+			description, err = streamDescription(ctx, imageFile, settings.Model, contents, config)
+			return err
+		}
+		description, err = genaiClient.GenerateContent(
+			ctx, settings.Model,
 			contents,
 			config,
 		)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("generation timed out after %s: %w", describeTimeout, err)
+		}
+		return err
+	})
+	observeFile(imageFile.Id, stageDescribe, start)
+	if err != nil {
+		stats.failErr(stageDescribe, err)
+		log.Printf("unable to generate content: %v", err)
+		log.Printf("prompt: %s", prompt)
+		return "", &describeError{err: err}
+	}
+	stats.addUsage(description.UsageMetadata)
+	if region == "" && backend == backendVertex && len(locations) == 1 {
+		region = locations[0] // served by the only region, without failing over
+	}
+	if region != "" {
+		servedRegions.Store(imageFile.Id, region)
+	}
+	if g, ok := groundingOf(description); ok {
+		groundings.Store(imageFile.Id, g)
+	}
+	text := description.Text()
+	if piiAction != "" {
+		// a file that can't be checked is quarantined rather than uploaded
+		finding, err := detectPII(ctx, imageFile, settings.Model, contents, config, text)
 		if err != nil {
-			log.Printf("unable to generate content: %v", err)
-			log.Printf("prompt: %s", prompt)
-			return "", 0, nil
+			stats.failErr(stagePII, err)
+			return "", &describeError{err: err}
+		}
+		if len(finding.Categories) > 0 {
+			log.Printf("%s contains personal information: %s", imageFile.Name, strings.Join(finding.Categories, ", "))
+			piiFindings.Store(imageFile.Id, finding)
+			if piiAction == piiRedact {
+				text = finding.Redacted
+			}
 		}
-		descriptionText = description.Text()
-	} else {
-		descriptionText = "Description skipped"
 	}
-
-	return descriptionText, byteCount, nil
+	if (countFaces || blurFaces) && strings.HasPrefix(imageFile.MimeType, "image/") {
+		boxes, err := detectFaces(ctx, imageFile, settings.Model, contents, config, text)
+		if err != nil {
+			stats.failErr(stageFaces, err)
+			if blurFaces {
+				// an image whose faces can't be found is quarantined rather than published
+				return "", &describeError{err: err}
+			}
+			stats.failFile(imageFile, stageFaces, err)
+			log.Printf("%s: %v", imageFile.Name, err)
+		} else {
+			faceDetections.Store(imageFile.Id, boxes)
+		}
+	}
+	if len(refinements) > 0 {
+		refinedOutputs.Store(imageFile.Id, refineDescription(ctx, imageFile, settings.Model, contents, config, text))
+	}
+	if tagFiles {
+		tags, err := tagFile(ctx, imageFile, settings.Model, contents, config, text)
+		if err != nil {
+			stats.failErr(stageTag, err)
+			stats.failFile(imageFile, stageTag, err)
+			log.Printf("%s: %v", imageFile.Name, err)
+		} else {
+			taggings.Store(imageFile.Id, tags)
+		}
+	}
+	return text, nil
 }
 
-// getFileBytes retrieves a file from Drive
-func getFileBytes(file drive.File) ([]byte, error) {
-	//ctx := context.Background()
-
-	// Download the file
-	call := driveSrv.Files.Get(file.Id)
-
-	resp, err := call.Download()
+// getFileBytes retrieves a file from Drive, keeping a local copy unless one
+// exists already; the local path is locked while it is written
+func getFileBytes(ctx context.Context, file drive.File) ([]byte, error) {
+	// Download the file, unless it was extracted from an archive
+	var body io.ReadCloser
+	err := withQuotaRetry(ctx, func() (err error) {
+		if contents, ok := extractedFiles.Load(file.Id); ok {
+			body = io.NopCloser(bytes.NewReader(contents.([]byte)))
+			return nil
+		}
+		body, err = driveSrv.Download(ctx, file.Id)
+		return err
+	})
 	if err != nil {
-		log.Fatalf("Error downloading file: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error: HTTP status code %d", resp.StatusCode)
+		return nil, fmt.Errorf("error downloading file: %w", err)
 	}
+	defer body.Close()
 
 	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, resp.Body)
+	_, err = io.Copy(buf, body)
 
 	if err != nil {
 		return nil, fmt.Errorf("Unable to read response body: %v", err)
@@ -313,17 +1481,19 @@ func getFileBytes(file drive.File) ([]byte, error) {
 
 	// Create the local folder if it doesn't exist.
 	if _, err := os.Stat(localFolderName); os.IsNotExist(err) {
-		if err := os.MkdirAll(localFolderName, 0755); err != nil { // Use MkdirAll for nested dirs
+		if err := os.MkdirAll(localFolderName, dirMode); err != nil { // Use MkdirAll for nested dirs
 			return nil, fmt.Errorf("Unable to create local folder: %v", err)
 		}
 	}
 
-	localFilePath := filepath.Join(localFolderName, file.Name) // Construct the full local file path.
+	localFilePath := filepath.Join(localFolderName, localName(file)) // Construct the full local file path.
+	unlock := lockPath(localFilePath)
+	defer unlock()
 
 	// Write the bytes to a file with the same name, but only if it doesn't already exist
 	if _, err := os.Stat(localFilePath); os.IsNotExist(err) {
 		log.Printf("writing %s ...", file.Name)
-		err = os.WriteFile(localFilePath, fileBytes, 0644)
+		err = writeFileAtomic(localFilePath, fileBytes)
 		if err != nil {
 			return nil, fmt.Errorf("unable to write file: %v", err)
 		}
@@ -337,59 +1507,70 @@ func getFileBytes(file drive.File) ([]byte, error) {
 }
 
 // uploadFileToGCS uploads a byte slice to a Google Cloud Storage bucket and folder path.
-func uploadFileToGCS(ctx context.Context, bucketName, folderPath, objectName string, fileBytes []byte, override bool) error {
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
-	}
-	defer client.Close()
-
-	objectPath := filepath.Join(folderPath, objectName) // Construct the full object path
+func uploadFileToGCS(ctx context.Context, bucketName, folderPath, objectName string, fileBytes []byte, attrs storage.ObjectAttrs, override bool) error {
+	objectPath := objectPath(folderPath, objectName)
 
 	// Check if the object already exists
-	if !override {
-		_, err = client.Bucket(bucketName).Object(objectPath).Attrs(ctx)
-		if err == nil {
+	var generation int64 // of the existing object, 0 for none
+	if !override || failOnChangedContent {
+		existing, err := storageSrv.Attrs(ctx, bucketName, objectPath)
+		switch {
+		case err == nil && failOnChangedContent && !sameContent(existing, fileBytes):
+			return fmt.Errorf("%w: %s/%s, generation %d", errContentChanged, bucketName, objectPath, existing.Generation)
+		case err == nil && !override:
 			log.Printf("File '%s' already exists in GCS %s. Skipping upload.\n", objectPath, bucketName)
+			recordGeneration(ctx, bucketName, objectPath, existing.Generation)
 			return nil // Object exists, return nil error
+		case err == nil:
+			generation = existing.Generation
+		case !errors.Is(err, storage.ErrObjectNotExist):
+			return fmt.Errorf("failed to check object existence: %w", err)
 		}
-
-		var gerr *googleapi.Error
-		if errors.As(err, &gerr) { // Use errors.As to check for the googleapi.Error type
-			if gerr.Code == http.StatusNotFound { // Check for 404 Not Found
-				// Bucket or Object does not exist, proceed
-			} else {
-				return fmt.Errorf("failed to check object existence: %v", err) // Other googleapi error
-			}
-		} else if errors.Is(err, storage.ErrObjectNotExist) {
-			// Object does not exist. proceed
-		} else {
-			return fmt.Errorf("failed to check object exisitence: %v", err)
-		}
+		// Object does not exist, or has the same content, proceed
 	}
 
-	wc := client.Bucket(bucketName).Object(objectPath).NewWriter(ctx)
-	if _, err = wc.Write(fileBytes); err != nil {
-		return fmt.Errorf("failed to write file to GCS: %v", err)
+	var written *storage.ObjectAttrs
+	var err error
+	if failOnChangedContent {
+		// the object may have been written since it was checked
+		written, err = storageSrv.UploadIfGeneration(ctx, bucketName, objectPath, fileBytes, attrs, generation)
+		if errors.Is(err, errPreconditionFailed) {
+			err = fmt.Errorf("%w: %s/%s was written during the upload", errContentChanged, bucketName, objectPath)
+		}
+	} else {
+		written, err = storageSrv.Upload(ctx, bucketName, objectPath, fileBytes, attrs)
 	}
-	if err := wc.Close(); err != nil {
-		return fmt.Errorf("failed to close writer: %v", err)
+	if err != nil {
+		return err
 	}
 	log.Printf("uploaded to %s/%s", bucketName, objectPath)
+	recordGeneration(ctx, bucketName, objectPath, written.Generation)
 
 	return nil
 }
 
-// createGenaiClient Creates a Google Generative AI client for use
-func createGenaiClient(ctx context.Context) (*genai.Client, error) {
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		Project:  projectID,
-		Location: location,
-		Backend:  genai.BackendVertexAI,
-	})
-	if err != nil {
-		log.Printf("failed to create client: %v", err)
-		return nil, err
+// maxSignedURLTTL is the longest expiration allowed for V4 signed URLs
+const maxSignedURLTTL = 7 * 24 * time.Hour
+
+// createGenaiClient Creates a Google Generative AI client for use, failing
+// over between the Vertex AI locations if there are several
+func createGenaiClient(ctx context.Context) (generator, error) {
+	if backend == backendGeminiAPI || len(locations) == 1 {
+		client, err := genai.NewClient(ctx, genaiConfig(location))
+		if err != nil {
+			log.Printf("failed to create client: %v", err)
+			return nil, err
+		}
+		return client.Models, nil
+	}
+	g := &regionalGenerator{locations: locations}
+	for _, l := range locations {
+		client, err := genai.NewClient(ctx, genaiConfig(l))
+		if err != nil {
+			log.Printf("failed to create client in %s: %v", l, err)
+			return nil, err
+		}
+		g.clients = append(g.clients, client.Models)
 	}
-	return client, nil
+	return g, nil
 }
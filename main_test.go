@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestBuildQuery(t *testing.T) {
+	got := buildQuery("folder1", []string{"image/jpeg", " image/png"})
+	want := "'folder1' in parents and (mimeType = 'image/jpeg' or mimeType = 'image/png')"
+	if got != want {
+		t.Errorf("buildQuery() = %q, want %q", got, want)
+	}
+}
+
+func TestListFilesFiltersByFolderAndMimeType(t *testing.T) {
+	f := useFakes(t)
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+	f.drive.add("2", "b.png", "image/png", "folder1", []byte("b"))
+	f.drive.add("3", "c.pdf", "application/pdf", "folder1", []byte("c"))
+	f.drive.add("4", "d.jpg", "image/jpeg", "folder2", []byte("d"))
+
+	files, err := listFiles(context.Background(), "folder1", []string{"image/jpeg", "image/png"})
+	if err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	if len(names) != 2 || names[0] != "a.jpg" || names[1] != "b.png" {
+		t.Errorf("listFiles() = %v, want [a.jpg b.png]", names)
+	}
+}
+
+func TestDescribeUploadsAndDescribes(t *testing.T) {
+	f := useFakes(t)
+	gcsFolderPath = "assets"
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("jpeg bytes"))
+
+	description, size, err := describe(context.Background(), drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"})
+	if err != nil {
+		t.Fatalf("describe() error = %v", err)
+	}
+	if description != "A test description." {
+		t.Errorf("describe() description = %q", description)
+	}
+	if size != len("jpeg bytes") {
+		t.Errorf("describe() size = %d, want %d", size, len("jpeg bytes"))
+	}
+	if got := string(f.storage.objects["test-bucket/assets/a.jpg"]); got != "jpeg bytes" {
+		t.Errorf("uploaded object = %q, want %q", got, "jpeg bytes")
+	}
+	if got := f.storage.attrs["test-bucket/assets/a.jpg"].ContentType; got != "image/jpeg" {
+		t.Errorf("uploaded content type = %q, want image/jpeg", got)
+	}
+	if got := f.storage.attrs["test-bucket/assets/a.jpg"].Metadata; got[runIDKey] != "test-run" || got["drive-file-id"] != "1" {
+		t.Errorf("uploaded metadata = %v, want run and Drive file IDs", got)
+	}
+	if _, err := os.Stat(filepath.Join(localFolderName, "a.jpg")); err != nil {
+		t.Errorf("local file not written: %v", err)
+	}
+	if summary := stats.summary(); summary.TotalTokens != 10 || summary.Files != 1 {
+		t.Errorf("summary = %+v, want 1 file and 10 tokens", summary)
+	}
+}
+
+func TestUploadSkipsExistingObjects(t *testing.T) {
+	f := useFakes(t)
+	ctx := context.Background()
+
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("first"), storage.ObjectAttrs{}, false); err != nil {
+		t.Fatalf("uploadFileToGCS() error = %v", err)
+	}
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("second"), storage.ObjectAttrs{}, false); err != nil {
+		t.Fatalf("uploadFileToGCS() error = %v", err)
+	}
+	if f.storage.uploads != 1 || string(f.storage.objects["test-bucket/a.jpg"]) != "first" {
+		t.Errorf("existing object was overwritten, uploads = %d", f.storage.uploads)
+	}
+
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("third"), storage.ObjectAttrs{}, true); err != nil {
+		t.Fatalf("uploadFileToGCS() error = %v", err)
+	}
+	if f.storage.uploads != 2 || string(f.storage.objects["test-bucket/a.jpg"]) != "third" {
+		t.Errorf("always upload did not overwrite, uploads = %d", f.storage.uploads)
+	}
+}
+
+func TestDescribeSkipped(t *testing.T) {
+	f := useFakes(t)
+	createDescription = false
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+
+	description, _, err := describe(context.Background(), drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"})
+	if err != nil {
+		t.Fatalf("describe() error = %v", err)
+	}
+	if description != "Description skipped" || f.generator.calls != 0 {
+		t.Errorf("describe() = %q with %d calls, want skipped", description, f.generator.calls)
+	}
+}
+
+func TestProcessFilesWritesCSV(t *testing.T) {
+	f := useFakes(t)
+	sourceFolderID = "folder1"
+	f.drive.add("folder1", "Folder One", "application/vnd.google-apps.folder", "root", nil)
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("aa"))
+	f.drive.add("2", "b.png", "image/png", "folder1", []byte("bbb"))
+	f.generator.err = errors.New("model unavailable")
+
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	output, err := newCSVRecordWriter(path, []string{"drive_id", "name", "folder_name", "gcs_uri"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(chan drive.File, 2)
+	files <- drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}
+	files <- drive.File{Id: "2", Name: "b.png", MimeType: "image/png"}
+	close(files)
+	processFiles(context.Background(), files, output)
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readCSV(t, path)
+	if len(rows) != 3 {
+		t.Fatalf("CSV has %d rows, want header and 2 records", len(rows))
+	}
+	if got := rows[0]; got[0] != "drive_id" || got[3] != "gcs_uri" {
+		t.Errorf("CSV header = %v", got)
+	}
+	found := map[string][]string{}
+	for _, row := range rows[1:] {
+		found[row[0]] = row
+	}
+	if row := found["2"]; row[1] != "b.png" || row[2] != "Folder One" || row[3] != "gs://test-bucket/needs-review/b.png" {
+		t.Errorf("CSV row = %v", row)
+	}
+	if summary := stats.summary(); summary.Failures[stageDescribe] != 2 {
+		t.Errorf("describe failures = %d, want 2", summary.Failures[stageDescribe])
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
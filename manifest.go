@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// manifestIDColumns are header names recognized as holding Drive file IDs
+var manifestIDColumns = []string{"drive_id", "id", "file_id"}
+
+// readManifest reads Drive file IDs from a manifest file, either one ID per
+// line or a CSV with a drive_id (or id) column
+func readManifest(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest: %v", err)
+	}
+	defer f.Close()
+	return parseManifest(f)
+}
+
+// parseManifest parses Drive file IDs from manifest contents; folder_id and
+// relative_path columns, as written to checkpoints and catalogs, restore where
+// each file was found in recursive mode
+func parseManifest(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	column, folderColumn, pathColumn := 0, -1, -1
+	ids := []string{}
+	seen := map[string]bool{}
+	first := true
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read manifest: %v", err)
+		}
+		if first {
+			first = false
+			if i := manifestIDColumn(row); i >= 0 {
+				column = i
+				folderColumn, pathColumn = manifestColumn(row, "folder_id"), manifestColumn(row, "relative_path")
+				continue
+			}
+		}
+		if column >= len(row) {
+			continue
+		}
+		id := strings.TrimSpace(row[column])
+		if id == "" || strings.HasPrefix(id, "#") || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+
+		var loc fileLocation
+		if folderColumn >= 0 && folderColumn < len(row) {
+			if loc.folderID = strings.TrimSpace(row[folderColumn]); loc.folderID != "" {
+				loc.ancestors = []string{loc.folderID}
+			}
+		}
+		if pathColumn >= 0 && pathColumn < len(row) {
+			loc.relativePath = strings.Trim(strings.TrimSpace(row[pathColumn]), "/")
+		}
+		if loc.folderID != "" || loc.relativePath != "" {
+			fileLocations.Store(id, loc)
+		}
+	}
+	return ids, nil
+}
+
+// manifestIDColumn returns the index of the Drive ID column in a header row, or -1
+func manifestIDColumn(header []string) int {
+	for _, candidate := range manifestIDColumns {
+		if i := manifestColumn(header, candidate); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// manifestColumn returns the index of the named column in a header row, or -1
+func manifestColumn(header []string, column string) int {
+	for i, name := range header {
+		if strings.ToLower(strings.TrimSpace(name)) == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// streamFiles reads Drive file IDs from r, one per line, and sends each file's
+// metadata to files as it arrives
+func streamFiles(ctx context.Context, r io.Reader, files chan<- drive.File) {
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || strings.HasPrefix(id, "#") || seen[id] {
+			continue
+		}
+		seen[id] = true
+		f, err := getFile(ctx, id)
+		if errors.Is(err, errNotOwned) {
+			stats.skip("owner")
+			continue
+		}
+		if err != nil {
+			log.Printf("unable to get Drive file %s: %v", id, err)
+			stats.failErr("list", err)
+			continue
+		}
+		files <- *f
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("error reading stdin: %v", err)
+	}
+}
+
+// getFile retrieves Drive file metadata for a file ID, or errNotOwned if it
+// doesn't pass the -owned-by filter
+func getFile(ctx context.Context, id string) (*drive.File, error) {
+	fields := "id, name, mimeType, parents, size, md5Checksum, createdTime, modifiedTime, imageMediaMetadata(width, height)"
+	if ownerQuery() != "" {
+		fields += ", owners(emailAddress, me)"
+	}
+	f, err := driveSrv.Get(ctx, id, fields)
+	if err != nil {
+		return nil, err
+	}
+	if !isOwned(f) {
+		return nil, errNotOwned
+	}
+	return f, nil
+}
+
+// errNotOwned is returned for files excluded by the -owned-by filter
+var errNotOwned = errors.New("file not owned by the -owned-by owner")
+
+// getFiles retrieves Drive file metadata for each of the given IDs
+func getFiles(ctx context.Context, ids []string) []drive.File {
+	found := []drive.File{}
+	for _, id := range ids {
+		f, err := getFile(ctx, id)
+		if errors.Is(err, errNotOwned) {
+			stats.skip("owner")
+			continue
+		}
+		if err != nil {
+			log.Printf("unable to get Drive file %s: %v", id, err)
+			stats.failErr("list", err)
+			continue
+		}
+		found = append(found, *f)
+	}
+	log.Printf("manifest has %d files, %d retrieved", len(ids), len(found))
+	return found
+}
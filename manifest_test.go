@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"one per line", "id1\n\nid2\n# comment\nid1\n", []string{"id1", "id2"}},
+		{"csv column", "name,drive_id,description\na.jpg,id1,\"a, b\"\nb.jpg,id2,c\n", []string{"id1", "id2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseManifest(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("parseManifest() error = %v", err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("parseManifest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"github.com/ghchinoy/drivetogcs/jobspb"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// mcpProtocolVersion is the Model Context Protocol version served to clients
+// that don't ask for another
+const mcpProtocolVersion = "2025-03-26"
+
+// The JSON-RPC error codes the MCP server responds with
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// mcpLocalDir is the directory describe_file may read local files from,
+// empty for Drive files only, so an agent can't read the server's files
+var mcpLocalDir string
+
+// mcpFlags registers the mcp command's flags
+func mcpFlags() {
+	flag.StringVar(&mcpLocalDir, "local-dir", mcpLocalDir, "the directory describe_file may read local files of the -mime-types from; Drive files only if empty")
+	flag.StringVar(&searchCatalog, "catalog", searchCatalog, "the CSV catalog the tools list and search, a local path or gs://bucket/object")
+	flag.StringVar(&jobsDir, "jobs-dir", jobsDir, "the directory each transfer_folder job runs in, in a subdirectory named after its ID")
+	flag.DurationVar(&jobRetention, "job-retention", jobRetention, "how long a finished transfer_folder job is kept, after which it is no longer known; its directory remains")
+	flag.StringVar(&embeddingModel, "embedding-model", embeddingModel, "the model embedding the descriptions and query of a semantic search_catalog")
+}
+
+// runMCP runs drivetogcs mcp, serving the Model Context Protocol over stdin
+// and stdout, so LLM agents and IDE assistants can list, describe and search
+// assets and start migrations. Anything else printed, such as the
+// authorization prompt, goes to stderr.
+func runMCP(ctx context.Context, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs mcp [-catalog descriptions.csv] [-jobs-dir jobs] [flags of the runs]")
+		return exitFailure
+	}
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	if err := os.MkdirAll(jobsDir, 0700); err != nil {
+		log.Printf("unable to create -jobs-dir: %v", err)
+		return exitFailure
+	}
+	closeClients := initClients(ctx)
+	defer closeClients()
+	log.Printf("serving MCP on stdin and stdout, running jobs in %s", jobsDir)
+	if err := newMCPServer(jobsDir).serve(ctx, os.Stdin, out); err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// rpcRequest is a JSON-RPC 2.0 request, or a notification if it has no ID
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool is a tool the MCP server offers, called with its JSON arguments
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+	call        func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// mcpServer serves the tools, starting transfers as jobs of the jobs API
+type mcpServer struct {
+	jobs  *jobServer
+	tools []mcpTool
+}
+
+func newMCPServer(dir string) *mcpServer {
+	s := &mcpServer{jobs: newJobServer(dir)}
+	s.tools = []mcpTool{
+		{
+			Name:        "list_assets",
+			Description: "Lists the assets of the catalog, or of the catalog of a transfer_folder job, with their Drive ID, Cloud Storage location and description.",
+			InputSchema: objectSchema(map[string]any{
+				"mime_type": stringSchema("only assets whose MIME type starts with this, such as image/"),
+				"job_id":    stringSchema("list the assets a transfer_folder job has transferred so far, rather than the catalog"),
+				"limit":     integerSchema("the most assets to list, 50 if unset"),
+				"offset":    integerSchema("the assets to skip, the next_offset of the previous page"),
+			}),
+			call: s.listAssets,
+		},
+		{
+			Name:        "describe_file",
+			Description: "Describes a file with the prompt and model a migration would use, returning the prompt, the description and the token usage. Nothing is transferred.",
+			InputSchema: objectSchema(map[string]any{
+				"file": stringSchema("the Drive file ID of the file, or its path in the server's -local-dir"),
+			}, "file"),
+			call: s.describeFile,
+		},
+		{
+			Name:        "transfer_folder",
+			Description: "Starts migrating a Drive folder to Cloud Storage, describing its files, as a job running in the background. Returns the job, whose state is returned again when called with its job_id.",
+			InputSchema: objectSchema(map[string]any{
+				"folder":        stringSchema("the Drive folder ID"),
+				"recursive":     map[string]any{"type": "boolean", "description": "include the files of subfolders"},
+				"gcs_bucket":    stringSchema("the bucket to transfer to, the run's default if unset"),
+				"gcs_path":      stringSchema("the path in the bucket to transfer to"),
+				"mime_types":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "the MIME types to transfer, the run's default if unset"},
+				"transfer_only": map[string]any{"type": "boolean", "description": "transfer without describing"},
+				"max_files":     integerSchema("the most files to transfer"),
+				"job_id":        stringSchema("return the state of this job instead of starting one"),
+			}),
+			call: s.transferFolder,
+		},
+		{
+			Name:        "search_catalog",
+			Description: "Searches the catalog for assets whose name, tags or description match the query, best first.",
+			InputSchema: objectSchema(map[string]any{
+				"query":    stringSchema("the words to search for"),
+				"semantic": map[string]any{"type": "boolean", "description": "rank by the similarity of the embeddings of the descriptions and query, rather than by keywords"},
+				"limit":    integerSchema("the most matches to return, 10 if unset"),
+			}, "query"),
+			call: s.searchCatalog,
+		},
+	}
+	return s
+}
+
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func stringSchema(description string) map[string]any {
+	return map[string]any{"type": "string", "description": description}
+}
+
+func integerSchema(description string) map[string]any {
+	return map[string]any{"type": "integer", "minimum": 0, "description": description}
+}
+
+// serve reads a JSON-RPC message per line of r, writing a response per line
+// to w for each request, until r ends
+func (s *mcpServer) serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	enc := json.NewEncoder(w)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if resp := s.handle(ctx, line); resp != nil {
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// handle returns the response to a message, or nil for a notification
+func (s *mcpServer) handle(ctx context.Context, msg []byte) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}}
+	}
+	if req.ID == nil {
+		return nil // notifications, such as notifications/initialized, need nothing
+	}
+	resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{rpcInvalidRequest, "expected a JSON-RPC 2.0 request"}
+		return resp
+	}
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		resp.Result = map[string]any{
+			"protocolVersion": cmp.Or(params.ProtocolVersion, mcpProtocolVersion),
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "drivetogcs", "version": buildVersion()},
+		}
+	case "ping":
+		resp.Result = map[string]any{}
+	case "tools/list":
+		resp.Result = map[string]any{"tools": s.tools}
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp.Error = &rpcError{rpcInvalidParams, err.Error()}
+			return resp
+		}
+		result, err := s.callTool(ctx, params.Name, params.Arguments)
+		if err != nil {
+			resp.Error = &rpcError{rpcInvalidParams, err.Error()}
+			return resp
+		}
+		resp.Result = result
+	default:
+		resp.Error = &rpcError{rpcMethodNotFound, "unknown method " + req.Method}
+	}
+	return resp
+}
+
+// mcpToolResult is the result of a tool call, a failed call's being its
+// error, so the model can see it
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// callTool calls a tool, returning an error only if there is no such tool
+func (s *mcpServer) callTool(ctx context.Context, name string, args json.RawMessage) (*mcpToolResult, error) {
+	for _, t := range s.tools {
+		if t.Name != name {
+			continue
+		}
+		if len(args) == 0 || string(args) == "null" {
+			args = json.RawMessage("{}")
+		}
+		text, err := t.call(ctx, args)
+		if err != nil {
+			log.Printf("mcp: %s: %v", name, err)
+			msg := err.Error()
+			if st, ok := status.FromError(err); ok {
+				msg = st.Message() // of the jobs API
+			}
+			return &mcpToolResult{Content: []mcpContent{{"text", msg}}, IsError: true}, nil
+		}
+		return &mcpToolResult{Content: []mcpContent{{"text", text}}}, nil
+	}
+	return nil, fmt.Errorf("unknown tool %q", name)
+}
+
+// buildVersion returns the version drivetogcs was built as
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// toolJSON returns v as a tool's indented JSON output
+func toolJSON(v any) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	return string(b), err
+}
+
+// listAssets returns a page of the catalog, or of a job's, as JSON objects of
+// each row's columns
+func (s *mcpServer) listAssets(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		MIMEType string `json:"mime_type"`
+		JobID    string `json:"job_id"`
+		Limit    int    `json:"limit"`
+		Offset   int    `json:"offset"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	if args.Limit <= 0 {
+		args.Limit = 50
+	}
+	var catalog *catalogTable
+	if args.JobID != "" {
+		j, err := s.jobs.job(args.JobID)
+		if err != nil {
+			return "", err
+		}
+		if catalog, err = j.catalog(); err != nil {
+			return "", err
+		}
+	} else {
+		var err error
+		if catalog, err = loadCatalog(ctx, searchCatalog); err != nil {
+			return "", err
+		}
+	}
+	page := struct {
+		Assets     []map[string]string `json:"assets"`
+		NextOffset int                 `json:"next_offset,omitempty"`
+	}{Assets: []map[string]string{}}
+	if catalog == nil {
+		return toolJSON(page) // the job hasn't written any yet
+	}
+	var matched int
+	for _, row := range catalog.rows {
+		if !strings.HasPrefix(catalog.get(row, "mime_type"), args.MIMEType) {
+			continue
+		}
+		matched++
+		if matched <= args.Offset {
+			continue
+		}
+		if len(page.Assets) == args.Limit {
+			page.NextOffset = args.Offset + args.Limit
+			break
+		}
+		page.Assets = append(page.Assets, catalogAsset(catalog, row))
+	}
+	return toolJSON(page)
+}
+
+// describeFile describes a Drive file, or a local file in -local-dir, as the
+// try command does, returning its transcript
+func (s *mcpServer) describeFile(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		File string `json:"file"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	if args.File == "" {
+		return "", errors.New("file is required")
+	}
+	var file drive.File
+	var data []byte
+	if path, err := mcpLocalPath(args.File); err != nil {
+		return "", err
+	} else if path != "" {
+		if file, data, err = readLocalFile(path); err != nil {
+			return "", err
+		}
+		if !slices.Contains(mimeTypes, file.MimeType) {
+			return "", fmt.Errorf("%s is %s, not one of the -mime-types", args.File, file.MimeType)
+		}
+	} else {
+		if !driveIDPattern.MatchString(args.File) {
+			return "", fmt.Errorf("%s is neither a Drive file ID nor a file in -local-dir", args.File)
+		}
+		f, err := getFile(ctx, args.File)
+		if err != nil {
+			return "", fmt.Errorf("unable to get Drive file %s: %v", args.File, err)
+		}
+		file = *f
+		if data, err = getFileBytes(ctx, file); err != nil {
+			return "", err
+		}
+	}
+	var out strings.Builder
+	if err := tryPrompt(ctx, file, data, &out); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// mcpLocalPath returns the path of a describe_file argument naming a file in
+// -local-dir, relative to it, or empty if there's no such file. Symbolic
+// links are resolved, so none leads out of the directory.
+func mcpLocalPath(name string) (string, error) {
+	if mcpLocalDir == "" {
+		return "", nil
+	}
+	dir, err := filepath.EvalSymlinks(mcpLocalDir)
+	if err != nil {
+		return "", fmt.Errorf("-local-dir: %v", err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s is outside -local-dir", name)
+	}
+	return path, nil
+}
+
+// transferFolder starts a job migrating a folder, or returns the state of one
+func (s *mcpServer) transferFolder(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Folder       string   `json:"folder"`
+		Recursive    bool     `json:"recursive"`
+		GCSBucket    string   `json:"gcs_bucket"`
+		GCSPath      string   `json:"gcs_path"`
+		MIMETypes    []string `json:"mime_types"`
+		TransferOnly bool     `json:"transfer_only"`
+		MaxFiles     int32    `json:"max_files"`
+		JobID        string   `json:"job_id"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	var j *jobspb.Job
+	var err error
+	if args.JobID != "" {
+		j, err = s.jobs.GetJob(ctx, &jobspb.GetJobRequest{Id: args.JobID})
+	} else {
+		j, err = s.jobs.CreateJob(ctx, &jobspb.CreateJobRequest{
+			Folder:       args.Folder,
+			Recursive:    args.Recursive,
+			GcsBucket:    args.GCSBucket,
+			GcsPath:      args.GCSPath,
+			MimeTypes:    args.MIMETypes,
+			TransferOnly: args.TransferOnly,
+			MaxFiles:     args.MaxFiles,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	b, err := protojson.MarshalOptions{Multiline: true, UseProtoNames: true}.Marshal(j)
+	return string(b), err
+}
+
+// searchCatalog searches the catalog as the search command does, returning
+// the matches as JSON
+func (s *mcpServer) searchCatalog(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query    string `json:"query"`
+		Semantic bool   `json:"semantic"`
+		Limit    int    `json:"limit"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(args.Query) == "" {
+		return "", errors.New("query is required")
+	}
+	catalog, err := loadCatalog(ctx, searchCatalog)
+	if err != nil {
+		return "", err
+	}
+	var matches []searchMatch
+	if args.Semantic {
+		e, err := newEmbedder(ctx)
+		if err != nil {
+			return "", fmt.Errorf("unable to create the embedding client: %v", err)
+		}
+		if matches, err = semanticSearch(ctx, e, catalog, args.Query); err != nil {
+			return "", err
+		}
+	} else {
+		matches = keywordSearch(catalog, args.Query)
+	}
+	if limit := cmp.Or(args.Limit, 10); len(matches) > limit {
+		matches = matches[:limit]
+	}
+	if matches == nil {
+		matches = []searchMatch{}
+	}
+	return toolJSON(matches)
+}
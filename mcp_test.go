@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mcpTestCatalog = `name,mime_type,drive_id,object_path,description
+beach.jpg,image/jpeg,1,photos/beach.jpg,A sunset over a sandy beach.
+waves.mp4,video/mp4,2,videos/waves.mp4,Waves at dusk.
+mountain.png,image/png,3,photos/mountain.png,A mountain at sunset.
+`
+
+// mcpResult is a response of the MCP server, with the result of a tool call
+type mcpResult struct {
+	ID     int       `json:"id"`
+	Error  *rpcError `json:"error"`
+	Result struct {
+		ProtocolVersion string         `json:"protocolVersion"`
+		Tools           []mcpTool      `json:"tools"`
+		Content         []mcpContent   `json:"content"`
+		IsError         bool           `json:"isError"`
+		ServerInfo      map[string]any `json:"serverInfo"`
+	} `json:"result"`
+}
+
+func (r mcpResult) text() string {
+	if len(r.Result.Content) == 0 {
+		return ""
+	}
+	return r.Result.Content[0].Text
+}
+
+// mcpSession sends the messages to an MCP server, returning its responses
+func mcpSession(t *testing.T, s *mcpServer, messages ...string) []mcpResult {
+	t.Helper()
+	var out bytes.Buffer
+	if err := s.serve(context.Background(), strings.NewReader(strings.Join(messages, "\n")), &out); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	var results []mcpResult
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r mcpResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestMCPProtocol(t *testing.T) {
+	s := newMCPServer(t.TempDir())
+	results := mcpSession(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/list"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"nope"}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"ping"}`,
+		`{`,
+	)
+	if len(results) != 6 {
+		t.Fatalf("got %d responses, want 6: %+v", len(results), results)
+	}
+	if r := results[0]; r.ID != 1 || r.Result.ProtocolVersion != "2024-11-05" || r.Result.ServerInfo["name"] != "drivetogcs" {
+		t.Errorf("initialize = %+v", r)
+	}
+	var tools []string
+	for _, tool := range results[1].Result.Tools {
+		tools = append(tools, tool.Name)
+	}
+	if got := strings.Join(tools, ","); got != "list_assets,describe_file,transfer_folder,search_catalog" {
+		t.Errorf("tools/list = %s", got)
+	}
+	if r := results[2]; r.Error == nil || r.Error.Code != rpcMethodNotFound {
+		t.Errorf("resources/list = %+v, want method not found", r)
+	}
+	if r := results[3]; r.Error == nil || r.Error.Code != rpcInvalidParams {
+		t.Errorf("unknown tool = %+v, want invalid params", r)
+	}
+	if r := results[4]; r.ID != 5 || r.Error != nil {
+		t.Errorf("ping = %+v", r)
+	}
+	if r := results[5]; r.Error == nil || r.Error.Code != rpcParseError {
+		t.Errorf("invalid JSON = %+v, want parse error", r)
+	}
+}
+
+func TestMCPCatalogTools(t *testing.T) {
+	useFakes(t)
+	defer func(prev string) { searchCatalog = prev }(searchCatalog)
+	searchCatalog = filepath.Join(t.TempDir(), "descriptions.csv")
+	if err := os.WriteFile(searchCatalog, []byte(mcpTestCatalog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := newMCPServer(t.TempDir())
+	results := mcpSession(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"list_assets","arguments":{"mime_type":"image/","limit":1}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"list_assets","arguments":{"mime_type":"image/","limit":1,"offset":1}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"search_catalog","arguments":{"query":"sunset"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"search_catalog","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"list_assets","arguments":{"job_id":"nope"}}}`,
+	)
+	var page struct {
+		Assets     []map[string]string `json:"assets"`
+		NextOffset int                 `json:"next_offset"`
+	}
+	if err := json.Unmarshal([]byte(results[0].text()), &page); err != nil {
+		t.Fatalf("list_assets = %q: %v", results[0].text(), err)
+	}
+	if len(page.Assets) != 1 || page.Assets[0]["name"] != "beach.jpg" || page.Assets[0]["drive_id"] != "1" || page.NextOffset != 1 {
+		t.Errorf("list_assets page 1 = %+v", page)
+	}
+	page.NextOffset = 0
+	json.Unmarshal([]byte(results[1].text()), &page)
+	if len(page.Assets) != 1 || page.Assets[0]["name"] != "mountain.png" || page.NextOffset != 0 {
+		t.Errorf("list_assets page 2 = %+v", page)
+	}
+
+	var matches []searchMatch
+	if err := json.Unmarshal([]byte(results[2].text()), &matches); err != nil {
+		t.Fatalf("search_catalog = %q: %v", results[2].text(), err)
+	}
+	if len(matches) != 2 || matches[0].Name != "beach.jpg" || matches[1].Name != "mountain.png" {
+		t.Errorf("search_catalog = %+v", matches)
+	}
+	if r := results[3]; !r.Result.IsError || r.text() != "query is required" {
+		t.Errorf("search_catalog without a query = %+v", r)
+	}
+	if r := results[4]; !r.Result.IsError || r.text() != `no job "nope"` {
+		t.Errorf("list_assets of an unknown job = %+v", r)
+	}
+}
+
+func TestMCPDescribeFile(t *testing.T) {
+	f := useFakes(t)
+	defer func(dir string, types []string) { mcpLocalDir, mimeTypes = dir, types }(mcpLocalDir, mimeTypes)
+	mcpLocalDir, mimeTypes = t.TempDir(), []string{"image/jpeg", "image/png"}
+	f.drive.add("abc", "photo.jpg", "image/jpeg", "folder1", testPNG(t, 8, 8))
+	if err := os.WriteFile(filepath.Join(mcpLocalDir, "sunset.png"), testPNG(t, 8, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mcpLocalDir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.png")
+	if err := os.WriteFile(outside, testPNG(t, 8, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(mcpLocalDir, "link.png")); err != nil {
+		t.Fatal(err)
+	}
+	call := func(id int, file string) string {
+		args, _ := json.Marshal(map[string]string{"file": file})
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"describe_file","arguments":%s}}`, id, args)
+	}
+	s := newMCPServer(t.TempDir())
+	results := mcpSession(t, s,
+		call(1, "sunset.png"),
+		call(2, "abc"),
+		call(3, "../nope"),
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"transfer_folder","arguments":{}}}`,
+		call(5, outside),
+		call(6, "../"+filepath.Base(filepath.Dir(outside))+"/secret.png"),
+		call(7, "link.png"),
+		call(8, "notes.txt"),
+	)
+	for i, name := range []string{"sunset.png", "photo.jpg"} {
+		if r := results[i]; r.Result.IsError || !strings.Contains(r.text(), "The image name is: "+name) || !strings.Contains(r.text(), f.generator.response) {
+			t.Errorf("describe_file %s = %+v", name, r)
+		}
+	}
+	if r := results[2]; !r.Result.IsError {
+		t.Errorf("describe_file of a missing file = %+v, want an error", r)
+	}
+	if r := results[3]; !r.Result.IsError || r.text() != "folder is required" {
+		t.Errorf("transfer_folder without a folder = %+v", r)
+	}
+	// files outside -local-dir, or not of the -mime-types, aren't read
+	for i, r := range results[4:] {
+		if !r.Result.IsError {
+			t.Errorf("describe_file %d = %+v, want an error", i+5, r)
+		}
+	}
+	if f.generator.calls != 2 {
+		t.Errorf("generator calls = %d, want the 2 files described", f.generator.calls)
+	}
+	if f.storage.uploads != 0 {
+		t.Errorf("describe_file uploaded %d objects, want none", f.storage.uploads)
+	}
+}
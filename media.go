@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+var exportMediaMetadata bool
+
+// mediaMetadataFields are the Drive file fields holding the metadata Drive
+// extracts from images, such as photos backed up from Google Photos
+const mediaMetadataFields = "imageMediaMetadata"
+
+// imageMetadata is the camera, exposure and location metadata of an image
+type imageMetadata struct {
+	Width        int64        `json:"width,omitempty"`
+	Height       int64        `json:"height,omitempty"`
+	Rotation     int64        `json:"rotation,omitempty"`
+	Time         string       `json:"time,omitempty"`
+	CameraMake   string       `json:"camera_make,omitempty"`
+	CameraModel  string       `json:"camera_model,omitempty"`
+	Lens         string       `json:"lens,omitempty"`
+	ExposureTime float64      `json:"exposure_time,omitempty"`
+	Aperture     float64      `json:"aperture,omitempty"`
+	FocalLength  float64      `json:"focal_length,omitempty"`
+	IsoSpeed     int64        `json:"iso_speed,omitempty"`
+	FlashUsed    bool         `json:"flash_used,omitempty"`
+	MeteringMode string       `json:"metering_mode,omitempty"`
+	WhiteBalance string       `json:"white_balance,omitempty"`
+	Location     *geoLocation `json:"location,omitempty"`
+}
+
+// geoLocation is where a photo was taken
+type geoLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// camera returns the camera make and model, e.g. Google Pixel 8
+func (m imageMetadata) camera() string {
+	if strings.HasPrefix(m.CameraModel, m.CameraMake) {
+		return m.CameraModel
+	}
+	return strings.TrimSpace(m.CameraMake + " " + m.CameraModel)
+}
+
+// exposure formats the exposure settings, e.g. 1/250s f/2.8 ISO 100 50mm
+func (m imageMetadata) exposure() string {
+	var parts []string
+	switch {
+	case m.ExposureTime > 0 && m.ExposureTime < 1:
+		parts = append(parts, fmt.Sprintf("1/%.0fs", 1/m.ExposureTime))
+	case m.ExposureTime >= 1:
+		parts = append(parts, strconv.FormatFloat(m.ExposureTime, 'f', -1, 64)+"s")
+	}
+	if m.Aperture > 0 {
+		parts = append(parts, "f/"+strconv.FormatFloat(m.Aperture, 'f', -1, 64))
+	}
+	if m.IsoSpeed > 0 {
+		parts = append(parts, fmt.Sprintf("ISO %d", m.IsoSpeed))
+	}
+	if m.FocalLength > 0 {
+		parts = append(parts, strconv.FormatFloat(m.FocalLength, 'f', -1, 64)+"mm")
+	}
+	return strings.Join(parts, " ")
+}
+
+// addMediaMetadata retrieves the metadata Drive extracted from an image and
+// adds it to the record
+func addMediaMetadata(ctx context.Context, r *record) error {
+	if !strings.HasPrefix(r.MimeType, "image/") {
+		return nil
+	}
+	f, err := driveSrv.Get(ctx, r.ID, mediaMetadataFields)
+	if err != nil {
+		return fmt.Errorf("unable to get image metadata: %w", err)
+	}
+	r.ImageMetadata = convertImageMetadata(f.ImageMediaMetadata)
+	return nil
+}
+
+// convertImageMetadata converts Drive image metadata, or returns nil if there is none
+func convertImageMetadata(m *drive.FileImageMediaMetadata) *imageMetadata {
+	if m == nil {
+		return nil
+	}
+	converted := &imageMetadata{
+		Width:        m.Width,
+		Height:       m.Height,
+		Rotation:     m.Rotation,
+		Time:         m.Time,
+		CameraMake:   m.CameraMake,
+		CameraModel:  m.CameraModel,
+		Lens:         m.Lens,
+		ExposureTime: m.ExposureTime,
+		Aperture:     m.Aperture,
+		FocalLength:  m.FocalLength,
+		IsoSpeed:     m.IsoSpeed,
+		FlashUsed:    m.FlashUsed,
+		MeteringMode: m.MeteringMode,
+		WhiteBalance: m.WhiteBalance,
+	}
+	if l := m.Location; l != nil {
+		converted.Location = &geoLocation{Latitude: l.Latitude, Longitude: l.Longitude, Altitude: l.Altitude}
+	}
+	return converted
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestMediaMetadataExportedToSidecar(t *testing.T) {
+	f := useFakes(t)
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+	f.drive.files["1"].ImageMediaMetadata = &drive.FileImageMediaMetadata{
+		Width: 4000, Height: 3000, Time: "2024:06:01 09:30:00",
+		CameraMake: "Google", CameraModel: "Pixel 8",
+		ExposureTime: 0.004, Aperture: 1.7, IsoSpeed: 100, FocalLength: 6.9,
+		Location: &drive.FileImageMediaMetadataLocation{Latitude: 37.422, Longitude: -122.084},
+	}
+	f.drive.add("2", "notes.pdf", "application/pdf", "folder1", []byte("b"))
+
+	r := record{Name: "a.jpg", ID: "1", MimeType: "image/jpeg", ObjectPath: "media/a.jpg"}
+	if err := addMediaMetadata(context.Background(), &r); err != nil {
+		t.Fatalf("addMediaMetadata() error = %v", err)
+	}
+	for column, want := range map[string]string{
+		"image_size": "4000x3000",
+		"camera":     "Google Pixel 8",
+		"taken_at":   "2024:06:01 09:30:00",
+		"exposure":   "1/250s f/1.7 ISO 100 6.9mm",
+		"location":   "37.422000,-122.084000",
+	} {
+		if got := columns[column](r); got != want {
+			t.Errorf("%s column = %q, want %q", column, got, want)
+		}
+	}
+
+	if err := writeSidecar(context.Background(), r); err != nil {
+		t.Fatalf("writeSidecar() error = %v", err)
+	}
+	var sidecar record
+	if err := json.Unmarshal(f.storage.objects["test-bucket/media/a.jpg.json"], &sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if m := sidecar.ImageMetadata; m == nil || m.CameraModel != "Pixel 8" || m.Location == nil || m.Location.Longitude != -122.084 {
+		t.Errorf("sidecar image metadata = %+v", m)
+	}
+
+	doc := record{Name: "notes.pdf", ID: "2", MimeType: "application/pdf"}
+	if err := addMediaMetadata(context.Background(), &doc); err != nil || doc.ImageMetadata != nil {
+		t.Errorf("addMediaMetadata(pdf) = %+v, %v, want none", doc.ImageMetadata, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memoryLimitFlag is the -memory-limit the process keeps under, e.g. 2GiB, or
+// auto for the container's limit, empty for no limit
+var memoryLimitFlag string
+
+// memoryLimit is the memory, in bytes, the process keeps under, 0 for no limit
+var memoryLimit int64
+
+// memoryHighWater is the fraction of memoryLimit in use at which the files
+// processed at once are halved
+const memoryHighWater = 0.85
+
+// memoryCheckInterval is how often the memory in use is checked
+var memoryCheckInterval = time.Second
+
+// memoryCooldown is how long the files processed at once stay halved after
+// the memory in use reaches the high water mark
+var memoryCooldown = 30 * time.Second
+
+// cgroupMemoryFiles are the container memory limits of cgroup v2 and v1
+var cgroupMemoryFiles = []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"}
+
+// parseMemoryLimit parses a -memory-limit, a byte size or auto for the
+// container's limit
+func parseMemoryLimit(s string) (int64, error) {
+	if s != "auto" {
+		return parseBytes(s)
+	}
+	for _, path := range cgroupMemoryFiles {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// cgroup v1 reports no limit as a huge number
+		if n, err := strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 64); err == nil && n < 1<<60 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("no container memory limit found for -memory-limit auto")
+}
+
+// memoryInUse returns the resident memory of the process, from /proc where
+// there is one, or else the memory the Go runtime holds from the OS
+func memoryInUse() int64 {
+	if b, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(b)); len(fields) > 1 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return int64(mem.Sys - mem.HeapReleased)
+}
+
+// watchMemory keeps the process under memoryLimit until ctx is done, setting
+// the Go runtime's soft limit to collect garbage harder near it, and halving
+// the files processed at once while the memory in use is above the high
+// water mark
+func watchMemory(ctx context.Context) {
+	debug.SetMemoryLimit(int64(float64(memoryLimit) * memoryHighWater))
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkMemory(throttler, memoryInUse())
+		}
+	}
+}
+
+// checkMemory halves the files processed at once when used is above the high
+// water mark of memoryLimit, reporting whether it did; the files in flight
+// finish, and no more start until enough have
+func checkMemory(t *throttle, used int64) bool {
+	if float64(used) < float64(memoryLimit)*memoryHighWater {
+		return false
+	}
+	t.slowDown(fmt.Sprintf("using %d MiB of the %d MiB memory limit", used>>20, memoryLimit>>20), memoryCooldown)
+	return true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckMemory(t *testing.T) {
+	prev := memoryLimit
+	t.Cleanup(func() { memoryLimit = prev })
+	memoryLimit = 1 << 30
+
+	th := newThrottle(8)
+	for range 8 {
+		th.acquire()
+	}
+	if checkMemory(th, 800<<20) || th.current() != 8 {
+		t.Errorf("throttled below the high water mark, limit %d", th.current())
+	}
+	if !checkMemory(th, 900<<20) || th.current() != 4 {
+		t.Errorf("limit above the high water mark = %d, want 4", th.current())
+	}
+}
+
+func TestParseMemoryLimit(t *testing.T) {
+	if n, err := parseMemoryLimit("2GiB"); err != nil || n != 2<<30 {
+		t.Errorf("parseMemoryLimit(2GiB) = %d, %v", n, err)
+	}
+
+	prev := cgroupMemoryFiles
+	t.Cleanup(func() { cgroupMemoryFiles = prev })
+	dir := t.TempDir()
+	v2, v1 := filepath.Join(dir, "memory.max"), filepath.Join(dir, "memory.limit_in_bytes")
+	cgroupMemoryFiles = []string{v2, v1}
+	if _, err := parseMemoryLimit("auto"); err == nil {
+		t.Error("auto without a container limit accepted")
+	}
+	os.WriteFile(v2, []byte("max\n"), 0o644)
+	os.WriteFile(v1, []byte("536870912\n"), 0o644)
+	if n, err := parseMemoryLimit("auto"); err != nil || n != 512<<20 {
+		t.Errorf("parseMemoryLimit(auto) = %d, %v", n, err)
+	}
+}
+
+func TestMemoryInUse(t *testing.T) {
+	if n := memoryInUse(); n <= 0 {
+		t.Errorf("memoryInUse = %d", n)
+	}
+}
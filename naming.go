@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"unicode"
+
+	"google.golang.org/api/drive/v3"
+)
+
+var replacementChar string = "_"
+
+// characters that are not allowed in file names on common local filesystems
+const illegalLocalChars = `<>:"/\|?*`
+
+// windowsReservedNames are device names that cannot be used as file names on Windows
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeLocalName makes a Drive file name safe to use as a local file name on
+// Linux, macOS and Windows
+func sanitizeLocalName(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsControl(r) || strings.ContainsRune(illegalLocalChars, r) {
+			sb.WriteString(replacementChar)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	safe := strings.TrimRight(sb.String(), ". ")
+	if safe == "" {
+		safe = replacementChar
+	}
+	base := strings.ToUpper(strings.SplitN(safe, ".", 2)[0])
+	if windowsReservedNames[base] {
+		safe = replacementChar + safe
+	}
+	return safe
+}
+
+// sanitizeObjectName makes a Drive file name safe to use as a single Cloud
+// Storage object name segment
+func sanitizeObjectName(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsControl(r) || r == '/' || r == '\\' || r == unicode.ReplacementChar {
+			sb.WriteString(replacementChar)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	safe := sb.String()
+	if safe == "" || safe == "." || safe == ".." {
+		safe = replacementChar + safe
+	}
+	return safe
+}
+
+// strategies for Drive files whose names collide
+const (
+	collisionDriveID = "id"     // suffix the name with the Drive file ID
+	collisionNumber  = "number" // suffix the name with a counter
+	collisionError   = "error"  // fail the file
+	collisionSkip    = "skip"   // skip the file
+)
+
+var collisionStrategies = []string{collisionDriveID, collisionNumber, collisionError, collisionSkip}
+
+var onCollision string = collisionDriveID
+
+// errNameCollision is returned when a name is already claimed by another file
+var errNameCollision = errors.New("name collision")
+
+// stageCollision is the stage files failed with -on-collision error fail at
+const stageCollision = "collision"
+
+// nameRegistry assigns unique names to Drive files; a file ID always receives
+// the same name, and a name already claimed by another file is resolved with
+// the registry's collision strategy
+type nameRegistry struct {
+	mu       sync.Mutex
+	byID     map[string]string
+	taken    map[string]string
+	fold     bool
+	strategy string
+}
+
+// newNameRegistry returns a registry; with fold, names differing only in case collide
+func newNameRegistry(fold bool, strategy string) *nameRegistry {
+	return &nameRegistry{byID: map[string]string{}, taken: map[string]string{}, fold: fold, strategy: strategy}
+}
+
+// claim returns the unique name for the file ID, given its desired name
+func (n *nameRegistry) claim(id, name string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if existing, ok := n.byID[id]; ok {
+		return existing, nil
+	}
+	candidate := name
+	for i := 2; ; i++ {
+		key := candidate
+		if n.fold {
+			key = strings.ToLower(candidate)
+		}
+		other, ok := n.taken[key]
+		if !ok {
+			n.taken[key] = id
+			n.byID[id] = candidate
+			return candidate, nil
+		}
+		switch n.strategy {
+		case collisionError, collisionSkip:
+			return "", fmt.Errorf("%w: %s is already used by Drive file %s", errNameCollision, name, other)
+		case collisionNumber:
+			candidate = suffixName(name, fmt.Sprintf("-%d", i))
+		default:
+			candidate = suffixName(name, "-"+id)
+			if i > 2 {
+				candidate = suffixName(name, fmt.Sprintf("-%s-%d", id, i-1))
+			}
+		}
+	}
+}
+
+// suffixName inserts suffix before the file extension of name
+func suffixName(name, suffix string) string {
+	ext := path.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+var (
+	// local names fold case, since Windows and macOS filesystems are
+	// case-insensitive, and are always made unique with the Drive file ID
+	localNames  = newNameRegistry(true, collisionDriveID)
+	objectNames = newNameRegistry(false, onCollision)
+)
+
+// localName returns the unique, sanitized local file name for a Drive file
+func localName(file drive.File) string {
+	name, _ := localNames.claim(file.Id, sanitizeLocalName(file.Name))
+	return name
+}
+
+// objectName returns the unique, sanitized object name for a Drive file,
+// including its relative folder path in recursive mode, or errNameCollision if
+// it collides and the strategy is to fail or skip; names only collide within
+// the same routed destination
+func objectName(file drive.File) (string, error) {
+	dest := routeFor(file)
+	scope := path.Join(dest.Bucket, objectPath(dest.Prefix, ""))
+	name, err := objectNames.claim(file.Id, path.Join(scope, relativePath(file), sanitizeObjectName(file.Name)))
+	return strings.TrimPrefix(name, scope+"/"), err
+}
+
+// objectPath constructs the full object path within the bucket; object paths
+// always use forward slashes, regardless of the local platform
+func objectPath(folderPath, objectName string) string {
+	return strings.TrimPrefix(path.Join(strings.ReplaceAll(folderPath, `\`, "/"), objectName), "/")
+}
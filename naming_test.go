@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestSanitizeLocalName(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":          "photo.jpg",
+		`a:b/c\d?.png`:       "a_b_c_d_.png",
+		"trailing. ":         "trailing",
+		"con.txt":            "_con.txt",
+		"tab\there.jpg":      "tab_here.jpg",
+		"résumé 2025.jpeg":   "résumé 2025.jpeg",
+		"":                   "_",
+		`what*is"this|<>`:    "what_is_this___",
+		"CONSOLE.jpg":        "CONSOLE.jpg",
+		"LPT1":               "_LPT1",
+		"ends with dot.jpg.": "ends with dot.jpg",
+	}
+	for name, want := range tests {
+		if got := sanitizeLocalName(name); got != want {
+			t.Errorf("sanitizeLocalName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSanitizeObjectName(t *testing.T) {
+	tests := map[string]string{
+		"a:b.jpg":     "a:b.jpg",
+		"a/b.jpg":     "a_b.jpg",
+		`a\b.jpg`:     "a_b.jpg",
+		"line\nbreak": "line_break",
+		"..":          "_..",
+	}
+	for name, want := range tests {
+		if got := sanitizeObjectName(name); got != want {
+			t.Errorf("sanitizeObjectName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	tests := []struct{ folder, name, want string }{
+		{"", "a.jpg", "a.jpg"},
+		{"vto/garments", "a.jpg", "vto/garments/a.jpg"},
+		{`vto\garments\`, "a.jpg", "vto/garments/a.jpg"},
+		{"/leading", "a.jpg", "leading/a.jpg"},
+	}
+	for _, tt := range tests {
+		if got := objectPath(tt.folder, tt.name); got != tt.want {
+			t.Errorf("objectPath(%q, %q) = %q, want %q", tt.folder, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNameRegistryCollisions(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     []string
+	}{
+		{collisionDriveID, []string{"a.jpg", "A-2.jpg", "a-3.jpg"}},
+		{collisionNumber, []string{"a.jpg", "A-2.jpg", "a-3.jpg"}},
+		{collisionError, []string{"a.jpg", "", ""}},
+		{collisionSkip, []string{"a.jpg", "", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			names := newNameRegistry(true, tt.strategy)
+			for i, name := range []string{"a.jpg", "A.jpg", "a.jpg"} {
+				got, err := names.claim(fmt.Sprint(i+1), name)
+				if got != tt.want[i] {
+					t.Errorf("claim(%d, %q) = %q, want %q", i+1, name, got, tt.want[i])
+				}
+				if (err != nil) != (tt.want[i] == "") || (err != nil && !errors.Is(err, errNameCollision)) {
+					t.Errorf("claim(%d, %q) error = %v", i+1, name, err)
+				}
+			}
+			if got, err := names.claim("1", "a.jpg"); got != "a.jpg" || err != nil {
+				t.Errorf("repeat claim = %q, %v, want a.jpg", got, err)
+			}
+		})
+	}
+}
+
+func TestDriveIDSuffix(t *testing.T) {
+	names := newNameRegistry(false, collisionDriveID)
+	names.claim("id1", "a.jpg")
+	if got, _ := names.claim("id2", "a.jpg"); got != "a-id2.jpg" {
+		t.Errorf("claim() = %q, want a-id2.jpg", got)
+	}
+}
+
+func TestLocalAndObjectNames(t *testing.T) {
+	useFakes(t)
+	a := drive.File{Id: "x1", Name: "a?.jpg"}
+	b := drive.File{Id: "x2", Name: "a_.jpg"}
+	if got := localName(a); got != "a_.jpg" {
+		t.Errorf("localName(a) = %q", got)
+	}
+	if got := localName(b); got != "a_-x2.jpg" {
+		t.Errorf("localName(b) = %q, want Drive ID suffix", got)
+	}
+	if got, _ := objectName(a); got != "a?.jpg" {
+		t.Errorf("objectName(a) = %q", got)
+	}
+}
+
+func TestProcessFilesSkipsCollisions(t *testing.T) {
+	f := useFakes(t)
+	objectNames = newNameRegistry(false, collisionSkip)
+	prev := onCollision
+	onCollision = collisionSkip
+	defer func() { onCollision = prev }()
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("first"))
+	f.drive.add("2", "a.jpg", "image/jpeg", "folder1", []byte("second"))
+
+	output := &markdownRecordWriter{}
+	files := make(chan drive.File, 2)
+	files <- drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}
+	files <- drive.File{Id: "2", Name: "a.jpg", MimeType: "image/jpeg"}
+	close(files)
+	processFiles(context.Background(), files, output)
+
+	if len(output.records) != 1 || output.records[0].ID != "1" || output.records[0].ObjectPath != "a.jpg" {
+		t.Errorf("records = %+v, want only the first file", output.records)
+	}
+	if got := string(f.storage.objects["test-bucket/a.jpg"]); got != "first" {
+		t.Errorf("object = %q, want first", got)
+	}
+	if got := stats.summary().Skipped["collision"]; got != 1 {
+		t.Errorf("skipped = %d, want 1", got)
+	}
+}
+
+func TestProcessFilesFailsCollisions(t *testing.T) {
+	f := useFakes(t)
+	objectNames = newNameRegistry(false, collisionError)
+	prev := onCollision
+	onCollision = collisionError
+	defer func() { onCollision = prev }()
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("first"))
+	f.drive.add("2", "a.jpg", "image/jpeg", "folder1", []byte("second"))
+
+	output := &markdownRecordWriter{}
+	files := make(chan drive.File, 2)
+	files <- drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}
+	files <- drive.File{Id: "2", Name: "a.jpg", MimeType: "image/jpeg"}
+	close(files)
+	processFiles(context.Background(), files, output)
+
+	if len(output.records) != 1 || output.records[0].ID != "1" {
+		t.Errorf("records = %+v, want only the first file", output.records)
+	}
+	// the colliding file is neither downloaded nor described
+	if f.drive.downloads != 1 || f.generator.calls != 1 {
+		t.Errorf("downloads, describe calls = %d, %d, want 1, 1", f.drive.downloads, f.generator.calls)
+	}
+	if failed := stats.failuresSince(0, "2"); len(failed) != 1 || failed[0].Stage != stageCollision {
+		t.Errorf("failures of the colliding file = %+v, want a collision", failed)
+	}
+	if got := failureStage(fmt.Errorf("upload: %w", errNameCollision)); got != stageCollision {
+		t.Errorf("failureStage(collision) = %q, want %q", got, stageCollision)
+	}
+}
+
+func TestCollisionsDontCountTowardsMax(t *testing.T) {
+	f := useFakes(t)
+	objectNames = newNameRegistry(false, collisionSkip)
+	defer func(max int, strategy string) { maxFiles, onCollision = max, strategy }(maxFiles, onCollision)
+	maxFiles, onCollision = 2, collisionSkip
+	files := make(chan drive.File, 3)
+	for _, id := range []string{"1", "2", "3"} {
+		name := map[string]string{"1": "a.jpg", "2": "a.jpg", "3": "b.jpg"}[id]
+		f.drive.add(id, name, "image/jpeg", "folder1", []byte(id))
+		files <- *f.drive.files[id]
+	}
+	close(files)
+	output := &markdownRecordWriter{}
+	processFiles(context.Background(), files, output)
+	if len(output.records) != 2 {
+		t.Errorf("records = %+v, want the 2 files not skipped", output.records)
+	}
+}
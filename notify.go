@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var notifyURL string
+
+// notifyFormat is the webhook payload format, detected from notifyURL if empty
+var notifyFormat string
+
+// webhook payload formats
+const (
+	notifyJSON  = "json"  // the run status JSON
+	notifySlack = "slack" // a Slack incoming webhook message
+	notifyChat  = "chat"  // a Google Chat incoming webhook message
+)
+
+var notifyFormats = []string{notifyJSON, notifySlack, notifyChat}
+
+// notifyTimeout bounds the webhook request, so an unresponsive endpoint
+// doesn't hold up the exit of a finished run
+const notifyTimeout = 30 * time.Second
+
+// webhookFormat returns the payload format for a webhook URL
+func webhookFormat(webhookURL string) string {
+	if notifyFormat != "" {
+		return notifyFormat
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return notifyJSON
+	}
+	switch u.Hostname() {
+	case "hooks.slack.com":
+		return notifySlack
+	case "chat.googleapis.com":
+		return notifyChat
+	}
+	return notifyJSON
+}
+
+// notify POSTs the run status to a webhook
+func notify(webhookURL string, status runStatus) error {
+	var payload any = status
+	if format := webhookFormat(webhookURL); format == notifySlack || format == notifyChat {
+		// both Slack and Google Chat render a text message with *bold* markup
+		payload = map[string]string{"text": statusMessage(status)}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal notification: %v", err)
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned HTTP status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// statusMessage renders the run status as a short chat message
+func statusMessage(status runStatus) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*drivetogcs run %s: %s* (exit code %d)", status.RunID, status.Status, status.ExitCode)
+	if status.Message != "" {
+		fmt.Fprintf(&sb, "\n%s", status.Message)
+	}
+	if s := status.Summary; s != nil {
+		fmt.Fprintf(&sb, "\n%d files, %d bytes in %.1fs, %d Gemini tokens", s.Files, s.Bytes, s.ElapsedSeconds, s.TotalTokens)
+		if len(s.Failures) > 0 {
+			failures := make([]string, 0, len(s.Failures))
+			for _, category := range sortedKeys(s.Failures) {
+				failures = append(failures, fmt.Sprintf("%s: %d", category, s.Failures[category]))
+			}
+			fmt.Fprintf(&sb, "\nfailures: %s", strings.Join(failures, ", "))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookFormat(t *testing.T) {
+	tests := map[string]string{
+		"https://hooks.slack.com/services/T/B/X":           notifySlack,
+		"https://chat.googleapis.com/v1/spaces/S/messages": notifyChat,
+		"https://example.com/hook":                         notifyJSON,
+	}
+	for u, want := range tests {
+		if got := webhookFormat(u); got != want {
+			t.Errorf("webhookFormat(%s) = %q, want %q", u, got, want)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	prev := notifyFormat
+	defer func() { notifyFormat = prev }()
+
+	status := runStatus{RunID: "r1", Status: "partial", ExitCode: exitPartial, Summary: &runSummary{Files: 3, Failures: map[string]int{stageDescribe: 1}}}
+	notifyFormat = ""
+	if err := notify(srv.URL, status); err != nil {
+		t.Fatalf("notify() error = %v", err)
+	}
+	if got["run_id"] != "r1" || got["status"] != "partial" {
+		t.Errorf("JSON payload = %v, want run status", got)
+	}
+
+	notifyFormat = notifySlack
+	if err := notify(srv.URL, status); err != nil {
+		t.Fatalf("notify() error = %v", err)
+	}
+	text, _ := got["text"].(string)
+	if !strings.Contains(text, "run r1: partial") || !strings.Contains(text, "describe: 1") {
+		t.Errorf("Slack payload = %q, want status and failures", text)
+	}
+}
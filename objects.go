@@ -0,0 +1,46 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+var cacheControl string
+var makePublic bool
+
+// objectAttrs returns the attributes to upload a Drive file's object with,
+// with the file's labels as metadata
+func objectAttrs(file drive.File) storage.ObjectAttrs {
+	dest := routeFor(file)
+	attrs := storage.ObjectAttrs{
+		ContentType:  file.MimeType,
+		CacheControl: cacheControl,
+		StorageClass: dest.StorageClass,
+		Metadata:     map[string]string{},
+	}
+	dest.Retention.apply(&attrs, file)
+	maps.Copy(attrs.Metadata, labelsFor(file))
+	attrs.Metadata["drive-file-id"] = file.Id
+	attrs.Metadata[runIDKey] = runID
+	maps.Copy(attrs.Metadata, faceMetadata(file))
+	maps.Copy(attrs.Metadata, languageMetadata(file))
+	if categories := piiFound(file); len(categories) > 0 {
+		attrs.Metadata[piiKey] = strings.Join(categories, ",")
+	}
+	if makePublic {
+		// not allowed on buckets with uniform bucket-level access, which are
+		// made public by granting allUsers the Storage Object Viewer role
+		attrs.PredefinedACL = "publicRead"
+	}
+	return attrs
+}
+
+// publicURL returns the public URL of an object, served when it is publicly readable
+func publicURL(bucket, objectPath string) string {
+	u := url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + bucket + "/" + objectPath}
+	return u.String()
+}
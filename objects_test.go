@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestObjectAttrs(t *testing.T) {
+	prevCache, prevPublic := cacheControl, makePublic
+	defer func() { cacheControl, makePublic = prevCache, prevPublic }()
+
+	attrs := objectAttrs(drive.File{MimeType: "image/png"})
+	if attrs.ContentType != "image/png" || attrs.CacheControl != "" || attrs.PredefinedACL != "" {
+		t.Errorf("objectAttrs() = %+v, want content type only", attrs)
+	}
+
+	cacheControl, makePublic = "public, max-age=3600", true
+	attrs = objectAttrs(drive.File{MimeType: "image/png"})
+	if attrs.CacheControl != "public, max-age=3600" || attrs.PredefinedACL != "publicRead" {
+		t.Errorf("objectAttrs() = %+v, want cache control and publicRead", attrs)
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// record is a single catalog entry for a processed Drive file; it is also
+// written as the sidecar JSON
+type record struct {
+	Name     string `json:"name"`
+	Size     int    `json:"size"`
+	MimeType string `json:"mime_type"`
+	ID       string `json:"drive_id"`
+	// RunID is the run that produced the record and uploaded its object
+	RunID      string `json:"run_id,omitempty"`
+	FolderID   string `json:"folder_id,omitempty"`
+	FolderName string `json:"folder_name,omitempty"`
+	// RelativePath is the folder path relative to the source folder in recursive mode
+	RelativePath string `json:"relative_path,omitempty"`
+	Bucket       string `json:"bucket,omitempty"`
+	ObjectPath   string `json:"object_path,omitempty"`
+	// OriginalPath is the object path of the original of a watermarked image
+	OriginalPath string `json:"original_path,omitempty"`
+	SignedURL    string `json:"signed_url,omitempty"`
+	Description  string `json:"description"`
+	// Model, Prompt and DescribedAt identify how and when the description
+	// was generated
+	Model       string `json:"model,omitempty"`
+	Prompt      string `json:"prompt,omitempty"`
+	DescribedAt string `json:"described_at,omitempty"`
+	// Region is the Vertex AI region that generated the description, when
+	// LOCATION lists several to fail over between
+	Region string `json:"region,omitempty"`
+	// Refinements are the responses to the -refine turns, by name
+	Refinements map[string]string `json:"refinements,omitempty"`
+	// Animation is the frame count and duration of an animated GIF or PNG
+	Animation *animationInfo `json:"animation,omitempty"`
+	// Labels are the file's labels from the config label rules
+	Labels map[string]string `json:"labels,omitempty"`
+	// Faces is how many faces were found in an image, with -faces or
+	// -blur-faces
+	Faces *int `json:"faces,omitempty"`
+	// Languages are the languages spoken in an audio or video file, most
+	// spoken first, with -speech-language
+	Languages []string `json:"spoken_languages,omitempty"`
+	// PII are the kinds of personal information found in the file, with -pii
+	PII []string `json:"pii,omitempty"`
+	// Tags and Category are the file's normalized tags, with -tags
+	Tags     []string `json:"tags,omitempty"`
+	Category string   `json:"category,omitempty"`
+	// Citations and SearchQueries are the web sources and Google searches a
+	// description was grounded with, with -search-grounding
+	Citations     []citation `json:"citations,omitempty"`
+	SearchQueries []string   `json:"search_queries,omitempty"`
+	// DescriptionVersions are the descriptions generated by earlier runs,
+	// oldest first, with -sidecar
+	DescriptionVersions []descriptionVersion `json:"description_versions,omitempty"`
+	// NeedsReview is set when the description failed and the object was
+	// quarantined under -review-prefix
+	NeedsReview bool `json:"needs_review,omitempty"`
+	// ReviewStatus is the review state of the description: pending,
+	// approved or rejected, with an optional reviewer's note
+	ReviewStatus string `json:"review_status,omitempty"`
+	ReviewNote   string `json:"review_note,omitempty"`
+	// EditedAt is when a reviewer last edited the description
+	EditedAt string `json:"edited_at,omitempty"`
+
+	// Timeline is the stages of the file's pipeline, in milliseconds since
+	// the run started
+	Timeline []stageSpan `json:"timeline,omitempty"`
+
+	// Generation is the generation of the uploaded object, in a bucket with
+	// versioning enabled
+	Generation int64 `json:"generation,omitempty"`
+
+	// Replicas are the gs:// URIs of the object's copies in the
+	// -replica-buckets
+	Replicas []string `json:"replicas,omitempty"`
+
+	// Revisions are the object paths of the file's Drive revisions, with -revisions
+	Revisions []string `json:"revisions,omitempty"`
+
+	// Drive access control, with -export-permissions
+	Owners      []string     `json:"owners,omitempty"`
+	Shared      *bool        `json:"shared,omitempty"`
+	Permissions []permission `json:"permissions,omitempty"`
+
+	// Comments are the Drive comments on the file, with -export-comments
+	Comments []comment `json:"comments,omitempty"`
+
+	// ImageMetadata is the camera, exposure and location metadata Drive
+	// extracted from an image, with -export-media-metadata
+	ImageMetadata *imageMetadata `json:"image_metadata,omitempty"`
+}
+
+// bucket returns the bucket of the uploaded object, which routing rules may
+// set to other than -gcs-bucket
+func (r record) bucket() string {
+	if r.Bucket != "" {
+		return r.Bucket
+	}
+	return gcsBucket
+}
+
+// gcsURI returns the gs:// URI of the uploaded object, empty if none was
+func (r record) gcsURI() string {
+	if r.ObjectPath == "" {
+		return ""
+	}
+	return fmt.Sprintf("gs://%s/%s", r.bucket(), r.ObjectPath)
+}
+
+// browserURL returns an authenticated browser URL for the uploaded object
+func (r record) browserURL() string {
+	u := url.URL{Scheme: "https", Host: "storage.cloud.google.com", Path: "/" + r.bucket() + "/" + r.ObjectPath}
+	return u.String()
+}
+
+// columns maps CSV column names to their value in a record
+var columns = map[string]func(r record) string{
+	"name":          func(r record) string { return r.Name },
+	"size":          func(r record) string { return fmt.Sprintf("%d", r.Size) },
+	"mime_type":     func(r record) string { return r.MimeType },
+	"drive_id":      func(r record) string { return r.ID },
+	"run_id":        func(r record) string { return r.RunID },
+	"folder_id":     func(r record) string { return r.FolderID },
+	"folder_name":   func(r record) string { return r.FolderName },
+	"relative_path": func(r record) string { return r.RelativePath },
+	"bucket":        func(r record) string { return r.bucket() },
+	"object_path":   func(r record) string { return r.ObjectPath },
+	"original_path": func(r record) string { return r.OriginalPath },
+	"gcs_uri":       func(r record) string { return r.gcsURI() },
+	"url":           func(r record) string { return r.browserURL() },
+	"signed_url":    func(r record) string { return r.SignedURL },
+	"public_url":    func(r record) string { return publicURL(r.bucket(), r.ObjectPath) },
+	"description":   func(r record) string { return r.Description },
+	"model":         func(r record) string { return r.Model },
+	"prompt":        func(r record) string { return r.Prompt },
+	"described_at":  func(r record) string { return r.DescribedAt },
+	"region":        func(r record) string { return r.Region },
+	"citations":     func(r record) string { return formatCitations(r.Citations) },
+	"tags":          func(r record) string { return strings.Join(r.Tags, ";") },
+	"pii":           func(r record) string { return strings.Join(r.PII, ";") },
+	"labels":        func(r record) string { return formatLabels(r.Labels) },
+	"faces": func(r record) string {
+		if r.Faces == nil {
+			return ""
+		}
+		return strconv.Itoa(*r.Faces)
+	},
+	"spoken_languages": func(r record) string { return strings.Join(r.Languages, ";") },
+	"timeline":         func(r record) string { return formatTimeline(r.Timeline) },
+	"replicas":         func(r record) string { return strings.Join(r.Replicas, ";") },
+	"generation": func(r record) string {
+		if r.Generation == 0 {
+			return ""
+		}
+		return strconv.FormatInt(r.Generation, 10)
+	},
+	"frames": func(r record) string {
+		if r.Animation == nil {
+			return ""
+		}
+		return fmt.Sprint(r.Animation.Frames)
+	},
+	"duration": func(r record) string {
+		if r.Animation == nil {
+			return ""
+		}
+		return r.Animation.duration().String()
+	},
+	"category":             func(r record) string { return r.Category },
+	"needs_review":         func(r record) string { return fmt.Sprintf("%t", r.NeedsReview) },
+	"description_versions": func(r record) string { return formatVersions(r.DescriptionVersions) },
+	"review_status":        func(r record) string { return r.ReviewStatus },
+	"review_note":          func(r record) string { return r.ReviewNote },
+	"edited_at":            func(r record) string { return r.EditedAt },
+	"revisions":            func(r record) string { return strings.Join(r.Revisions, ";") },
+	"owners":               func(r record) string { return strings.Join(r.Owners, ";") },
+	"comments":             func(r record) string { return formatComments(r.Comments) },
+	"image_size": func(r record) string {
+		if r.ImageMetadata == nil || r.ImageMetadata.Width == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%dx%d", r.ImageMetadata.Width, r.ImageMetadata.Height)
+	},
+	"camera": func(r record) string {
+		if r.ImageMetadata == nil {
+			return ""
+		}
+		return r.ImageMetadata.camera()
+	},
+	"taken_at": func(r record) string {
+		if r.ImageMetadata == nil {
+			return ""
+		}
+		return r.ImageMetadata.Time
+	},
+	"exposure": func(r record) string {
+		if r.ImageMetadata == nil {
+			return ""
+		}
+		return r.ImageMetadata.exposure()
+	},
+	"location": func(r record) string {
+		if r.ImageMetadata == nil || r.ImageMetadata.Location == nil {
+			return ""
+		}
+		return fmt.Sprintf("%f,%f", r.ImageMetadata.Location.Latitude, r.ImageMetadata.Location.Longitude)
+	},
+	"shared": func(r record) string {
+		if r.Shared == nil {
+			return ""
+		}
+		return fmt.Sprintf("%t", *r.Shared)
+	},
+	"permissions": func(r record) string {
+		perms := make([]string, len(r.Permissions))
+		for i, p := range r.Permissions {
+			perms[i] = p.String()
+		}
+		return strings.Join(perms, ";")
+	},
+}
+
+// defaultColumns are the CSV columns written when none are specified
+var defaultColumns = []string{"name", "size", "mime_type", "drive_id", "object_path", "description"}
+
+// parseColumns parses and validates a comma-separated list of column names
+func parseColumns(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return defaultColumnsWithRefinements(), nil
+	}
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("unknown column %q, available columns: %s", name, strings.Join(sortedKeys(columns), ","))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// recordWriter writes catalog records in a given output format; implementations
+// are safe for concurrent use
+type recordWriter interface {
+	Write(r record) error
+	Close() error
+}
+
+// catalogFile is the path of the CSV catalog
+var catalogFile string = "descriptions.csv"
+
+// newRecordWriter returns a recordWriter for the output format
+func newRecordWriter(format string) (recordWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVRecordWriter(catalogFile, csvColumns)
+	case "markdown", "md":
+		return &markdownRecordWriter{}, nil
+	case formatAEM, formatWordPress, formatContentful:
+		return newDAMRecordWriter(format), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q, expected csv, markdown, aem, wordpress or contentful", format)
+	}
+}
+
+// csvRecordWriter streams records to a CSV file, starting with a header row.
+// The records are written to a pending file, flushed to disk every
+// flushEvery records, which is renamed into place on Close.
+type csvRecordWriter struct {
+	mu      sync.Mutex
+	file    *pendingFile
+	writer  *csv.Writer
+	columns []string
+	written int
+}
+
+func newCSVRecordWriter(path string, columns []string) (*csvRecordWriter, error) {
+	f, err := createPending(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSV file: %v", err)
+	}
+	w := &csvRecordWriter{file: f, writer: csv.NewWriter(f), columns: columns}
+	if err := w.writer.Write(columns); err != nil {
+		f.abort()
+		return nil, fmt.Errorf("failed to write CSV header: %v", err)
+	}
+	return w, nil
+}
+
+func (w *csvRecordWriter) Write(r record) error {
+	row := make([]string, len(w.columns))
+	for i, name := range w.columns {
+		row[i] = columns[name](r)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.writer.Write(row); err != nil {
+		return err
+	}
+	w.written++
+	if flushEvery > 0 && w.written%flushEvery == 0 {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered records to disk
+func (w *csvRecordWriter) flush() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close flushes the records and renames the CSV file into place, leaving the
+// pending file, with the records flushed so far, if they cannot be written
+func (w *csvRecordWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.commit()
+}
+
+// markdownRecordWriter collects records and writes one Markdown document per
+// Drive folder on Close
+type markdownRecordWriter struct {
+	mu      sync.Mutex
+	records []record
+}
+
+func (w *markdownRecordWriter) Write(r record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.records = append(w.records, r)
+	return nil
+}
+
+func (w *markdownRecordWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	byFolder := map[string][]record{}
+	for _, r := range w.records {
+		byFolder[r.FolderID] = append(byFolder[r.FolderID], r)
+	}
+	for _, folderID := range sortedKeys(byFolder) {
+		records := byFolder[folderID]
+		sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+		name := folderID
+		if name == "" {
+			name = "descriptions"
+		}
+		path := fmt.Sprintf("%s.md", name)
+		if err := writeFile(path, []byte(markdownDocument(records))); err != nil {
+			return fmt.Errorf("unable to write markdown catalog: %v", err)
+		}
+	}
+	return nil
+}
+
+// markdownDocument renders a folder's records as a Markdown document
+func markdownDocument(records []record) string {
+	var sb strings.Builder
+	title := records[0].FolderName
+	if title == "" {
+		title = records[0].FolderID
+	}
+	if title == "" {
+		title = "Descriptions"
+	}
+	fmt.Fprintf(&sb, "# %s\n\n", title)
+	if records[0].RelativePath != "" {
+		fmt.Fprintf(&sb, "`%s`\n\n", records[0].RelativePath)
+	}
+	for _, r := range records {
+		fmt.Fprintf(&sb, "## %s\n\n", r.Name)
+		link := recordLink(r)
+		if strings.HasPrefix(r.MimeType, "image/") {
+			fmt.Fprintf(&sb, "![%s](%s)\n\n", markdownEscape(r.Name), link)
+		} else {
+			fmt.Fprintf(&sb, "[%s](%s)\n\n", markdownEscape(r.Name), link)
+		}
+		fmt.Fprintf(&sb, "%s\n\n", strings.TrimSpace(r.Description))
+		fmt.Fprintf(&sb, "`%s` · %s · %d bytes\n\n", r.gcsURI(), r.MimeType, r.Size)
+	}
+	return sb.String()
+}
+
+// markdownEscape escapes characters that would break Markdown link text
+func markdownEscape(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(s)
+}
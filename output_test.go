@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestParseColumns(t *testing.T) {
+	got, err := parseColumns("name, gcs_uri,description")
+	if err != nil {
+		t.Fatalf("parseColumns() error = %v", err)
+	}
+	if strings.Join(got, ",") != "name,gcs_uri,description" {
+		t.Errorf("parseColumns() = %v", got)
+	}
+	if got, _ := parseColumns(""); strings.Join(got, ",") != strings.Join(defaultColumns, ",") {
+		t.Errorf("parseColumns(\"\") = %v, want defaults", got)
+	}
+	if _, err := parseColumns("name,bogus"); err == nil {
+		t.Error("parseColumns() accepted an unknown column")
+	}
+}
+
+func TestMarkdownDocument(t *testing.T) {
+	prev := gcsBucket
+	gcsBucket = "bucket"
+	defer func() { gcsBucket = prev }()
+
+	doc := markdownDocument([]record{
+		{Name: "sun set.jpg", MimeType: "image/jpeg", FolderID: "f1", FolderName: "Photos", ObjectPath: "p/sun set.jpg", Description: "A sunset.\n"},
+	})
+	for _, want := range []string{
+		"# Photos\n",
+		"![sun set.jpg](https://storage.cloud.google.com/bucket/p/sun%20set.jpg)",
+		"A sunset.\n",
+		"`gs://bucket/p/sun set.jpg`",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("markdown document missing %q:\n%s", want, doc)
+		}
+	}
+}
+
+func TestProcessFileSignedURL(t *testing.T) {
+	useFakes(t)
+	prev := signedURLTTL
+	signedURLTTL = time.Hour
+	defer func() { signedURLTTL = prev }()
+
+	r := processFile(context.Background(), drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}, "a.jpg")
+	if want := "https://signed.example.com/test-bucket/a.jpg?ttl=1h0m0s"; r.SignedURL != want {
+		t.Errorf("signed URL = %q, want %q", r.SignedURL, want)
+	}
+	if doc := markdownDocument([]record{r}); !strings.Contains(doc, "](https://signed.example.com/") {
+		t.Errorf("markdown does not link the signed URL:\n%s", doc)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// ownership filters
+const (
+	ownedByAnyone = "anyone"
+	ownedByMe     = "me"
+)
+
+// ownedBy limits the files processed to those owned by the running user (me),
+// an email address, or anyone
+var ownedBy string = ownedByAnyone
+
+// validOwnedBy reports whether an -owned-by value is valid
+func validOwnedBy(owner string) bool {
+	return owner == ownedByAnyone || owner == ownedByMe || strings.Contains(owner, "@")
+}
+
+// ownerQuery returns the Drive query clause for the ownership filter, or an
+// empty string for anyone
+func ownerQuery() string {
+	if ownedBy == ownedByAnyone || ownedBy == "" {
+		return ""
+	}
+	// quotes and backslashes are escaped with a backslash in Drive queries
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(ownedBy)
+	return fmt.Sprintf("'%s' in owners", escaped)
+}
+
+// isOwned reports whether a file passes the ownership filter; the file's
+// owners must have been retrieved
+func isOwned(file *drive.File) bool {
+	if ownedBy == ownedByAnyone || ownedBy == "" {
+		return true
+	}
+	for _, owner := range file.Owners {
+		if ownedBy == ownedByMe && owner.Me || strings.EqualFold(owner.EmailAddress, ownedBy) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestOwnerQuery(t *testing.T) {
+	prev := ownedBy
+	defer func() { ownedBy = prev }()
+
+	ownedBy = ownedByAnyone
+	if got := buildQuery("f", []string{"image/png"}); got != "'f' in parents and (mimeType = 'image/png')" {
+		t.Errorf("buildQuery() = %q, want no owner clause", got)
+	}
+	ownedBy = ownedByMe
+	if got := buildQuery("f", []string{"image/png"}); got != "'f' in parents and (mimeType = 'image/png') and 'me' in owners" {
+		t.Errorf("buildQuery() = %q", got)
+	}
+	ownedBy = "o'brien@example.com"
+	if got := ownerQuery(); got != `'o\'brien@example.com' in owners` {
+		t.Errorf("ownerQuery() = %q", got)
+	}
+	for _, owner := range []string{"me", "anyone", "a@example.com"} {
+		if !validOwnedBy(owner) {
+			t.Errorf("validOwnedBy(%q) = false", owner)
+		}
+	}
+	if validOwnedBy("someone") {
+		t.Error("validOwnedBy(someone) = true, want false")
+	}
+}
+
+func TestManifestOwnerFilter(t *testing.T) {
+	f := useFakes(t)
+	prev := ownedBy
+	ownedBy = "a@example.com"
+	defer func() { ownedBy = prev }()
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", nil)
+	f.drive.add("2", "b.jpg", "image/jpeg", "folder1", nil)
+	f.drive.files["1"].Owners = []*drive.User{{EmailAddress: "A@example.com"}}
+	f.drive.files["2"].Owners = []*drive.User{{EmailAddress: "b@example.com", Me: true}}
+
+	files := getFiles(context.Background(), []string{"1", "2"})
+	if len(files) != 1 || files[0].Id != "1" {
+		t.Errorf("getFiles() = %v, want only the file owned by a@example.com", files)
+	}
+	if summary := stats.summary(); summary.Skipped["owner"] != 1 {
+		t.Errorf("skipped = %v, want 1 owner", summary.Skipped)
+	}
+
+	ownedBy = ownedByMe
+	if files := getFiles(context.Background(), []string{"1", "2"}); len(files) != 1 || files[0].Id != "2" {
+		t.Errorf("getFiles() = %v, want only the file owned by me", files)
+	}
+}
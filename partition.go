@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// partitionBy splits the listing of the source folder into windows of
+// modifiedTime, listed and processed one after another: year, month, week or
+// day; empty to list the folder at once
+var partitionBy string
+
+var partitionUnits = []string{"year", "month", "week", "day"}
+
+// partitionSince is the start of the first window, as YYYY-MM-DD; the files
+// modified before it are listed together, first
+var partitionSince string = "2012-01-01"
+
+// partitionStart is the parsed -partition-since
+var partitionStart time.Time
+
+// partitionState is where the windows processed are recorded, so a rerun
+// carries on from the first window not done
+var partitionState string = "partitions.csv"
+
+// modifiedWindow is the modifiedTime clause of the window being listed, empty
+// to list every file
+var modifiedWindow string
+
+// partition is a window of modifiedTime, from start up to end; a zero start
+// or end leaves it open
+type partition struct {
+	start, end time.Time
+}
+
+// key names the window in the partition state, as start..end
+func (p partition) key() string {
+	var start, end string
+	if !p.start.IsZero() {
+		start = p.start.Format(time.DateOnly)
+	}
+	if !p.end.IsZero() {
+		end = p.end.Format(time.DateOnly)
+	}
+	return start + ".." + end
+}
+
+// query returns the Drive query clause of the window
+func (p partition) query() string {
+	var clauses []string
+	if !p.start.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("modifiedTime >= '%s'", p.start.Format(time.RFC3339)))
+	}
+	if !p.end.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("modifiedTime < '%s'", p.end.Format(time.RFC3339)))
+	}
+	return strings.Join(clauses, " and ")
+}
+
+// partitions returns the windows of a unit from since until now: the files
+// modified before since, each window, and the files modified after the last
+func partitions(unit string, since, now time.Time) []partition {
+	out := []partition{{end: since}}
+	for start := since; start.Before(now); {
+		var end time.Time
+		switch unit {
+		case "year":
+			end = start.AddDate(1, 0, 0)
+		case "month":
+			end = start.AddDate(0, 1, 0)
+		case "week":
+			end = start.AddDate(0, 0, 7)
+		default:
+			end = start.AddDate(0, 0, 1)
+		}
+		out = append(out, partition{start: start, end: end})
+		start = end
+	}
+	// files modified later, such as while the run lasts
+	return append(out, partition{start: out[len(out)-1].end})
+}
+
+// checkPartitionFlags validates -partition and parses -partition-since
+func checkPartitionFlags() error {
+	if partitionBy == "" {
+		return nil
+	}
+	if !slices.Contains(partitionUnits, partitionBy) {
+		return fmt.Errorf("unknown -partition %q, expected one of %s", partitionBy, strings.Join(partitionUnits, ", "))
+	}
+	var err error
+	if partitionStart, err = time.Parse(time.DateOnly, partitionSince); err != nil {
+		return fmt.Errorf("invalid -partition-since %q, expected YYYY-MM-DD", partitionSince)
+	}
+	switch {
+	case manifestFile != "" || readStdin:
+		return errors.New("-partition splits the listing of -folder, not -manifest or -stdin")
+	case maxFiles > 0 || maxBytes > 0:
+		return errors.New("-partition processes whole windows, without -max or -max-bytes")
+	}
+	return nil
+}
+
+// partitionScope names what the run lists, the folder, MIME types, recursion
+// and shard, so the windows a run on something else recorded in the same
+// partition state aren't taken as done
+func partitionScope() string {
+	scope := fmt.Sprintf("%s;%s;recursive=%t", sourceFolderID, strings.Join(mimeTypes, "|"), recursive)
+	if shardCount > 1 {
+		scope += fmt.Sprintf(";shard=%d/%d", shardIndex, shardCount)
+	}
+	return scope
+}
+
+// readPartitionState returns the windows already processed, in order; rows
+// of a state written before scopes were recorded are dropped
+func readPartitionState(path string) ([]partitionRecord, error) {
+	var records []partitionRecord
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(bufio.NewReader(f)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, row := range rows {
+		if i == 0 || len(row) < 5 {
+			continue // header
+		}
+		r := partitionRecord{key: row[0], scope: row[1], runID: row[3]}
+		r.files, _ = strconv.Atoi(row[2])
+		r.at, _ = time.Parse(time.RFC3339, row[4])
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// partitionRecord is a window processed, as a row of the partition state
+type partitionRecord struct {
+	key string
+	// scope is the partitionScope of the run that processed the window
+	scope string
+	files int
+	runID string
+	at    time.Time
+}
+
+// writePartitionState records the windows processed, replacing the file
+// atomically so a run stopped while writing keeps the previous state
+func writePartitionState(path string, records []partitionRecord) error {
+	f, err := createPending(path)
+	if err != nil {
+		return fmt.Errorf("unable to create partition state: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"window", "scope", "files", "run_id", "completed_at"})
+	for _, r := range records {
+		w.Write([]string{r.key, r.scope, strconv.Itoa(r.files), r.runID, r.at.UTC().Format(time.RFC3339)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.abort()
+		return fmt.Errorf("unable to write partition state: %v", err)
+	}
+	return f.commit()
+}
+
+// processPartitions lists and processes the source folder a window of
+// modifiedTime at a time, so no single listing holds millions of files,
+// recording each window processed in -partition-state. A rerun skips the
+// windows recorded for the same scope, except the last, open one, which may
+// have new files; the windows of other scopes are kept, but not skipped.
+func processPartitions(ctx context.Context, output recordWriter) error {
+	state, err := readPartitionState(partitionState)
+	if err != nil {
+		return fmt.Errorf("unable to read partition state: %v", err)
+	}
+	scope := partitionScope()
+	done := map[string]partitionRecord{}
+	var records []partitionRecord
+	for _, r := range state {
+		if r.scope == scope {
+			done[r.key] = r
+		} else {
+			records = append(records, r)
+		}
+	}
+	if len(records) > 0 {
+		log.Printf("partition state %s: ignoring %d windows processed for another folder, MIME types, recursion or shard", partitionState, len(records))
+	}
+	windows := partitions(partitionBy, partitionStart, time.Now())
+	for i, p := range windows {
+		last := i == len(windows)-1
+		if r, ok := done[p.key()]; ok && !last {
+			log.Printf("window %s (%d of %d): processed by run %s, skipping", p.key(), i+1, len(windows), r.runID)
+			records = append(records, r)
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		modifiedWindow = p.query()
+		var fileList []drive.File
+		if recursive {
+			fileList, err = listFilesRecursive(ctx, sourceFolderID, mimeTypes)
+		} else {
+			fileList, err = listFiles(ctx, sourceFolderID, mimeTypes)
+		}
+		modifiedWindow = ""
+		if err != nil {
+			return fmt.Errorf("unable to list window %s: %w", p.key(), err)
+		}
+		log.Printf("window %s (%d of %d): %d files", p.key(), i+1, len(windows), len(fileList))
+		if len(fileList) > 0 {
+			if err := confirmRun(fileList); err != nil {
+				return err
+			}
+			if err := delegateTransfer(ctx, fileList); err != nil {
+				return err
+			}
+			files := make(chan drive.File)
+			go func() {
+				defer close(files)
+				for _, file := range fileList {
+					files <- file
+				}
+			}()
+			var started <-chan drive.File = files
+			if activeHours != nil {
+				started = gateActiveHours(ctx, files)
+			}
+			processFiles(ctx, started, output)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err() // the window may not have been processed in full
+		}
+		records = append(records, partitionRecord{key: p.key(), scope: scope, files: len(fileList), runID: runID, at: time.Now()})
+		if err := writePartitionState(partitionState, records); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return nil
+}
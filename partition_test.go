@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitions(t *testing.T) {
+	since := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	var keys []string
+	for _, p := range partitions("month", since, time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)) {
+		keys = append(keys, p.key())
+	}
+	if got := strings.Join(keys, " "); got != "..2024-11-01 2024-11-01..2024-12-01 2024-12-01..2025-01-01 2025-01-01..2025-02-01 2025-02-01.." {
+		t.Errorf("windows = %s", got)
+	}
+	p := partition{start: since, end: since.AddDate(0, 0, 7)}
+	if got := p.query(); got != "modifiedTime >= '2024-11-01T00:00:00Z' and modifiedTime < '2024-11-08T00:00:00Z'" {
+		t.Errorf("query = %s", got)
+	}
+}
+
+func TestProcessPartitions(t *testing.T) {
+	f := useFakes(t)
+	defer func(by, state string, start time.Time, prev bool) {
+		partitionBy, partitionState, partitionStart, assumeYes = by, state, start, prev
+	}(partitionBy, partitionState, partitionStart, assumeYes)
+	partitionBy, assumeYes = "year", true
+	partitionStart = time.Date(time.Now().Year()-1, 1, 1, 0, 0, 0, 0, time.UTC)
+	partitionState = filepath.Join(t.TempDir(), "partitions.csv")
+	sourceFolderID = "root"
+	modified := map[string]time.Time{
+		"old":  partitionStart.AddDate(-5, 0, 0),
+		"last": partitionStart.AddDate(0, 2, 0),
+		"this": time.Now().AddDate(0, 0, -1),
+	}
+	for id, at := range modified {
+		f.drive.add(id, id+".jpg", "image/jpeg", "root", []byte(id))
+		f.drive.files[id].ModifiedTime = at.UTC().Format(time.RFC3339)
+	}
+
+	output := &markdownRecordWriter{}
+	if err := processPartitions(context.Background(), output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.records) != 3 {
+		t.Fatalf("records = %d, want each file once", len(output.records))
+	}
+	b, err := os.ReadFile(partitionState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Count(string(b), "\n"); rows != 5 {
+		t.Errorf("partition state = %q, want a row for each of the 4 windows", b)
+	}
+
+	// a rerun skips the windows done, except the last, open one
+	output = &markdownRecordWriter{}
+	if err := processPartitions(context.Background(), output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.records) != 0 {
+		t.Errorf("rerun records = %+v, want none, the open window being empty", output.records)
+	}
+
+	// a run on another folder, with the same state, processes every window
+	f.drive.add("other", "other", folderMimeType, "root", nil)
+	f.drive.add("elsewhere", "elsewhere.jpg", "image/jpeg", "other", []byte("elsewhere"))
+	f.drive.files["elsewhere"].ModifiedTime = modified["last"].UTC().Format(time.RFC3339)
+	sourceFolderID = "other"
+	output = &markdownRecordWriter{}
+	if err := processPartitions(context.Background(), output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.records) != 1 {
+		t.Errorf("other folder records = %d, want the file in a window done for root", len(output.records))
+	}
+	if b, _ = os.ReadFile(partitionState); strings.Count(string(b), "\n") != 9 {
+		t.Errorf("partition state = %q, want the windows of both folders", b)
+	}
+}
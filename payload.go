@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// maxInlineFlag is the -max-inline size, e.g. 15MB
+var maxInlineFlag string = "15MB"
+
+// maxInlineBytes is the largest file sent inline to Gemini; the request
+// limit is 20MB, and inline data is base64 encoded
+var maxInlineBytes int64 = 15e6
+
+// maxSide is the longest side, in pixels, of images sent to Gemini, which
+// are downscaled if larger; 0 sends images at full size. Originals are uploaded.
+var maxSide int
+
+// stagingPrefix is where files too large to send inline are staged for
+// Gemini to read, under the run ID
+const stagingPrefix = ".drivetogcs/staging"
+
+// describePart returns the part holding a file's contents for Gemini. Files
+// over maxInlineBytes are downscaled if they are images, or else read by
+// Gemini from Cloud Storage: from the file's object if it was uploaded
+// already, or a staged copy removed by the returned cleanup function; this
+// fails with the Gemini Developer API, which cannot read Cloud Storage. Images
+// are first downscaled to -max-side, and animations replaced by the
+// -animation frame. Camera RAW files are described from their JPEG preview. Files described from their object with
+// -describe-from-gcs are read from its gs:// URI.
+func describePart(ctx context.Context, file drive.File, data []byte) (*genai.Part, func(), error) {
+	mimeType := file.MimeType
+	if uri, ok := gcsSources.Load(file.Id); ok {
+		return genai.NewPartFromURI(uri.(string), mimeType), func() {}, nil
+	}
+	if isRAW(mimeType) {
+		preview, err := rawPreview(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to describe RAW file %s: %v", file.Name, err)
+		}
+		log.Printf("describing %s from its %d byte JPEG preview", file.Name, len(preview))
+		data, mimeType = preview, "image/jpeg"
+	}
+	if _, ok := animations.Load(file.Id); ok {
+		if a, ok, err := decodeAnimation(data, mimeType); err == nil && ok {
+			frame, err := describedFrame(a)
+			if err != nil {
+				log.Printf("unable to extract a frame of %s, describing it as is: %v", file.Name, err)
+			} else {
+				data, mimeType = frame, "image/png"
+			}
+		}
+	}
+	if maxSide > 0 && resizable[mimeType] {
+		if w, h, err := imageSize(data); err == nil && max(w, h) > maxSide {
+			resized, resizedType, err := resizeImage(data, mimeType, maxSide)
+			if err != nil {
+				log.Printf("unable to downscale %s, describing it at full size: %v", file.Name, err)
+			} else {
+				rw, rh, _ := imageSize(resized)
+				log.Printf("describing %s downscaled from %dx%d to %dx%d (about %d tokens instead of %d)", file.Name, w, h, rw, rh, imageTokens(rw, rh), imageTokens(w, h))
+				data, mimeType = resized, resizedType
+			}
+		}
+	}
+	if int64(len(data)) <= maxInlineBytes {
+		return genai.NewPartFromBytes(data, mimeType), func() {}, nil
+	}
+
+	if resizable[mimeType] {
+		if w, h, err := imageSize(data); err == nil {
+			resized, resizedType := data, mimeType
+			// halve the longest side until the image fits
+			for side := max(w, h) / 2; side >= 64 && int64(len(resized)) > maxInlineBytes; side /= 2 {
+				resized, resizedType, err = resizeImage(data, mimeType, side)
+				if err != nil {
+					break
+				}
+				w, h, _ = imageSize(resized)
+			}
+			if err == nil && int64(len(resized)) <= maxInlineBytes {
+				log.Printf("%s is %d bytes, over the inline limit of %d, describing it downscaled to %dx%d (about %d tokens)", file.Name, len(data), maxInlineBytes, w, h, imageTokens(w, h))
+				return genai.NewPartFromBytes(resized, resizedType), func() {}, nil
+			}
+		}
+	}
+
+	if backend == backendGeminiAPI {
+		return nil, nil, fmt.Errorf("%s is %d bytes, over the inline limit of %d, and the Gemini Developer API cannot read files from Cloud Storage", file.Name, len(data), maxInlineBytes)
+	}
+	if describeOnly {
+		return nil, nil, fmt.Errorf("%s is %d bytes, over the inline limit of %d, and -describe-only doesn't stage files in Cloud Storage", file.Name, len(data), maxInlineBytes)
+	}
+	dest := routeFor(file)
+	if name, err := objectName(file); err == nil && !alwaysUploadToGCS {
+		object := objectPath(dest.Prefix, contentName(file, name))
+		if attrs, err := storageSrv.Attrs(ctx, dest.Bucket, object); err == nil && attrs.Size == int64(len(data)) {
+			log.Printf("%s is %d bytes, over the inline limit, describing gs://%s/%s", file.Name, len(data), dest.Bucket, object)
+			return genai.NewPartFromURI(fmt.Sprintf("gs://%s/%s", dest.Bucket, object), mimeType), func() {}, nil
+		}
+	}
+	staged := path.Join(stagingPrefix, runID, file.Id)
+	attrs := storage.ObjectAttrs{ContentType: mimeType, Metadata: map[string]string{runIDKey: runID}}
+	written, err := storageSrv.Upload(ctx, dest.Bucket, staged, data, attrs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to stage %s for Gemini: %w", file.Name, err)
+	}
+	log.Printf("%s is %d bytes, over the inline limit, describing it staged at gs://%s/%s", file.Name, len(data), dest.Bucket, staged)
+	cleanup := func() {
+		if err := storageSrv.DeleteIfGeneration(context.Background(), dest.Bucket, staged, written.Generation); err != nil {
+			log.Printf("unable to remove staged gs://%s/%s: %v", dest.Bucket, staged, err)
+		}
+	}
+	return genai.NewPartFromURI(fmt.Sprintf("gs://%s/%s", dest.Bucket, staged), mimeType), cleanup, nil
+}
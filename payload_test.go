@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDescribePartOverInlineLimit(t *testing.T) {
+	f := useFakes(t)
+	prev := maxInlineBytes
+	defer func() { maxInlineBytes = prev }()
+	img := testPNG(t, 800, 400)
+	maxInlineBytes = int64(len(img)) - 1
+	doc := []byte(strings.Repeat("x", len(img)))
+	f.drive.add("img", "big.png", "image/png", "root", img)
+	f.drive.add("doc", "big.pdf", "application/pdf", "root", doc)
+	f.drive.add("small", "small.pdf", "application/pdf", "root", []byte("x"))
+	ctx := context.Background()
+
+	part, cleanup, err := describePart(ctx, *f.drive.files["small"], []byte("x"))
+	if err != nil || part.InlineData == nil {
+		t.Fatalf("describePart(small) = %+v, %v, want inline data", part, err)
+	}
+	cleanup()
+
+	// images are downscaled until they fit
+	part, cleanup, err = describePart(ctx, *f.drive.files["img"], img)
+	if err != nil || part.InlineData == nil || int64(len(part.InlineData.Data)) > maxInlineBytes {
+		t.Fatalf("describePart(image) = %+v, %v, want downscaled inline data", part, err)
+	}
+	if w, h, _ := imageSize(part.InlineData.Data); w != 400 || h != 200 {
+		t.Errorf("downscaled to %dx%d, want 400x200", w, h)
+	}
+	cleanup()
+
+	// other files are staged for Gemini to read from Cloud Storage, and removed
+	part, cleanup, err = describePart(ctx, *f.drive.files["doc"], doc)
+	if err != nil || part.FileData == nil || part.FileData.FileURI != "gs://test-bucket/.drivetogcs/staging/test-run/doc" {
+		t.Fatalf("describePart(doc) = %+v, %v, want a staged gs:// URI", part, err)
+	}
+	if _, ok := f.storage.objects["test-bucket/.drivetogcs/staging/test-run/doc"]; !ok {
+		t.Error("staged object not uploaded")
+	}
+	cleanup()
+	if _, ok := f.storage.objects["test-bucket/.drivetogcs/staging/test-run/doc"]; ok {
+		t.Error("staged object not removed")
+	}
+
+	// an object already uploaded is read in place
+	r := processFile(ctx, *f.drive.files["doc"], "big.pdf")
+	if r.NeedsReview {
+		t.Fatalf("record = %+v, want described", r)
+	}
+	part, _, err = describePart(ctx, *f.drive.files["doc"], doc)
+	if err != nil || part.FileData == nil || part.FileData.FileURI != "gs://test-bucket/big.pdf" {
+		t.Errorf("describePart(uploaded doc) = %+v, %v, want the object's URI", part, err)
+	}
+	if got := f.generator.contents[0].Parts[0].FileData; got == nil {
+		t.Errorf("Gemini request parts = %+v, want file data", f.generator.contents[0].Parts)
+	}
+
+	if got := imageTokens(300, 300); got != 258 {
+		t.Errorf("imageTokens(300, 300) = %d, want 258", got)
+	}
+	if got := imageTokens(1600, 800); got != 258*6 {
+		t.Errorf("imageTokens(1600, 800) = %d, want %d", got, 258*6)
+	}
+}
+
+func TestDescribePartMaxSide(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev int) { maxSide = prev }(maxSide)
+	maxSide = 100
+	img := testPNG(t, 400, 200)
+	f.drive.add("img", "photo.png", "image/png", "root", img)
+
+	part, _, err := describePart(context.Background(), *f.drive.files["img"], img)
+	if err != nil || part.InlineData == nil {
+		t.Fatalf("describePart = %+v, %v, want inline data", part, err)
+	}
+	if w, h, _ := imageSize(part.InlineData.Data); w != 100 || h != 50 {
+		t.Errorf("described at %dx%d, want 100x50", w, h)
+	}
+
+	r := processFile(context.Background(), *f.drive.files["img"], "photo.png")
+	if r.NeedsReview {
+		t.Fatalf("record = %+v, want described", r)
+	}
+	if got := f.storage.objects["test-bucket/photo.png"]; !bytes.Equal(got, img) {
+		t.Errorf("uploaded %d bytes, want the %d byte original", len(got), len(img))
+	}
+}
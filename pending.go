@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// flushEvery is how many catalog records are written between flushes to disk
+var flushEvery = 100
+
+// pendingSuffix is appended to the name of a file while it is written
+const pendingSuffix = ".partial"
+
+// pendingFile is a local file written under its name with pendingSuffix and
+// renamed into place when complete, so a crash leaves the previous file, if
+// any, and the records flushed so far, rather than an empty or truncated file
+type pendingFile struct {
+	*os.File
+	path string
+}
+
+// createPending creates a pending file for path, with -file-mode permissions
+func createPending(path string) (*pendingFile, error) {
+	f, err := createFile(path + pendingSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingFile{File: f, path: path}, nil
+}
+
+// commit syncs the pending file to disk and renames it to its path
+func (f *pendingFile) commit() error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to sync %s: %v", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), f.path)
+}
+
+// abort closes and removes the pending file, leaving the file at its path
+func (f *pendingFile) abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSVRecordWriterFlushes(t *testing.T) {
+	defer func(prev int) { flushEvery = prev }(flushEvery)
+	flushEvery = 2
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	if err := os.WriteFile(path, []byte("name\nprevious run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newCSVRecordWriter(path, []string{"name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := w.Write(record{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a crash now leaves the previous catalog and the records flushed so far
+	if b, _ := os.ReadFile(path); string(b) != "name\nprevious run\n" {
+		t.Errorf("catalog during the run = %q, want the previous catalog", b)
+	}
+	if b, _ := os.ReadFile(path + pendingSuffix); string(b) != "name\na.jpg\nb.jpg\n" {
+		t.Errorf("pending catalog = %q, want the first 2 records flushed", b)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "name\na.jpg\nb.jpg\nc.jpg\n" {
+		t.Errorf("catalog = %q, want all records", b)
+	}
+	if _, err := os.Stat(path + pendingSuffix); !os.IsNotExist(err) {
+		t.Errorf("pending catalog left behind: %v", err)
+	}
+}
+
+func TestPendingFileAbort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "needs-review.csv")
+	f, err := createPending(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("drive_id\n")
+	f.abort()
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 0 {
+		t.Errorf("files left = %v, want none", entries)
+	}
+	if err := writeCheckpoint(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); !strings.HasPrefix(string(b), "drive_id,") {
+		t.Errorf("checkpoint = %q", b)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+)
+
+var exportPermissions bool
+
+// permissionFields are the Drive file fields describing ownership and sharing
+const permissionFields = "owners(emailAddress, displayName), shared, permissions(type, role, emailAddress, domain, displayName)"
+
+// permission is a single Drive access grant
+type permission struct {
+	Type         string `json:"type"`
+	Role         string `json:"role"`
+	EmailAddress string `json:"email_address,omitempty"`
+	Domain       string `json:"domain,omitempty"`
+	DisplayName  string `json:"display_name,omitempty"`
+}
+
+// String formats the permission as role:type[:grantee], e.g. writer:user:a@example.com
+func (p permission) String() string {
+	grantee := p.EmailAddress
+	if grantee == "" {
+		grantee = p.Domain
+	}
+	if grantee == "" {
+		return fmt.Sprintf("%s:%s", p.Role, p.Type)
+	}
+	return fmt.Sprintf("%s:%s:%s", p.Role, p.Type, grantee)
+}
+
+// addPermissions retrieves a file's owners, sharing state and permissions from
+// Drive and adds them to the record
+func addPermissions(ctx context.Context, r *record) error {
+	f, err := driveSrv.Get(ctx, r.ID, permissionFields)
+	if err != nil {
+		return fmt.Errorf("unable to get permissions: %w", err)
+	}
+	applyPermissions(r, f)
+	return nil
+}
+
+// applyPermissions copies ownership and sharing from Drive file metadata to the record
+func applyPermissions(r *record, f *drive.File) {
+	r.Owners = nil
+	for _, owner := range f.Owners {
+		r.Owners = append(r.Owners, owner.EmailAddress)
+	}
+	shared := f.Shared
+	r.Shared = &shared
+	r.Permissions = nil
+	for _, p := range f.Permissions {
+		r.Permissions = append(r.Permissions, permission{
+			Type:         p.Type,
+			Role:         p.Role,
+			EmailAddress: p.EmailAddress,
+			Domain:       p.Domain,
+			DisplayName:  p.DisplayName,
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestPermissionsExportedToSidecar(t *testing.T) {
+	f := useFakes(t)
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+	file := f.drive.files["1"]
+	file.Owners = []*drive.User{{EmailAddress: "owner@example.com"}}
+	file.Shared = true
+	file.Permissions = []*drive.Permission{
+		{Type: "user", Role: "owner", EmailAddress: "owner@example.com"},
+		{Type: "domain", Role: "reader", Domain: "example.com"},
+		{Type: "anyone", Role: "reader"},
+	}
+
+	r := record{Name: "a.jpg", ID: "1", ObjectPath: "media/a.jpg"}
+	if err := addPermissions(context.Background(), &r); err != nil {
+		t.Fatalf("addPermissions() error = %v", err)
+	}
+	if got := columns["permissions"](r); got != "owner:user:owner@example.com;reader:domain:example.com;reader:anyone" {
+		t.Errorf("permissions column = %q", got)
+	}
+	if got := columns["owners"](r); got != "owner@example.com" {
+		t.Errorf("owners column = %q", got)
+	}
+	if got := columns["shared"](r); got != "true" {
+		t.Errorf("shared column = %q", got)
+	}
+
+	if err := writeSidecar(context.Background(), r); err != nil {
+		t.Fatalf("writeSidecar() error = %v", err)
+	}
+	var sidecar record
+	if err := json.Unmarshal(f.storage.objects["test-bucket/media/a.jpg.json"], &sidecar); err != nil {
+		t.Fatalf("sidecar is not valid JSON: %v", err)
+	}
+	if len(sidecar.Permissions) != 3 || sidecar.Owners[0] != "owner@example.com" || !*sidecar.Shared {
+		t.Errorf("sidecar = %+v", sidecar)
+	}
+	if got := f.storage.attrs["test-bucket/media/a.jpg.json"].ContentType; got != "application/json" {
+		t.Errorf("sidecar content type = %q", got)
+	}
+}
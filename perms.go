@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// The -file-mode, -dir-mode and -token-mode permissions, in octal, of the
+// files and folders written locally and of the OAuth token file, and the
+// process -umask, empty to keep the inherited one
+var (
+	fileModeFlag  string = "0644"
+	dirModeFlag   string = "0755"
+	tokenModeFlag string = "0600"
+	umaskFlag     string
+)
+
+var (
+	fileMode  os.FileMode = 0644
+	dirMode   os.FileMode = 0755
+	tokenMode os.FileMode = 0600
+)
+
+// parseMode parses octal permissions, such as 0640
+func parseMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid permissions %q, expected octal such as 0640", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// parseModes parses the permission flags, setting the umask if given
+func parseModes() error {
+	var err error
+	if fileMode, err = parseMode(fileModeFlag); err != nil {
+		return fmt.Errorf("-file-mode: %v", err)
+	}
+	if dirMode, err = parseMode(dirModeFlag); err != nil {
+		return fmt.Errorf("-dir-mode: %v", err)
+	}
+	if tokenMode, err = parseMode(tokenModeFlag); err != nil {
+		return fmt.Errorf("-token-mode: %v", err)
+	}
+	if umaskFlag != "" {
+		mask, err := parseMode(umaskFlag)
+		if err != nil {
+			return fmt.Errorf("-umask: %v", err)
+		}
+		if err := setUmask(mask); err != nil {
+			return fmt.Errorf("-umask: %v", err)
+		}
+	}
+	return nil
+}
+
+// createFile creates or truncates a local file with -file-mode permissions,
+// less the umask
+func createFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode)
+}
+
+// writeFile writes a local file with -file-mode permissions, less the umask
+func writeFile(path string, data []byte) error {
+	return os.WriteFile(path, data, fileMode)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]os.FileMode{"0640": 0640, "600": 0600, "0": 0} {
+		if got, err := parseMode(s); err != nil || got != want {
+			t.Errorf("parseMode(%q) = %o, %v, want %o", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "rw-r-----", "0999", "01777"} {
+		if _, err := parseMode(s); err == nil {
+			t.Errorf("parseMode(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestFileModes(t *testing.T) {
+	defer func(prevFile, prevToken os.FileMode) { fileMode, tokenMode = prevFile, prevToken }(fileMode, tokenMode)
+	fileMode, tokenMode = 0600, 0400
+	dir := t.TempDir()
+
+	catalog := filepath.Join(dir, "descriptions.csv")
+	if err := writeFile(catalog, []byte("name\n")); err != nil {
+		t.Fatal(err)
+	}
+	table, err := readCatalog(catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.write(catalog); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(catalog); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("catalog mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// an existing token file is narrowed to -token-mode
+	token := filepath.Join(dir, "token.json")
+	if err := os.WriteFile(token, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveToken(token, &oauth2.Token{AccessToken: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(token); err != nil || info.Mode().Perm() != 0400 {
+		t.Errorf("token mode = %v, %v, want 0400", info.Mode().Perm(), err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// actions on files Gemini finds personal information in
+const (
+	piiFlag     = "flag"     // record the categories found
+	piiSkip     = "skip"     // don't upload the file
+	piiRestrict = "restrict" // upload the file to -pii-bucket
+	piiRedact   = "redact"   // redact the personal information from the description
+)
+
+var piiActions = []string{piiFlag, piiSkip, piiRestrict, piiRedact}
+
+// piiAction is what is done with files containing personal information,
+// empty to not look for it
+var piiAction string
+
+// piiBucket is the restricted bucket files with personal information are
+// uploaded to with -pii restrict
+var piiBucket string
+
+// piiCategories are the kinds of personal information looked for
+var piiCategories = []string{"face", "id_document", "document", "contact", "financial", "health", "license_plate", "signature"}
+
+// stagePII is the stats category of personal information detection
+const stagePII = "pii"
+
+// piiKey is the object metadata key of the personal information found
+const piiKey = "pii"
+
+// piiFunction is the function Gemini calls with the personal information found
+const piiFunction = "report_personal_information"
+
+// piiPrompt asks for the personal information in the file described
+const piiPrompt = "Report any personal information in this file and its description by calling " + piiFunction + "."
+
+// piiFinding is the personal information found in a file and its
+// description with that information redacted
+type piiFinding struct {
+	Categories []string
+	Redacted   string
+}
+
+// piiFindings holds the personal information found in this run's files, by
+// Drive file ID
+var piiFindings sync.Map
+
+// piiError is returned for files skipped for containing personal information
+type piiError struct {
+	categories []string
+}
+
+func (e *piiError) Error() string {
+	return fmt.Sprintf("personal information: %s", strings.Join(e.categories, ", "))
+}
+
+// piiFound returns the categories of personal information found in a file
+func piiFound(file drive.File) []string {
+	if f, ok := piiFindings.Load(file.Id); ok {
+		return f.(piiFinding).Categories
+	}
+	return nil
+}
+
+// piiDeclaration declares the function Gemini calls with the personal
+// information found
+func piiDeclaration() *genai.FunctionDeclaration {
+	return &genai.FunctionDeclaration{
+		Name:        piiFunction,
+		Description: "Reports the personal information in the file described, such as faces, identity documents, or names, addresses and numbers that identify a person.",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"categories": {
+					Type:        genai.TypeArray,
+					Items:       &genai.Schema{Type: genai.TypeString, Enum: piiCategories},
+					Description: "the kinds of personal information in the file, empty if there is none",
+				},
+				"redacted_description": {
+					Type:        genai.TypeString,
+					Description: "the description with names, numbers, addresses and other details identifying a person replaced by [REDACTED], unchanged if there are none",
+				},
+			},
+			Required: []string{"categories", "redacted_description"},
+		},
+	}
+}
+
+// detectPII asks Gemini, in the conversation that described the file, to
+// call piiFunction with the personal information in it. Invalid arguments
+// are sent back as the function's error for Gemini to correct, up to
+// tagAttempts times.
+func detectPII(ctx context.Context, file drive.File, model string, contents []*genai.Content, config *genai.GenerateContentConfig, description string) (piiFinding, error) {
+	piiConfig := *config
+	piiConfig.Tools = []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{piiDeclaration()}}}
+	piiConfig.ToolConfig = &genai.ToolConfig{FunctionCallingConfig: &genai.FunctionCallingConfig{
+		Mode:                 genai.FunctionCallingConfigModeAny,
+		AllowedFunctionNames: []string{piiFunction},
+	}}
+	contents = append(contents, genai.NewModelContentFromText(description))
+	contents = append(contents, genai.Text(piiPrompt)...)
+
+	var err error
+	for range tagAttempts {
+		start := time.Now()
+		var resp *genai.GenerateContentResponse
+		err = withQuotaRetry(ctx, func() (err error) {
+			resp, err = genaiClient.GenerateContent(ctx, model, contents, &piiConfig)
+			return err
+		})
+		observeFile(file.Id, stagePII, start)
+		if err != nil {
+			return piiFinding{}, fmt.Errorf("unable to detect personal information: %w", err)
+		}
+		stats.addUsage(resp.UsageMetadata)
+		var call *genai.FunctionCall
+		for _, c := range resp.FunctionCalls() {
+			if c.Name == piiFunction {
+				call = c
+				break
+			}
+		}
+		if call == nil {
+			return piiFinding{}, fmt.Errorf("model did not call %s", piiFunction)
+		}
+		var finding piiFinding
+		if finding, err = parsePIIFinding(call.Args); err == nil {
+			return finding, nil
+		}
+		log.Printf("%s: invalid personal information report, asking for a correction: %v", file.Name, err)
+		contents = append(contents,
+			genai.NewModelContentFromParts([]*genai.Part{{FunctionCall: call}}),
+			genai.NewUserContentFromParts([]*genai.Part{genai.NewPartFromFunctionResponse(piiFunction, map[string]any{"error": err.Error()})}),
+		)
+	}
+	return piiFinding{}, err
+}
+
+// parsePIIFinding validates the arguments of a piiFunction call, requiring
+// known categories and a redacted description when any are found
+func parsePIIFinding(args map[string]any) (piiFinding, error) {
+	var finding piiFinding
+	raw, _ := args["categories"].([]any)
+	for _, c := range raw {
+		s, _ := c.(string)
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !slices.Contains(piiCategories, s) {
+			return piiFinding{}, fmt.Errorf("category %q must be one of %s", s, strings.Join(piiCategories, ", "))
+		}
+		if !slices.Contains(finding.Categories, s) {
+			finding.Categories = append(finding.Categories, s)
+		}
+	}
+	finding.Redacted, _ = args["redacted_description"].(string)
+	finding.Redacted = strings.TrimSpace(finding.Redacted)
+	if len(finding.Categories) > 0 && finding.Redacted == "" {
+		return piiFinding{}, fmt.Errorf("redacted_description must not be empty")
+	}
+	return finding, nil
+}
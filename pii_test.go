@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestPIIActions(t *testing.T) {
+	found := map[string]any{"categories": []any{"face", "Contact"}, "redacted_description": "A person named [REDACTED]"}
+	for _, tt := range []struct {
+		action      string
+		description string
+		object      string
+	}{
+		{piiFlag, "A person named Ada", "test-bucket/a.jpg"},
+		{piiRedact, "A person named [REDACTED]", "test-bucket/a.jpg"},
+		{piiRestrict, "A person named Ada", "restricted-bucket/a.jpg"},
+		{piiSkip, "Error: personal information: face, contact", ""},
+	} {
+		t.Run(tt.action, func(t *testing.T) {
+			f := useFakes(t)
+			prevAction, prevBucket := piiAction, piiBucket
+			t.Cleanup(func() { piiAction, piiBucket = prevAction, prevBucket })
+			piiAction, piiBucket = tt.action, "restricted-bucket"
+			f.generator.response = "A person named Ada"
+			f.generator.functionArgs = []map[string]any{found}
+			f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+
+			r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+			if r.Description != tt.description || !slices.Equal(r.PII, []string{"face", "contact"}) {
+				t.Errorf("record = %q %v", r.Description, r.PII)
+			}
+			for key := range f.storage.objects {
+				if key != tt.object {
+					t.Errorf("uploaded %s, want only %q", key, tt.object)
+				}
+			}
+			if tt.object != "" && f.storage.attrs[tt.object].Metadata[piiKey] != "face,contact" {
+				t.Errorf("metadata = %v", f.storage.attrs[tt.object].Metadata)
+			}
+		})
+	}
+}
+
+func TestPIINoneFound(t *testing.T) {
+	f := useFakes(t)
+	prev := piiAction
+	t.Cleanup(func() { piiAction = prev })
+	piiAction = piiSkip
+	f.generator.functionArgs = []map[string]any{
+		{"categories": []any{"fingerprint"}, "redacted_description": "x"}, // corrected
+		{"categories": []any{}, "redacted_description": "A landscape"},
+	}
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+
+	r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+	if r.Description != f.generator.response || r.PII != nil || r.NeedsReview {
+		t.Errorf("record = %+v", r)
+	}
+	if f.generator.calls != 3 {
+		t.Errorf("%d calls, want the description and 2 reports", f.generator.calls)
+	}
+	if _, ok := f.storage.attrs["test-bucket/a.jpg"].Metadata[piiKey]; ok {
+		t.Error("pii metadata set without personal information")
+	}
+}
+
+func TestParsePIIFinding(t *testing.T) {
+	if _, err := parsePIIFinding(map[string]any{"categories": []any{"face"}}); err == nil {
+		t.Error("accepted personal information without a redacted description")
+	}
+	if _, err := parsePIIFinding(map[string]any{"categories": []any{"tattoo"}, "redacted_description": "x"}); err == nil {
+		t.Error("accepted an unknown category")
+	}
+}
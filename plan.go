@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// runPlan is what a run is about to do, logged before it starts so the
+// configuration can be checked, and kept in the run summary so an audit can
+// tell what was run
+type runPlan struct {
+	RunID string `json:"run_id"`
+	// Source is where the files are read from: a Drive folder, a manifest
+	// or stdin
+	Source    string      `json:"source"`
+	Recursive bool        `json:"recursive,omitempty"`
+	Public    bool        `json:"public_folder,omitempty"`
+	Account   string      `json:"account,omitempty"`
+	Filters   planFilters `json:"filters"`
+	// Destinations are the gs:// folders files are uploaded to, with the
+	// files and bytes planned for each when the files were listed first
+	Destinations []planDestination `json:"destinations,omitempty"`
+	// Describe, Backend, Model and Prompt are how files are described, unless
+	// a config rule says otherwise; Prompt is the template name and a hash
+	// of its text, and of the system instruction
+	Describe  bool     `json:"describe"`
+	Backend   string   `json:"backend,omitempty"`
+	Model     string   `json:"model,omitempty"`
+	Prompt    string   `json:"prompt,omitempty"`
+	Locations []string `json:"locations,omitempty"`
+	// Files and Bytes are the estimated files and bytes to process, nil for
+	// files read from stdin as they arrive
+	Files *int  `json:"files,omitempty"`
+	Bytes int64 `json:"bytes,omitempty"`
+	// Cost is the estimated Gemini cost in US dollars
+	Cost float64 `json:"estimated_cost,omitempty"`
+}
+
+// planFilters are the filters selecting the files a run processes
+type planFilters struct {
+	MimeTypes []string `json:"mime_types"`
+	Ignore    string   `json:"ignore,omitempty"`
+	Shard     string   `json:"shard,omitempty"`
+	Max       int      `json:"max,omitempty"`
+	MaxBytes  int64    `json:"max_bytes,omitempty"`
+	Rules     int      `json:"rules,omitempty"`
+}
+
+// planDestination is a gs:// folder files are uploaded to
+type planDestination struct {
+	URI   string `json:"uri"`
+	Files int    `json:"files,omitempty"`
+	Bytes int64  `json:"bytes,omitempty"`
+}
+
+// currentPlan is the plan of the run, once it is known
+var currentPlan *runPlan
+
+// newRunPlan returns the plan of a run of the listed files, or, if nil, of a
+// run whose files aren't known in advance, with -stdin or -partition, and
+// aren't estimated
+func newRunPlan(files []drive.File) *runPlan {
+	p := &runPlan{
+		RunID:     runID,
+		Recursive: recursive,
+		Public:    publicDrive,
+		Account:   driveAccount,
+		Filters: planFilters{
+			MimeTypes: mimeTypes,
+			Shard:     shardSpec,
+			Max:       maxFiles,
+			MaxBytes:  maxBytes,
+			Rules:     len(cfg.Rules),
+		},
+		Describe:  describesFiles(),
+		Locations: locations,
+	}
+	switch {
+	case readStdin:
+		p.Source = "stdin"
+	case manifestFile != "":
+		p.Source = "manifest " + manifestFile
+	default:
+		p.Source = "drive folder " + sourceFolderID
+	}
+	if partitionBy != "" {
+		p.Source += fmt.Sprintf(", by %s of modified time since %s", partitionBy, partitionSince)
+	}
+	if len(ignorePatterns) > 0 {
+		p.Filters.Ignore = ignoreFile
+	}
+	if p.Describe {
+		p.Backend, p.Model, p.Prompt = backend, model, promptID(customPromptLocation)
+	}
+	if files == nil {
+		if !describeOnly {
+			p.Destinations = []planDestination{{URI: destinationURI(destination{Bucket: gcsBucket, Prefix: gcsFolderPath})}}
+		}
+		return p
+	}
+
+	planned := plannedFiles(files)
+	n := len(planned)
+	p.Files = &n
+	byURI := map[string]*planDestination{}
+	for _, file := range planned {
+		p.Bytes += file.Size
+		if describeOnly {
+			continue
+		}
+		uri := destinationURI(routeFor(file))
+		d, ok := byURI[uri]
+		if !ok {
+			d = &planDestination{URI: uri}
+			byURI[uri] = d
+		}
+		d.Files++
+		d.Bytes += file.Size
+	}
+	for _, uri := range sortedKeys(byURI) {
+		p.Destinations = append(p.Destinations, *byURI[uri])
+	}
+	if p.Describe {
+		p.Cost, _ = estimateCost(planned)
+	}
+	return p
+}
+
+// plannedFiles returns the listed files a run would start, as processFiles
+// picks them: not ignored or skipped by a rule, in the shard, and within -max
+// and -max-bytes
+func plannedFiles(files []drive.File) []drive.File {
+	var planned []drive.File
+	var started int64
+	for _, file := range files {
+		if maxFiles > 0 && len(planned) >= maxFiles {
+			break
+		}
+		if _, ok := ignored(file); ok || !inShard(file) {
+			continue
+		}
+		if r := ruleFor(file); r != nil && r.Skip {
+			continue
+		}
+		if maxBytes > 0 && started >= maxBytes {
+			break
+		}
+		started += file.Size
+		planned = append(planned, file)
+	}
+	return planned
+}
+
+// destinationURI returns the gs:// URI of a destination's folder
+func destinationURI(d destination) string {
+	return "gs://" + strings.TrimSuffix(objectPath(d.Bucket, d.Prefix), "/") + "/"
+}
+
+// print logs a human readable version of the plan
+func (p *runPlan) print() {
+	log.Printf("Run plan %s", p.RunID)
+	source := p.Source
+	if p.Recursive {
+		source += ", recursive"
+	}
+	if p.Public {
+		source += ", public"
+	}
+	if p.Account != "" {
+		source += ", as " + p.Account
+	}
+	log.Printf("  source: %s", source)
+	f := p.Filters
+	filters := []string{"mime-types " + strings.Join(f.MimeTypes, ",")}
+	if f.Ignore != "" {
+		filters = append(filters, "ignore "+f.Ignore)
+	}
+	if f.Shard != "" {
+		filters = append(filters, "shard "+f.Shard)
+	}
+	if f.Max > 0 {
+		filters = append(filters, fmt.Sprintf("max %d", f.Max))
+	}
+	if f.MaxBytes > 0 {
+		filters = append(filters, fmt.Sprintf("max-bytes %d", f.MaxBytes))
+	}
+	if f.Rules > 0 {
+		filters = append(filters, fmt.Sprintf("%d config rules", f.Rules))
+	}
+	log.Printf("  filters: %s", strings.Join(filters, ", "))
+	if len(p.Destinations) == 0 {
+		log.Println("  destination: none, catalog only")
+	}
+	for _, d := range p.Destinations {
+		if p.Files != nil {
+			log.Printf("  destination: %s, %d files, %d bytes", d.URI, d.Files, d.Bytes)
+		} else {
+			log.Printf("  destination: %s", d.URI)
+		}
+	}
+	if p.Describe {
+		log.Printf("  describe: %s %s in %s, prompt %s", p.Backend, p.Model, strings.Join(p.Locations, ","), p.Prompt)
+	} else {
+		log.Println("  describe: no, transfer only")
+	}
+	if p.Files != nil {
+		log.Printf("  estimated: %d files, %d bytes, $%.2f of Gemini usage", *p.Files, p.Bytes, p.Cost)
+	} else {
+		log.Println("  estimated: unknown, files are listed as the run goes")
+	}
+}
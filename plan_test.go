@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewRunPlan(t *testing.T) {
+	f := useFakes(t)
+	prevCfg, prevIgnore := cfg, ignorePatterns
+	defer func() { cfg, ignorePatterns = prevCfg, prevIgnore }()
+	cfg = config{Routes: []route{{FolderID: "cdn", Bucket: "cdn-bucket", Prefix: "/"}}}
+	ignorePatterns = []string{"*.psd"}
+	sourceFolderID = "root"
+	gcsFolderPath = "media"
+	f.drive.add("cdn", "Web", folderMimeType, "root", nil)
+	f.drive.add("1", "a.jpg", "image/jpeg", "root", []byte("aa"))
+	f.drive.add("2", "b.psd", "image/png", "root", []byte("b"))
+	f.drive.add("3", "c.jpg", "image/jpeg", "cdn", []byte("ccc"))
+	f.drive.add("4", "d.jpg", "image/jpeg", "root", []byte("d"))
+	f.drive.add("5", "e.jpg", "image/jpeg", "root", []byte("e"))
+
+	files, err := listFilesRecursive(context.Background(), "root", []string{"image/jpeg", "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newRunPlan(files)
+	if p.Files == nil || *p.Files != 4 {
+		t.Fatalf("files = %v, want 4, without the ignored one", p.Files)
+	}
+	if p.Source != "drive folder root" || !p.Describe || !strings.HasPrefix(p.Prompt, builtinPrompt+"@") {
+		t.Errorf("plan = %+v", p)
+	}
+	var uris []string
+	var planned int
+	for _, d := range p.Destinations {
+		uris = append(uris, d.URI)
+		planned += d.Files
+	}
+	if strings.Join(uris, " ") != "gs://cdn-bucket/ gs://test-bucket/media/" || planned != 4 {
+		t.Errorf("destinations = %+v", p.Destinations)
+	}
+
+	// the plan is kept in the run summary
+	b, err := json.Marshal(runSummary{Plan: p})
+	if err != nil || !strings.Contains(string(b), `"plan":{"run_id"`) {
+		t.Errorf("summary = %s, %v", b, err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// projectFlag and locationFlag are the -project and -location; empty for the
+// PROJECT_ID and LOCATION environment variables
+var projectFlag, locationFlag string
+
+// projectIDPattern matches a Cloud project ID, optionally domain scoped as
+// example.com:project
+var projectIDPattern = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// locationPattern matches a Google Cloud region or multi-region, such as
+// us-central1, europe-west4, us or global
+var locationPattern = regexp.MustCompile(`^[a-z]+[0-9]*(-[a-z]+[0-9]*)*$`)
+
+// resolveProject sets the project and Vertex AI locations from -project and
+// -location, or else PROJECT_ID and LOCATION, checking they are well formed so
+// a typo fails the run before any client is created rather than at the first
+// request
+func resolveProject() error {
+	projectID = cmp.Or(projectFlag, os.Getenv("PROJECT_ID"))
+	if projectID != "" && !projectIDPattern.MatchString(projectID) {
+		return fmt.Errorf("invalid project ID %q from %s, expected 6 to 30 lowercase letters, digits and hyphens, such as my-project-123", projectID, projectSource())
+	}
+	locations = parseLocations(cmp.Or(locationFlag, os.Getenv("LOCATION")))
+	for _, l := range locations {
+		if !locationPattern.MatchString(l) {
+			return fmt.Errorf("invalid location %q from %s, expected a region such as us-central1, or comma-separated regions", l, locationSource())
+		}
+	}
+	location = locations[0]
+	return nil
+}
+
+// projectSource names where the project ID was set, for errors
+func projectSource() string {
+	if projectFlag != "" {
+		return "-project"
+	}
+	return "PROJECT_ID"
+}
+
+// locationSource names where the locations were set, for errors
+func locationSource() string {
+	if locationFlag != "" {
+		return "-location"
+	}
+	return "LOCATION"
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveProject(t *testing.T) {
+	defer func(p, l string, ls []string) {
+		projectFlag, locationFlag, projectID, location, locations = "", "", p, l, ls
+	}(projectID, location, locations)
+	t.Setenv("PROJECT_ID", "env-project")
+	t.Setenv("LOCATION", "us-east4,europe-west4")
+
+	if err := resolveProject(); err != nil || projectID != "env-project" || location != "us-east4" || !slices.Equal(locations, []string{"us-east4", "europe-west4"}) {
+		t.Errorf("resolveProject from the environment = %v: %s %v", err, projectID, locations)
+	}
+
+	projectFlag, locationFlag = "example.com:flag-project", "global"
+	if err := resolveProject(); err != nil || projectID != "example.com:flag-project" || location != "global" {
+		t.Errorf("resolveProject from the flags = %v: %s %s", err, projectID, location)
+	}
+
+	projectFlag = "My Project"
+	if err := resolveProject(); err == nil || !strings.Contains(err.Error(), "-project") {
+		t.Errorf("resolveProject(%q) = %v, want an invalid -project error", projectFlag, err)
+	}
+	projectFlag, locationFlag = "", ""
+	t.Setenv("LOCATION", "us-central1,US Central")
+	if err := resolveProject(); err == nil || !strings.Contains(err.Error(), "LOCATION") {
+		t.Errorf("resolveProject with LOCATION %q = %v, want an invalid LOCATION error", "US Central", err)
+	}
+}
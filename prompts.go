@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// promptData is the data prompt templates are executed with
+type promptData struct {
+	ImageName    string
+	MimeType     string
+	Size         int64
+	FolderName   string
+	RelativePath string
+	CreatedTime  string
+	ModifiedTime string
+	// Frames and Duration are the frame count and length of an animated
+	// GIF or PNG, 0 and empty for other files
+	Frames   int
+	Duration string
+	// Labels are the file's labels from the config label rules, e.g.
+	// {{.Labels.campaign}}, empty if the file doesn't have the label
+	Labels map[string]string
+	// Language and Languages are the main and all languages spoken in an
+	// audio or video file, with -speech-language, such as en or pt-BR
+	Language  string
+	Languages []string
+}
+
+// samplePromptData is the data prompt templates are checked with
+var samplePromptData = promptData{
+	ImageName:    "sample.jpg",
+	MimeType:     "image/jpeg",
+	Size:         1 << 20,
+	FolderName:   "Samples",
+	RelativePath: "Samples",
+	CreatedTime:  "2024-06-01T09:30:00Z",
+	ModifiedTime: "2024-06-02T17:45:00Z",
+	Labels:       map[string]string{"campaign": "spring2025"},
+}
+
+// newPromptData returns the prompt data of a file
+func newPromptData(file drive.File) promptData {
+	d := promptData{
+		ImageName:    file.Name,
+		MimeType:     file.MimeType,
+		Size:         file.Size,
+		FolderName:   getFolderName(fileFolderID(file)),
+		RelativePath: relativePath(file),
+		CreatedTime:  file.CreatedTime,
+		ModifiedTime: file.ModifiedTime,
+		Labels:       labelsFor(file),
+	}
+	if info, ok := animations.Load(file.Id); ok {
+		d.Frames, d.Duration = info.(animationInfo).Frames, info.(animationInfo).duration().String()
+	}
+	if langs := spokenLanguages(file); len(langs) > 0 {
+		d.Language, d.Languages = langs[0], langs
+	}
+	return d
+}
+
+// promptFuncs are the functions available to prompt templates
+var promptFuncs = template.FuncMap{
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"trimExt":  func(name string) string { return strings.TrimSuffix(name, path.Ext(name)) },
+	"ext":      path.Ext,
+	"date":     formatDate,
+	"json":     toJSON,
+	"truncate": truncate,
+}
+
+// formatDate formats an RFC 3339 time, such as .ModifiedTime, with a Go
+// layout, e.g. {{date "January 2006" .ModifiedTime}}; an empty time is empty
+func formatDate(layout, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", fmt.Errorf("date: %v", err)
+	}
+	return t.Format(layout), nil
+}
+
+// toJSON encodes a value as JSON, e.g. {{json .}} for all the file's fields
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// truncate shortens a string to at most n characters, e.g.
+// {{.ImageName | truncate 40}}
+func truncate(n int, s string) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:max(n, 0)])
+	}
+	return s
+}
+
+// parsePrompt parses a prompt template, or the built in prompt if empty
+func parsePrompt(promptPath string) (*template.Template, error) {
+	if promptPath == "" {
+		return template.Must(
+			template.New(builtinPrompt).Option("missingkey=zero").Funcs(promptFuncs).ParseFS(promptTemplates, "prompts/"+builtinPrompt),
+		), nil
+	}
+	tmpl, err := template.New(filepath.Base(promptPath)).Option("missingkey=zero").Funcs(promptFuncs).ParseFiles(promptPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse custom template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderPrompt executes a prompt template, or the built in prompt if empty,
+// for a file
+func renderPrompt(file drive.File, promptPath string) (string, error) {
+	tmpl, err := parsePrompt(promptPath)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, newPromptData(file)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validatePrompts checks the -prompt and config rule prompt templates parse
+// and execute to a prompt with sample data, so a bad template fails the run
+// before any file is processed
+func validatePrompts() error {
+	if err := validatePrompt(customPromptLocation); err != nil {
+		return fmt.Errorf("-prompt %s: %v", customPromptLocation, err)
+	}
+	for i, r := range cfg.Rules {
+		if r.Prompt == "" {
+			continue
+		}
+		if err := validatePrompt(r.Prompt); err != nil {
+			return fmt.Errorf("config rule %d prompt %s: %v", i+1, r.Prompt, err)
+		}
+	}
+	return nil
+}
+
+// validatePrompt checks a prompt template, unless empty
+func validatePrompt(promptPath string) error {
+	if promptPath == "" {
+		return nil
+	}
+	tmpl, err := parsePrompt(promptPath)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, samplePromptData); err != nil {
+		return fmt.Errorf("%v; prompts can use %s and the functions %s", err, promptFields(), strings.Join(sortedKeys(promptFuncs), ", "))
+	}
+	if strings.TrimSpace(buf.String()) == "" {
+		return fmt.Errorf("the prompt is empty")
+	}
+	return nil
+}
+
+// promptFields lists the fields of promptData, e.g. {{.ImageName}}
+func promptFields() string {
+	t := reflect.TypeFor[promptData]()
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		fields[i] = "{{." + t.Field(i).Name + "}}"
+	}
+	return strings.Join(fields, ", ")
+}
+
+// systemInstruction is guidance, such as brand and tone, given to Gemini as
+// the system instruction for every description, separate from the prompt
+var systemInstruction string
+
+// systemInstructionFile is a file holding the system instruction
+var systemInstructionFile string
+
+// loadSystemInstruction reads -system-instruction-file into systemInstruction
+func loadSystemInstruction() error {
+	if systemInstructionFile == "" {
+		return nil
+	}
+	if systemInstruction != "" {
+		return fmt.Errorf("set only one of -system-instruction and -system-instruction-file")
+	}
+	b, err := os.ReadFile(systemInstructionFile)
+	if err != nil {
+		return fmt.Errorf("unable to read system instruction: %v", err)
+	}
+	systemInstruction = strings.TrimSpace(string(b))
+	if systemInstruction == "" {
+		return fmt.Errorf("system instruction %s is empty", systemInstructionFile)
+	}
+	return nil
+}
+
+// generateConfig returns the Gemini request configuration for a description,
+// with the system instruction if there is one, and Google Search with
+// -search-grounding
+func generateConfig() *genai.GenerateContentConfig {
+	config := &genai.GenerateContentConfig{}
+	if systemInstruction != "" {
+		config.SystemInstruction = &genai.Content{Parts: []*genai.Part{genai.NewPartFromText(systemInstruction)}}
+	}
+	if searchGrounding {
+		config.Tools = []*genai.Tool{{GoogleSearch: &genai.GoogleSearch{}}}
+	}
+	return config
+}
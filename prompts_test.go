@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestValidatePrompts(t *testing.T) {
+	defer func(prevPrompt string, prevCfg config) { customPromptLocation, cfg = prevPrompt, prevCfg }(customPromptLocation, cfg)
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	good := write("good.tpl", "Describe {{.ImageName}}")
+
+	tests := []struct {
+		prompt, rulePrompt string
+		want               string
+	}{
+		{good, "", ""},
+		{"", good, ""},
+		{write("unparsed.tpl", "Describe {{.ImageName"), "", "-prompt " + dir + "/unparsed.tpl: failed to parse"},
+		{write("unknown.tpl", "Describe {{.FileName}}"), "", "can't evaluate field FileName"},
+		{"", write("empty.tpl", "{{/* nothing */}}\n"), "config rule 1 prompt " + dir + "/empty.tpl: the prompt is empty"},
+		{filepath.Join(dir, "missing.tpl"), "", "no such file"},
+	}
+	for _, tt := range tests {
+		customPromptLocation = tt.prompt
+		cfg = config{Rules: []rule{{MimeType: "image/*", Prompt: tt.rulePrompt}}}
+		err := validatePrompts()
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("validatePrompts(%q, %q) = %v, want %q", tt.prompt, tt.rulePrompt, err, tt.want)
+		}
+	}
+	if err := validatePrompt(write("fields.tpl", "{{.Nope}}")); err == nil || !strings.Contains(err.Error(), "prompts can use {{.ImageName}}") {
+		t.Errorf("validatePrompt error = %v, want the fields listed", err)
+	}
+}
+
+func TestPromptFuncs(t *testing.T) {
+	useFakes(t)
+	p := filepath.Join(t.TempDir(), "funcs.tpl")
+	contents := `{{trimExt .ImageName | upper}} {{ext .ImageName}} {{lower .MimeType}} {{date "January 2006" .ModifiedTime}} {{.ImageName | truncate 6}} {{json .RelativePath}}`
+	if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePrompt(p); err != nil {
+		t.Fatalf("validatePrompt: %v", err)
+	}
+	file := drive.File{Id: "1", Name: "Tom & Jerry's.JPG", MimeType: "IMAGE/JPEG", ModifiedTime: "2024-06-02T17:45:00Z"}
+	got, err := renderPrompt(file, p)
+	if want := `TOM & JERRY'S .JPG image/jpeg June 2024 Tom &  ""`; err != nil || got != want {
+		t.Errorf("renderPrompt = %q, %v, want %q", got, err, want)
+	}
+
+	if _, err := formatDate("2006", "yesterday"); err == nil {
+		t.Error("formatDate(yesterday) succeeded")
+	}
+	if got := truncate(3, "héllo"); got != "hél" {
+		t.Errorf("truncate = %q", got)
+	}
+}
+
+func TestSystemInstruction(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev, prevFile string) { systemInstruction, systemInstructionFile = prev, prevFile }(systemInstruction, systemInstructionFile)
+	file := drive.File{Id: "1", Name: "logo.png", MimeType: "image/png"}
+	before := promptID("")
+	if _, err := generateDescription(context.Background(), file, []byte("png"), describeSettingsFor(file)); err != nil {
+		t.Fatalf("generateDescription: %v", err)
+	}
+	if f.generator.config.SystemInstruction != nil {
+		t.Errorf("SystemInstruction = %+v, want none", f.generator.config.SystemInstruction)
+	}
+
+	p := filepath.Join(t.TempDir(), "brand.txt")
+	os.WriteFile(p, []byte("Write in a warm, plain voice.\n"), 0644)
+	systemInstruction, systemInstructionFile = "", p
+	if err := loadSystemInstruction(); err != nil || systemInstruction != "Write in a warm, plain voice." {
+		t.Fatalf("loadSystemInstruction = %q, %v", systemInstruction, err)
+	}
+	if _, err := generateDescription(context.Background(), file, []byte("png"), describeSettingsFor(file)); err != nil {
+		t.Fatalf("generateDescription: %v", err)
+	}
+	si := f.generator.config.SystemInstruction
+	if si == nil || len(si.Parts) != 1 || si.Parts[0].Text != systemInstruction {
+		t.Errorf("SystemInstruction = %+v, want %q", si, systemInstruction)
+	}
+	for _, c := range f.generator.contents {
+		for _, part := range c.Parts {
+			if strings.Contains(part.Text, "warm, plain voice") {
+				t.Error("system instruction sent in the prompt")
+			}
+		}
+	}
+	// a changed system instruction changes the prompt ID
+	if id := promptID(""); !strings.HasPrefix(id, before+"+system@") {
+		t.Errorf("promptID = %q, want %q with the system instruction hash", id, before)
+	}
+
+	if err := loadSystemInstruction(); err == nil {
+		t.Error("loadSystemInstruction with both set succeeded")
+	}
+	os.WriteFile(p, []byte("\n"), 0644)
+	systemInstruction = ""
+	if err := loadSystemInstruction(); err == nil {
+		t.Error("loadSystemInstruction of an empty file succeeded")
+	}
+}
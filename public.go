@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// publicDrive reads a publicly shared Drive folder with an API key rather
+// than OAuth, so public datasets are migrated without a consent flow
+var publicDrive bool
+
+// driveAPIKey returns the API key public folders are read with, from
+// DRIVE_API_KEY, or GOOGLE_API_KEY
+func driveAPIKey() string {
+	if key := os.Getenv("DRIVE_API_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("GOOGLE_API_KEY")
+}
+
+// checkPublicFlags returns an error if a flag needing an authorized user is
+// set with -public-folder: an API key reads only the files anyone with the
+// link can view, not revisions, comments or the user
+func checkPublicFlags() error {
+	switch {
+	case !publicDrive:
+		return nil
+	case driveAPIKey() == "":
+		return errors.New("-public-folder requires DRIVE_API_KEY, an API key of the project with the Drive API enabled")
+	case driveAccount != "" || driveCredentials != "":
+		return errors.New("-public-folder reads Drive with an API key, not -account or -drive-credentials")
+	case migrateRevisions:
+		return errors.New("-revisions needs OAuth, Drive revisions aren't readable with an API key")
+	case exportComments:
+		return errors.New("-export-comments needs OAuth, Drive comments aren't readable with an API key")
+	}
+	return nil
+}
+
+// newPublicDrive returns a Drive client reading public files with the API key
+func newPublicDrive(ctx context.Context, opts ...option.ClientOption) (driveClient, error) {
+	srv, err := drive.NewService(ctx, append(append(driveOptions(), option.WithAPIKey(driveAPIKey())), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &driveService{srv: srv}, nil
+}
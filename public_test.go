@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+func TestCheckPublicFlags(t *testing.T) {
+	defer func(p, r, c bool, a string) {
+		publicDrive, migrateRevisions, exportComments, driveAccount = p, r, c, a
+	}(publicDrive, migrateRevisions, exportComments, driveAccount)
+	t.Setenv("DRIVE_API_KEY", "")
+	t.Setenv("GOOGLE_API_KEY", "")
+
+	publicDrive = false
+	if err := checkPublicFlags(); err != nil {
+		t.Errorf("without -public-folder: %v", err)
+	}
+	publicDrive = true
+	if err := checkPublicFlags(); err == nil || !strings.Contains(err.Error(), "DRIVE_API_KEY") {
+		t.Errorf("without a key = %v, want DRIVE_API_KEY required", err)
+	}
+	t.Setenv("GOOGLE_API_KEY", "google-key")
+	if err := checkPublicFlags(); err != nil {
+		t.Errorf("with GOOGLE_API_KEY: %v", err)
+	}
+	migrateRevisions = true
+	if err := checkPublicFlags(); err == nil {
+		t.Error("-public-folder accepted -revisions")
+	}
+	migrateRevisions, exportComments = false, true
+	if err := checkPublicFlags(); err == nil {
+		t.Error("-public-folder accepted -export-comments")
+	}
+	exportComments, driveAccount = false, "user@example.com"
+	if err := checkPublicFlags(); err == nil {
+		t.Error("-public-folder accepted -account")
+	}
+}
+
+func TestPublicDriveSendsAPIKey(t *testing.T) {
+	t.Setenv("DRIVE_API_KEY", "drive-key")
+	var key, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, auth = r.URL.Query().Get("key"), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"files":[{"id":"1","name":"public.jpg"}]}`))
+	}))
+	defer srv.Close()
+
+	d, err := newPublicDrive(context.Background(), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := d.List(context.Background(), "'folder' in parents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "public.jpg" {
+		t.Errorf("files = %v", files)
+	}
+	if key != "drive-key" || auth != "" {
+		t.Errorf("key = %q, Authorization = %q, want the API key and no OAuth", key, auth)
+	}
+}
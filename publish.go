@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// The flags of the publish command
+var (
+	sitePath string = "site"
+	siteDir  string
+)
+
+// publishFlags registers the publish command's flags
+func publishFlags() {
+	flag.StringVar(&searchCatalog, "catalog", searchCatalog, "the CSV catalog to publish, a local path or gs://bucket/object")
+	flag.StringVar(&sitePath, "site-path", sitePath, "the path in the bucket to upload the site to")
+	flag.StringVar(&siteDir, "site-dir", siteDir, "a local directory to write the site to instead of uploading it")
+}
+
+// runPublish runs drivetogcs publish, generating a static site of the
+// catalog, with index pages by folder and tag and a page per asset with its
+// description and a signed URL, and uploading it to -site-path of the bucket,
+// as an internal gallery of the migration. The signed URLs expire after
+// -signed-url-ttl, the longest allowed if unset, so the site must be
+// published again to keep its links working.
+func runPublish(ctx context.Context, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs publish [-catalog descriptions.csv] [-site-path site] [-site-dir dir] [-signed-url-ttl 168h]")
+		return exitFailure
+	}
+	bucket := gcsBucket
+	if bucket == "" && projectID != "" {
+		bucket = projectID + "-media"
+	}
+	if bucket == "" && siteDir == "" {
+		log.Printf("please provide the bucket to publish to with -gcs-bucket, or a project with -project or PROJECT_ID")
+		return exitFailure
+	}
+	opts, err := storageOptions(ctx)
+	if err != nil {
+		log.Printf("unable to read -storage-credentials: %v", err)
+		return exitFailure
+	}
+	gcsClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		log.Printf("unable to create storage client: %v", err)
+		return exitFailure
+	}
+	defer gcsClient.Close()
+	storageSrv = &gcsStorage{client: gcsClient}
+
+	catalog, err := loadCatalog(ctx, searchCatalog)
+	if err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	pages, err := buildSite(catalog, cmp.Or(signedURLTTL, maxSignedURLTTL))
+	if err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	if err := publishSite(ctx, pages, bucket); err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	if siteDir != "" {
+		log.Printf("wrote %d pages of %d assets to %s", len(pages), len(catalog.rows), filepath.Join(siteDir, "index.html"))
+	} else {
+		log.Printf("published %d pages of %d assets to %s", len(pages), len(catalog.rows), (record{Bucket: bucket, ObjectPath: path.Join(sitePath, "index.html")}).browserURL())
+	}
+	return exitSuccess
+}
+
+// publishSite writes the pages to -site-dir, if set, or else uploads them to
+// -site-path of the bucket, uncached, as their signed URLs expire
+func publishSite(ctx context.Context, pages map[string][]byte, bucket string) error {
+	for _, name := range slices.Sorted(maps.Keys(pages)) {
+		if siteDir != "" {
+			p := filepath.Join(siteDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(p, pages[name], 0644); err != nil {
+				return err
+			}
+			continue
+		}
+		attrs := storage.ObjectAttrs{ContentType: "text/html; charset=utf-8", CacheControl: "no-cache"}
+		if _, err := storageSrv.Upload(ctx, bucket, path.Join(sitePath, name), pages[name], attrs); err != nil {
+			return fmt.Errorf("unable to upload %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// siteAsset is a catalog entry on the site
+type siteAsset struct {
+	Name        string
+	Page        string
+	MimeType    string
+	Description string
+	URL         string
+	GCSURI      string
+	Folder      *siteIndex
+	Tags        []*siteIndex
+	Columns     [][2]string
+}
+
+// Image and Video report whether the asset can be shown inline
+func (a *siteAsset) Image() bool { return strings.HasPrefix(a.MimeType, "image/") }
+func (a *siteAsset) Video() bool { return strings.HasPrefix(a.MimeType, "video/") }
+
+// siteIndex is a page listing the assets of a folder or tag
+type siteIndex struct {
+	Name   string
+	Page   string
+	Assets []*siteAsset
+}
+
+// sitePages assigns unique page names, from slugs of names
+type sitePages map[string]bool
+
+func (p sitePages) add(dir, name string) string {
+	slug := strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name)), "-")
+	slug = cmp.Or(slug, "page")
+	page := dir + "/" + slug + ".html"
+	for i := 2; p[page]; i++ {
+		page = fmt.Sprintf("%s/%s-%d.html", dir, slug, i)
+	}
+	p[page] = true
+	return page
+}
+
+// buildSite renders the site of a catalog: index.html, listing the folders
+// and tags, a page per folder and tag in folders/ and tags/, and a page per
+// asset in assets/, linking to its object by a URL signed for ttl. The
+// folder of an entry is its folder_name or folder_id column, or else the
+// directory of its object.
+func buildSite(catalog *catalogTable, ttl time.Duration) (map[string][]byte, error) {
+	pages := sitePages{}
+	folders := map[string]*siteIndex{}
+	tags := map[string]*siteIndex{}
+	var assets []*siteAsset
+	for _, row := range catalog.rows {
+		m := newSearchMatch(catalog, row, 0)
+		a := &siteAsset{
+			Name:        m.Name,
+			Page:        pages.add("assets", cmp.Or(m.DriveID, m.Name)),
+			MimeType:    catalog.get(row, "mime_type"),
+			Description: m.Description,
+			GCSURI:      m.GCSURI,
+		}
+		if m.GCSURI != "" {
+			bucket, object, err := parseGCSURI(m.GCSURI)
+			if err != nil {
+				return nil, err
+			}
+			if a.URL, err = storageSrv.SignedURL(bucket, object, ttl); err != nil {
+				return nil, fmt.Errorf("unable to sign the URL of %s: %w", m.GCSURI, err)
+			}
+		}
+		for _, column := range catalog.header {
+			if v := catalog.get(row, column); v != "" && column != "description" && column != "signed_url" {
+				a.Columns = append(a.Columns, [2]string{column, v})
+			}
+		}
+
+		folder := cmp.Or(catalog.get(row, "folder_name"), catalog.get(row, "folder_id"))
+		if dir := path.Dir(catalog.get(row, "object_path")); folder == "" && dir != "." && dir != "/" {
+			folder = dir
+		}
+		folder = cmp.Or(folder, "Unfiled")
+		if folders[folder] == nil {
+			folders[folder] = &siteIndex{Name: folder, Page: pages.add("folders", folder)}
+		}
+		a.Folder = folders[folder]
+		a.Folder.Assets = append(a.Folder.Assets, a)
+		for _, tag := range strings.Split(catalog.get(row, "tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag == "" {
+				continue
+			}
+			key := strings.ToLower(tag)
+			if tags[key] == nil {
+				tags[key] = &siteIndex{Name: tag, Page: pages.add("tags", tag)}
+			}
+			if i := tags[key]; !slices.Contains(i.Assets, a) {
+				i.Assets = append(i.Assets, a)
+				a.Tags = append(a.Tags, i)
+			}
+		}
+		assets = append(assets, a)
+	}
+
+	sorted := func(indexes map[string]*siteIndex) []*siteIndex {
+		return slices.SortedFunc(maps.Values(indexes), func(a, b *siteIndex) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		})
+	}
+	site := map[string][]byte{}
+	now := time.Now().UTC()
+	render := func(page, tmpl string, data map[string]any) error {
+		data["Root"] = strings.Repeat("../", strings.Count(page, "/"))
+		data["Published"] = now.Format(time.RFC3339)
+		data["Expires"] = now.Add(ttl).Format(time.RFC3339)
+		var b bytes.Buffer
+		if err := siteTemplate.ExecuteTemplate(&b, tmpl, data); err != nil {
+			return fmt.Errorf("unable to render %s: %v", page, err)
+		}
+		site[page] = b.Bytes()
+		return nil
+	}
+	errs := []error{render("index.html", "index", map[string]any{
+		"Assets":  len(assets),
+		"Folders": sorted(folders),
+		"Tags":    sorted(tags),
+	})}
+	for _, i := range slices.Concat(sorted(folders), sorted(tags)) {
+		errs = append(errs, render(i.Page, "list", map[string]any{"Index": i}))
+	}
+	for _, a := range assets {
+		errs = append(errs, render(a.Page, "asset", map[string]any{"Asset": a}))
+	}
+	return site, errors.Join(errs...)
+}
+
+var siteTemplate = template.Must(template.New("site").Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 1em auto; max-width: 72em; padding: 0 1em; }
+a { color: #1a73e8; text-decoration: none; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(12em, 1fr)); gap: 1em; }
+.card { border: 1px solid #ddd; border-radius: 4px; padding: .5em; overflow: hidden; }
+.card img { width: 100%; height: 10em; object-fit: cover; }
+.card .name { overflow: hidden; white-space: nowrap; text-overflow: ellipsis; }
+.media img, .media video { max-width: 100%; max-height: 70vh; }
+.tag { display: inline-block; background: #eee; border-radius: 3px; padding: 0 .4em; margin-right: .3em; }
+table { border-collapse: collapse; } td { border-top: 1px solid #eee; padding: .2em .6em .2em 0; vertical-align: top; }
+footer { color: #777; margin-top: 2em; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "foot"}}<footer>Published {{.Published}}; the links expire {{.Expires}}.</footer>
+</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "head" "Assets"}}<h1>Assets</h1>
+<p>{{.Assets}} assets</p>
+<h2>Folders</h2>
+<ul>
+{{range .Folders}}<li><a href="{{$.Root}}{{.Page}}">{{.Name}}</a> ({{len .Assets}})</li>
+{{end}}</ul>
+{{if .Tags}}<h2>Tags</h2>
+<p>{{range .Tags}}<a class="tag" href="{{$.Root}}{{.Page}}">{{.Name}} ({{len .Assets}})</a> {{end}}</p>
+{{end}}{{template "foot" .}}{{end}}
+
+{{define "list"}}{{template "head" .Index.Name}}<p><a href="{{.Root}}index.html">Assets</a></p>
+<h1>{{.Index.Name}}</h1>
+<div class="grid">
+{{range .Index.Assets}}<a class="card" href="{{$.Root}}{{.Page}}">{{if and .Image .URL}}<img loading="lazy" src="{{.URL}}" alt="{{.Description}}">{{end}}<div class="name" title="{{.Name}}">{{.Name}}</div></a>
+{{end}}</div>
+{{template "foot" .}}{{end}}
+
+{{define "asset"}}{{template "head" .Asset.Name}}{{with .Asset}}<p><a href="{{$.Root}}index.html">Assets</a> › <a href="{{$.Root}}{{.Folder.Page}}">{{.Folder.Name}}</a></p>
+<h1>{{.Name}}</h1>
+{{if .URL}}<div class="media">{{if .Image}}<img src="{{.URL}}" alt="{{.Description}}">{{else if .Video}}<video controls preload="metadata" src="{{.URL}}"></video>{{end}}</div>
+<p><a href="{{.URL}}">Download</a>{{if .GCSURI}} · {{.GCSURI}}{{end}}</p>
+{{end}}<p>{{.Description}}</p>
+{{if .Tags}}<p>{{range .Tags}}<a class="tag" href="{{$.Root}}{{.Page}}">{{.Name}}</a> {{end}}</p>
+{{end}}<table>
+{{range .Columns}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>
+{{end}}</table>
+{{end}}{{template "foot" .}}{{end}}
+`))
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const publishTestCatalog = `name,mime_type,drive_id,folder_name,object_path,tags,description
+beach.jpg,image/jpeg,1,Summer,photos/beach.jpg,beach;Sunset,A sunset over a <b>sandy</b> beach.
+waves.mp4,video/mp4,2,Summer,videos/waves.mp4,Beach,Waves at dusk.
+notes.pdf,application/pdf,3,,docs/notes.pdf,,Meeting notes.
+`
+
+func TestBuildSite(t *testing.T) {
+	useFakes(t)
+	gcsBucket = "media"
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	if err := os.WriteFile(path, []byte(publishTestCatalog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := readCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site, err := buildSite(catalog, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"assets/1.html", "assets/2.html", "assets/3.html",
+		"folders/docs.html", "folders/summer.html",
+		"index.html",
+		"tags/beach.html", "tags/sunset.html",
+	}
+	if got := slices.Sorted(maps.Keys(site)); !slices.Equal(got, want) {
+		t.Fatalf("pages = %q, want %q", got, want)
+	}
+
+	for page, wants := range map[string][]string{
+		"index.html": {
+			"3 assets",
+			`<a href="folders/summer.html">Summer</a> (2)`,
+			`<a href="folders/docs.html">docs</a> (1)`,
+			`<a class="tag" href="tags/beach.html">beach (2)</a>`,
+		},
+		"tags/beach.html": {
+			`<a class="card" href="../assets/1.html"><img loading="lazy" src="https://signed.example.com/media/photos/beach.jpg?ttl=1h0m0s"`,
+			`<a class="card" href="../assets/2.html"><div class="name" title="waves.mp4">`,
+		},
+		"assets/1.html": {
+			`<a href="../folders/summer.html">Summer</a>`,
+			`<img src="https://signed.example.com/media/photos/beach.jpg?ttl=1h0m0s"`,
+			"gs://media/photos/beach.jpg",
+			"<p>A sunset over a &lt;b&gt;sandy&lt;/b&gt; beach.</p>",
+			`<a class="tag" href="../tags/sunset.html">Sunset</a>`,
+			"<td>mime_type</td><td>image/jpeg</td>",
+		},
+		"assets/2.html": {`<video controls preload="metadata" src="https://signed.example.com/media/videos/waves.mp4?ttl=1h0m0s">`},
+	} {
+		for _, w := range wants {
+			if !strings.Contains(string(site[page]), w) {
+				t.Errorf("%s missing %q:\n%s", page, w, site[page])
+			}
+		}
+	}
+}
+
+func TestPublishSite(t *testing.T) {
+	f := useFakes(t)
+	defer func(path, dir string) { sitePath, siteDir = path, dir }(sitePath, siteDir)
+	sitePath, siteDir = "gallery", ""
+	pages := map[string][]byte{"index.html": []byte("index"), "assets/1.html": []byte("asset")}
+	if err := publishSite(context.Background(), pages, "media"); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.storage.objects["media/gallery/assets/1.html"]) != "asset" {
+		t.Errorf("objects = %v", slices.Collect(maps.Keys(f.storage.objects)))
+	}
+	if attrs := f.storage.attrs["media/gallery/index.html"]; attrs.ContentType != "text/html; charset=utf-8" || attrs.CacheControl != "no-cache" {
+		t.Errorf("index.html attrs = %+v", attrs)
+	}
+
+	siteDir = t.TempDir()
+	uploads := f.storage.uploads
+	if err := publishSite(context.Background(), pages, "media"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(siteDir, "assets", "1.html")); err != nil || string(b) != "asset" {
+		t.Errorf("assets/1.html = %q, %v", b, err)
+	}
+	if f.storage.uploads != uploads {
+		t.Errorf("-site-dir uploaded %d objects", f.storage.uploads-uploads)
+	}
+}
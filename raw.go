@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/jpeg"
+	"mime"
+	"slices"
+	"strings"
+)
+
+// rawMimeTypes are the camera RAW formats, by extension, described from the
+// JPEG preview they embed since Gemini cannot read RAW files
+var rawMimeTypes = map[string]string{
+	".cr2": "image/x-canon-cr2",
+	".nef": "image/x-nikon-nef",
+	".arw": "image/x-sony-arw",
+	".dng": "image/x-adobe-dng",
+}
+
+// rawMimeTypesAlias lists all of rawMimeTypes in -mime-types
+const rawMimeTypesAlias = "raw"
+
+func init() {
+	for ext, mimeType := range rawMimeTypes {
+		mime.AddExtensionType(ext, mimeType)
+	}
+}
+
+// isRAW reports whether a MIME type is a camera RAW format
+func isRAW(mimeType string) bool {
+	for _, t := range rawMimeTypes {
+		if t == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// expandMimeTypes replaces the raw alias in a list of MIME types with the
+// camera RAW MIME types
+func expandMimeTypes(types []string) []string {
+	var expanded []string
+	for _, t := range types {
+		if strings.TrimSpace(t) != rawMimeTypesAlias {
+			expanded = append(expanded, t)
+			continue
+		}
+		for _, ext := range sortedKeys(rawMimeTypes) {
+			if !slices.Contains(expanded, rawMimeTypes[ext]) {
+				expanded = append(expanded, rawMimeTypes[ext])
+			}
+		}
+	}
+	return expanded
+}
+
+// TIFF tags locating the JPEG previews of RAW files
+const (
+	tagCompression       = 0x0103
+	tagStripOffsets      = 0x0111
+	tagStripByteCounts   = 0x0117
+	tagSubIFDs           = 0x014a
+	tagJPEGOffset        = 0x0201
+	tagJPEGLength        = 0x0202
+	tagExifIFD           = 0x8769
+	compressionOldJPEG   = 6
+	compressionJPEG      = 7
+	maxRAWIFDs           = 64
+	tiffLittleEndianMark = "II*\x00"
+	tiffBigEndianMark    = "MM\x00*"
+)
+
+// rawPreview returns the largest JPEG embedded in a TIFF based RAW file, such
+// as CR2, NEF, ARW and DNG, by walking its image file directories
+func rawPreview(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("not a TIFF based RAW file")
+	}
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte(tiffLittleEndianMark)):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte(tiffBigEndianMark)):
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF based RAW file")
+	}
+
+	var best []byte
+	bestPixels := 0
+	consider := func(offset, length uint32) {
+		end := uint64(offset) + uint64(length)
+		if length == 0 || end > uint64(len(data)) {
+			return
+		}
+		candidate := data[offset:end]
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(candidate))
+		if err != nil {
+			return
+		}
+		if pixels := cfg.Width * cfg.Height; pixels > bestPixels {
+			best, bestPixels = candidate, pixels
+		}
+	}
+
+	queue := []uint32{order.Uint32(data[4:])}
+	seen := map[uint32]bool{}
+	for len(queue) > 0 && len(seen) < maxRAWIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || seen[offset] || uint64(offset)+2 > uint64(len(data)) {
+			continue
+		}
+		seen[offset] = true
+		n := int(order.Uint16(data[offset:]))
+		entries := data[offset+2:]
+		if len(entries) < n*12+4 {
+			continue
+		}
+		tags := map[uint16]uint32{}
+		for i := range n {
+			e := entries[i*12:]
+			tag, typ, count := order.Uint16(e), order.Uint16(e[2:]), order.Uint32(e[4:])
+			value := order.Uint32(e[8:])
+			if typ == 3 { // SHORT, left justified in the value
+				value = uint32(order.Uint16(e[8:]))
+			}
+			switch {
+			case tag == tagSubIFDs && count > 1:
+				for j := range min(count, maxRAWIFDs) {
+					if p := uint64(value) + uint64(j)*4; p+4 <= uint64(len(data)) {
+						queue = append(queue, order.Uint32(data[p:]))
+					}
+				}
+			case tag == tagSubIFDs || tag == tagExifIFD:
+				queue = append(queue, value)
+			case count == 1:
+				tags[tag] = value
+			}
+		}
+		consider(tags[tagJPEGOffset], tags[tagJPEGLength])
+		if c := tags[tagCompression]; c == compressionOldJPEG || c == compressionJPEG {
+			consider(tags[tagStripOffsets], tags[tagStripByteCounts])
+		}
+		queue = append(queue, order.Uint32(entries[n*12:]))
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no embedded JPEG preview")
+	}
+	return best, nil
+}
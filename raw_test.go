@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"slices"
+	"testing"
+)
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testRAW builds a little endian TIFF with a small thumbnail in IFD0 and a
+// larger preview in a SubIFD, as NEF files do
+func testRAW(t *testing.T, thumbnail, preview []byte) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+	entry := func(b []byte, tag, typ uint16, count, value uint32) []byte {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, count)
+		return le.AppendUint32(b, value)
+	}
+	const ifd0, subIFD, data = 8, 8 + 2 + 3*12 + 4, 8 + 2 + 3*12 + 4 + 2 + 2*12 + 4
+	b := []byte(tiffLittleEndianMark)
+	b = le.AppendUint32(b, ifd0)
+	b = le.AppendUint16(b, 3)
+	b = entry(b, tagSubIFDs, 4, 1, subIFD)
+	b = entry(b, tagJPEGOffset, 4, 1, data)
+	b = entry(b, tagJPEGLength, 4, 1, uint32(len(thumbnail)))
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint16(b, 2)
+	b = entry(b, tagJPEGOffset, 4, 1, data+uint32(len(thumbnail)))
+	b = entry(b, tagJPEGLength, 4, 1, uint32(len(preview)))
+	b = le.AppendUint32(b, 0)
+	if len(b) != data {
+		t.Fatalf("header is %d bytes, want %d", len(b), data)
+	}
+	return append(append(b, thumbnail...), preview...)
+}
+
+func TestRAWPreview(t *testing.T) {
+	thumbnail, preview := testJPEG(t, 16, 12), testJPEG(t, 64, 48)
+	got, err := rawPreview(testRAW(t, thumbnail, preview))
+	if err != nil || !bytes.Equal(got, preview) {
+		t.Errorf("rawPreview = %d bytes, %v, want the %d byte preview", len(got), err, len(preview))
+	}
+	for _, bad := range [][]byte{nil, []byte("II*\x00"), testJPEG(t, 8, 8), testRAW(t, nil, nil)} {
+		if _, err := rawPreview(bad); err == nil {
+			t.Errorf("rawPreview(%d bytes) succeeded", len(bad))
+		}
+	}
+
+	if got := expandMimeTypes([]string{"image/jpeg", "raw"}); !slices.Contains(got, "image/x-nikon-nef") || got[0] != "image/jpeg" || len(got) != 5 {
+		t.Errorf("expandMimeTypes = %v", got)
+	}
+}
+
+func TestDescribeRAW(t *testing.T) {
+	f := useFakes(t)
+	preview := testJPEG(t, 64, 48)
+	raw := testRAW(t, testJPEG(t, 16, 12), preview)
+	f.drive.add("a", "DSC_0001.NEF", "image/x-nikon-nef", "root", raw)
+
+	r := processFile(context.Background(), *f.drive.files["a"], "DSC_0001.NEF")
+	if r.Description != f.generator.response {
+		t.Errorf("description = %q", r.Description)
+	}
+	part := f.generator.contents[0].Parts[0]
+	if part.InlineData.MIMEType != "image/jpeg" || !bytes.Equal(part.InlineData.Data, preview) {
+		t.Errorf("described %s, want the JPEG preview", part.InlineData.MIMEType)
+	}
+	// the RAW file itself is uploaded
+	if !bytes.Equal(f.storage.objects["test-bucket/DSC_0001.NEF"], raw) || f.storage.attrs["test-bucket/DSC_0001.NEF"].ContentType != "image/x-nikon-nef" {
+		t.Error("RAW file not uploaded as is")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/oauth2"
+)
+
+// reauthenticate runs the -auth-mode flow again when the refresh token is
+// revoked or expires mid-run, pausing Drive calls until it completes; without
+// it, the run stops starting files and writes the rest to -checkpoint
+var reauthenticate bool
+
+// tokenRevoked is set once the refresh token was found revoked or expired
+// and not replaced
+var tokenRevoked atomic.Bool
+
+// reauthTokenSource refreshes the OAuth token, authorizing again if the
+// refresh token is revoked or expired. Drive calls wait for its lock while
+// authorizing, so the workers pause rather than each failing.
+type reauthTokenSource struct {
+	mu        sync.Mutex
+	config    *oauth2.Config
+	src       oauth2.TokenSource
+	revoked   error                // the refresh error, once revoked and not replaced
+	authorize func() *oauth2.Token // runs the -auth-mode flow
+}
+
+// newReauthTokenSource returns a token source refreshing tok
+func newReauthTokenSource(config *oauth2.Config, tok *oauth2.Token) oauth2.TokenSource {
+	s := &reauthTokenSource{config: config, src: config.TokenSource(context.Background(), tok)}
+	s.authorize = func() *oauth2.Token { return authorize(config) }
+	return oauth2.ReuseTokenSource(tok, s)
+}
+
+func (s *reauthTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revoked != nil {
+		return nil, s.revoked
+	}
+	tok, err := s.src.Token()
+	if err == nil || !isRevoked(err) {
+		return tok, err
+	}
+	if !reauthenticate {
+		s.revoked = fmt.Errorf("the OAuth refresh token in %s was revoked or has expired: %w", tokenFile, err)
+		tokenRevoked.Store(true)
+		stats.failErr("token", err)
+		log.Printf("%v; not starting more files, rerun to authorize again, or use -reauth", s.revoked)
+		return nil, s.revoked
+	}
+	log.Printf("the OAuth refresh token in %s was revoked or has expired, authorizing again: %v", tokenFile, err)
+	tok = s.authorize()
+	if err := saveToken(tokenFile, tok); err != nil {
+		// the run carries on with the new token, which the next run authorizes again
+		log.Printf("%v; keeping the new token for this run only", err)
+	}
+	s.src = s.config.TokenSource(context.Background(), tok)
+	return tok, nil
+}
+
+// isRevoked reports whether a token refresh failed because the refresh token
+// was revoked or expired, rather than transiently
+func isRevoked(err error) bool {
+	var rerr *oauth2.RetrieveError
+	return errors.As(err, &rerr) && rerr.ErrorCode == "invalid_grant"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+)
+
+// revokedTokenSource returns a token source whose refresh token is revoked,
+// counting the refreshes tried
+func revokedTokenSource(t *testing.T, refreshes *int) *reauthTokenSource {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*refreshes++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`)
+	}))
+	t.Cleanup(srv.Close)
+	config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: srv.URL}}
+	expired := &oauth2.Token{AccessToken: "old", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)}
+	return &reauthTokenSource{config: config, src: config.TokenSource(context.Background(), expired)}
+}
+
+func TestRevokedToken(t *testing.T) {
+	useFakes(t)
+	prevReauth, prevToken := reauthenticate, tokenFile
+	t.Cleanup(func() {
+		reauthenticate, tokenFile = prevReauth, prevToken
+		tokenRevoked.Store(false)
+	})
+	tokenFile = filepath.Join(t.TempDir(), "token.json")
+
+	// without -reauth, the run stops starting files
+	var refreshes int
+	s := revokedTokenSource(t, &refreshes)
+	if _, err := s.Token(); err == nil || classifyError(err) != errorClassAuth {
+		t.Errorf("Token() = %v, want an auth error", err)
+	}
+	tried := refreshes
+	if _, err := s.Token(); err == nil || refreshes != tried {
+		t.Errorf("Token() = %v after %d more refreshes, want the error without refreshing again", err, refreshes-tried)
+	}
+	if !tokenRevoked.Load() {
+		t.Error("token not marked revoked")
+	}
+
+	// with -reauth, it authorizes again and keeps the new token
+	tokenRevoked.Store(false)
+	reauthenticate = true
+	s = revokedTokenSource(t, &refreshes)
+	s.authorize = func() *oauth2.Token { return &oauth2.Token{AccessToken: "new", RefreshToken: "fresh"} }
+	tok, err := s.Token()
+	if err != nil || tok.AccessToken != "new" || tokenRevoked.Load() {
+		t.Errorf("Token() = %+v, %v", tok, err)
+	}
+	if saved, err := tokenFromFile(tokenFile); err != nil || saved.RefreshToken != "fresh" {
+		t.Errorf("saved token = %+v, %v", saved, err)
+	}
+
+	// a token that can't be saved is kept for the run
+	tokenFile = filepath.Join(t.TempDir(), "missing", "token.json")
+	s = revokedTokenSource(t, &refreshes)
+	s.authorize = func() *oauth2.Token { return &oauth2.Token{AccessToken: "unsaved"} }
+	if tok, err := s.Token(); err != nil || tok.AccessToken != "unsaved" {
+		t.Errorf("Token() = %+v, %v, want the new token despite failing to save it", tok, err)
+	}
+}
+
+func TestRevokedTokenCheckpoint(t *testing.T) {
+	f := useFakes(t)
+	prevCheckpoint := checkpointFile
+	t.Cleanup(func() {
+		checkpointFile = prevCheckpoint
+		tokenRevoked.Store(false)
+	})
+	checkpointFile = filepath.Join(t.TempDir(), "checkpoint.csv")
+	tokenRevoked.Store(true)
+
+	files := make(chan drive.File, 2)
+	for _, id := range []string{"1", "2"} {
+		f.drive.add(id, id+".jpg", "image/jpeg", "root", []byte("data"))
+		files <- *f.drive.files[id]
+	}
+	close(files)
+	output := &markdownRecordWriter{}
+	processFiles(context.Background(), files, output)
+
+	if len(output.records) != 0 || stats.summary().Skipped["unauthorized"] != 2 {
+		t.Errorf("records = %d, skipped = %v", len(output.records), stats.summary().Skipped)
+	}
+	if rows := readCSV(t, checkpointFile); len(rows) != 3 {
+		t.Errorf("checkpoint = %v, want both files", rows)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+// redescribeIfPromptChanged describes again only the uploaded files whose
+// sidecar records a description generated under another prompt template or
+// system instruction, reading them from their objects and leaving the
+// objects as they are
+var redescribeIfPromptChanged bool
+
+// staleObject is the object a file described under an older prompt is read
+// from to describe it again
+type staleObject struct {
+	bucket, path string
+}
+
+// staleObjects holds the objects of the files this run describes again, by
+// Drive file ID
+var staleObjects sync.Map
+
+// redescribing reports whether a file is described again from its object
+func redescribing(file drive.File) bool {
+	_, ok := staleObjects.Load(file.Id)
+	return ok
+}
+
+// describedUnderOlderPrompt reports whether a file's sidecar records a
+// description generated under another prompt than the current one, keeping
+// the object to describe it from, or else why the file is skipped
+func describedUnderOlderPrompt(ctx context.Context, file drive.File, name string) (bool, string, error) {
+	settings := describeSettingsFor(file)
+	if !settings.Describe {
+		return false, "not-described", nil
+	}
+	dest := routeFor(file)
+	object := objectPath(dest.Prefix, name)
+	b, err := storageSrv.Read(ctx, dest.Bucket, sidecarPath(object))
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, "not-uploaded", nil
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("unable to read sidecar: %w", err)
+	}
+	var prev record
+	if err := json.Unmarshal(b, &prev); err != nil {
+		return false, "", fmt.Errorf("unable to parse sidecar %s: %v", sidecarPath(object), err)
+	}
+	if prev.Prompt == promptID(settings.Prompt) {
+		return false, "prompt-unchanged", nil
+	}
+	// describe the original of a watermarked image
+	source := staleObject{bucket: dest.Bucket, path: object}
+	if prev.OriginalPath != "" {
+		source.path = prev.OriginalPath
+	}
+	staleObjects.Store(file.Id, source)
+	return true, "", nil
+}
+
+// readStaleObject reads the object a file is described again from
+func readStaleObject(ctx context.Context, file drive.File) ([]byte, error) {
+	v, _ := staleObjects.Load(file.Id)
+	source := v.(staleObject)
+	b, err := storageSrv.Read(ctx, source.bucket, source.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read gs://%s/%s: %w", source.bucket, source.path, err)
+	}
+	return b, nil
+}
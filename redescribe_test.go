@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestRedescribeIfPromptChanged(t *testing.T) {
+	f := useFakes(t)
+	prevSidecars, prevRedescribe := writeSidecars, redescribeIfPromptChanged
+	t.Cleanup(func() { writeSidecars, redescribeIfPromptChanged = prevSidecars, prevRedescribe })
+	writeSidecars, redescribeIfPromptChanged = true, true
+
+	sidecar := func(object, prompt string) {
+		b, err := json.Marshal(record{Name: object, Description: "old " + object, Prompt: prompt, Model: model, DescribedAt: "2024-01-01T00:00:00Z"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.storage.upload("test-bucket", sidecarPath(object), b, storage.ObjectAttrs{})
+	}
+	for _, name := range []string{"stale.jpg", "current.jpg", "new.jpg"} {
+		f.drive.add(name, name, "image/jpeg", "root", []byte("drive "+name))
+	}
+	f.storage.upload("test-bucket", "stale.jpg", []byte("object stale.jpg"), storage.ObjectAttrs{})
+	sidecar("stale.jpg", "describe_media.tpl@000000000000")
+	f.storage.upload("test-bucket", "current.jpg", []byte("object current.jpg"), storage.ObjectAttrs{})
+	sidecar("current.jpg", promptID(""))
+	uploads := f.storage.uploads
+
+	output, err := newCSVRecordWriter(filepath.Join(t.TempDir(), "descriptions.csv"), []string{"drive_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(chan drive.File, 3)
+	for _, name := range []string{"stale.jpg", "current.jpg", "new.jpg"} {
+		files <- *f.drive.files[name]
+	}
+	close(files)
+	processFiles(context.Background(), files, output)
+	output.Close()
+
+	if f.generator.calls != 1 || f.drive.downloads != 0 {
+		t.Errorf("%d descriptions and %d downloads, want 1 and 0", f.generator.calls, f.drive.downloads)
+	}
+	if got := string(f.generator.contents[0].Parts[0].InlineData.Data); got != "object stale.jpg" {
+		t.Errorf("described %q, want the object", got)
+	}
+	if f.storage.uploads != uploads+1 {
+		t.Errorf("%d uploads, want only the stale file's sidecar", f.storage.uploads-uploads)
+	}
+	var r record
+	if err := json.Unmarshal(f.storage.objects["test-bucket/stale.jpg.json"], &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Description != f.generator.response || r.Prompt != promptID("") || len(r.DescriptionVersions) != 1 || r.DescriptionVersions[0].Description != "old stale.jpg" {
+		t.Errorf("sidecar = %+v", r)
+	}
+	if skipped := stats.summary().Skipped; skipped["prompt-unchanged"] != 1 || skipped["not-uploaded"] != 1 {
+		t.Errorf("skipped = %v", skipped)
+	}
+}
+
+func TestRedescribeFailureKeepsDescription(t *testing.T) {
+	f := useFakes(t)
+	prevSidecars, prevRedescribe := writeSidecars, redescribeIfPromptChanged
+	t.Cleanup(func() { writeSidecars, redescribeIfPromptChanged = prevSidecars, prevRedescribe })
+	writeSidecars, redescribeIfPromptChanged = true, true
+	f.generator.err = context.DeadlineExceeded
+
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+	f.storage.upload("test-bucket", "a.jpg", []byte("a"), storage.ObjectAttrs{})
+	b, _ := json.Marshal(record{Name: "a.jpg", Description: "kept", Prompt: "old@1", DescribedAt: "2024-01-01T00:00:00Z"})
+	f.storage.upload("test-bucket", "a.jpg.json", b, storage.ObjectAttrs{})
+
+	stale, _, err := describedUnderOlderPrompt(context.Background(), *f.drive.files["a"], "a.jpg")
+	if !stale || err != nil {
+		t.Fatalf("describedUnderOlderPrompt = %v, %v", stale, err)
+	}
+	r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+	if r.NeedsReview || r.ObjectPath != "a.jpg" {
+		t.Errorf("record = %+v, want the object left in place", r)
+	}
+	var sidecar record
+	json.Unmarshal(f.storage.objects["test-bucket/a.jpg.json"], &sidecar)
+	if sidecar.Description != "kept" {
+		t.Errorf("sidecar description = %q, want the earlier one kept", sidecar.Description)
+	}
+	if _, ok := f.storage.objects["test-bucket/needs-review/a.jpg"]; ok {
+		t.Error("object quarantined")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// refinement is a follow up prompt sent after a file's description, in the
+// same conversation, whose response is written to its own catalog column
+type refinement struct {
+	// Name is the catalog column of the response, e.g. keywords
+	Name   string
+	Prompt string
+}
+
+// refinements are the -refine turns, in order
+var refinements []refinement
+
+// stageRefine is the stats category of refinement turns
+const stageRefine = "refine"
+
+// refinedOutputs holds the responses to the refinements of this run's files,
+// by Drive file ID, as a map of refinement name to response
+var refinedOutputs sync.Map
+
+// refinementName is a valid refinement name, usable as a column name
+var refinementName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// addRefinement parses a -refine value, name=prompt, adding its column
+func addRefinement(value string) error {
+	name, prompt, ok := strings.Cut(value, "=")
+	name, prompt = strings.TrimSpace(name), strings.TrimSpace(prompt)
+	if !ok || prompt == "" {
+		return fmt.Errorf("expected name=prompt, e.g. keywords=Now produce 5 keywords from the above description")
+	}
+	if !refinementName.MatchString(name) {
+		return fmt.Errorf("refinement name %q must be lowercase letters, digits and underscores", name)
+	}
+	if _, ok := columns[name]; ok {
+		return fmt.Errorf("refinement name %q is already a column", name)
+	}
+	refinements = append(refinements, refinement{Name: name, Prompt: prompt})
+	columns[name] = func(r record) string { return r.Refinements[name] }
+	return nil
+}
+
+// refineDescription sends each refinement after a file's description, in the
+// same conversation, returning the responses by refinement name. A failed
+// turn is logged and ends the refinements, keeping the earlier responses.
+func refineDescription(ctx context.Context, file drive.File, model string, contents []*genai.Content, config *genai.GenerateContentConfig, description string) map[string]string {
+	outputs := map[string]string{}
+	contents = append(contents, genai.NewModelContentFromText(description))
+	for _, r := range refinements {
+		contents = append(contents, genai.Text(r.Prompt)...)
+		start := time.Now()
+		var resp *genai.GenerateContentResponse
+		err := withQuotaRetry(ctx, func() (err error) {
+			resp, err = genaiClient.GenerateContent(ctx, model, contents, config)
+			return err
+		})
+		observeFile(file.Id, stageRefine, start)
+		if err != nil {
+			stats.failErr(stageRefine, err)
+			stats.failFile(file, stageRefine, err)
+			log.Printf("%s: unable to refine the description with %s: %v", file.Name, r.Name, err)
+			break
+		}
+		stats.addUsage(resp.UsageMetadata)
+		text := strings.TrimSpace(resp.Text())
+		outputs[r.Name] = text
+		contents = append(contents, genai.NewModelContentFromText(text))
+	}
+	return outputs
+}
+
+// defaultColumnsWithRefinements returns the default columns followed by the
+// refinement columns
+func defaultColumnsWithRefinements() []string {
+	names := append([]string{}, defaultColumns...)
+	for _, r := range refinements {
+		names = append(names, r.Name)
+	}
+	return names
+}
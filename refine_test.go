@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRefinements(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev []refinement) {
+		for _, r := range refinements[len(prev):] {
+			delete(columns, r.Name)
+		}
+		refinements = prev
+	}(refinements)
+	for _, value := range []string{"keywords=Now produce 5 keywords from the above description", "alt_text = Shorten it to alt text"} {
+		if err := addRefinement(value); err != nil {
+			t.Fatalf("addRefinement(%q): %v", value, err)
+		}
+	}
+	for _, bad := range []string{"keywords", "Keywords=x", "description=x", "tags="} {
+		if err := addRefinement(bad); err == nil {
+			t.Errorf("addRefinement(%q) succeeded", bad)
+		}
+	}
+	if got, _ := parseColumns(""); strings.Join(got, ",") != strings.Join(defaultColumns, ",")+",keywords,alt_text" {
+		t.Errorf("default columns = %v, want the refinements last", got)
+	}
+
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+	r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+	if r.Refinements["keywords"] != f.generator.response || columns["alt_text"](r) != f.generator.response {
+		t.Errorf("refinements = %v", r.Refinements)
+	}
+	if f.generator.calls != 3 {
+		t.Errorf("calls = %d, want the description and 2 refinements", f.generator.calls)
+	}
+	// the last turn is sent with the whole conversation
+	var roles []string
+	for _, c := range f.generator.contents {
+		roles = append(roles, c.Role)
+	}
+	if got, want := strings.Join(roles, ","), "user,user,model,user,model,user"; got != want {
+		t.Errorf("roles = %s, want %s", got, want)
+	}
+	if last := f.generator.contents[len(f.generator.contents)-1]; last.Parts[0].Text != "Shorten it to alt text" {
+		t.Errorf("last turn = %q", last.Parts[0].Text)
+	}
+
+	// a failed turn keeps the description
+	f.generator.err = errors.New("refused")
+	outputs := refineDescription(context.Background(), *f.drive.files["a"], model, nil, generateConfig(), "a description")
+	if len(outputs) != 0 || stats.summary().Failures[stageRefine] != 1 {
+		t.Errorf("outputs = %v, failures = %v, want a refine failure", outputs, stats.summary().Failures)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/genai"
+)
+
+// locations are the Vertex AI regions from LOCATION, the first preferred
+var locations = []string{"us-central1"}
+
+// servedRegions holds the Vertex AI region that described each of this run's
+// files, by Drive file ID
+var servedRegions sync.Map
+
+// parseLocations parses LOCATION, a Vertex AI region or a comma-separated
+// list of regions to fail over between, in order
+func parseLocations(v string) []string {
+	var locations []string
+	for _, l := range strings.Split(v, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			locations = append(locations, l)
+		}
+	}
+	if len(locations) == 0 {
+		return []string{"us-central1"}
+	}
+	return locations
+}
+
+// regionUnavailable reports whether a Gemini error is a region running out of
+// capacity or being unavailable, which another region may not be
+func regionUnavailable(err error) bool {
+	var serr genai.ServerError
+	if errors.As(err, &serr) {
+		return serr.Code == http.StatusServiceUnavailable || serr.Code == http.StatusInternalServerError
+	}
+	var cerr genai.ClientError
+	if errors.As(err, &cerr) {
+		// RESOURCE_EXHAUSTED is also a shortage of shared regional capacity
+		return cerr.Code == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// regionKey is the context key of the *string the serving region is stored in
+type regionKey struct{}
+
+// withServedRegion returns a context recording the region a request through
+// it was served by into region
+func withServedRegion(ctx context.Context, region *string) context.Context {
+	return context.WithValue(ctx, regionKey{}, region)
+}
+
+func setServedRegion(ctx context.Context, region string) {
+	if r, ok := ctx.Value(regionKey{}).(*string); ok {
+		*r = region
+	}
+}
+
+// regionalGenerator sends requests to the first of several Vertex AI regions,
+// failing over to the next when a region is out of capacity or unavailable
+type regionalGenerator struct {
+	locations []string
+	clients   []generator
+}
+
+func (g *regionalGenerator) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	var err error
+	for i, client := range g.clients {
+		var resp *genai.GenerateContentResponse
+		resp, err = client.GenerateContent(ctx, model, contents, config)
+		if err == nil {
+			setServedRegion(ctx, g.locations[i])
+			return resp, nil
+		}
+		if !regionUnavailable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		if i+1 < len(g.clients) {
+			log.Printf("%s unavailable, failing over to %s: %v", g.locations[i], g.locations[i+1], err)
+		}
+	}
+	return nil, err
+}
+
+// GenerateContentStream fails over only until a region sends its first chunk
+func (g *regionalGenerator) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		for i, client := range g.clients {
+			started, failover := false, false
+			for resp, err := range client.GenerateContentStream(ctx, model, contents, config) {
+				if err != nil && !started && regionUnavailable(err) && ctx.Err() == nil && i+1 < len(g.clients) {
+					log.Printf("%s unavailable, failing over to %s: %v", g.locations[i], g.locations[i+1], err)
+					failover = true
+					break
+				}
+				if !started && err == nil {
+					started = true
+					setServedRegion(ctx, g.locations[i])
+				}
+				if !yield(resp, err) || err != nil {
+					return
+				}
+			}
+			if !failover {
+				return
+			}
+		}
+	}
+}
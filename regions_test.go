@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestParseLocations(t *testing.T) {
+	for v, want := range map[string][]string{
+		"":                          {"us-central1"},
+		"europe-west4":              {"europe-west4"},
+		"us-central1, europe-west4": {"us-central1", "europe-west4"},
+	} {
+		if got := parseLocations(v); !slices.Equal(got, want) {
+			t.Errorf("parseLocations(%q) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestRegionalFailover(t *testing.T) {
+	f := useFakes(t)
+	unavailable := &fakeGenerator{err: serverError(http.StatusServiceUnavailable)}
+	exhausted := &fakeGenerator{err: clientError(http.StatusTooManyRequests)}
+	genaiClient = &regionalGenerator{
+		locations: []string{"us-central1", "us-east4", "europe-west4"},
+		clients:   []generator{unavailable, exhausted, f.generator},
+	}
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+
+	r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+	if r.Description != f.generator.response || r.Region != "europe-west4" {
+		t.Errorf("record = %+v, want described in europe-west4", r)
+	}
+	if unavailable.calls != 1 || exhausted.calls != 1 {
+		t.Errorf("calls = %d, %d, want 1 to each region failed over from", unavailable.calls, exhausted.calls)
+	}
+
+	// other errors do not fail over
+	invalid := &fakeGenerator{err: clientError(http.StatusBadRequest)}
+	g := &regionalGenerator{locations: []string{"us-central1", "europe-west4"}, clients: []generator{invalid, f.generator}}
+	var region string
+	if _, err := g.GenerateContent(withServedRegion(context.Background(), &region), model, nil, nil); err == nil || region != "" {
+		t.Errorf("GenerateContent = %v in %q, want the bad request error", err, region)
+	}
+
+	// streams fail over before their first chunk
+	for resp, err := range (&regionalGenerator{locations: []string{"us-central1", "europe-west4"}, clients: []generator{unavailable, f.generator}}).GenerateContentStream(withServedRegion(context.Background(), &region), model, nil, nil) {
+		if err != nil || resp == nil {
+			t.Fatalf("stream = %v, %v, want chunks from europe-west4", resp, err)
+		}
+	}
+	if region != "europe-west4" {
+		t.Errorf("stream served in %q, want europe-west4", region)
+	}
+}
+
+func serverError(code int) error {
+	var err genai.ServerError
+	err.Code = code
+	return err
+}
+
+func clientError(code int) error {
+	var err genai.ClientError
+	err.Code = code
+	return err
+}
+
+func TestSingleRegion(t *testing.T) {
+	f := useFakes(t)
+	defer func(l []string, b string) { locations, backend = l, b }(locations, backend)
+	locations, backend = []string{"europe-west4"}, backendVertex
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+	if r := processFile(context.Background(), *f.drive.files["a"], "a.jpg"); r.Region != "europe-west4" {
+		t.Errorf("region = %q, want europe-west4", r.Region)
+	}
+
+	// the Gemini Developer API isn't regional
+	backend = backendGeminiAPI
+	f.drive.add("b", "b.jpg", "image/jpeg", "root", []byte("b"))
+	if r := processFile(context.Background(), *f.drive.files["b"], "b.jpg"); r.Region != "" {
+		t.Errorf("region with -backend geminiapi = %q, want none", r.Region)
+	}
+}
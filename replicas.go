@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+// replicaBucketsList is the comma separated -replica-buckets
+var replicaBucketsList string
+
+// replicaBuckets are the buckets, usually in other regions, every object is
+// also written to, in parallel, after the primary bucket
+var replicaBuckets []string
+
+// replicatedObjects are the replica buckets a file's object was written to,
+// by Drive file ID
+var replicatedObjects sync.Map
+
+// parseReplicaBuckets parses -replica-buckets, which must not name the
+// primary bucket
+func parseReplicaBuckets(list, primary string) ([]string, error) {
+	var buckets []string
+	for _, b := range strings.Split(list, ",") {
+		b = strings.TrimPrefix(strings.TrimSpace(b), "gs://")
+		if b == "" || slices.Contains(buckets, b) {
+			continue
+		}
+		if b == primary {
+			return nil, fmt.Errorf("-replica-buckets includes the primary bucket %s", b)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// replicate writes an object to each replica bucket in parallel, under the
+// same path and with the same attributes as in the primary bucket, recording
+// the buckets written
+func replicate(ctx context.Context, file drive.File, prefix, name string, data []byte, attrs storage.ObjectAttrs) {
+	if len(replicaBuckets) == 0 {
+		return
+	}
+	var mu sync.Mutex
+	var written []string
+	var wg sync.WaitGroup
+	for _, bucket := range replicaBuckets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := uploadFileToGCS(ctx, bucket, prefix, name, data, attrs, alwaysUploadToGCS); err != nil {
+				stats.failErr("replica", err)
+				stats.failFile(file, "replica", err)
+				log.Printf("%s: unable to write replica to %s: %v", file.Name, bucket, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			written = append(written, bucket)
+		}()
+	}
+	wg.Wait()
+	slices.Sort(written)
+	replicatedObjects.Store(file.Id, written)
+}
+
+// replicasOf returns the gs:// URIs of a file's replicated objects
+func replicasOf(fileID, objectPath string) []string {
+	buckets, ok := replicatedObjects.Load(fileID)
+	if !ok {
+		return nil
+	}
+	var uris []string
+	for _, b := range buckets.([]string) {
+		uris = append(uris, "gs://"+b+"/"+objectPath)
+	}
+	return uris
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestParseReplicaBuckets(t *testing.T) {
+	got, err := parseReplicaBuckets(" gs://media-eu, media-asia,,media-eu", "media")
+	if err != nil || !slices.Equal(got, []string{"media-eu", "media-asia"}) {
+		t.Errorf("parseReplicaBuckets = %v, %v", got, err)
+	}
+	if _, err := parseReplicaBuckets("media-eu,media", "media"); err == nil {
+		t.Error("parseReplicaBuckets accepted the primary bucket")
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	f := useFakes(t)
+	prev := replicaBuckets
+	defer func() { replicaBuckets = prev }()
+	replicaBuckets = []string{"media-eu", "media-asia"}
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("jpeg"))
+
+	files := make(chan drive.File, 1)
+	file, _ := f.drive.Get(context.Background(), "a", "")
+	files <- *file
+	close(files)
+	output := &markdownRecordWriter{}
+	processFiles(context.Background(), files, output)
+
+	for _, bucket := range []string{"test-bucket", "media-eu", "media-asia"} {
+		if got, err := f.storage.Read(context.Background(), bucket, "a.jpg"); err != nil || string(got) != "jpeg" {
+			t.Errorf("%s/a.jpg = %q, %v", bucket, got, err)
+		}
+	}
+	if len(output.records) != 1 {
+		t.Fatalf("records = %d", len(output.records))
+	}
+	if r := output.records[0]; !slices.Equal(r.Replicas, []string{"gs://media-asia/a.jpg", "gs://media-eu/a.jpg"}) {
+		t.Errorf("replicas = %v", r.Replicas)
+	}
+}
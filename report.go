@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// pipeline stages tracked for latency reporting
+const (
+	stageDownload = "download"
+	stageUpload   = "upload"
+	stageDescribe = "describe"
+)
+
+// runStats collects counters and per-stage latencies over a run
+type runStats struct {
+	mu sync.Mutex
+
+	start     time.Time
+	files     int
+	bytes     int64
+	failures  map[string]int
+	errors    map[string]int
+	skipped   map[string]int
+	latencies map[string][]time.Duration
+	failed    []fileFailure
+	retries   int
+	paused    time.Duration
+
+	promptTokens    int64
+	candidateTokens int64
+	totalTokens     int64
+}
+
+// stageSummary holds latency percentiles for a single stage
+type stageSummary struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+}
+
+// fileFailure is a file that failed a stage of the pipeline
+type fileFailure struct {
+	DriveID string `json:"drive_id"`
+	Name    string `json:"name"`
+	Stage   string `json:"stage,omitempty"`
+	Error   string `json:"error"`
+}
+
+// runSummary is the end of run report
+type runSummary struct {
+	RunID           string                  `json:"run_id,omitempty"`
+	Files           int                     `json:"files"`
+	Bytes           int64                   `json:"bytes"`
+	Failures        map[string]int          `json:"failures"`
+	Errors          map[string]int          `json:"errors"`
+	Skipped         map[string]int          `json:"skipped"`
+	Stages          map[string]stageSummary `json:"stages"`
+	PromptTokens    int64                   `json:"prompt_tokens"`
+	CandidateTokens int64                   `json:"candidate_tokens"`
+	TotalTokens     int64                   `json:"total_tokens"`
+	ElapsedSeconds  float64                 `json:"elapsed_seconds"`
+	FailedFiles     []fileFailure           `json:"failed_files,omitempty"`
+	QuotaRetries    int                     `json:"quota_retries,omitempty"`
+	// PausedSeconds is the time paused outside -active-hours
+	PausedSeconds float64 `json:"paused_seconds,omitempty"`
+	// Verification is the outcome of comparing a sample of the uploaded
+	// objects with their Drive originals, with -verify-sample
+	Verification *verificationReport `json:"verification,omitempty"`
+	// Plan is what the run was configured to do, as logged before it started
+	Plan *runPlan `json:"plan,omitempty"`
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		start:     time.Now(),
+		failures:  map[string]int{},
+		errors:    map[string]int{},
+		skipped:   map[string]int{},
+		latencies: map[string][]time.Duration{},
+	}
+}
+
+// observe records the duration of a stage
+func (s *runStats) observe(stage string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies[stage] = append(s.latencies[stage], d)
+}
+
+// fail records a failure in the given category
+func (s *runStats) fail(category string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[category]++
+}
+
+// failErr records a failure in the given category along with the class of err
+func (s *runStats) failErr(category string, err error) {
+	s.fail(category)
+	if class := classifyError(err); class != "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.errors[class]++
+	}
+}
+
+// failFile records a file that failed a stage
+func (s *runStats) failFile(file drive.File, stage string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = append(s.failed, fileFailure{DriveID: file.Id, Name: file.Name, Stage: stage, Error: err.Error()})
+}
+
+// failedCount returns how many file failures were recorded so far
+func (s *runStats) failedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.failed)
+}
+
+// forgetFiles drops the failed files and stage latencies, which grow with
+// each file, keeping the counts
+func (s *runStats) forgetFiles() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = nil
+	clear(s.latencies)
+}
+
+// failuresSince returns the failures of a file recorded after the first n
+func (s *runStats) failuresSince(n int, id string) []fileFailure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failures []fileFailure
+	for _, f := range s.failed[n:] {
+		if f.DriveID == id {
+			failures = append(failures, f)
+		}
+	}
+	return failures
+}
+
+// skip records a file skipped for the given reason
+func (s *runStats) skip(reason string) {
+	s.skipN(reason, 1)
+}
+
+// skipN records n files skipped for the given reason
+func (s *runStats) skipN(reason string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped[reason] += n
+}
+
+// retry records a call retried after a quota error
+func (s *runStats) retry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries++
+}
+
+// pause records time paused outside -active-hours
+func (s *runStats) pause(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused += d
+}
+
+// addFile records a processed file and its size
+func (s *runStats) addFile(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files++
+	s.bytes += int64(size)
+}
+
+// addUsage accumulates Gemini token usage
+func (s *runStats) addUsage(usage *genai.GenerateContentResponseUsageMetadata) {
+	if usage == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if usage.PromptTokenCount != nil {
+		s.promptTokens += int64(*usage.PromptTokenCount)
+	}
+	if usage.CandidatesTokenCount != nil {
+		s.candidateTokens += int64(*usage.CandidatesTokenCount)
+	}
+	s.totalTokens += int64(usage.TotalTokenCount)
+}
+
+// summary computes the run summary from the collected stats
+func (s *runStats) summary() runSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := runSummary{
+		RunID:           runID,
+		Files:           s.files,
+		Bytes:           s.bytes,
+		Failures:        map[string]int{},
+		Errors:          map[string]int{},
+		Skipped:         map[string]int{},
+		Stages:          map[string]stageSummary{},
+		PromptTokens:    s.promptTokens,
+		CandidateTokens: s.candidateTokens,
+		TotalTokens:     s.totalTokens,
+		ElapsedSeconds:  time.Since(s.start).Seconds(),
+		FailedFiles:     slices.Clone(s.failed),
+		QuotaRetries:    s.retries,
+		PausedSeconds:   s.paused.Seconds(),
+	}
+	for category, count := range s.failures {
+		r.Failures[category] = count
+	}
+	for class, count := range s.errors {
+		r.Errors[class] = count
+	}
+	for reason, count := range s.skipped {
+		r.Skipped[reason] = count
+	}
+	for stage, durations := range s.latencies {
+		r.Stages[stage] = stageSummary{
+			Count: len(durations),
+			P50Ms: milliseconds(percentile(durations, 50)),
+			P95Ms: milliseconds(percentile(durations, 95)),
+		}
+	}
+	return r
+}
+
+// percentile returns the p-th percentile (nearest rank) of the durations
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// print logs a human readable version of the summary
+func (r runSummary) print() {
+	log.Printf("Run summary %s", r.RunID)
+	log.Printf("  files: %d, bytes: %d, elapsed: %.1fs", r.Files, r.Bytes, r.ElapsedSeconds)
+	for _, stage := range sortedKeys(r.Stages) {
+		st := r.Stages[stage]
+		log.Printf("  %s: n=%d p50=%.0fms p95=%.0fms", stage, st.Count, st.P50Ms, st.P95Ms)
+	}
+	if len(r.Failures) == 0 {
+		log.Println("  failures: none")
+	}
+	for _, category := range sortedKeys(r.Failures) {
+		log.Printf("  failures (%s): %d", category, r.Failures[category])
+	}
+	for _, reason := range sortedKeys(r.Skipped) {
+		log.Printf("  skipped (%s): %d", reason, r.Skipped[reason])
+	}
+	for _, class := range sortedKeys(r.Errors) {
+		log.Printf("  errors (%s): %d", class, r.Errors[class])
+	}
+	if r.QuotaRetries > 0 {
+		log.Printf("  quota retries: %d", r.QuotaRetries)
+	}
+	if r.PausedSeconds > 0 {
+		log.Printf("  paused: %.1fs", r.PausedSeconds)
+	}
+	if r.Verification != nil {
+		r.Verification.print()
+	}
+	log.Printf("  gemini tokens: prompt=%d candidates=%d total=%d", r.PromptTokens, r.CandidateTokens, r.TotalTokens)
+}
+
+// writeSummary writes the summary as JSON to the given path
+func writeSummary(path string, r runSummary) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal summary: %v", err)
+	}
+	if err := writeFile(path, b); err != nil {
+		return fmt.Errorf("unable to write summary: %v", err)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// resizable are the image types that can be downscaled before describing
+var resizable = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true}
+
+// imageSize returns the dimensions of an image without decoding it
+func imageSize(data []byte) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to decode image: %v", err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// resizeImage scales an image down so its longest side is at most maxSide,
+// returning it encoded as PNG for PNG and GIF images, the first frame of an
+// animation, or as JPEG otherwise, along with its MIME type
+func resizeImage(data []byte, mimeType string, maxSide int) ([]byte, string, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to decode image: %v", err)
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h && w > maxSide {
+		w, h = maxSide, max(h*maxSide/w, 1)
+	} else if h > w && h > maxSide {
+		w, h = max(w*maxSide/h, 1), maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	switch mimeType {
+	case "image/png", "image/gif":
+		err, mimeType = png.Encode(&buf, dst), "image/png"
+	default:
+		err, mimeType = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}), "image/jpeg"
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to encode resized image: %v", err)
+	}
+	return buf.Bytes(), mimeType, nil
+}
+
+// imageTokens estimates the Gemini input tokens of an image: 258 for images
+// up to 384 pixels a side, otherwise 258 for each 768 pixel tile
+func imageTokens(w, h int) int {
+	if w <= 384 && h <= 384 {
+		return 258
+	}
+	tiles := ((w + 767) / 768) * ((h + 767) / 768)
+	return 258 * tiles
+}
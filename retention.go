@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+// retention places holds, a retention period and a custom time on the
+// objects of a route or rule, for WORM and compliance buckets
+type retention struct {
+	// EventBasedHold and TemporaryHold place the holds on the objects, so
+	// they can't be deleted or replaced until the holds are released
+	EventBasedHold bool `json:"event_based_hold,omitempty"`
+	TemporaryHold  bool `json:"temporary_hold,omitempty"`
+	// RetainFor retains the objects for a period from upload, such as 7y,
+	// 90d or 12h, on a bucket with object retention enabled
+	RetainFor string `json:"retain_for,omitempty"`
+	// RetentionMode is the mode of the retention: Unlocked, the default,
+	// which can be shortened or removed, or Locked, which can't
+	RetentionMode string `json:"retention_mode,omitempty"`
+	// CustomTime sets the objects' custom time from the Drive file, created
+	// or modified, for lifecycle rules by the age of the original
+	CustomTime string `json:"custom_time,omitempty"`
+}
+
+// object retention modes
+var retentionModes = []string{"Unlocked", "Locked"}
+
+// custom times of the objects, from the Drive file
+const (
+	customTimeCreated  = "created"
+	customTimeModified = "modified"
+)
+
+var customTimes = []string{customTimeCreated, customTimeModified}
+
+// validate checks the retention period, mode and custom time, normalizing the
+// mode's case
+func (r *retention) validate() error {
+	if r.RetainFor != "" {
+		if _, err := retainUntil(time.Now(), r.RetainFor); err != nil {
+			return fmt.Errorf("retain_for: %v", err)
+		}
+	}
+	if r.RetentionMode != "" {
+		i := slices.IndexFunc(retentionModes, func(m string) bool { return strings.EqualFold(m, r.RetentionMode) })
+		if i < 0 {
+			return fmt.Errorf("unknown retention_mode %q, expected one of %s", r.RetentionMode, strings.Join(retentionModes, ", "))
+		}
+		if r.RetainFor == "" {
+			return fmt.Errorf("retention_mode requires retain_for")
+		}
+		r.RetentionMode = retentionModes[i]
+	}
+	if r.CustomTime != "" && !slices.Contains(customTimes, r.CustomTime) {
+		return fmt.Errorf("unknown custom_time %q, expected one of %s", r.CustomTime, strings.Join(customTimes, ", "))
+	}
+	return nil
+}
+
+// merge returns the retention with a rule's retention applied over it: the
+// holds of either, and the rule's period and custom time if set
+func (r retention) merge(over retention) retention {
+	r.EventBasedHold = r.EventBasedHold || over.EventBasedHold
+	r.TemporaryHold = r.TemporaryHold || over.TemporaryHold
+	if over.RetainFor != "" {
+		r.RetainFor, r.RetentionMode = over.RetainFor, over.RetentionMode
+	}
+	if over.CustomTime != "" {
+		r.CustomTime = over.CustomTime
+	}
+	return r
+}
+
+// retainUntil returns the end of a retention period from now: a number of
+// years or days, or a Go duration
+func retainUntil(now time.Time, period string) (time.Time, error) {
+	if n, ok := strings.CutSuffix(period, "y"); ok {
+		years, err := strconv.Atoi(n)
+		if err != nil || years <= 0 {
+			return time.Time{}, fmt.Errorf("invalid period %q", period)
+		}
+		return now.AddDate(years, 0, 0), nil
+	}
+	if n, ok := strings.CutSuffix(period, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil || days <= 0 {
+			return time.Time{}, fmt.Errorf("invalid period %q", period)
+		}
+		return now.AddDate(0, 0, days), nil
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid period %q, expected years, days or a duration such as 7y, 90d or 12h", period)
+	}
+	return now.Add(d), nil
+}
+
+// apply sets the holds, retention and custom time on a Drive file's object
+// attributes
+func (r retention) apply(attrs *storage.ObjectAttrs, file drive.File) {
+	attrs.EventBasedHold = r.EventBasedHold
+	attrs.TemporaryHold = r.TemporaryHold
+	if r.RetainFor != "" {
+		until, _ := retainUntil(time.Now(), r.RetainFor) // validated with the config
+		attrs.Retention = &storage.ObjectRetention{Mode: cmp.Or(r.RetentionMode, retentionModes[0]), RetainUntil: until}
+	}
+	var custom string
+	switch r.CustomTime {
+	case customTimeCreated:
+		custom = file.CreatedTime
+	case customTimeModified:
+		custom = file.ModifiedTime
+	}
+	if t, err := time.Parse(time.RFC3339, custom); err == nil {
+		attrs.CustomTime = t
+	}
+}
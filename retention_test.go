@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestRetainUntil(t *testing.T) {
+	now := time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC)
+	for period, want := range map[string]time.Time{
+		"7y":  time.Date(2032, 2, 28, 12, 0, 0, 0, time.UTC),
+		"90d": time.Date(2025, 5, 29, 12, 0, 0, 0, time.UTC),
+		"12h": time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if got, err := retainUntil(now, period); err != nil || !got.Equal(want) {
+			t.Errorf("retainUntil(%s) = %v, %v, want %v", period, got, err, want)
+		}
+	}
+	for _, period := range []string{"", "0d", "-1y", "ay", "forever"} {
+		if _, err := retainUntil(now, period); err == nil {
+			t.Errorf("retainUntil(%q) = nil error", period)
+		}
+	}
+}
+
+func TestRetentionConfig(t *testing.T) {
+	for _, contents := range []string{
+		`{"routes": [{"path": "Legal", "retain_for": "forever"}]}`,
+		`{"routes": [{"path": "Legal", "retain_for": "7y", "retention_mode": "frozen"}]}`,
+		`{"rules": [{"name": "*.pdf", "retention_mode": "Locked"}]}`,
+		`{"rules": [{"name": "*.pdf", "custom_time": "uploaded"}]}`,
+	} {
+		if _, err := loadConfig(writeConfig(t, contents)); err == nil {
+			t.Errorf("loadConfig(%s) = nil error, want error", contents)
+		}
+	}
+
+	c, err := loadConfig(writeConfig(t, `{
+		"routes": [{"path": "Legal", "bucket": "worm", "event_based_hold": true, "retain_for": "1y", "retention_mode": "locked"}],
+		"rules": [{"name": "*.pdf", "temporary_hold": true, "custom_time": "created"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := cfg
+	defer func() { cfg = prev }()
+	cfg = c
+	fileLocations.Store("a", fileLocation{folderID: "legal", relativePath: "Legal"})
+	defer fileLocations.Delete("a")
+
+	file := drive.File{Id: "a", Name: "contract.pdf", MimeType: "application/pdf", CreatedTime: "2020-01-02T03:04:05Z"}
+	attrs := objectAttrs(file)
+	if !attrs.EventBasedHold || !attrs.TemporaryHold {
+		t.Errorf("holds = %v, %v, want the route's and the rule's", attrs.EventBasedHold, attrs.TemporaryHold)
+	}
+	if attrs.Retention == nil || attrs.Retention.Mode != "Locked" || time.Until(attrs.Retention.RetainUntil) < 364*24*time.Hour {
+		t.Errorf("retention = %+v, want locked for a year", attrs.Retention)
+	}
+	if !attrs.CustomTime.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("custom time = %v, want the Drive file's created time", attrs.CustomTime)
+	}
+
+	other := objectAttrs(drive.File{Id: "b", Name: "photo.jpg", MimeType: "image/jpeg"})
+	if other.EventBasedHold || other.TemporaryHold || other.Retention != nil || !other.CustomTime.IsZero() {
+		t.Errorf("unmatched file attrs = %+v, want no holds or retention", other)
+	}
+}
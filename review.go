@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// reviewPrefix is the folder, within the destination prefix, that files whose
+// description failed are uploaded under
+var reviewPrefix string = "needs-review"
+
+// reviewQueueFile lists the files needing review, as a CSV usable as a -manifest
+var reviewQueueFile string = "needs-review.csv"
+
+// reviewStatusKey is the object metadata key labeling files needing review
+const reviewStatusKey = "review-status"
+
+// describeError is returned by describe when Gemini failed to describe a file
+// that was otherwise downloaded and uploaded
+type describeError struct {
+	err error
+}
+
+func (e *describeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *describeError) Unwrap() error {
+	return e.err
+}
+
+// needsReview reports whether err is a describe failure, whose file is
+// quarantined for review
+func needsReview(err error) bool {
+	var derr *describeError
+	return errors.As(err, &derr)
+}
+
+// reviewName returns the object name of a file quarantined for review
+func reviewName(name string) string {
+	return path.Join(reviewPrefix, name)
+}
+
+// reviewQueue collects the records of files needing review
+type reviewQueue struct {
+	mu      sync.Mutex
+	records []record
+}
+
+var reviews = &reviewQueue{}
+
+func (q *reviewQueue) add(r record) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.records = append(q.records, r)
+}
+
+// write writes the review queue as a CSV, in the order of the Drive file IDs
+func (q *reviewQueue) write(path string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	sort.Slice(q.records, func(i, j int) bool { return q.records[i].ID < q.records[j].ID })
+
+	f, err := createPending(path)
+	if err != nil {
+		return fmt.Errorf("unable to create review queue: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"drive_id", "name", "gcs_uri", "error"})
+	for _, r := range q.records {
+		w.Write([]string{r.ID, r.Name, r.gcsURI(), r.Description})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.abort()
+		return fmt.Errorf("unable to write review queue: %v", err)
+	}
+	return f.commit()
+}
+
+// review states of a description
+const (
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+)
+
+var reviewStatuses = []string{reviewPending, reviewApproved, reviewRejected}
+
+// reviewSheetColumns are the columns of the spreadsheet exported for reviewers
+var reviewSheetColumns = []string{"drive_id", "name", "bucket", "object_path", "url", "description", "review_status", "review_note"}
+
+// runReview runs drivetogcs review export, writing a spreadsheet of catalog
+// descriptions for reviewers, or review import, applying a reviewed
+// spreadsheet to the catalog, sidecars, object metadata and Drive
+func runReview(ctx context.Context, args []string) int {
+	usage := "usage: drivetogcs review export [-catalog descriptions.csv] [-status pending] [-out review.csv]\n       drivetogcs review import [-catalog descriptions.csv] [-drive=false] review.csv"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return exitFailure
+	}
+	fs := flag.NewFlagSet("review "+args[0], flag.ContinueOnError)
+	catalog := fs.String("catalog", catalogFile, "the CSV catalog to export from or import into")
+	switch args[0] {
+	case "export":
+		out := fs.String("out", "review.csv", "path to write the review spreadsheet CSV")
+		status := fs.String("status", "", "only export descriptions with these comma-separated review statuses, e.g. pending")
+		if err := fs.Parse(args[1:]); err != nil {
+			return exitFailure
+		}
+		var statuses []string
+		if *status != "" {
+			statuses = strings.Split(*status, ",")
+		}
+		n, err := exportReview(*catalog, *out, statuses)
+		if err != nil {
+			log.Printf("%v", err)
+			return exitFailure
+		}
+		log.Printf("exported %d descriptions for review to %s", n, *out)
+		return exitSuccess
+	case "import":
+		updateDrive := fs.Bool("drive", true, "write approved descriptions to the Drive file's description")
+		if err := fs.Parse(args[1:]); err != nil {
+			return exitFailure
+		}
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			return exitFailure
+		}
+		closeClients := initClients(ctx)
+		defer closeClients()
+		n, failed, err := importReview(ctx, *catalog, fs.Arg(0), *updateDrive)
+		if err != nil {
+			log.Printf("%v", err)
+			return exitFailure
+		}
+		log.Printf("imported %d reviews, %d failed", n, failed)
+		if failed > 0 {
+			return exitPartial
+		}
+		return exitSuccess
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return exitFailure
+}
+
+// exportReview writes the catalog's descriptions, optionally only those with
+// the given review statuses, as a review spreadsheet, returning the number of
+// descriptions written
+func exportReview(catalogPath, out string, statuses []string) (int, error) {
+	t, err := readCatalog(catalogPath)
+	if err != nil {
+		return 0, err
+	}
+	if !t.has("drive_id") || !t.has("description") {
+		return 0, fmt.Errorf("catalog %s needs drive_id and description columns, see -columns", catalogPath)
+	}
+	f, err := createFile(out)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create review spreadsheet: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write(reviewSheetColumns)
+	n := 0
+	for _, row := range t.rows {
+		status := t.get(row, "review_status")
+		if status == "" {
+			status = reviewPending
+		}
+		if len(statuses) > 0 && !slices.Contains(statuses, status) {
+			continue
+		}
+		r := record{
+			ID:          t.get(row, "drive_id"),
+			Name:        t.get(row, "name"),
+			Bucket:      t.get(row, "bucket"),
+			ObjectPath:  t.get(row, "object_path"),
+			Description: t.get(row, "description"),
+		}
+		var url string
+		if r.ObjectPath != "" && r.bucket() != "" {
+			url = r.browserURL()
+		}
+		w.Write([]string{r.ID, r.Name, r.Bucket, r.ObjectPath, url, r.Description, status, t.get(row, "review_note")})
+		n++
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("unable to write review spreadsheet: %v", err)
+	}
+	return n, f.Close()
+}
+
+// importReview applies a reviewed spreadsheet's statuses, notes and edited
+// descriptions to the catalog, the objects' review-status metadata and
+// sidecars and, for approved descriptions, the Drive files' descriptions,
+// returning the number of rows imported and failed
+func importReview(ctx context.Context, catalogPath, sheetPath string, updateDrive bool) (int, int, error) {
+	sheet, err := readCatalog(sheetPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !sheet.has("drive_id") || !sheet.has("review_status") {
+		return 0, 0, fmt.Errorf("review spreadsheet %s needs drive_id and review_status columns", sheetPath)
+	}
+	for i, row := range sheet.rows {
+		status := strings.ToLower(strings.TrimSpace(sheet.get(row, "review_status")))
+		if status != "" && !slices.Contains(reviewStatuses, status) {
+			return 0, 0, fmt.Errorf("review spreadsheet row %d: unknown review status %q, expected one of %s", i+2, status, strings.Join(reviewStatuses, ", "))
+		}
+		sheet.set(row, "review_status", status)
+	}
+	catalog, err := readCatalog(catalogPath)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("no catalog %s, updating objects and Drive only", catalogPath)
+		catalog = nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	imported, failed := 0, 0
+	for _, row := range sheet.rows {
+		id := sheet.get(row, "drive_id")
+		status := sheet.get(row, "review_status")
+		if id == "" || status == "" {
+			continue
+		}
+		r := record{
+			ID:           id,
+			Bucket:       sheet.get(row, "bucket"),
+			ObjectPath:   sheet.get(row, "object_path"),
+			Description:  sheet.get(row, "description"),
+			ReviewStatus: status,
+			ReviewNote:   sheet.get(row, "review_note"),
+		}
+		var catalogRow []string
+		if catalog != nil {
+			catalogRow = catalog.find(id)
+		}
+		if catalogRow != nil {
+			if r.ObjectPath == "" {
+				r.Bucket, r.ObjectPath = catalog.get(catalogRow, "bucket"), catalog.get(catalogRow, "object_path")
+			}
+			if !sheet.has("description") {
+				r.Description = catalog.get(catalogRow, "description")
+			}
+			if catalog.get(catalogRow, "description") != r.Description {
+				r.EditedAt = now
+				catalog.set(catalogRow, "edited_at", now)
+			}
+			catalog.set(catalogRow, "description", r.Description)
+			catalog.set(catalogRow, "review_status", r.ReviewStatus)
+			catalog.set(catalogRow, "review_note", r.ReviewNote)
+		}
+		if err := applyReview(ctx, r, updateDrive, now); err != nil {
+			log.Printf("%s: %v", id, err)
+			failed++
+			continue
+		}
+		imported++
+	}
+	if catalog != nil {
+		if err := catalog.write(catalogPath); err != nil {
+			return imported, failed, err
+		}
+	}
+	return imported, failed, nil
+}
+
+// applyReview labels a reviewed file's object with its review status, records
+// the review and any edited description in its sidecar, and writes an
+// approved description to Drive
+func applyReview(ctx context.Context, r record, updateDrive bool, now string) error {
+	if r.ObjectPath != "" {
+		if err := storageSrv.UpdateMetadata(ctx, r.bucket(), r.ObjectPath, map[string]string{reviewStatusKey: r.ReviewStatus}); err != nil {
+			return fmt.Errorf("unable to update metadata of %s: %w", r.gcsURI(), err)
+		}
+		b, err := storageSrv.Read(ctx, r.bucket(), sidecarPath(r.ObjectPath))
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("unable to read sidecar: %w", err)
+		}
+		if err == nil {
+			var sidecar record
+			if err := json.Unmarshal(b, &sidecar); err != nil {
+				return fmt.Errorf("unable to parse sidecar %s: %v", sidecarPath(r.ObjectPath), err)
+			}
+			if r.Description != "" && r.Description != sidecar.Description {
+				if !sidecar.NeedsReview {
+					sidecar.DescriptionVersions = append(sidecar.DescriptionVersions, sidecar.version())
+				}
+				sidecar.Description, sidecar.EditedAt = r.Description, now
+				sidecar.Model, sidecar.Prompt, sidecar.DescribedAt = "", "", ""
+			}
+			sidecar.ReviewStatus, sidecar.ReviewNote = r.ReviewStatus, r.ReviewNote
+			if err := writeSidecar(ctx, sidecar); err != nil {
+				return err
+			}
+		}
+	}
+	if updateDrive && r.ReviewStatus == reviewApproved && r.Description != "" {
+		if err := driveSrv.UpdateDescription(ctx, r.ID, r.Description); err != nil {
+			return fmt.Errorf("unable to update Drive description: %w", err)
+		}
+	}
+	return nil
+}
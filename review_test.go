@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestDescribeFailureQuarantinesFile(t *testing.T) {
+	f := useFakes(t)
+	prev := reviews
+	reviews = &reviewQueue{}
+	defer func() { reviews = prev }()
+	gcsFolderPath = "assets"
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+	f.generator.err = errors.New("model unavailable")
+
+	r := processFile(context.Background(), drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}, "a.jpg")
+	if !r.NeedsReview || r.ObjectPath != "assets/needs-review/a.jpg" || r.Size != 1 {
+		t.Errorf("record = %+v, want quarantined under assets/needs-review", r)
+	}
+	attrs, ok := f.storage.attrs["test-bucket/assets/needs-review/a.jpg"]
+	if !ok || attrs.Metadata[reviewStatusKey] != "needs-review" {
+		t.Errorf("quarantined object attrs = %+v, want review-status metadata", attrs)
+	}
+
+	path := filepath.Join(t.TempDir(), "needs-review.csv")
+	if err := reviews.write(path); err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, path)
+	if len(rows) != 2 || rows[1][0] != "1" || rows[1][2] != "gs://test-bucket/assets/needs-review/a.jpg" || rows[1][3] != "Error: model unavailable" {
+		t.Errorf("review queue = %v", rows)
+	}
+	if ids, err := readManifest(path); err != nil || len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("review queue as manifest = %v, %v", ids, err)
+	}
+}
+
+func TestEmptyReviewPrefixLabelsInPlace(t *testing.T) {
+	f := useFakes(t)
+	prevPrefix, prevReviews := reviewPrefix, reviews
+	reviewPrefix, reviews = "", &reviewQueue{}
+	defer func() { reviewPrefix, reviews = prevPrefix, prevReviews }()
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("a"))
+	f.generator.err = errors.New("model unavailable")
+
+	r := processFile(context.Background(), drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}, "a.jpg")
+	if !r.NeedsReview || r.ObjectPath != "a.jpg" {
+		t.Errorf("record = %+v, want labeled in place", r)
+	}
+	if got := f.storage.attrs["test-bucket/a.jpg"].Metadata[reviewStatusKey]; got != "needs-review" {
+		t.Errorf("review-status = %q, want needs-review", got)
+	}
+}
+
+func TestReviewExportImport(t *testing.T) {
+	f := useFakes(t)
+	prev := writeSidecars
+	writeSidecars = true
+	defer func() { writeSidecars = prev }()
+	f.drive.add("a", "a.png", "image/png", "root", []byte("a"))
+	f.drive.add("b", "b.png", "image/png", "root", []byte("b"))
+
+	dir := t.TempDir()
+	catalog := filepath.Join(dir, "descriptions.csv")
+	w, err := newCSVRecordWriter(catalog, []string{"drive_id", "name", "bucket", "object_path", "description", "review_status", "review_note", "edited_at"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		r := processFile(context.Background(), *f.drive.files[id], id+".png")
+		if r.ReviewStatus != reviewPending || f.storage.attrs["test-bucket/"+id+".png"].Metadata[reviewStatusKey] != reviewPending {
+			t.Errorf("record %s review status = %q, want pending in the record and metadata", id, r.ReviewStatus)
+		}
+		w.Write(r)
+	}
+	w.Close()
+
+	sheet := filepath.Join(dir, "review.csv")
+	if n, err := exportReview(catalog, sheet, []string{reviewPending}); err != nil || n != 2 {
+		t.Fatalf("exportReview() = %d, %v, want 2", n, err)
+	}
+	rows := readCSV(t, sheet)
+	if rows[1][0] != "a" || rows[1][4] != "https://storage.cloud.google.com/test-bucket/a.png" || rows[1][6] != reviewPending {
+		t.Errorf("review sheet row = %v", rows[1])
+	}
+
+	// the reviewer approves a with an edit and rejects b
+	sheetRows := "drive_id,name,bucket,object_path,url,description,review_status,review_note\n" +
+		"a,a.png,test-bucket,a.png,,A better description.,Approved,fixed the color\n" +
+		"b,b.png,test-bucket,b.png,,A test description.,rejected,\n"
+	if err := os.WriteFile(sheet, []byte(sheetRows), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, failed, err := importReview(context.Background(), catalog, sheet, true)
+	if err != nil || n != 2 || failed != 0 {
+		t.Fatalf("importReview() = %d, %d, %v, want 2 imported", n, failed, err)
+	}
+
+	rows = readCSV(t, catalog)
+	if rows[1][4] != "A better description." || rows[1][5] != reviewApproved || rows[1][6] != "fixed the color" || rows[1][7] == "" {
+		t.Errorf("catalog row a = %v, want the edit and approval", rows[1])
+	}
+	if rows[2][4] != "A test description." || rows[2][5] != reviewRejected || rows[2][7] != "" {
+		t.Errorf("catalog row b = %v, want rejected and unedited", rows[2])
+	}
+	if got := f.storage.attrs["test-bucket/b.png"].Metadata[reviewStatusKey]; got != reviewRejected {
+		t.Errorf("b review-status metadata = %q, want rejected", got)
+	}
+	var sidecar record
+	if err := json.Unmarshal(f.storage.objects["test-bucket/a.png.json"], &sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.Description != "A better description." || sidecar.ReviewStatus != reviewApproved || sidecar.EditedAt == "" ||
+		len(sidecar.DescriptionVersions) != 1 || sidecar.DescriptionVersions[0].Description != "A test description." {
+		t.Errorf("sidecar = %+v, want the edit with the generated description as a prior version", sidecar)
+	}
+	if got := f.drive.files["a"].Description; got != "A better description." {
+		t.Errorf("Drive description of a = %q, want the approved description", got)
+	}
+	if got := f.drive.files["b"].Description; got != "" {
+		t.Errorf("Drive description of b = %q, want rejected descriptions left out", got)
+	}
+
+	if err := os.WriteFile(sheet, []byte("drive_id,review_status\na,maybe\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := importReview(context.Background(), catalog, sheet, true); err == nil {
+		t.Error("importReview() with an unknown status = nil error, want error")
+	}
+}
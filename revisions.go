@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+
+	"cloud.google.com/go/storage"
+)
+
+var migrateRevisions bool
+
+// revisionPath returns the object path of a file revision
+func revisionPath(objectPath, revisionID string) string {
+	return path.Join(objectPath, "revisions", revisionID)
+}
+
+// uploadRevisions downloads every Drive revision of a file and uploads each to
+// <object>/revisions/<revisionId>, returning the revision object paths
+func uploadRevisions(ctx context.Context, fileID, bucket, objectPath string) ([]string, error) {
+	revisions, err := driveSrv.Revisions(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list revisions: %w", err)
+	}
+
+	var paths []string
+	for _, rev := range revisions {
+		revPath := revisionPath(objectPath, rev.Id)
+		if !alwaysUploadToGCS {
+			_, err := storageSrv.Attrs(ctx, bucket, revPath)
+			if err == nil {
+				paths = append(paths, revPath)
+				continue
+			}
+			if !errors.Is(err, storage.ErrObjectNotExist) {
+				return paths, fmt.Errorf("failed to check revision existence: %w", err)
+			}
+		}
+
+		body, err := driveSrv.DownloadRevision(ctx, fileID, rev.Id)
+		if err != nil {
+			return paths, fmt.Errorf("unable to download revision %s: %w", rev.Id, err)
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return paths, fmt.Errorf("unable to read revision %s: %w", rev.Id, err)
+		}
+
+		attrs := storage.ObjectAttrs{
+			ContentType: rev.MimeType,
+			Metadata: map[string]string{
+				"drive-file-id":       fileID,
+				"drive-revision-id":   rev.Id,
+				"drive-modified-time": rev.ModifiedTime,
+				runIDKey:              runID,
+			},
+		}
+		if _, err := storageSrv.Upload(ctx, bucket, revPath, data, attrs); err != nil {
+			return paths, err
+		}
+		log.Printf("uploaded revision to %s/%s", bucket, revPath)
+		paths = append(paths, revPath)
+	}
+	return paths, nil
+}
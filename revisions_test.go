@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestUploadRevisions(t *testing.T) {
+	f := useFakes(t)
+	f.drive.add("1", "doc.pdf", "application/pdf", "folder1", []byte("v3"))
+	f.drive.addRevision("1", "r1", []byte("v1"))
+	f.drive.addRevision("1", "r2", []byte("v2"))
+	f.drive.addRevision("1", "r3", []byte("v3"))
+	f.storage.Upload(context.Background(), "test-bucket", "media/doc.pdf/revisions/r1", []byte("already"), storage.ObjectAttrs{})
+
+	paths, err := uploadRevisions(context.Background(), "1", "test-bucket", "media/doc.pdf")
+	if err != nil {
+		t.Fatalf("uploadRevisions() error = %v", err)
+	}
+	if got := strings.Join(paths, ","); got != "media/doc.pdf/revisions/r1,media/doc.pdf/revisions/r2,media/doc.pdf/revisions/r3" {
+		t.Errorf("uploadRevisions() = %s", got)
+	}
+	if got := string(f.storage.objects["test-bucket/media/doc.pdf/revisions/r1"]); got != "already" {
+		t.Errorf("existing revision overwritten with %q", got)
+	}
+	if got := string(f.storage.objects["test-bucket/media/doc.pdf/revisions/r2"]); got != "v2" {
+		t.Errorf("revision r2 = %q, want v2", got)
+	}
+	if got := f.storage.attrs["test-bucket/media/doc.pdf/revisions/r3"].Metadata["drive-revision-id"]; got != "r3" {
+		t.Errorf("revision metadata = %q, want r3", got)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "github.com/google/uuid"
+
+// runID identifies the run that produced each object and catalog record
+var runID string
+
+// runIDKey is the object metadata key holding the run ID
+const runIDKey = "drive-to-gcs-run-id"
+
+// newRunID returns a new random run ID
+func newRunID() string {
+	return uuid.NewString()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clamdAddress is the ClamAV daemon to scan files with, host:port or the path
+// of its unix socket, empty to skip scanning
+var clamdAddress string
+
+// clamdChunkSize is the size of the chunks streamed to clamd
+const clamdChunkSize = 1 << 20
+
+// clamdTimeout bounds a scan
+const clamdTimeout = 5 * time.Minute
+
+// stageScan is the stats category of virus scans
+const stageScan = "scan"
+
+// infectedError is returned for files the scanner found a virus in
+type infectedError struct {
+	signature string
+}
+
+func (e *infectedError) Error() string {
+	return fmt.Sprintf("infected: %s", e.signature)
+}
+
+// scanClamd scans data with the clamd INSTREAM command, returning an
+// infectedError if a virus is found
+func scanClamd(ctx context.Context, address string, data []byte) error {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("unable to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(clamdTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("unable to write to clamd: %w", err)
+	}
+	size := make([]byte, 4)
+	for chunk := range slices.Chunk(data, clamdChunkSize) {
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		if _, err := conn.Write(size); err != nil {
+			return fmt.Errorf("unable to stream to clamd: %w", err)
+		}
+		if _, err := conn.Write(chunk); err != nil {
+			return fmt.Errorf("unable to stream to clamd: %w", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("unable to stream to clamd: %w", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("unable to read clamd reply: %w", err)
+	}
+	result := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+	result = strings.TrimPrefix(result, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &infectedError{signature: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("clamd: %s", result)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeClamd serves the clamd INSTREAM command, finding streams containing
+// "EICAR" infected
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					io.CopyN(&data, r, int64(size))
+				}
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					return
+				}
+				conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestScanClamd(t *testing.T) {
+	address := fakeClamd(t)
+	if err := scanClamd(context.Background(), address, bytes.Repeat([]byte("a"), clamdChunkSize+1)); err != nil {
+		t.Errorf("scanClamd() error = %v, want clean", err)
+	}
+	err := scanClamd(context.Background(), address, []byte("X5O!P%@AP EICAR"))
+	if ierr, ok := err.(*infectedError); !ok || ierr.signature != "Eicar-Test-Signature" {
+		t.Errorf("scanClamd() error = %v, want infected", err)
+	}
+}
+
+func TestInfectedFilesAreSkipped(t *testing.T) {
+	f := useFakes(t)
+	prev := clamdAddress
+	clamdAddress = fakeClamd(t)
+	defer func() { clamdAddress = prev }()
+	f.drive.add("1", "virus.exe", "application/octet-stream", "folder1", []byte("EICAR"))
+
+	r := processFile(context.Background(), drive.File{Id: "1", Name: "virus.exe", MimeType: "application/octet-stream"}, "virus.exe")
+	if f.storage.uploads != 0 || f.generator.calls != 0 {
+		t.Errorf("infected file was uploaded %d times and described %d times", f.storage.uploads, f.generator.calls)
+	}
+	if r.Description != "Error: infected: Eicar-Test-Signature" || r.ObjectPath != "" {
+		t.Errorf("record = %+v, want infected and no object", r)
+	}
+	if _, err := os.Stat(filepath.Join(localFolderName, "virus.exe")); !os.IsNotExist(err) {
+		t.Errorf("infected local file not removed: %v", err)
+	}
+	summary := stats.summary()
+	if summary.Skipped["infected"] != 1 || len(summary.FailedFiles) != 1 || summary.FailedFiles[0].Stage != stageScan {
+		t.Errorf("summary = %+v, want infected file reported", summary)
+	}
+}
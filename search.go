@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/genai"
+)
+
+// The flags of the search command
+var (
+	searchCatalog  string = catalogFile
+	searchLimit    int    = 10
+	searchSemantic bool
+	searchJSON     bool
+	embeddingModel string = "text-embedding-005"
+)
+
+// embedBatch is how many descriptions are embedded per request
+const embedBatch = 50
+
+// newEmbedder returns the client embedding descriptions and queries
+var newEmbedder = func(ctx context.Context) (embedder, error) {
+	if backend == backendVertex && projectID == "" {
+		return nil, errors.New("-semantic needs a project, set with -project or PROJECT_ID")
+	}
+	if err := loadVertexCredentials(ctx); err != nil {
+		return nil, fmt.Errorf("unable to read -vertex-credentials: %v", err)
+	}
+	client, err := genai.NewClient(ctx, genaiConfig(location))
+	if err != nil {
+		return nil, err
+	}
+	return client.Models, nil
+}
+
+// searchFlags registers the search command's flags
+func searchFlags() {
+	flag.StringVar(&searchCatalog, "catalog", searchCatalog, "the CSV catalog to search, a local path or gs://bucket/object")
+	flag.IntVar(&searchLimit, "limit", searchLimit, "the most matches to print")
+	flag.BoolVar(&searchSemantic, "semantic", searchSemantic, "rank the descriptions by the similarity of their embeddings to the query's, rather than by keywords")
+	flag.BoolVar(&searchJSON, "json", searchJSON, "print the matches as JSON lines")
+	flag.StringVar(&embeddingModel, "embedding-model", embeddingModel, "the model embedding the descriptions and query with -semantic")
+}
+
+// searchMatch is a catalog entry matching a search
+type searchMatch struct {
+	Name        string  `json:"name"`
+	DriveID     string  `json:"drive_id,omitempty"`
+	GCSURI      string  `json:"gcs_uri,omitempty"`
+	Description string  `json:"description"`
+	Score       float64 `json:"score"`
+}
+
+// runSearch runs drivetogcs search "sunset beach", printing the catalog
+// entries whose name, tags or description match the query, best first
+func runSearch(ctx context.Context, args []string) int {
+	query := strings.TrimSpace(strings.Join(args, " "))
+	if query == "" || searchLimit < 1 {
+		fmt.Fprintln(os.Stderr, `usage: drivetogcs search [-catalog descriptions.csv] [-limit 10] [-semantic] [-json] "sunset beach"`)
+		return exitFailure
+	}
+	closeStorage, err := catalogStorage(ctx, searchCatalog)
+	if err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	defer closeStorage()
+	catalog, err := loadCatalog(ctx, searchCatalog)
+	if err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	var matches []searchMatch
+	if searchSemantic {
+		e, err := newEmbedder(ctx)
+		if err != nil {
+			log.Printf("unable to create the embedding client: %v", err)
+			return exitFailure
+		}
+		if matches, err = semanticSearch(ctx, e, catalog, query); err != nil {
+			log.Printf("%v", err)
+			return exitFailure
+		}
+	} else {
+		matches = keywordSearch(catalog, query)
+	}
+	if len(matches) > searchLimit {
+		matches = matches[:searchLimit]
+	}
+	if len(matches) == 0 {
+		log.Printf("no entries of %s match %q", searchCatalog, query)
+	}
+	printMatches(os.Stdout, matches)
+	return exitSuccess
+}
+
+// catalogStorage creates the storage client reading a gs:// catalog, if path
+// is one, returning a function closing it
+func catalogStorage(ctx context.Context, path string) (func(), error) {
+	if !strings.HasPrefix(path, "gs://") {
+		return func() {}, nil
+	}
+	opts, err := storageOptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read -storage-credentials: %v", err)
+	}
+	gcsClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create storage client: %v", err)
+	}
+	storageSrv = &gcsStorage{client: gcsClient}
+	return func() { gcsClient.Close() }, nil
+}
+
+// loadCatalog reads a CSV catalog from a local path or gs://bucket/object
+func loadCatalog(ctx context.Context, path string) (*catalogTable, error) {
+	if !strings.HasPrefix(path, "gs://") {
+		return readCatalog(path)
+	}
+	bucket, object, err := parseGCSURI(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := storageSrv.Read(ctx, bucket, object)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog %s: %w", path, err)
+	}
+	return parseCatalog(path, bytes.NewReader(data))
+}
+
+// searchTerms splits text into lowercase words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// keywordSearch returns the entries matching every word of the query, as a
+// prefix of a word of their name, tags or description, scored by the words
+// matched, those of the name counting thrice and tags twice
+func keywordSearch(catalog *catalogTable, query string) []searchMatch {
+	terms := searchTerms(query)
+	var matches []searchMatch
+	for _, row := range catalog.rows {
+		fields := []struct {
+			words  []string
+			weight float64
+		}{
+			{searchTerms(catalog.get(row, "name")), 3},
+			{searchTerms(catalog.get(row, "tags")), 2},
+			{searchTerms(catalog.get(row, "description")), 1},
+		}
+		var score float64
+		matchesAll := true
+		for _, term := range terms {
+			var termScore float64
+			for _, f := range fields {
+				for _, w := range f.words {
+					if strings.HasPrefix(w, term) {
+						termScore += f.weight
+					}
+				}
+			}
+			if termScore == 0 {
+				matchesAll = false
+				break
+			}
+			score += termScore
+		}
+		if matchesAll {
+			matches = append(matches, newSearchMatch(catalog, row, score))
+		}
+	}
+	sortMatches(matches)
+	return matches
+}
+
+// semanticSearch returns the described entries, scored by the cosine
+// similarity of the embeddings of their description and the query. Every
+// description is embedded on each search.
+func semanticSearch(ctx context.Context, e embedder, catalog *catalogTable, query string) ([]searchMatch, error) {
+	resp, err := e.EmbedContent(ctx, embeddingModel, genai.Text(query), &genai.EmbedContentConfig{TaskType: "RETRIEVAL_QUERY"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to embed the query: %w", err)
+	}
+	if len(resp.Embeddings) != 1 {
+		return nil, fmt.Errorf("unable to embed the query: %d embeddings returned", len(resp.Embeddings))
+	}
+	q := resp.Embeddings[0].Values
+
+	var rows [][]string
+	for _, row := range catalog.rows {
+		if d := catalog.get(row, "description"); d != "" && !strings.HasPrefix(d, "Error:") {
+			rows = append(rows, row)
+		}
+	}
+	var matches []searchMatch
+	for batch := range slices.Chunk(rows, embedBatch) {
+		contents := make([]*genai.Content, len(batch))
+		for i, row := range batch {
+			contents[i] = &genai.Content{Parts: []*genai.Part{{Text: catalog.get(row, "description")}}}
+		}
+		resp, err := e.EmbedContent(ctx, embeddingModel, contents, &genai.EmbedContentConfig{TaskType: "RETRIEVAL_DOCUMENT", AutoTruncate: true})
+		if err != nil {
+			return nil, fmt.Errorf("unable to embed descriptions: %w", err)
+		}
+		if len(resp.Embeddings) != len(batch) {
+			return nil, fmt.Errorf("unable to embed descriptions: %d embeddings returned for %d", len(resp.Embeddings), len(batch))
+		}
+		for i, row := range batch {
+			matches = append(matches, newSearchMatch(catalog, row, cosineSimilarity(q, resp.Embeddings[i].Values)))
+		}
+	}
+	sortMatches(matches)
+	return matches, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// newSearchMatch returns a catalog row as a match. Catalogs without the
+// gcs_uri column are taken to be in their bucket column, or else in the
+// -gcs-bucket, defaulting to PROJECT_ID-media as in a run.
+func newSearchMatch(catalog *catalogTable, row []string, score float64) searchMatch {
+	m := searchMatch{
+		Name:        catalog.get(row, "name"),
+		DriveID:     catalog.get(row, "drive_id"),
+		GCSURI:      catalog.get(row, "gcs_uri"),
+		Description: catalog.get(row, "description"),
+		Score:       score,
+	}
+	if object := catalog.get(row, "object_path"); m.GCSURI == "" && object != "" {
+		bucket := cmp.Or(catalog.get(row, "bucket"), gcsBucket)
+		if bucket == "" && projectID != "" {
+			bucket = projectID + "-media"
+		}
+		if bucket != "" {
+			m.GCSURI = "gs://" + objectPath(bucket, object)
+		}
+	}
+	return m
+}
+
+// sortMatches sorts matches by score, best first, then by name
+func sortMatches(matches []searchMatch) {
+	slices.SortStableFunc(matches, func(a, b searchMatch) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Name, b.Name))
+	})
+}
+
+// printMatches prints the matches, with a line of each description
+func printMatches(w io.Writer, matches []searchMatch) {
+	if searchJSON {
+		enc := json.NewEncoder(w)
+		for _, m := range matches {
+			enc.Encode(m)
+		}
+		return
+	}
+	for i, m := range matches {
+		fmt.Fprintf(w, "%d. %s  %s\n", i+1, m.Name, cmp.Or(m.GCSURI, m.DriveID))
+		if d := strings.Join(strings.Fields(m.Description), " "); d != "" {
+			if r := []rune(d); len(r) > 160 {
+				d = string(r[:160]) + "…"
+			}
+			fmt.Fprintf(w, "   %s\n", d)
+		}
+	}
+}
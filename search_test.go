@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/genai"
+)
+
+const searchTestCatalog = `name,drive_id,bucket,object_path,tags,description
+beach.jpg,1,media,photos/beach.jpg,beach;sunset,A sunset over a sandy beach.
+sunset-beach.png,2,media,photos/sunset-beach.png,,Waves at dusk.
+mountain.jpg,3,,photos/mountain.jpg,mountain,A mountain at sunset.
+broken.jpg,4,,photos/broken.jpg,,Error: unable to describe
+`
+
+func writeSearchCatalog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	if err := os.WriteFile(path, []byte(searchTestCatalog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeywordSearch(t *testing.T) {
+	useFakes(t)
+	gcsBucket = "default-bucket"
+	catalog, err := readCatalog(writeSearchCatalog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := keywordSearch(catalog, "Sunset BEACH")
+	var names []string
+	for _, m := range matches {
+		names = append(names, m.Name)
+	}
+	// beach.jpg matches in its name, tags and description
+	if strings.Join(names, ",") != "beach.jpg,sunset-beach.png" {
+		t.Errorf("keywordSearch = %v, want beach.jpg,sunset-beach.png", names)
+	}
+	if matches[0].GCSURI != "gs://media/photos/beach.jpg" {
+		t.Errorf("GCSURI = %q", matches[0].GCSURI)
+	}
+	matches = keywordSearch(catalog, "mount")
+	if len(matches) != 1 || matches[0].GCSURI != "gs://default-bucket/photos/mountain.jpg" {
+		t.Errorf("keywordSearch(mount) = %+v", matches)
+	}
+	if matches := keywordSearch(catalog, "sunset forest"); len(matches) != 0 {
+		t.Errorf("keywordSearch(sunset forest) = %+v, want none", matches)
+	}
+}
+
+// fakeEmbedder embeds text as the counts of the words sunset and mountain
+type fakeEmbedder struct {
+	requests int
+}
+
+func (e *fakeEmbedder) EmbedContent(ctx context.Context, model string, contents []*genai.Content, config *genai.EmbedContentConfig) (*genai.EmbedContentResponse, error) {
+	e.requests++
+	resp := &genai.EmbedContentResponse{}
+	for _, c := range contents {
+		text := strings.ToLower(c.Parts[0].Text)
+		resp.Embeddings = append(resp.Embeddings, &genai.ContentEmbedding{Values: []float32{
+			float32(strings.Count(text, "sunset")), float32(strings.Count(text, "mountain")), 0.1,
+		}})
+	}
+	return resp, nil
+}
+
+func TestSemanticSearch(t *testing.T) {
+	f := useFakes(t)
+	f.storage.upload("media", "catalog/descriptions.csv", []byte(searchTestCatalog), storage.ObjectAttrs{})
+	catalog, err := loadCatalog(context.Background(), "gs://media/catalog/descriptions.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &fakeEmbedder{}
+	matches, err := semanticSearch(context.Background(), e, catalog, "mountains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failed description isn't embedded
+	if len(matches) != 3 || matches[0].Name != "mountain.jpg" || e.requests != 2 {
+		t.Errorf("semanticSearch = %+v after %d requests", matches, e.requests)
+	}
+}
+
+func TestPrintMatches(t *testing.T) {
+	var buf bytes.Buffer
+	printMatches(&buf, []searchMatch{{Name: "beach.jpg", GCSURI: "gs://media/beach.jpg", Description: "A sunset\n over a beach."}, {Name: "x.jpg", DriveID: "9"}})
+	want := "1. beach.jpg  gs://media/beach.jpg\n   A sunset over a beach.\n2. x.jpg  9\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printMatches = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghchinoy/drivetogcs/jobspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The -grpc-addr and -jobs-dir of the serve command
+var (
+	grpcAddr string = "unix:drivetogcs-jobs.sock"
+	jobsDir  string = "jobs"
+)
+
+// jobRetention is how long the server keeps a finished job, after which the
+// API no longer knows it, though its directory remains
+var jobRetention = 24 * time.Hour
+
+// progressInterval is how often StreamProgress sends the progress of a job
+var progressInterval = 2 * time.Second
+
+// The files a job writes in its directory
+const (
+	jobLog     = "job.log"
+	jobStatus  = "run-status.json"
+	jobSocket  = "control.sock"
+	jobCatalog = "descriptions.csv"
+)
+
+// jobColumns are the catalog columns a job writes, those ListFiles returns
+var jobColumns = []string{"name", "size", "mime_type", "drive_id", "gcs_uri", "description"}
+
+// jobArgFlags are the flags a CreateJob request can set in its args, those
+// tuning how files are processed. Flags of paths, addresses, credentials,
+// endpoints and hooks, which would let a request read or write the server's
+// files or run commands as its user, and those the server sets, aren't.
+var jobArgFlags = []string{
+	"always-upload", "animation", "backend", "blur-faces", "cache-control", "concurrency",
+	"describe", "describe-from-gcs", "describe-timeout", "embed-metadata", "expand-zips",
+	"export-comments", "export-media-metadata", "export-permissions", "faces",
+	"fail-on-changed-content", "flush-every", "gcs-bucket", "gcs-path", "layout", "location",
+	"lock", "lock-ttl", "max", "max-bytes", "max-inline", "max-side", "max-tags", "memory-limit",
+	"mime-types", "model", "on-collision", "owned-by", "partition", "partition-since", "pii",
+	"pii-bucket", "quota-cooldown", "quota-retries", "recursive", "redescribe-if-prompt-changed",
+	"refine", "replace-char", "replica-buckets", "revisions", "search-grounding", "shard",
+	"sidecar", "signed-url-ttl", "speech-language", "stall-timeout", "system-instruction",
+	"tag-categories", "tags", "verify-sample", "watermark-text",
+}
+
+// jobCommand returns the command running a job, a drivetogcs run in the job's
+// directory
+var jobCommand = func(dir string, args []string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	return cmd, nil
+}
+
+// serveFlags registers the serve command's flags
+func serveFlags() {
+	flag.StringVar(&grpcAddr, "grpc-addr", grpcAddr, "where to serve the jobs API: a unix socket, as unix:/path/to.sock, or a localhost address")
+	flag.StringVar(&jobsDir, "jobs-dir", jobsDir, "the directory each job runs in, in a subdirectory named after its ID")
+	flag.DurationVar(&jobRetention, "job-retention", jobRetention, "how long a finished job is kept, after which the API no longer knows it; its directory remains")
+	flag.StringVar(&assetsAddr, "http-addr", assetsAddr, "where to serve GET /assets, the entries of -catalog, as for -grpc-addr, empty not to")
+	flag.StringVar(&searchCatalog, "catalog", searchCatalog, "the CSV catalog GET /assets returns, a local path or gs://bucket/object")
+}
+
+// runServe runs drivetogcs serve, serving the jobs API, so orchestration
+// systems can start migrations and follow them over gRPC. Each job is a
+// drivetogcs run, with the server's environment, in its own directory under
+// -jobs-dir, where it writes its log, catalog and run status.
+func runServe(ctx context.Context, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs serve [-grpc-addr unix:drivetogcs-jobs.sock] [-jobs-dir jobs] [-http-addr localhost:8080 [-catalog descriptions.csv]]")
+		return exitFailure
+	}
+	if err := os.MkdirAll(jobsDir, 0700); err != nil {
+		log.Printf("unable to create -jobs-dir: %v", err)
+		return exitFailure
+	}
+	ln, err := localListener(grpcAddr, "-grpc-addr")
+	if err != nil {
+		log.Printf("unable to serve the jobs API: %v", err)
+		return exitFailure
+	}
+	if assetsAddr != "" {
+		closeStorage, err := catalogStorage(ctx, searchCatalog)
+		if err != nil {
+			log.Printf("%v", err)
+			return exitFailure
+		}
+		defer closeStorage()
+		assetsLn, err := localListener(assetsAddr, "-http-addr")
+		if err != nil {
+			log.Printf("unable to serve the assets API: %v", err)
+			return exitFailure
+		}
+		srv := &http.Server{Handler: assetsHandler(searchCatalog), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.Serve(assetsLn); err != nil {
+				log.Printf("assets API: %v", err)
+			}
+		}()
+		log.Printf("serving GET /assets of %s on %s", searchCatalog, assetsAddr)
+	}
+	srv := grpc.NewServer()
+	jobspb.RegisterJobsServer(srv, newJobServer(jobsDir))
+	log.Printf("serving the jobs API on %s, running jobs in %s", grpcAddr, jobsDir)
+	if err := srv.Serve(ln); err != nil {
+		log.Printf("%v", err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// jobServer implements the jobs API, keeping the jobs it started in memory
+type jobServer struct {
+	jobspb.UnimplementedJobsServer
+	dir  string
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobServer(dir string) *jobServer {
+	return &jobServer{dir: dir, jobs: map[string]*job{}}
+}
+
+// job is a drivetogcs run started by the server
+type job struct {
+	id, dir string
+	args    []string
+	created time.Time
+	control *http.Client
+	// done is closed when the run exits, after finished, exitCode and
+	// status are set
+	done     chan struct{}
+	finished time.Time
+	exitCode int
+	status   runStatus
+}
+
+// CreateJob starts a run of the request in a new job directory
+func (s *jobServer) CreateJob(ctx context.Context, req *jobspb.CreateJobRequest) (*jobspb.Job, error) {
+	id := newRunID()
+	args, err := jobArgs(id, req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	dir := filepath.Join(s.dir, id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to create job directory: %v", err)
+	}
+	logFile, err := os.Create(filepath.Join(dir, jobLog))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to create job log: %v", err)
+	}
+	cmd, err := jobCommand(dir, args)
+	if err != nil {
+		logFile.Close()
+		return nil, status.Errorf(codes.Internal, "unable to run job: %v", err)
+	}
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, status.Errorf(codes.Internal, "unable to run job: %v", err)
+	}
+	socket := filepath.Join(dir, jobSocket)
+	j := &job{
+		id:      id,
+		dir:     dir,
+		args:    args,
+		created: time.Now(),
+		control: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			}},
+		},
+		done: make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.prune()
+	s.jobs[id] = j
+	s.mu.Unlock()
+	log.Printf("job %s: started %s", id, strings.Join(args, " "))
+	go func() {
+		defer close(j.done)
+		defer logFile.Close()
+		cmd.Wait()
+		j.finished = time.Now()
+		j.exitCode = cmd.ProcessState.ExitCode()
+		j.status = readJobStatus(dir, j.exitCode)
+		log.Printf("job %s: %s, exit code %d", id, j.status.Status, j.exitCode)
+	}()
+	return j.proto(), nil
+}
+
+// jobArgs returns the flags of a job's run
+func jobArgs(id string, req *jobspb.CreateJobRequest) ([]string, error) {
+	if req.Folder == "" {
+		return nil, errors.New("folder is required")
+	}
+	args := []string{
+		"-run-id=" + id,
+		"-yes",
+		"-output-format=csv",
+		"-columns=" + strings.Join(jobColumns, ","),
+		"-status-file=" + jobStatus,
+		"-control=unix:" + jobSocket,
+		"-folder=" + req.Folder,
+	}
+	if req.Recursive {
+		args = append(args, "-recursive")
+	}
+	if req.GcsBucket != "" {
+		args = append(args, "-gcs-bucket="+req.GcsBucket)
+	}
+	if req.GcsPath != "" {
+		args = append(args, "-gcs-path="+req.GcsPath)
+	}
+	if len(req.MimeTypes) > 0 {
+		args = append(args, "-mime-types="+strings.Join(req.MimeTypes, ","))
+	}
+	if req.TransferOnly {
+		args = append(args, "-describe=false")
+	}
+	if req.MaxFiles > 0 {
+		args = append(args, fmt.Sprintf("-max=%d", req.MaxFiles))
+	}
+	if req.Shard != "" {
+		args = append(args, "-shard="+req.Shard)
+	}
+	for _, arg := range req.Args {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch {
+		case !strings.HasPrefix(arg, "-") || flag.Lookup(name) == nil:
+			return nil, fmt.Errorf("unknown flag %q, expected -flag or -flag=value", arg)
+		case !slices.Contains(jobArgFlags, name):
+			return nil, fmt.Errorf("-%s can't be set on a job", name)
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readJobStatus reads the run status a job wrote as it exited, or makes one
+// up from its exit code if it exited without writing it
+func readJobStatus(dir string, exitCode int) runStatus {
+	var s runStatus
+	b, err := os.ReadFile(filepath.Join(dir, jobStatus))
+	if err == nil {
+		err = json.Unmarshal(b, &s)
+	}
+	if err != nil {
+		s = runStatus{
+			Status:   statusNames[exitCode],
+			ExitCode: exitCode,
+			Message:  fmt.Sprintf("the run exited without a run status, see %s", filepath.Join(dir, jobLog)),
+		}
+		if s.Status == "" {
+			s.Status = statusNames[exitFailure]
+		}
+	}
+	return s
+}
+
+// job returns a job by ID
+func (s *jobServer) job(id string) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no job %q", id)
+	}
+	return j, nil
+}
+
+// prune forgets the jobs finished more than -job-retention ago, so a server
+// running for long doesn't keep every job; s.mu must be held
+func (s *jobServer) prune() {
+	for id, j := range s.jobs {
+		if j.finishedRun() && time.Since(j.finished) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// finishedRun reports whether the job's run has exited
+func (j *job) finishedRun() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// state returns the state of the job
+func (j *job) state() jobspb.JobState {
+	if !j.finishedRun() {
+		return jobspb.JobState_RUNNING
+	}
+	switch j.exitCode {
+	case exitSuccess:
+		return jobspb.JobState_SUCCEEDED
+	case exitPartial:
+		return jobspb.JobState_PARTIAL
+	default:
+		return jobspb.JobState_FAILED
+	}
+}
+
+// liveStatus asks a running job for its progress over its control endpoint
+func (j *job) liveStatus() (*controlStatus, error) {
+	resp, err := j.control.Get("http://" + jobSocket + "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var s controlStatus
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// proto returns the job as the API's Job
+func (j *job) proto() *jobspb.Job {
+	p := &jobspb.Job{
+		Id:         j.id,
+		State:      j.state(),
+		Args:       j.args,
+		CreateTime: timestamppb.New(j.created),
+	}
+	if p.State == jobspb.JobState_RUNNING {
+		// the run may not be serving its control endpoint yet
+		if s, err := j.liveStatus(); err == nil {
+			p.Files, p.Bytes = int64(s.Files), s.Bytes
+		}
+		return p
+	}
+	p.FinishTime = timestamppb.New(j.finished)
+	p.ExitCode = int32(j.exitCode)
+	p.Status = j.status.Status
+	p.Message = j.status.Message
+	if sum := j.status.Summary; sum != nil {
+		p.Files, p.Bytes = int64(sum.Files), sum.Bytes
+	}
+	return p
+}
+
+// progress returns the progress of the job, nil while the run isn't
+// serving its control endpoint
+func (j *job) progress() *jobspb.Progress {
+	p := &jobspb.Progress{JobId: j.id, State: j.state()}
+	if p.State == jobspb.JobState_RUNNING {
+		s, err := j.liveStatus()
+		if err != nil {
+			return nil
+		}
+		p.Files, p.Bytes = int64(s.Files), s.Bytes
+		p.Failed, p.Skipped = int64(sumCounts(s.Failures)), int64(sumCounts(s.Skipped))
+		p.Active, p.Concurrency, p.Paused = int32(s.Active), int32(s.Ceiling), s.Paused
+		p.ElapsedSeconds = s.ElapsedSeconds
+		return p
+	}
+	if sum := j.status.Summary; sum != nil {
+		p.Files, p.Bytes = int64(sum.Files), sum.Bytes
+		p.Failed, p.Skipped = int64(sumCounts(sum.Failures)), int64(sumCounts(sum.Skipped))
+		p.ElapsedSeconds = sum.ElapsedSeconds
+	}
+	return p
+}
+
+func sumCounts(counts map[string]int) int {
+	var n int
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+// GetJob returns a job
+func (s *jobServer) GetJob(ctx context.Context, req *jobspb.GetJobRequest) (*jobspb.Job, error) {
+	j, err := s.job(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return j.proto(), nil
+}
+
+// ListFiles returns a page of a job's catalog, read from the catalog being
+// written while the job runs, which has the records flushed so far
+func (s *jobServer) ListFiles(ctx context.Context, req *jobspb.ListFilesRequest) (*jobspb.ListFilesResponse, error) {
+	j, err := s.job(req.JobId)
+	if err != nil {
+		return nil, err
+	}
+	size := int(req.PageSize)
+	switch {
+	case size <= 0:
+		size = 100
+	case size > 1000:
+		size = 1000
+	}
+	var offset int
+	if req.PageToken != "" {
+		if offset, err = strconv.Atoi(req.PageToken); err != nil || offset < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token %q", req.PageToken)
+		}
+	}
+	catalog, err := j.catalog()
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	if catalog == nil {
+		return &jobspb.ListFilesResponse{}, nil // nothing written yet
+	}
+	resp := &jobspb.ListFilesResponse{}
+	for i := offset; i < len(catalog.rows) && i < offset+size; i++ {
+		row := catalog.rows[i]
+		fileSize, _ := strconv.ParseInt(catalog.get(row, "size"), 10, 64)
+		resp.Files = append(resp.Files, &jobspb.File{
+			Name:        catalog.get(row, "name"),
+			Size:        fileSize,
+			MimeType:    catalog.get(row, "mime_type"),
+			DriveId:     catalog.get(row, "drive_id"),
+			GcsUri:      catalog.get(row, "gcs_uri"),
+			Description: catalog.get(row, "description"),
+		})
+	}
+	if offset+size < len(catalog.rows) {
+		resp.NextPageToken = strconv.Itoa(offset + size)
+	}
+	return resp, nil
+}
+
+// catalog reads the catalog a job has written, the one being written while
+// it runs, or nil if it hasn't written one yet
+func (j *job) catalog() (*catalogTable, error) {
+	path := filepath.Join(j.dir, jobCatalog)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		path += pendingSuffix
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return readCatalog(path)
+}
+
+// StreamProgress sends a job's progress every progressInterval until it
+// finishes, and then its final progress
+func (s *jobServer) StreamProgress(req *jobspb.StreamProgressRequest, stream grpc.ServerStreamingServer[jobspb.Progress]) error {
+	j, err := s.job(req.JobId)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for !j.finishedRun() {
+		if p := j.progress(); p != nil {
+			if err := stream.Send(p); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-j.done:
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+	return stream.Send(j.progress())
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ghchinoy/drivetogcs/jobspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeJob waits for a release file, then writes a catalog and run status and
+// exits as a partial run
+const fakeJob = `
+while [ ! -e release ]; do sleep 0.01; done
+printf 'name,size,mime_type,drive_id,gcs_uri,description\n' > descriptions.csv
+for i in 1 2 3; do printf 'photo%s.jpg,10,image/jpeg,id%s,gs://bucket/photo%s.jpg,A photo.\n' $i $i $i >> descriptions.csv; done
+printf '{"status":"partial","exit_code":2,"summary":{"files":3,"bytes":30,"failures":{"describe":1},"skipped":{}}}' > run-status.json
+exit 2
+`
+
+func TestJobServer(t *testing.T) {
+	useFakes(t)
+	defer func(prev *throttle) { throttler = prev }(throttler)
+	throttler = newThrottle(4)
+	defer func(prev time.Duration) { progressInterval = prev }(progressInterval)
+	progressInterval = 10 * time.Millisecond
+	dirs := make(chan string, 1)
+	defer func(prev func(string, []string) (*exec.Cmd, error)) { jobCommand = prev }(jobCommand)
+	jobCommand = func(dir string, args []string) (*exec.Cmd, error) {
+		dirs <- dir
+		cmd := exec.Command("sh", "-c", fakeJob)
+		cmd.Dir = dir
+		return cmd, nil
+	}
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	jobspb.RegisterJobsServer(srv, newJobServer(t.TempDir()))
+	go srv.Serve(ln)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := jobspb.NewJobsClient(conn)
+	ctx := context.Background()
+
+	for _, req := range []*jobspb.CreateJobRequest{
+		{},
+		{Folder: "folder", Args: []string{"-control=localhost:1"}},
+		{Folder: "folder", Args: []string{"-hook-post-file=touch /tmp/pwned"}},
+		{Folder: "folder", Args: []string{"-token-file", "/etc/passwd"}},
+		{Folder: "folder", Args: []string{"-no-such-flag"}},
+		{Folder: "folder", Args: []string{"model"}},
+	} {
+		if _, err := client.CreateJob(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("CreateJob(%v) = %v, want InvalidArgument", req, err)
+		}
+	}
+	if _, err := client.GetJob(ctx, &jobspb.GetJobRequest{Id: "nope"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetJob(nope) = %v, want NotFound", err)
+	}
+
+	job, err := client.CreateJob(ctx, &jobspb.CreateJobRequest{Folder: "folder", Recursive: true, Args: []string{"-model=gemini-2.0-flash"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != jobspb.JobState_RUNNING || !slices.Contains(job.Args, "-folder=folder") || !slices.Contains(job.Args, "-model=gemini-2.0-flash") {
+		t.Errorf("CreateJob = %v", job)
+	}
+	dir := <-dirs
+
+	// the run's control endpoint
+	control, err := net.Listen("unix", filepath.Join(dir, jobSocket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctl := &http.Server{Handler: controlHandler()}
+	go ctl.Serve(control)
+	stats.addFile(1234)
+	if got, err := client.GetJob(ctx, &jobspb.GetJobRequest{Id: job.Id}); err != nil || got.State != jobspb.JobState_RUNNING || got.Bytes != 1234 {
+		t.Errorf("GetJob while running = %v, %v", got, err)
+	}
+
+	stream, err := client.StreamProgress(ctx, &jobspb.StreamProgressRequest{JobId: job.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.Recv()
+	if err != nil || first.State != jobspb.JobState_RUNNING || first.Concurrency != 4 {
+		t.Errorf("first progress = %v, %v", first, err)
+	}
+	ctl.Close()
+	if err := os.WriteFile(filepath.Join(dir, "release"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var last *jobspb.Progress
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = p
+	}
+	if last == nil || last.State != jobspb.JobState_PARTIAL || last.Files != 3 || last.Failed != 1 {
+		t.Errorf("last progress = %v", last)
+	}
+
+	got, err := client.GetJob(ctx, &jobspb.GetJobRequest{Id: job.Id})
+	if err != nil || got.State != jobspb.JobState_PARTIAL || got.ExitCode != exitPartial || got.Status != "partial" || got.Files != 3 || got.FinishTime == nil {
+		t.Errorf("GetJob = %v, %v", got, err)
+	}
+
+	page, err := client.ListFiles(ctx, &jobspb.ListFilesRequest{JobId: job.Id, PageSize: 2})
+	if err != nil || len(page.Files) != 2 || page.NextPageToken == "" {
+		t.Fatalf("ListFiles = %v, %v", page, err)
+	}
+	if f := page.Files[1]; f.Name != "photo2.jpg" || f.Size != 10 || f.GcsUri != "gs://bucket/photo2.jpg" || f.Description != "A photo." {
+		t.Errorf("file = %v", f)
+	}
+	page, err = client.ListFiles(ctx, &jobspb.ListFilesRequest{JobId: job.Id, PageSize: 2, PageToken: page.NextPageToken})
+	if err != nil || len(page.Files) != 1 || page.Files[0].DriveId != "id3" || page.NextPageToken != "" {
+		t.Errorf("ListFiles page 2 = %v, %v", page, err)
+	}
+}
+
+func TestJobArgsAllowlist(t *testing.T) {
+	for _, arg := range []string{"-hook-pre-upload=id", "-hook-post-describe=id", "-hook-post-file=id", "-config=/etc/passwd", "-prompt=/etc/passwd", "-clamd=localhost:3310", "-token-file=token.json", "-run-id=x"} {
+		if _, err := jobArgs("id", &jobspb.CreateJobRequest{Folder: "folder", Args: []string{arg}}); err == nil {
+			t.Errorf("jobArgs(%s) = nil, want error", arg)
+		}
+	}
+	args, err := jobArgs("id", &jobspb.CreateJobRequest{Folder: "folder", Args: []string{"-model=gemini-2.5-flash", "-concurrency=2"}})
+	if err != nil || !slices.Contains(args, "-concurrency=2") {
+		t.Errorf("jobArgs = %q, %v", args, err)
+	}
+}
+
+func TestJobRetention(t *testing.T) {
+	s := newJobServer(t.TempDir())
+	finished := func(at time.Time) *job {
+		j := &job{finished: at, done: make(chan struct{})}
+		close(j.done)
+		return j
+	}
+	s.jobs["old"] = finished(time.Now().Add(-jobRetention - time.Minute))
+	s.jobs["recent"] = finished(time.Now())
+	s.jobs["running"] = &job{done: make(chan struct{})}
+	if _, err := s.job("old"); status.Code(err) != codes.NotFound {
+		t.Errorf("job(old) = %v, want not found", err)
+	}
+	for _, id := range []string{"recent", "running"} {
+		if _, err := s.job(id); err != nil {
+			t.Errorf("job(%s) = %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// shardSpec is the -shard flag, i/n, empty to process all files
+var shardSpec string
+
+// shardIndex and shardCount select the shard of files processed by this run
+var shardIndex, shardCount int
+
+// parseShard parses a shard specification of the form i/n, with 0 <= i < n
+func parseShard(spec string) (int, int, error) {
+	if spec == "" {
+		return 0, 1, nil
+	}
+	is, ns, ok := strings.Cut(spec, "/")
+	i, ierr := strconv.Atoi(strings.TrimSpace(is))
+	n, nerr := strconv.Atoi(strings.TrimSpace(ns))
+	if !ok || ierr != nil || nerr != nil || n < 1 || i < 0 || i >= n {
+		return 0, 0, fmt.Errorf("invalid -shard %q, expected i/n with 0 <= i < n, e.g. 0/4", spec)
+	}
+	return i, n, nil
+}
+
+// inShard reports whether a file belongs to this run's shard, by the hash of
+// its Drive file ID, so every machine assigns files to the same shards
+func inShard(file drive.File) bool {
+	if shardCount <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(file.Id))
+	return int(h.Sum32()%uint32(shardCount)) == shardIndex
+}
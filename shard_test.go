@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestParseShard(t *testing.T) {
+	if i, n, err := parseShard("2/4"); err != nil || i != 2 || n != 4 {
+		t.Errorf("parseShard(2/4) = %d, %d, %v", i, n, err)
+	}
+	if i, n, err := parseShard(""); err != nil || i != 0 || n != 1 {
+		t.Errorf("parseShard() = %d, %d, %v, want all files", i, n, err)
+	}
+	for _, spec := range []string{"4/4", "-1/4", "1", "a/b", "0/0"} {
+		if _, _, err := parseShard(spec); err == nil {
+			t.Errorf("parseShard(%q) = nil error, want error", spec)
+		}
+	}
+}
+
+func TestShardsPartitionFiles(t *testing.T) {
+	prevIndex, prevCount := shardIndex, shardCount
+	defer func() { shardIndex, shardCount = prevIndex, prevCount }()
+
+	shardCount = 3
+	seen := map[string]int{}
+	for shardIndex = 0; shardIndex < shardCount; shardIndex++ {
+		for i := 0; i < 100; i++ {
+			id := fmt.Sprintf("file%d", i)
+			if inShard(drive.File{Id: id}) {
+				seen[id]++
+			}
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("shards cover %d files, want 100", len(seen))
+	}
+	for id, count := range seen {
+		if count != 1 {
+			t.Errorf("%s is in %d shards, want 1", id, count)
+		}
+	}
+}
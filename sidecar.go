@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+var writeSidecars bool
+
+// sidecarPath returns the object path of the sidecar JSON for an object
+func sidecarPath(objectPath string) string {
+	return objectPath + ".json"
+}
+
+// writeSidecar uploads the record as a JSON sidecar next to its object
+func writeSidecar(ctx context.Context, r record) error {
+	if r.ObjectPath == "" {
+		return fmt.Errorf("no object path for %s", r.Name)
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal sidecar: %v", err)
+	}
+	attrs := storage.ObjectAttrs{
+		ContentType: "application/json",
+		Metadata:    map[string]string{runIDKey: r.RunID},
+	}
+	if _, err := storageSrv.Upload(ctx, r.bucket(), sidecarPath(r.ObjectPath), b, attrs); err != nil {
+		return fmt.Errorf("unable to upload sidecar: %w", err)
+	}
+	return nil
+}
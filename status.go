@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genai"
+)
+
+// process exit codes
+const (
+	exitSuccess = 0
+	exitFailure = 1 // configuration or unexpected errors
+	exitPartial = 2 // the run completed with some failed files
+	exitAuth    = 3 // authentication or authorization failed
+	exitQuota   = 4 // API quota was exhausted
+)
+
+// error classes used to select exit codes
+const (
+	errorClassAuth  = "auth"
+	errorClassQuota = "quota"
+)
+
+var statusFile string = "run-status.json"
+
+// runStatus is the machine-readable status of a run
+type runStatus struct {
+	RunID    string      `json:"run_id,omitempty"`
+	Status   string      `json:"status"`
+	ExitCode int         `json:"exit_code"`
+	Message  string      `json:"message,omitempty"`
+	Started  time.Time   `json:"started"`
+	Finished time.Time   `json:"finished"`
+	Summary  *runSummary `json:"summary,omitempty"`
+}
+
+// statusNames maps exit codes to run status names
+var statusNames = map[int]string{
+	exitSuccess: "success",
+	exitFailure: "failed",
+	exitPartial: "partial",
+	exitAuth:    "auth_error",
+	exitQuota:   "quota_exhausted",
+}
+
+// quotaReasons are googleapi error reasons indicating exhausted quota
+var quotaReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"dailyLimitExceeded":    true,
+}
+
+// classifyError returns the error class of err, auth or quota, or an empty
+// string for other errors
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) {
+		return errorClassAuth
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		for _, e := range gerr.Errors {
+			if quotaReasons[e.Reason] {
+				return errorClassQuota
+			}
+		}
+		return classifyStatusCode(gerr.Code)
+	}
+	var cerr genai.ClientError
+	if errors.As(err, &cerr) {
+		return classifyStatusCode(cerr.Code)
+	}
+	return ""
+}
+
+func classifyStatusCode(code int) string {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errorClassAuth
+	case http.StatusTooManyRequests:
+		return errorClassQuota
+	}
+	return ""
+}
+
+// exitCode determines the process exit code from a run summary
+func exitCode(summary runSummary) int {
+	switch {
+	case summary.Errors[errorClassQuota] > 0:
+		return exitQuota
+	case summary.Errors[errorClassAuth] > 0:
+		return exitAuth
+	}
+	for _, count := range summary.Failures {
+		if count > 0 {
+			return exitPartial
+		}
+	}
+	return exitSuccess
+}
+
+// reportStatus writes the run status JSON to statusFile, sends it to the
+// -notify-url webhook and emails it to -email-to
+func reportStatus(code int, message string, summary *runSummary) {
+	status := runStatus{
+		RunID:    runID,
+		Status:   statusNames[code],
+		ExitCode: code,
+		Message:  message,
+		Finished: time.Now(),
+		Summary:  summary,
+	}
+	if stats != nil {
+		status.Started = stats.start
+	}
+	writeStatus(status)
+	if notifyURL != "" {
+		if err := notify(notifyURL, status); err != nil {
+			log.Printf("unable to send notification: %v", err)
+		}
+	}
+	if emailTo != "" {
+		if err := emailStatus(status); err != nil {
+			log.Printf("unable to send email: %v", err)
+		}
+	}
+}
+
+// writeStatus writes the run status JSON to statusFile
+func writeStatus(status runStatus) {
+	if statusFile == "" {
+		return
+	}
+	b, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		log.Printf("unable to marshal run status: %v", err)
+		return
+	}
+	if err := writeFile(statusFile, b); err != nil {
+		log.Printf("unable to write run status: %v", err)
+	}
+}
+
+// fatal logs the message, reports the run status and exits with code
+func fatal(code int, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	logSeverity(severityCritical, message)
+	reportStatus(code, message, nil)
+	os.Exit(code)
+}
+
+// fatalErr is fatal with the exit code chosen from the class of err
+func fatalErr(err error, format string, args ...any) {
+	code := exitFailure
+	switch classifyError(err) {
+	case errorClassAuth:
+		code = exitAuth
+	case errorClassQuota:
+		code = exitQuota
+	}
+	fatal(code, format, args...)
+}
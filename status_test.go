@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("boom"), ""},
+		{&googleapi.Error{Code: 401}, errorClassAuth},
+		{fmt.Errorf("wrapped: %w", &googleapi.Error{Code: 429}), errorClassQuota},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, errorClassQuota},
+		{&googleapi.Error{Code: 404}, ""},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		summary runSummary
+		want    int
+	}{
+		{runSummary{}, exitSuccess},
+		{runSummary{Failures: map[string]int{stageUpload: 1}}, exitPartial},
+		{runSummary{Failures: map[string]int{stageDownload: 1}, Errors: map[string]int{errorClassAuth: 1}}, exitAuth},
+		{runSummary{Failures: map[string]int{stageDescribe: 1}, Errors: map[string]int{errorClassQuota: 1}}, exitQuota},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.summary); got != tt.want {
+			t.Errorf("exitCode(%+v) = %d, want %d", tt.summary, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// streamOverFlag is the -stream-over size, e.g. 10MB, of files whose
+// description is streamed, empty to never stream
+var streamOverFlag string
+
+// streamOver is the size of files whose description is streamed, -1 to never stream
+var streamOver int64 = -1
+
+// stallTimeout fails a streamed description when the model sends no output for this long
+var stallTimeout time.Duration = 2 * time.Minute
+
+// describeTimeout limits the time to generate a description, 0 for no limit
+var describeTimeout time.Duration
+
+// streamProgressInterval is how often the progress of a streamed description is logged
+const streamProgressInterval = 10 * time.Second
+
+// errModelStalled is returned when a streamed description stops sending output
+var errModelStalled = errors.New("model stalled")
+
+// streams reports whether a file's description is streamed
+func streams(file drive.File) bool {
+	return streamOver >= 0 && file.Size >= streamOver
+}
+
+// partialPath returns the local path the partial output of a streamed
+// description is written to, kept if the description fails
+func partialPath(file drive.File) string {
+	return filepath.Join(localFolderName, localName(file)+".partial.txt")
+}
+
+// streamDescription generates a description with the streaming API, writing
+// the output to a partial file as it arrives, and failing with
+// errModelStalled if no output arrives for stallTimeout. The chunks are
+// returned as a single response.
+func streamDescription(ctx context.Context, file drive.File, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stalled atomic.Bool
+	stall := time.AfterFunc(stallTimeout, func() {
+		stalled.Store(true)
+		cancel()
+	})
+	defer stall.Stop()
+
+	partial, err := createFile(partialPath(file))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create partial output: %v", err)
+	}
+	defer partial.Close()
+
+	var text strings.Builder
+	var usage *genai.GenerateContentResponseUsageMetadata
+	var groundingMetadata *genai.GroundingMetadata
+	chunks := 0
+	start, logged := time.Now(), time.Now()
+	for resp, err := range genaiClient.GenerateContentStream(ctx, model, contents, config) {
+		if err != nil {
+			switch {
+			case stalled.Load():
+				return nil, fmt.Errorf("%w: no output for %s after %d chunks, partial output in %s", errModelStalled, stallTimeout, chunks, partial.Name())
+			case errors.Is(err, context.DeadlineExceeded):
+				return nil, fmt.Errorf("generation timed out after %s with %d chunks, partial output in %s: %w", time.Since(start).Round(time.Second), chunks, partial.Name(), err)
+			}
+			return nil, err
+		}
+		stall.Reset(stallTimeout)
+		chunks++
+		chunk := resp.Text()
+		text.WriteString(chunk)
+		partial.WriteString(chunk)
+		if resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata
+		}
+		if len(resp.Candidates) > 0 && resp.Candidates[0].GroundingMetadata != nil {
+			groundingMetadata = resp.Candidates[0].GroundingMetadata
+		}
+		if time.Since(logged) >= streamProgressInterval {
+			log.Printf("%s: streamed %d characters in %s", file.Name, text.Len(), time.Since(start).Round(time.Second))
+			logged = time.Now()
+		}
+	}
+	partial.Close()
+	os.Remove(partial.Name())
+	return &genai.GenerateContentResponse{
+		Candidates:    []*genai.Candidate{{Content: genai.NewModelContentFromText(text.String()), GroundingMetadata: groundingMetadata}},
+		UsageMetadata: usage,
+	}, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamDescription(t *testing.T) {
+	f := useFakes(t)
+	prevOver, prevStall := streamOver, stallTimeout
+	defer func() { streamOver, stallTimeout = prevOver, prevStall }()
+	streamOver = 2
+	f.drive.add("small", "small.png", "image/png", "root", []byte("s"))
+	f.drive.add("large", "large.pdf", "application/pdf", "root", []byte("large"))
+	f.generator.response = "A long document summary."
+
+	r := processFile(context.Background(), *f.drive.files["small"], "small.png")
+	if f.generator.streams != 0 || r.Description != "A long document summary." {
+		t.Errorf("small file streamed %d times, description %q, want not streamed", f.generator.streams, r.Description)
+	}
+	r = processFile(context.Background(), *f.drive.files["large"], "large.pdf")
+	if f.generator.streams != 1 || r.Description != "A long document summary." {
+		t.Errorf("large file streamed %d times, description %q, want the streamed chunks joined", f.generator.streams, r.Description)
+	}
+	if got := stats.summary().TotalTokens; got != 20 {
+		t.Errorf("TotalTokens = %d, want the usage of the final chunk counted", got)
+	}
+	if _, err := os.Stat(partialPath(*f.drive.files["large"])); !os.IsNotExist(err) {
+		t.Errorf("partial output left after success: %v", err)
+	}
+
+	// a stalled model fails the description, keeping the partial output
+	f.generator.stall = true
+	stallTimeout = 20 * time.Millisecond
+	_, err := generateDescription(context.Background(), *f.drive.files["large"], []byte("large"), describeSettingsFor(*f.drive.files["large"]))
+	if !errors.Is(err, errModelStalled) || !needsReview(err) {
+		t.Fatalf("generateDescription() error = %v, want a stalled describe error", err)
+	}
+	b, err := os.ReadFile(partialPath(*f.drive.files["large"]))
+	if err != nil || string(b) != "A " {
+		t.Errorf("partial output = %q, %v, want the first chunk", b, err)
+	}
+
+	// a stream running past -describe-timeout is told apart from a stall
+	prevTimeout := describeTimeout
+	describeTimeout, stallTimeout = 20*time.Millisecond, time.Minute
+	defer func() { describeTimeout = prevTimeout }()
+	_, err = generateDescription(context.Background(), *f.drive.files["large"], []byte("large"), describeSettingsFor(*f.drive.files["large"]))
+	if err == nil || errors.Is(err, errModelStalled) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("generateDescription() error = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// tagFiles asks Gemini for normalized tags and a category for each file,
+// with function calling, after describing it
+var tagFiles bool
+
+// tagCategoriesList is the -tag-categories comma-separated list
+var tagCategoriesList string
+
+// tagCategories are the categories a file can be put in, any if empty
+var tagCategories []string
+
+// maxTags is the most tags kept for a file
+var maxTags = 10
+
+// stageTag is the stats category of tagging
+const stageTag = "tag"
+
+// tagFunction is the function Gemini calls with a file's tags
+const tagFunction = "record_tags"
+
+// tagPrompt asks for the tags of the file described
+const tagPrompt = "Record tags and a category for this file by calling " + tagFunction + "."
+
+// tagAttempts is how many times Gemini is asked to correct invalid tags
+const tagAttempts = 2
+
+// fileTags are the normalized tags and category of a file
+type fileTags struct {
+	Tags     []string
+	Category string
+}
+
+// taggings holds the tags of this run's files, by Drive file ID
+var taggings sync.Map
+
+// parseTagCategories parses -tag-categories into tagCategories
+func parseTagCategories() {
+	tagCategories = nil
+	for _, c := range strings.Split(tagCategoriesList, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" && !slices.Contains(tagCategories, c) {
+			tagCategories = append(tagCategories, c)
+		}
+	}
+}
+
+// tagDeclaration declares the function Gemini calls with a file's tags
+func tagDeclaration() *genai.FunctionDeclaration {
+	category := &genai.Schema{Type: genai.TypeString, Description: "the single category that best fits the file"}
+	if len(tagCategories) > 0 {
+		category.Enum = tagCategories
+	}
+	return &genai.FunctionDeclaration{
+		Name:        tagFunction,
+		Description: "Records the tags and category of the file described.",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"tags": {
+					Type:        genai.TypeArray,
+					Items:       &genai.Schema{Type: genai.TypeString},
+					Description: fmt.Sprintf("up to %d short lowercase tags, most relevant first, such as subjects, places, colors or styles", maxTags),
+				},
+				"category": category,
+			},
+			Required: []string{"tags", "category"},
+		},
+	}
+}
+
+// tagFile asks Gemini, in the conversation that described the file, to call
+// tagFunction with its tags. Invalid arguments are sent back as the
+// function's error for Gemini to correct, up to tagAttempts times.
+func tagFile(ctx context.Context, file drive.File, model string, contents []*genai.Content, config *genai.GenerateContentConfig, description string) (fileTags, error) {
+	tagConfig := *config
+	tagConfig.Tools = []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{tagDeclaration()}}}
+	tagConfig.ToolConfig = &genai.ToolConfig{FunctionCallingConfig: &genai.FunctionCallingConfig{
+		Mode:                 genai.FunctionCallingConfigModeAny,
+		AllowedFunctionNames: []string{tagFunction},
+	}}
+	contents = append(contents, genai.NewModelContentFromText(description))
+	contents = append(contents, genai.Text(tagPrompt)...)
+
+	var err error
+	for range tagAttempts {
+		start := time.Now()
+		var resp *genai.GenerateContentResponse
+		err = withQuotaRetry(ctx, func() (err error) {
+			resp, err = genaiClient.GenerateContent(ctx, model, contents, &tagConfig)
+			return err
+		})
+		observeFile(file.Id, stageTag, start)
+		if err != nil {
+			return fileTags{}, fmt.Errorf("unable to generate tags: %w", err)
+		}
+		stats.addUsage(resp.UsageMetadata)
+		var call *genai.FunctionCall
+		for _, c := range resp.FunctionCalls() {
+			if c.Name == tagFunction {
+				call = c
+				break
+			}
+		}
+		if call == nil {
+			return fileTags{}, fmt.Errorf("model did not call %s", tagFunction)
+		}
+		var tags fileTags
+		if tags, err = normalizeTags(call.Args); err == nil {
+			return tags, nil
+		}
+		log.Printf("%s: invalid tags, asking for a correction: %v", file.Name, err)
+		contents = append(contents,
+			genai.NewModelContentFromParts([]*genai.Part{{FunctionCall: call}}),
+			genai.NewUserContentFromParts([]*genai.Part{genai.NewPartFromFunctionResponse(tagFunction, map[string]any{"error": err.Error()})}),
+		)
+	}
+	return fileTags{}, err
+}
+
+// normalizeTags validates the arguments of a tagFunction call, coercing them
+// to lowercase tags without duplicates, at most maxTags, and one of
+// tagCategories, matched case insensitively
+func normalizeTags(args map[string]any) (fileTags, error) {
+	var raw []string
+	switch v := args["tags"].(type) {
+	case []any:
+		for _, t := range v {
+			if s, ok := t.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	case string:
+		raw = strings.Split(v, ",")
+	}
+	var tags fileTags
+	for _, t := range raw {
+		t = strings.ToLower(strings.Join(strings.Fields(strings.TrimLeft(t, "# ")), " "))
+		if t != "" && !slices.Contains(tags.Tags, t) {
+			tags.Tags = append(tags.Tags, t)
+		}
+	}
+	if len(tags.Tags) == 0 {
+		return fileTags{}, fmt.Errorf("tags must be a non-empty list of strings")
+	}
+	if len(tags.Tags) > maxTags {
+		tags.Tags = tags.Tags[:maxTags]
+	}
+
+	category, _ := args["category"].(string)
+	tags.Category = strings.ToLower(strings.TrimSpace(category))
+	if len(tagCategories) > 0 && !slices.Contains(tagCategories, tags.Category) {
+		return fileTags{}, fmt.Errorf("category %q must be one of %s", category, strings.Join(tagCategories, ", "))
+	}
+	return tags, nil
+}
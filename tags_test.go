@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	defer func(prev []string, prevMax int) { tagCategories, maxTags = prev, prevMax }(tagCategories, maxTags)
+	tagCategoriesList, maxTags = " Landscape,portrait, landscape ,", 3
+	defer func() { tagCategoriesList = "" }()
+	parseTagCategories()
+	if strings.Join(tagCategories, ",") != "landscape,portrait" {
+		t.Fatalf("tagCategories = %v", tagCategories)
+	}
+
+	tests := []struct {
+		args map[string]any
+		want string
+		err  string
+	}{
+		{map[string]any{"tags": []any{"Sunset", " #beach  ", "sunset", 7, "golden   hour", "sea"}, "category": "LANDSCAPE"}, "sunset;beach;golden hour/landscape", ""},
+		{map[string]any{"tags": "sunset, Beach", "category": "portrait"}, "sunset;beach/portrait", ""},
+		{map[string]any{"tags": []any{}, "category": "portrait"}, "", "non-empty list"},
+		{map[string]any{"tags": []any{"cat"}, "category": "animals"}, "", "must be one of landscape, portrait"},
+		{map[string]any{"category": "portrait"}, "", "non-empty list"},
+	}
+	for _, tt := range tests {
+		tags, err := normalizeTags(tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("normalizeTags(%v) = %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if got := strings.Join(tags.Tags, ";") + "/" + tags.Category; err != nil || got != tt.want {
+			t.Errorf("normalizeTags(%v) = %q, %v, want %q", tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestTagFile(t *testing.T) {
+	f := useFakes(t)
+	defer func(prev bool, prevCategories []string) { tagFiles, tagCategories = prev, prevCategories }(tagFiles, tagCategories)
+	tagFiles, tagCategories = true, []string{"landscape", "portrait"}
+	// the first call has an unknown category, sent back to be corrected
+	f.generator.functionArgs = []map[string]any{
+		{"tags": []any{"Beach"}, "category": "seascape"},
+		{"tags": []any{"Beach", "Sunset"}, "category": "Landscape"},
+	}
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("a"))
+
+	r := processFile(context.Background(), *f.drive.files["a"], "a.jpg")
+	if columns["tags"](r) != "beach;sunset" || r.Category != "landscape" {
+		t.Errorf("tags, category = %v, %q", r.Tags, r.Category)
+	}
+	if f.generator.calls != 3 {
+		t.Errorf("calls = %d, want the description and 2 tag calls", f.generator.calls)
+	}
+	last := f.generator.contents[len(f.generator.contents)-1]
+	if resp := last.Parts[0].FunctionResponse; resp == nil || !strings.Contains(resp.Response["error"].(string), "seascape") {
+		t.Errorf("last turn = %+v, want the function's error", last.Parts[0])
+	}
+	if decl := f.generator.config.Tools[0].FunctionDeclarations[0]; decl.Name != tagFunction || len(decl.Parameters.Properties["category"].Enum) != 2 {
+		t.Errorf("declaration = %+v", decl)
+	}
+
+	// a model that doesn't call the function fails tagging, keeping the description
+	f.drive.add("b", "b.jpg", "image/jpeg", "root", []byte("b"))
+	r = processFile(context.Background(), *f.drive.files["b"], "b.jpg")
+	if r.Description != f.generator.response || r.Tags != nil || stats.summary().Failures[stageTag] != 1 {
+		t.Errorf("record = %+v, failures = %v, want a tag failure", r, stats.summary().Failures)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genai"
+)
+
+// maxConcurrency limits the files processed at once, 0 for no limit
+var maxConcurrency int
+
+// quotaRetries is the number of times a Drive or Gemini call failing on
+// quota is retried, after cooling down, before the file fails
+var quotaRetries int = 5
+
+// quotaCooldown is how long to cool down after a quota error without a
+// Retry-After
+var quotaCooldown time.Duration = 30 * time.Second
+
+// throttle is an adaptive limit on the files processed at once; quota errors
+// halve the limit for a cool-down window, after which it grows back by one
+// file at a time
+type throttle struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	ceiling int // the configured limit, 0 for no limit
+	limit   int // the current limit, 0 for no limit
+	peak    int // files in flight when an unlimited throttle was first limited
+	active  int
+	until   time.Time // end of the cool-down window
+	paused  bool      // no files are started until resumed
+}
+
+var throttler = newThrottle(0)
+
+func newThrottle(ceiling int) *throttle {
+	t := &throttle{ceiling: ceiling, limit: ceiling}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// acquire waits for a slot to process a file
+func (t *throttle) acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.paused || t.limit > 0 && t.active >= t.limit {
+		t.cond.Wait()
+	}
+	t.active++
+}
+
+// release frees a slot, raising the limit once the cool-down has passed
+func (t *throttle) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.limit > 0 && t.limit != t.ceiling && time.Now().After(t.until) {
+		t.limit++
+		switch {
+		case t.ceiling > 0 && t.limit >= t.ceiling:
+			t.limit = t.ceiling
+		case t.ceiling == 0 && t.limit >= t.peak:
+			t.limit = 0
+		}
+	}
+	t.cond.Broadcast()
+}
+
+// backoff halves the limit and starts, or extends, a cool-down window of
+// wait, returning when the window ends; errors within a window don't halve
+// the limit again
+func (t *throttle) backoff(wait time.Duration) time.Time {
+	return t.slowDown("quota exceeded", wait)
+}
+
+// slowDown halves the limit for a reason, logged, and starts, or extends, a
+// cool-down window of wait, returning when the window ends
+func (t *throttle) slowDown(reason string, wait time.Duration) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if now.After(t.until) {
+		current := t.limit
+		if current == 0 {
+			current = t.active
+			t.peak = t.active
+		}
+		t.limit = max(current/2, 1)
+		log.Printf("%s, processing %d files at once for %s", reason, t.limit, wait)
+	}
+	if end := now.Add(wait); end.After(t.until) {
+		t.until = end
+	}
+	return t.until
+}
+
+// setPaused pauses or resumes starting files; the files in flight carry on
+func (t *throttle) setPaused(paused bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = paused
+	t.cond.Broadcast()
+}
+
+// setCeiling changes the configured limit, 0 for no limit. During a
+// cool-down, the current limit is only lowered to it, and grows back to it
+// as usual.
+func (t *throttle) setCeiling(ceiling int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ceiling = ceiling
+	if time.Now().After(t.until) || ceiling > 0 && (t.limit == 0 || t.limit > ceiling) {
+		t.limit = ceiling
+	}
+	t.cond.Broadcast()
+}
+
+// throttleState is a snapshot of a throttle
+type throttleState struct {
+	Paused  bool `json:"paused"`
+	Ceiling int  `json:"concurrency"`
+	Limit   int  `json:"limit"`
+	Active  int  `json:"active"`
+}
+
+// state returns a snapshot of the throttle
+func (t *throttle) state() throttleState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return throttleState{Paused: t.paused, Ceiling: t.ceiling, Limit: t.limit, Active: t.active}
+}
+
+// current returns the current limit, 0 for no limit
+func (t *throttle) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// withQuotaRetry calls fn, and on quota errors throttles the run and retries
+// after the cool-down, up to quotaRetries times
+func withQuotaRetry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if attempt >= quotaRetries || classifyError(err) != errorClassQuota {
+			return err
+		}
+		until := throttler.backoff(retryAfter(err))
+		stats.retry()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Until(until)):
+		}
+	}
+}
+
+// retryAfter returns how long the server asked to wait before retrying, from
+// the Retry-After header of Drive errors or the RetryInfo of Gemini errors,
+// or quotaCooldown
+func retryAfter(err error) time.Duration {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		if d, ok := parseRetryAfter(gerr.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+	var cerr genai.ClientError
+	if errors.As(err, &cerr) {
+		for _, detail := range cerr.Details {
+			kind, _ := detail["@type"].(string)
+			delay, _ := detail["retryDelay"].(string)
+			if strings.HasSuffix(kind, "google.rpc.RetryInfo") {
+				if d, err := time.ParseDuration(delay); err == nil && d > 0 {
+					return d
+				}
+			}
+		}
+	}
+	return quotaCooldown
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or an HTTP date
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
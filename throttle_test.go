@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genai"
+)
+
+func TestThrottleBackoff(t *testing.T) {
+	th := newThrottle(8)
+	for range 6 {
+		th.acquire()
+	}
+	th.backoff(time.Hour)
+	if got := th.current(); got != 4 {
+		t.Errorf("limit after backoff = %d, want 4", got)
+	}
+	th.backoff(time.Hour)
+	if got := th.current(); got != 4 {
+		t.Errorf("limit after a second error in the window = %d, want 4", got)
+	}
+	th.release()
+	if got := th.current(); got != 4 {
+		t.Errorf("limit during the cool-down = %d, want 4", got)
+	}
+
+	// once the window has passed, each file raises the limit by one
+	th.until = time.Now().Add(-time.Second)
+	for _, want := range []int{5, 6, 7, 8, 8} {
+		th.release()
+		if got := th.current(); got != want {
+			t.Errorf("limit after release = %d, want %d", got, want)
+		}
+	}
+
+	// an unlimited throttle halves the files in flight, then is unlimited again
+	th = newThrottle(0)
+	for range 4 {
+		th.acquire()
+	}
+	th.backoff(time.Millisecond)
+	if got := th.current(); got != 2 {
+		t.Errorf("unlimited limit after backoff = %d, want 2", got)
+	}
+	time.Sleep(2 * time.Millisecond)
+	th.release()
+	th.release()
+	if got := th.current(); got != 0 {
+		t.Errorf("limit after recovering = %d, want 0 (no limit)", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	prev := quotaCooldown
+	quotaCooldown = 7 * time.Second
+	defer func() { quotaCooldown = prev }()
+
+	tests := []struct {
+		err  error
+		want time.Duration
+	}{
+		{&googleapi.Error{Code: 429, Header: http.Header{"Retry-After": {"12"}}}, 12 * time.Second},
+		{&googleapi.Error{Code: 429}, 7 * time.Second},
+		{genai.ClientError{}, 7 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.err); got != tt.want {
+			t.Errorf("retryAfter(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+	if d, ok := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); !ok || d < 58*time.Second || d > time.Minute {
+		t.Errorf("parseRetryAfter(date) = %s, %t, want about a minute", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Error("parseRetryAfter(soon) ok, want not")
+	}
+}
+
+func TestQuotaRetry(t *testing.T) {
+	f := useFakes(t)
+	f.drive.add("a", "a.png", "image/png", "root", []byte("a"))
+	prev := throttler
+	throttler = newThrottle(0)
+	defer func() { throttler = prev }()
+
+	// the generator fails on quota twice, then succeeds
+	calls := 0
+	retry := &googleapi.Error{Code: 429, Header: http.Header{"Retry-After": {"0"}}}
+	err := withQuotaRetry(context.Background(), func() error {
+		calls++
+		if calls <= 2 {
+			return retry
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("withQuotaRetry() = %v after %d calls, want success after 3", err, calls)
+	}
+	if got := stats.summary().QuotaRetries; got != 2 {
+		t.Errorf("QuotaRetries = %d, want 2", got)
+	}
+
+	// other errors are returned straight away
+	calls = 0
+	err = withQuotaRetry(context.Background(), func() error {
+		calls++
+		return &googleapi.Error{Code: 404}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("withQuotaRetry() = %v after %d calls, want the error after 1", err, calls)
+	}
+
+	// quota errors fail the file once the retries are spent
+	prevRetries := quotaRetries
+	quotaRetries = 1
+	defer func() { quotaRetries = prevRetries }()
+	f.generator.err = retry
+	_, _, err = describe(context.Background(), *f.drive.files["a"])
+	if !needsReview(err) || f.generator.calls != 2 {
+		t.Errorf("describe() = %v after %d calls, want a describe error after 2", err, f.generator.calls)
+	}
+}
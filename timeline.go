@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timelineFile is a path to write an HTML Gantt chart of every file's
+// pipeline stages to, empty to skip writing
+var timelineFile string
+
+// stageSpan is a stage of a file's pipeline, in milliseconds since the run
+// started
+type stageSpan struct {
+	Stage      string `json:"stage"`
+	StartMs    int64  `json:"start_ms"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// fileTimeline is the stages of a file's pipeline, in the order they ended
+type fileTimeline struct {
+	mu    sync.Mutex
+	spans []stageSpan
+}
+
+// fileTimelines holds the timelines of this run's files, by Drive file ID
+var fileTimelines sync.Map
+
+// observeFile records the duration of a stage that started at start, both in
+// the run's latencies and in the timeline of a file
+func observeFile(fileID, stage string, start time.Time) {
+	d := time.Since(start)
+	stats.observe(stage, d)
+	t, _ := fileTimelines.LoadOrStore(fileID, &fileTimeline{})
+	timeline := t.(*fileTimeline)
+	timeline.mu.Lock()
+	defer timeline.mu.Unlock()
+	timeline.spans = append(timeline.spans, stageSpan{
+		Stage:      stage,
+		StartMs:    start.Sub(stats.start).Milliseconds(),
+		DurationMs: d.Milliseconds(),
+	})
+}
+
+// timelineOf returns the stages of a file's pipeline so far
+func timelineOf(fileID string) []stageSpan {
+	t, ok := fileTimelines.Load(fileID)
+	if !ok {
+		return nil
+	}
+	timeline := t.(*fileTimeline)
+	timeline.mu.Lock()
+	defer timeline.mu.Unlock()
+	return slices.Clone(timeline.spans)
+}
+
+// formatTimeline formats the total duration of each stage of a timeline for
+// the catalog, as stage=duration separated by semicolons, in the order the
+// stages first started
+func formatTimeline(spans []stageSpan) string {
+	var stages []string
+	totals := map[string]int64{}
+	for _, s := range spans {
+		if _, ok := totals[s.Stage]; !ok {
+			stages = append(stages, s.Stage)
+		}
+		totals[s.Stage] += s.DurationMs
+	}
+	parts := make([]string, len(stages))
+	for i, stage := range stages {
+		parts[i] = fmt.Sprintf("%s=%s", stage, time.Duration(totals[stage])*time.Millisecond)
+	}
+	return strings.Join(parts, ";")
+}
+
+// timelineChart collects the timelines of the files processed for the HTML
+// chart
+type timelineChart struct {
+	mu      sync.Mutex
+	records []record
+}
+
+var timelines = &timelineChart{}
+
+func (c *timelineChart) add(r record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records = append(c.records, r)
+}
+
+// timelineColors are the bar colors of the stages, in the order they are
+// first seen
+var timelineColors = []string{"#4e79a7", "#f28e2b", "#59a14f", "#e15759", "#76b7b2", "#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac"}
+
+// timelineRow is a file in the HTML chart
+type timelineRow struct {
+	Name string
+	ID   string
+	Bars []timelineBar
+}
+
+// timelineBar is a stage of a file in the HTML chart, positioned as
+// percentages of the run
+type timelineBar struct {
+	Stage string
+	Title string
+	Color string
+	Left  float64
+	Width float64
+}
+
+// timelineLegend is a stage and its color in the HTML chart
+type timelineLegend struct {
+	Stage string
+	Color string
+}
+
+var timelineTemplate = template.Must(template.New("timeline").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>drivetogcs run {{.RunID}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 1em; }
+.row { display: flex; align-items: center; height: 18px; }
+.row:nth-child(even) { background: #f4f4f4; }
+.name { width: 22em; overflow: hidden; white-space: nowrap; text-overflow: ellipsis; }
+.track { position: relative; flex: 1; height: 14px; }
+.bar { position: absolute; height: 100%; min-width: 1px; }
+.legend span { display: inline-block; margin-right: 1em; }
+.legend i { display: inline-block; width: 10px; height: 10px; margin-right: 4px; }
+</style>
+</head>
+<body>
+<h1>Run {{.RunID}}</h1>
+<p>{{len .Rows}} files in {{.Elapsed}}</p>
+<p class="legend">{{range .Legend}}<span><i style="background: {{.Color}}"></i>{{.Stage}}</span>{{end}}</p>
+<div>
+{{range .Rows}}<div class="row"><div class="name" title="{{.ID}}">{{.Name}}</div><div class="track">{{range .Bars}}<div class="bar" title="{{.Title}}" style="left: {{.Left}}%; width: {{.Width}}%; background: {{.Color}}"></div>{{end}}</div></div>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// write renders the timelines as an HTML Gantt chart, a row per file in the
+// order they started, so the stages holding up a run can be seen
+func (c *timelineChart) write(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	records := slices.Clone(c.records)
+	sort.SliceStable(records, func(i, j int) bool { return timelineStart(records[i]) < timelineStart(records[j]) })
+
+	var end int64 = 1
+	for _, r := range records {
+		for _, s := range r.Timeline {
+			end = max(end, s.StartMs+s.DurationMs)
+		}
+	}
+	colors := map[string]string{}
+	var legend []timelineLegend
+	var rows []timelineRow
+	for _, r := range records {
+		row := timelineRow{Name: r.Name, ID: r.ID}
+		for _, s := range r.Timeline {
+			color, ok := colors[s.Stage]
+			if !ok {
+				color = timelineColors[len(colors)%len(timelineColors)]
+				colors[s.Stage] = color
+				legend = append(legend, timelineLegend{Stage: s.Stage, Color: color})
+			}
+			row.Bars = append(row.Bars, timelineBar{
+				Stage: s.Stage,
+				Title: fmt.Sprintf("%s: %s", s.Stage, time.Duration(s.DurationMs)*time.Millisecond),
+				Color: color,
+				Left:  float64(s.StartMs) * 100 / float64(end),
+				Width: float64(s.DurationMs) * 100 / float64(end),
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	f, err := createPending(path)
+	if err != nil {
+		return fmt.Errorf("unable to create timeline: %v", err)
+	}
+	data := map[string]any{
+		"RunID":   runID,
+		"Elapsed": time.Duration(end) * time.Millisecond,
+		"Legend":  legend,
+		"Rows":    rows,
+	}
+	if err := timelineTemplate.Execute(f, data); err != nil {
+		f.abort()
+		return fmt.Errorf("unable to write timeline: %v", err)
+	}
+	return f.commit()
+}
+
+// timelineStart returns when a record's first stage started
+func timelineStart(r record) int64 {
+	if len(r.Timeline) == 0 {
+		return 0
+	}
+	start := r.Timeline[0].StartMs
+	for _, s := range r.Timeline {
+		start = min(start, s.StartMs)
+	}
+	return start
+}
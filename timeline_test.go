@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatTimeline(t *testing.T) {
+	spans := []stageSpan{
+		{Stage: "download", StartMs: 0, DurationMs: 120},
+		{Stage: "describe", StartMs: 120, DurationMs: 2300},
+		{Stage: "upload", StartMs: 2420, DurationMs: 40},
+		{Stage: "upload", StartMs: 2460, DurationMs: 60},
+	}
+	if got, want := formatTimeline(spans), "download=120ms;describe=2.3s;upload=100ms"; got != want {
+		t.Errorf("formatTimeline = %q, want %q", got, want)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	f := useFakes(t)
+	prevFile, prevChart := timelineFile, timelines
+	t.Cleanup(func() { timelineFile, timelines = prevFile, prevChart })
+	timelineFile, timelines = filepath.Join(t.TempDir(), "timeline.html"), &timelineChart{}
+	f.drive.add("1", "a.jpg", "image/jpeg", "root", []byte("jpeg"))
+
+	r := processFile(context.Background(), *f.drive.files["1"], "a.jpg")
+	var stages []string
+	for _, s := range r.Timeline {
+		stages = append(stages, s.Stage)
+		if s.StartMs < 0 || s.DurationMs < 0 {
+			t.Errorf("span %+v", s)
+		}
+	}
+	if got := strings.Join(stages, ","); got != "download,describe,upload" {
+		t.Errorf("timeline stages = %s", got)
+	}
+
+	if err := timelines.write(timelineFile); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(timelineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a.jpg", "download", "describe", "upload", "background: #4e79a7"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("timeline missing %q:\n%s", want, b)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// token encryptions at rest
+const (
+	tokenPlain      = "none"       // the token file is plaintext JSON
+	tokenPassphrase = "passphrase" // encrypted with a key derived from TOKEN_PASSPHRASE
+	tokenKeyring    = "keyring"    // encrypted with a random key kept in the OS keychain
+)
+
+var tokenEncryptions = []string{tokenPlain, tokenPassphrase, tokenKeyring}
+
+// tokenEncryption is how the cached OAuth token is encrypted at rest
+var tokenEncryption = tokenPlain
+
+// keyringService is the OS keychain service the token keys are kept under
+const keyringService = "drivetogcs"
+
+// sealedToken is an encrypted token file
+type sealedToken struct {
+	Encryption string `json:"encryption"`
+	Salt       []byte `json:"salt,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// keyStore keeps secrets by account in the OS keychain
+type keyStore interface {
+	get(account string) (string, error)
+	set(account, secret string) error
+}
+
+// tokenKeys is the OS keychain the -token-encryption keyring keys are kept in
+var tokenKeys keyStore = systemKeyring{}
+
+// sealToken encrypts a token file's contents with -token-encryption
+func sealToken(path string, plaintext []byte) ([]byte, error) {
+	if tokenEncryption == tokenPlain {
+		return plaintext, nil
+	}
+	sealed := sealedToken{Encryption: tokenEncryption}
+	var key []byte
+	var err error
+	switch tokenEncryption {
+	case tokenPassphrase:
+		sealed.Salt = make([]byte, 16)
+		rand.Read(sealed.Salt)
+		key, err = passphraseKey(sealed.Salt)
+	case tokenKeyring:
+		key, err = keyringKey(path, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	rand.Read(sealed.Nonce)
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, plaintext, []byte(sealed.Encryption))
+	return json.Marshal(sealed)
+}
+
+// openToken decrypts a token file's contents, which may be plaintext from
+// before it was encrypted
+func openToken(path string, data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	var sealed sealedToken
+	json.Unmarshal(data, &sealed)
+	var key []byte
+	var err error
+	switch sealed.Encryption {
+	case tokenPassphrase:
+		key, err = passphraseKey(sealed.Salt)
+	case tokenKeyring:
+		key, err = keyringKey(path, false)
+	default:
+		return nil, fmt.Errorf("unknown token encryption %q", sealed.Encryption)
+	}
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(sealed.Encryption))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s, the %s may have changed", path, sealed.Encryption)
+	}
+	return plaintext, nil
+}
+
+// isSealed reports whether a token file's contents are encrypted
+func isSealed(data []byte) bool {
+	var sealed sealedToken
+	return json.Unmarshal(data, &sealed) == nil && sealed.Ciphertext != nil
+}
+
+// newAEAD returns AES-256-GCM with a key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// passphraseKey derives a key from the TOKEN_PASSPHRASE environment variable
+// with scrypt
+func passphraseKey(salt []byte) ([]byte, error) {
+	passphrase := os.Getenv("TOKEN_PASSPHRASE")
+	if passphrase == "" {
+		return nil, errors.New("TOKEN_PASSPHRASE is not set, needed for -token-encryption passphrase")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// keyringKey returns the key of a token file from the OS keychain, creating
+// one if there is none and create is set
+func keyringKey(path string, create bool) ([]byte, error) {
+	account, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := tokenKeys.get(account)
+	if err == nil {
+		return base64.StdEncoding.DecodeString(secret)
+	}
+	if !create {
+		return nil, fmt.Errorf("unable to read the key of %s from the keychain: %v", path, err)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := tokenKeys.set(account, base64.StdEncoding.EncodeToString(key)); err != nil {
+		return nil, fmt.Errorf("unable to keep the key of %s in the keychain: %v", path, err)
+	}
+	return key, nil
+}
+
+// systemKeyring keeps secrets in the macOS keychain with security, or the
+// Secret Service, such as GNOME Keyring, with secret-tool on Linux
+type systemKeyring struct{}
+
+func (systemKeyring) get(account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	default:
+		return "", fmt.Errorf("no keychain support on %s, use -token-encryption passphrase", runtime.GOOS)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (systemKeyring) set(account, secret string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w", secret)
+	case "linux":
+		cmd = exec.Command("secret-tool", "store", "--label", "drivetogcs token key", "service", keyringService, "account", account)
+		cmd.Stdin = strings.NewReader(secret)
+	default:
+		return fmt.Errorf("no keychain support on %s, use -token-encryption passphrase", runtime.GOOS)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
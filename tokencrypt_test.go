@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeKeyring is an in-memory OS keychain
+type fakeKeyring map[string]string
+
+func (k fakeKeyring) get(account string) (string, error) {
+	if secret, ok := k[account]; ok {
+		return secret, nil
+	}
+	return "", errors.New("not found")
+}
+
+func (k fakeKeyring) set(account, secret string) error {
+	k[account] = secret
+	return nil
+}
+
+func TestTokenEncryption(t *testing.T) {
+	prevEncryption, prevKeys := tokenEncryption, tokenKeys
+	t.Cleanup(func() { tokenEncryption, tokenKeys = prevEncryption, prevKeys })
+	keys := fakeKeyring{}
+	tokenKeys = keys
+	t.Setenv("TOKEN_PASSPHRASE", "correct horse")
+
+	for _, encryption := range []string{tokenPassphrase, tokenKeyring} {
+		tokenEncryption = encryption
+		path := filepath.Join(t.TempDir(), "token.json")
+		if err := saveToken(path, &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		if !isSealed(data) || bytes.Contains(data, []byte("refresh")) {
+			t.Errorf("%s: token file is not encrypted: %s", encryption, data)
+		}
+		tok, err := tokenFromFile(path)
+		if err != nil || tok.RefreshToken != "refresh" {
+			t.Errorf("%s: tokenFromFile = %+v, %v", encryption, tok, err)
+		}
+	}
+	if len(keys) != 1 {
+		t.Errorf("keychain has %d keys, want 1", len(keys))
+	}
+
+	// a wrong passphrase can't decrypt the token
+	tokenEncryption = tokenPassphrase
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := saveToken(path, &oauth2.Token{AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOKEN_PASSPHRASE", "wrong")
+	if _, err := tokenFromFile(path); err == nil {
+		t.Error("decrypted with the wrong passphrase")
+	}
+
+	// a token that can't be encrypted leaves the cached one in place
+	t.Setenv("TOKEN_PASSPHRASE", "")
+	cached := filepath.Join(t.TempDir(), "token.json")
+	os.WriteFile(cached, []byte(`{"access_token": "cached"}`), 0o600)
+	if err := saveToken(cached, &oauth2.Token{AccessToken: "new"}); err == nil {
+		t.Error("saved a token without TOKEN_PASSPHRASE")
+	}
+	if tok, err := tokenFromFile(cached); err != nil || tok.AccessToken != "cached" {
+		t.Errorf("cached tokenFromFile = %+v, %v, want the cached token kept", tok, err)
+	}
+
+	// plaintext token files from before encryption are still read
+	plain := filepath.Join(t.TempDir(), "token.json")
+	os.WriteFile(plain, []byte(`{"access_token": "old"}`), 0o600)
+	if tok, err := tokenFromFile(plain); err != nil || tok.AccessToken != "old" {
+		t.Errorf("plaintext tokenFromFile = %+v, %v", tok, err)
+	}
+}
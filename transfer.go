@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/storagetransfer/v1"
+)
+
+// transferOverFlag is the -transfer-over size, e.g. 1TB, empty to always
+// download and upload through this machine
+var transferOverFlag string
+
+// transferOver is the total Drive size of a run's files from which their
+// bytes are transferred by Storage Transfer Service, 0 for never
+var transferOver int64
+
+// transferPollInterval is how often a transfer's progress is checked
+var transferPollInterval = 30 * time.Second
+
+// transferPrefix is where transfers stage their objects in a bucket, before
+// they are copied to their object paths
+const transferPrefix = ".transfer"
+
+// transferRunner copies the URLs of a URL list into a bucket
+type transferRunner interface {
+	// transfer copies the URLs listed at listURL into a bucket under a
+	// prefix, waiting until done
+	transfer(ctx context.Context, listURL, bucket, prefix string) error
+}
+
+// transfers runs the transfers, Storage Transfer Service unless replaced in
+// tests
+var transfers transferRunner
+
+// driveMediaURL returns the URL downloading a public Drive file with the API
+// key, which Storage Transfer Service can fetch without OAuth
+func driveMediaURL(id string) string {
+	return "https://www.googleapis.com/drive/v3/files/" + url.PathEscape(id) + "?alt=media&key=" + url.QueryEscape(driveAPIKey())
+}
+
+// transferable returns the files of a run whose bytes can be transferred:
+// those the run would process, up to -max, that can be described from their
+// object and aren't uploaded already
+func transferable(ctx context.Context, files []drive.File) []drive.File {
+	var out []drive.File
+	for _, file := range files {
+		if maxFiles > 0 && len(out) >= maxFiles {
+			break
+		}
+		if _, ok := ignored(file); ok || !inShard(file) || !describableFromObject(file) || file.Size == 0 {
+			continue
+		}
+		if r := ruleFor(file); r != nil && r.Skip {
+			continue
+		}
+		if _, ok := gcsSource(ctx, file); ok {
+			continue
+		}
+		out = append(out, file)
+	}
+	return out
+}
+
+// urlList returns a Storage Transfer Service URL list of the files
+func urlList(files []drive.File) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("TsvHttpData-1.0\n")
+	for _, file := range files {
+		sum, err := hex.DecodeString(file.Md5Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid MD5 checksum %q", file.Name, file.Md5Checksum)
+		}
+		fmt.Fprintf(&b, "%s\t%d\t%s\n", driveMediaURL(file.Id), file.Size, base64.StdEncoding.EncodeToString(sum))
+	}
+	return b.Bytes(), nil
+}
+
+// delegateTransfer has Storage Transfer Service copy the bytes of the run's
+// files from Drive into their buckets when they total -transfer-over or
+// more, so they don't pass through this machine. The run then describes them
+// from their objects, as with -describe-from-gcs, and downloads from Drive
+// only the files the transfer didn't copy.
+func delegateTransfer(ctx context.Context, files []drive.File) error {
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	if transferOver == 0 || total < transferOver {
+		return nil
+	}
+	describeFromGCS = true
+	byBucket := map[string][]drive.File{}
+	for _, file := range transferable(ctx, files) {
+		bucket := routeFor(file).Bucket
+		byBucket[bucket] = append(byBucket[bucket], file)
+	}
+	if len(byBucket) == 0 {
+		return nil
+	}
+	if transfers == nil {
+		srv, err := storagetransfer.NewService(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to create Storage Transfer Service client: %v", err)
+		}
+		transfers = &stsRunner{srv: srv}
+	}
+	for _, bucket := range sortedKeys(byBucket) {
+		if err := transferToBucket(ctx, bucket, byBucket[bucket]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferToBucket transfers files into a bucket under a staging prefix,
+// then copies each to its object path, server side
+func transferToBucket(ctx context.Context, bucket string, files []drive.File) error {
+	list, err := urlList(files)
+	if err != nil {
+		return err
+	}
+	staging := path.Join(transferPrefix, runID)
+	listObject := staging + ".tsv"
+	if _, err := storageSrv.Upload(ctx, bucket, listObject, list, storage.ObjectAttrs{ContentType: "text/tab-separated-values", Metadata: map[string]string{runIDKey: runID}}); err != nil {
+		return fmt.Errorf("unable to write the URL list: %v", err)
+	}
+	// the list must be readable by Storage Transfer Service without credentials
+	listURL, err := storageSrv.SignedURL(bucket, listObject, maxSignedURLTTL)
+	if err != nil {
+		return fmt.Errorf("unable to sign the URL list: %v", err)
+	}
+	log.Printf("transferring %d files to gs://%s with Storage Transfer Service", len(files), bucket)
+	if err := transfers.transfer(ctx, listURL, bucket, staging+"/"); err != nil {
+		log.Printf("transfer to gs://%s: %v; downloading the files not transferred", bucket, err)
+	}
+
+	// objects are staged as <host>/<path> of their URL, ending in the file ID
+	staged, err := storageSrv.List(ctx, bucket, staging+"/")
+	if err != nil {
+		return fmt.Errorf("unable to list the transferred objects: %v", err)
+	}
+	byID := map[string]*storage.ObjectAttrs{}
+	for _, o := range staged {
+		id, _, _ := strings.Cut(path.Base(o.Name), "?")
+		byID[id] = o
+	}
+	copied := 0
+	for _, file := range files {
+		o, ok := byID[file.Id]
+		if !ok {
+			continue
+		}
+		name, err := objectName(file)
+		if err != nil {
+			continue
+		}
+		dest := routeFor(file)
+		if _, err := storageSrv.Copy(ctx, bucket, o.Name, objectPath(dest.Prefix, name), objectAttrs(file)); err != nil {
+			log.Printf("%s: unable to copy the transferred object: %v", file.Name, err)
+			continue
+		}
+		storageSrv.DeleteIfGeneration(ctx, bucket, o.Name, o.Generation)
+		copied++
+	}
+	log.Printf("transferred %d of %d files to gs://%s", copied, len(files), bucket)
+	return nil
+}
+
+// stsRunner runs transfers with Storage Transfer Service
+type stsRunner struct {
+	srv *storagetransfer.Service
+}
+
+func (s *stsRunner) transfer(ctx context.Context, listURL, bucket, prefix string) error {
+	job, err := s.srv.TransferJobs.Create(&storagetransfer.TransferJob{
+		Description: "drivetogcs run " + runID,
+		ProjectId:   projectID,
+		Status:      "ENABLED",
+		TransferSpec: &storagetransfer.TransferSpec{
+			HttpDataSource: &storagetransfer.HttpData{ListUrl: listURL},
+			GcsDataSink:    &storagetransfer.GcsData{BucketName: bucket, Path: prefix},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to create transfer job: %v", err)
+	}
+	op, err := s.srv.TransferJobs.Run(job.Name, &storagetransfer.RunTransferJobRequest{ProjectId: projectID}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to run transfer job %s: %v", job.Name, err)
+	}
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(transferPollInterval):
+		}
+		if op, err = s.srv.TransferOperations.Get(op.Name).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("unable to check transfer %s: %v", job.Name, err)
+		}
+	}
+	if op.Error != nil {
+		return errors.New(op.Error.Message)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeTransfer stages the public Drive files of a URL list as Storage
+// Transfer Service does, as <host>/<path> under the prefix
+type fakeTransfer struct {
+	f       *fakes
+	listURL string
+	skip    string // a file ID not transferred
+}
+
+func (t *fakeTransfer) transfer(ctx context.Context, listURL, bucket, prefix string) error {
+	t.listURL = listURL
+	list, err := t.f.storage.Read(ctx, bucket, transferPrefix+"/"+runID+".tsv")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(list)), "\n")[1:] {
+		u, err := url.Parse(strings.Split(line, "\t")[0])
+		if err != nil {
+			return err
+		}
+		id := path.Base(u.Path)
+		if id == t.skip {
+			continue
+		}
+		t.f.storage.Upload(ctx, bucket, prefix+u.Host+u.Path, t.f.drive.contents[id], storage.ObjectAttrs{})
+	}
+	return nil
+}
+
+func TestDelegateTransfer(t *testing.T) {
+	f := useFakes(t)
+	prevOver, prevTransfers, prevDescribe := transferOver, transfers, describeFromGCS
+	defer func() { transferOver, transfers, describeFromGCS = prevOver, prevTransfers, prevDescribe }()
+	t.Setenv("DRIVE_API_KEY", "key")
+	runner := &fakeTransfer{f: f, skip: "c"}
+	transfers = runner
+	ctx := context.Background()
+
+	for _, id := range []string{"a", "b", "c"} {
+		f.drive.add(id, id+".jpg", "image/jpeg", "root", []byte("image "+id))
+	}
+	files, _ := f.drive.List(ctx, "'root' in parents")
+	var fileList []drive.File
+	for _, file := range files {
+		fileList = append(fileList, *file)
+	}
+
+	transferOver = 1 << 20
+	if err := delegateTransfer(ctx, fileList); err != nil || runner.listURL != "" {
+		t.Fatalf("under the threshold: %v, transferred %q", err, runner.listURL)
+	}
+
+	transferOver = 10
+	if err := delegateTransfer(ctx, fileList); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(runner.listURL, "https://signed.example.com/test-bucket/.transfer/test-run.tsv") {
+		t.Errorf("list URL = %q, want the signed URL list", runner.listURL)
+	}
+	for _, id := range []string{"a", "b"} {
+		if got, err := f.storage.Read(ctx, "test-bucket", id+".jpg"); err != nil || string(got) != "image "+id {
+			t.Errorf("%s.jpg = %q, %v, want the transferred file", id, got, err)
+		}
+	}
+	if staged, _ := f.storage.List(ctx, "test-bucket", ".transfer/test-run/"); len(staged) != 0 {
+		t.Errorf("staged objects left: %d", len(staged))
+	}
+
+	// the transferred files are described from their objects, and the
+	// file not transferred is downloaded
+	for _, file := range fileList {
+		processFile(ctx, file, file.Name)
+	}
+	if f.drive.downloads != 1 {
+		t.Errorf("downloads = %d, want only the file not transferred", f.drive.downloads)
+	}
+}
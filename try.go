@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/genai"
+)
+
+// tryFile is the -file of the try command, a Drive file ID or local path
+var tryFile string
+
+// tryFlags registers the try command's flags
+func tryFlags() {
+	flag.StringVar(&tryFile, "file", tryFile, "Drive file ID or local path of the file to describe")
+}
+
+// runTry runs drivetogcs try -file <id or path>, describing a single file
+// and printing the prompt, response and token usage, to iterate on prompts
+func runTry(ctx context.Context, args []string) int {
+	if tryFile == "" || len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: drivetogcs try -file <Drive file ID or local path> [-prompt prompt.tpl] [-model model]")
+		return exitFailure
+	}
+	var file drive.File
+	var data []byte
+	if _, err := os.Stat(tryFile); err == nil {
+		closeClients := initCloudClients(ctx)
+		defer closeClients()
+		file, data, err = readLocalFile(tryFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+	} else if errors.Is(err, fs.ErrNotExist) {
+		closeClients := initClients(ctx)
+		defer closeClients()
+		f, err := getFile(ctx, tryFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to get Drive file %s: %v\n", tryFile, err)
+			return exitFailure
+		}
+		file = *f
+		if data, err = getFileBytes(ctx, file); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	if err := tryPrompt(ctx, file, data, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// readLocalFile reads a local file as a Drive file, with its MIME type from
+// its extension or contents
+func readLocalFile(path string) (drive.File, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return drive.File{}, nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(path)))
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	return drive.File{Id: "local", Name: filepath.Base(path), MimeType: mimeType, Size: int64(len(data))}, data, nil
+}
+
+// tryPrompt describes a file with the prompt and model the run would use,
+// printing the prompt, the response and its token usage
+func tryPrompt(ctx context.Context, file drive.File, data []byte, out io.Writer) error {
+	settings := describeSettingsFor(file)
+	prompt, err := renderPrompt(file, settings.Prompt)
+	if err != nil {
+		return err
+	}
+	if systemInstruction != "" {
+		fmt.Fprintf(out, "── system instruction ──\n%s\n", systemInstruction)
+	}
+	fmt.Fprintf(out, "── prompt (%s) ──\n%s\n", promptID(settings.Prompt), strings.TrimRight(prompt, "\n"))
+
+	part, cleanup, err := describePart(ctx, file, data)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	contents := []*genai.Content{genai.NewUserContentFromParts([]*genai.Part{part})}
+	contents = append(contents, genai.Text(prompt)...)
+	start := time.Now()
+	var resp *genai.GenerateContentResponse
+	err = withQuotaRetry(ctx, func() (err error) {
+		resp, err = genaiClient.GenerateContent(ctx, settings.Model, contents, generateConfig())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to generate content: %w", err)
+	}
+	fmt.Fprintf(out, "── response (%s, %s) ──\n%s\n", settings.Model, time.Since(start).Round(time.Millisecond), strings.TrimRight(resp.Text(), "\n"))
+	if g, ok := groundingOf(resp); ok {
+		fmt.Fprintf(out, "── grounding ──\n")
+		for _, q := range g.SearchQueries {
+			fmt.Fprintf(out, "searched %q\n", q)
+		}
+		for _, c := range g.Citations {
+			fmt.Fprintf(out, "%s %s\n", c.Title, c.URI)
+		}
+	}
+	if len(refinements) > 0 {
+		outputs := refineDescription(ctx, file, settings.Model, contents, generateConfig(), resp.Text())
+		for _, r := range refinements {
+			fmt.Fprintf(out, "── %s ──\n%s\n", r.Name, outputs[r.Name])
+		}
+	}
+	if u := resp.UsageMetadata; u != nil {
+		var promptTokens, responseTokens int32
+		if u.PromptTokenCount != nil {
+			promptTokens = *u.PromptTokenCount
+		}
+		if u.CandidatesTokenCount != nil {
+			responseTokens = *u.CandidatesTokenCount
+		}
+		fmt.Fprintf(out, "── usage ──\nprompt %d tokens, response %d tokens, total %d tokens\n", promptTokens, responseTokens, u.TotalTokenCount)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTryPrompt(t *testing.T) {
+	f := useFakes(t)
+	path := filepath.Join(t.TempDir(), "sunset.png")
+	if err := os.WriteFile(path, testPNG(t, 8, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, data, err := readLocalFile(path)
+	if err != nil || file.Name != "sunset.png" || file.MimeType != "image/png" {
+		t.Fatalf("readLocalFile = %+v, %v", file, err)
+	}
+
+	var out bytes.Buffer
+	if err := tryPrompt(context.Background(), file, data, &out); err != nil {
+		t.Fatalf("tryPrompt: %v", err)
+	}
+	for _, want := range []string{
+		"── prompt (describe_media.tpl@",
+		"The image name is: sunset.png",
+		"── response (" + model + ", ",
+		f.generator.response,
+		"total 10 tokens",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+	if f.generator.calls != 1 || f.storage.uploads != 0 {
+		t.Errorf("calls, uploads = %d, %d, want 1, 0", f.generator.calls, f.storage.uploads)
+	}
+
+	// the type of files without a known extension is detected
+	untyped := filepath.Join(t.TempDir(), "sunset")
+	os.WriteFile(untyped, data, 0644)
+	if file, _, _ := readLocalFile(untyped); file.MimeType != "image/png" {
+		t.Errorf("readLocalFile(untyped) MIME type = %q, want image/png", file.MimeType)
+	}
+}
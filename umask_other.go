@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// setUmask is not supported without a umask
+func setUmask(mask os.FileMode) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// setUmask sets the process umask
+func setUmask(mask os.FileMode) error {
+	syscall.Umask(int(mask))
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// verifySample is how many uploaded objects to re-download and compare with
+// their Drive originals after the run, 0 for none
+var verifySample int
+
+// verification outcomes
+const (
+	verifyMatched    = "matched"
+	verifyMismatched = "mismatched"
+	verifyFailed     = "failed" // either side could not be read
+)
+
+// verifyTarget is an uploaded object and the Drive file it came from
+type verifyTarget struct {
+	file   drive.File
+	bucket string
+	object string
+}
+
+// verifyResult is the outcome of comparing an object with its original
+type verifyResult struct {
+	DriveID string `json:"drive_id"`
+	Name    string `json:"name"`
+	Object  string `json:"object"`
+	Status  string `json:"status"`
+	// Method is how the object was compared: md5, with Drive's checksum, or
+	// sha256, of the Drive file downloaded again
+	Method string `json:"method,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// verificationReport is the outcome of -verify-sample in the run summary
+type verificationReport struct {
+	Sampled    int `json:"sampled"`
+	Matched    int `json:"matched"`
+	Mismatched int `json:"mismatched"`
+	Failed     int `json:"failed"`
+	// Problems are the objects that mismatched or couldn't be compared
+	Problems []verifyResult `json:"problems,omitempty"`
+}
+
+// verifySampler keeps a uniform random sample of -verify-sample uploaded
+// objects, by reservoir sampling, so its memory doesn't grow with the run
+type verifySampler struct {
+	mu     sync.Mutex
+	seen   int
+	sample []verifyTarget
+}
+
+var verifications = &verifySampler{}
+
+// add offers an uploaded object to the sample
+func (s *verifySampler) add(file drive.File, bucket, object string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	t := verifyTarget{file: file, bucket: bucket, object: object}
+	if len(s.sample) < verifySample {
+		s.sample = append(s.sample, t)
+		return
+	}
+	if i := rand.IntN(s.seen); i < verifySample {
+		s.sample[i] = t
+	}
+}
+
+// verify compares each sampled object with its Drive original, counting
+// mismatches and unreadable objects as failures of the run
+func (s *verifySampler) verify(ctx context.Context) *verificationReport {
+	s.mu.Lock()
+	sample := s.sample
+	s.mu.Unlock()
+
+	report := &verificationReport{Sampled: len(sample)}
+	for _, t := range sample {
+		result := verifyObject(ctx, t)
+		switch result.Status {
+		case verifyMatched:
+			report.Matched++
+			continue
+		case verifyMismatched:
+			report.Mismatched++
+			stats.failFile(t.file, "verify", errors.New("the object differs from the Drive file"))
+		default:
+			report.Failed++
+			stats.failFile(t.file, "verify", errors.New(result.Error))
+		}
+		stats.fail("verify")
+		report.Problems = append(report.Problems, result)
+		log.Printf("verify: %s (%s) gs://%s/%s %s %s", t.file.Name, t.file.Id, t.bucket, t.object, result.Status, result.Error)
+	}
+	return report
+}
+
+// verifyObject downloads an object again and compares it with Drive's MD5
+// checksum of the file or, for files without one, with the file downloaded
+// again from Drive
+func verifyObject(ctx context.Context, t verifyTarget) verifyResult {
+	result := verifyResult{DriveID: t.file.Id, Name: t.file.Name, Object: t.object}
+	data, err := storageSrv.Read(ctx, t.bucket, t.object)
+	if err != nil {
+		result.Status, result.Error = verifyFailed, fmt.Sprintf("unable to read the object: %v", err)
+		return result
+	}
+
+	var matched bool
+	if t.file.Md5Checksum != "" {
+		result.Method = "md5"
+		sum := md5.Sum(data)
+		matched = hex.EncodeToString(sum[:]) == t.file.Md5Checksum
+	} else {
+		result.Method = "sha256"
+		original, err := driveSHA256(ctx, t.file.Id)
+		if err != nil {
+			result.Status, result.Error = verifyFailed, fmt.Sprintf("unable to download the Drive file: %v", err)
+			return result
+		}
+		sum := sha256.Sum256(data)
+		matched = bytes.Equal(sum[:], original)
+	}
+	result.Status = verifyMismatched
+	if matched {
+		result.Status = verifyMatched
+	}
+	return result
+}
+
+// driveSHA256 downloads a Drive file and returns its SHA-256
+func driveSHA256(ctx context.Context, id string) ([]byte, error) {
+	body, err := driveSrv.Download(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// print logs the verification outcome with the run summary
+func (r *verificationReport) print() {
+	log.Printf("  verified: %d sampled, %d matched, %d mismatched, %d failed", r.Sampled, r.Matched, r.Mismatched, r.Failed)
+}
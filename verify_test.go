@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestVerifySample(t *testing.T) {
+	f := useFakes(t)
+	prevSample, prevSampler := verifySample, verifications
+	defer func() { verifySample, verifications = prevSample, prevSampler }()
+	verifySample, verifications = 2, &verifySampler{}
+
+	files := make(chan drive.File, 3)
+	for _, id := range []string{"1", "2", "3"} {
+		f.drive.add(id, id+".jpg", "image/jpeg", "root", []byte("image "+id))
+		file, _ := f.drive.Get(context.Background(), id, "")
+		files <- *file
+	}
+	close(files)
+	processFiles(context.Background(), files, &markdownRecordWriter{})
+
+	if verifications.seen != 3 || len(verifications.sample) != 2 {
+		t.Fatalf("sampled %d of %d uploads, want 2 of 3", len(verifications.sample), verifications.seen)
+	}
+	report := verifications.verify(context.Background())
+	if report.Sampled != 2 || report.Matched != 2 || len(report.Problems) != 0 {
+		t.Errorf("report = %+v, want 2 matched", report)
+	}
+	if summary := stats.summary(); summary.Failures["verify"] != 0 {
+		t.Errorf("failures = %v, want none", summary.Failures)
+	}
+}
+
+func TestVerifyObject(t *testing.T) {
+	f := useFakes(t)
+	ctx := context.Background()
+	f.drive.add("a", "a.jpg", "image/jpeg", "root", []byte("original"))
+	file, _ := f.drive.Get(ctx, "a", "")
+	f.storage.Upload(ctx, "test-bucket", "a.jpg", []byte("original"), storage.ObjectAttrs{})
+	f.storage.Upload(ctx, "test-bucket", "corrupt.jpg", []byte("corrupted"), storage.ObjectAttrs{})
+
+	if r := verifyObject(ctx, verifyTarget{file: *file, bucket: "test-bucket", object: "a.jpg"}); r.Status != verifyMatched || r.Method != "md5" {
+		t.Errorf("matching object = %+v", r)
+	}
+	if r := verifyObject(ctx, verifyTarget{file: *file, bucket: "test-bucket", object: "corrupt.jpg"}); r.Status != verifyMismatched {
+		t.Errorf("corrupted object = %+v, want mismatched", r)
+	}
+	if r := verifyObject(ctx, verifyTarget{file: *file, bucket: "test-bucket", object: "missing.jpg"}); r.Status != verifyFailed || r.Error == "" {
+		t.Errorf("missing object = %+v, want failed", r)
+	}
+
+	// without Drive's checksum, the file is downloaded again
+	noChecksum := *file
+	noChecksum.Md5Checksum = ""
+	downloads := f.drive.downloads
+	if r := verifyObject(ctx, verifyTarget{file: noChecksum, bucket: "test-bucket", object: "a.jpg"}); r.Status != verifyMatched || r.Method != "sha256" {
+		t.Errorf("object without a checksum = %+v", r)
+	}
+	if f.drive.downloads != downloads+1 {
+		t.Error("the Drive file wasn't downloaded again")
+	}
+	if r := verifyObject(ctx, verifyTarget{file: noChecksum, bucket: "test-bucket", object: "corrupt.jpg"}); r.Status != verifyMismatched {
+		t.Errorf("corrupted object without a checksum = %+v, want mismatched", r)
+	}
+}
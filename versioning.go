@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"hash/crc32"
+	"log"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// failOnChangedContent fails a file whose object already exists with other
+// content, rather than replacing it with a new generation with
+// -always-upload, or keeping the other content
+var failOnChangedContent bool
+
+// errContentChanged is returned when an object exists with other content and
+// -fail-on-changed-content is set
+var errContentChanged = errors.New("the object exists with different content")
+
+// bucketVersioning caches whether each bucket has object versioning enabled
+var bucketVersioning sync.Map
+
+// objectGenerations are the generations of the uploaded objects in buckets
+// with versioning enabled, by bucket/object
+var objectGenerations sync.Map
+
+// versioned reports whether a bucket has object versioning enabled,
+// checking each bucket once
+func versioned(ctx context.Context, bucket string) bool {
+	if v, ok := bucketVersioning.Load(bucket); ok {
+		return v.(bool)
+	}
+	attrs, err := storageSrv.BucketAttrs(ctx, bucket)
+	if err != nil {
+		log.Printf("unable to check versioning of bucket %s: %v", bucket, err)
+		return false
+	}
+	bucketVersioning.Store(bucket, attrs.VersioningEnabled)
+	return attrs.VersioningEnabled
+}
+
+// recordGeneration keeps the generation of an uploaded object for the
+// catalog, if its bucket is versioned
+func recordGeneration(ctx context.Context, bucket, object string, generation int64) {
+	if generation != 0 && versioned(ctx, bucket) {
+		objectGenerations.Store(bucket+"/"+object, generation)
+	}
+}
+
+// generationOf returns the generation of an uploaded object in a versioned
+// bucket, or 0
+func generationOf(bucket, object string) int64 {
+	if g, ok := objectGenerations.Load(bucket + "/" + object); ok {
+		return g.(int64)
+	}
+	return 0
+}
+
+// sameContent reports whether an object has the given contents, by MD5, or
+// CRC32C for composite objects, which have no MD5
+func sameContent(attrs *storage.ObjectAttrs, data []byte) bool {
+	if len(attrs.MD5) > 0 {
+		sum := md5.Sum(data)
+		return bytes.Equal(attrs.MD5, sum[:])
+	}
+	return attrs.Size == int64(len(data)) && attrs.CRC32C == crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+}
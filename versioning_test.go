@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestFailOnChangedContent(t *testing.T) {
+	f := useFakes(t)
+	prev := failOnChangedContent
+	defer func() { failOnChangedContent = prev }()
+	failOnChangedContent = true
+	ctx := context.Background()
+
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("first"), storage.ObjectAttrs{}, false); err != nil {
+		t.Fatal(err)
+	}
+	// the same content is skipped, or replaced with -always-upload
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("first"), storage.ObjectAttrs{}, false); err != nil {
+		t.Errorf("same content: %v", err)
+	}
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("first"), storage.ObjectAttrs{}, true); err != nil {
+		t.Errorf("same content with -always-upload: %v", err)
+	}
+	uploads := f.storage.uploads
+	for _, override := range []bool{false, true} {
+		if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("changed"), storage.ObjectAttrs{}, override); !errors.Is(err, errContentChanged) {
+			t.Errorf("changed content, override %v: %v, want errContentChanged", override, err)
+		}
+	}
+	if f.storage.uploads != uploads {
+		t.Error("the changed content was uploaded")
+	}
+	if got, _ := f.storage.Read(ctx, "test-bucket", "a.jpg"); string(got) != "first" {
+		t.Errorf("object = %q, want the first content kept", got)
+	}
+}
+
+func TestGenerationRecorded(t *testing.T) {
+	f := useFakes(t)
+	ctx := context.Background()
+
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("a"), storage.ObjectAttrs{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if g := generationOf("test-bucket", "a.jpg"); g != 0 {
+		t.Errorf("generation in an unversioned bucket = %d, want none", g)
+	}
+
+	bucketVersioning.Clear()
+	f.storage.versioning = true
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "b.jpg", []byte("b"), storage.ObjectAttrs{}, false); err != nil {
+		t.Fatal(err)
+	}
+	attrs, _ := f.storage.Attrs(ctx, "test-bucket", "b.jpg")
+	if g := generationOf("test-bucket", "b.jpg"); g == 0 || g != attrs.Generation {
+		t.Errorf("generation = %d, want %d", g, attrs.Generation)
+	}
+	// an existing object's generation is recorded when it is skipped
+	if err := uploadFileToGCS(ctx, "test-bucket", "", "a.jpg", []byte("a"), storage.ObjectAttrs{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if g := generationOf("test-bucket", "a.jpg"); g == 0 {
+		t.Error("the existing object's generation wasn't recorded")
+	}
+	if got := columns["generation"](record{Generation: 42}); got != "42" {
+		t.Errorf("generation column = %q", got)
+	}
+}
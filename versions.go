@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// builtinPrompt is the prompt identifier of the built in prompt template
+const builtinPrompt = "describe_media.tpl"
+
+// descriptionVersion is a description generated by an earlier run, kept when
+// a later run regenerates it
+type descriptionVersion struct {
+	Description string `json:"description"`
+	Model       string `json:"model,omitempty"`
+	Prompt      string `json:"prompt,omitempty"`
+	RunID       string `json:"run_id,omitempty"`
+	DescribedAt string `json:"described_at,omitempty"`
+	// EditedAt is set for descriptions written by a reviewer
+	EditedAt string `json:"edited_at,omitempty"`
+}
+
+// promptID identifies a prompt template by its file name and a hash of its
+// text, so descriptions from edited templates can be told apart, followed by
+// a hash of the system instruction if there is one
+func promptID(promptPath string) string {
+	name := builtinPrompt
+	var b []byte
+	var err error
+	if promptPath == "" {
+		b, err = promptTemplates.ReadFile("prompts/" + builtinPrompt)
+	} else {
+		name = filepath.Base(promptPath)
+		b, err = os.ReadFile(promptPath)
+	}
+	if err != nil {
+		return name
+	}
+	sum := sha256.Sum256(b)
+	id := name + "@" + hex.EncodeToString(sum[:])[:12]
+	if systemInstruction != "" {
+		sum := sha256.Sum256([]byte(systemInstruction))
+		id += "+system@" + hex.EncodeToString(sum[:])[:12]
+	}
+	return id
+}
+
+// version returns the record's description as a version
+func (r record) version() descriptionVersion {
+	return descriptionVersion{
+		Description: r.Description,
+		Model:       r.Model,
+		Prompt:      r.Prompt,
+		RunID:       r.RunID,
+		DescribedAt: r.DescribedAt,
+		EditedAt:    r.EditedAt,
+	}
+}
+
+// addDescriptionVersions carries the description history over from the
+// object's existing sidecar, keeping its description as a prior version if
+// this run generated a different one, or as the current description if this
+// run didn't describe the file
+func addDescriptionVersions(ctx context.Context, r *record) error {
+	b, err := storageSrv.Read(ctx, r.bucket(), sidecarPath(r.ObjectPath))
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read sidecar: %w", err)
+	}
+	var prev record
+	if err := json.Unmarshal(b, &prev); err != nil {
+		return fmt.Errorf("unable to parse sidecar %s: %v", sidecarPath(r.ObjectPath), err)
+	}
+	r.DescriptionVersions = prev.DescriptionVersions
+	if prev.DescribedAt == "" && prev.EditedAt == "" || prev.NeedsReview {
+		return nil // no generated or edited description to keep
+	}
+	if r.DescribedAt == "" && r.EditedAt == "" && !r.NeedsReview {
+		// not described this run, keep the earlier description
+		r.Description, r.Model, r.Prompt, r.DescribedAt = prev.Description, prev.Model, prev.Prompt, prev.DescribedAt
+		r.ReviewStatus, r.ReviewNote, r.EditedAt = prev.ReviewStatus, prev.ReviewNote, prev.EditedAt
+		return nil
+	}
+	v := prev.version()
+	if v.Description != r.Description || v.Model != r.Model || v.Prompt != r.Prompt {
+		r.DescriptionVersions = append(r.DescriptionVersions, v)
+		return nil
+	}
+	// the same description keeps its review
+	r.ReviewStatus, r.ReviewNote = prev.ReviewStatus, prev.ReviewNote
+	return nil
+}
+
+// formatVersions formats prior descriptions one per line, oldest first
+func formatVersions(versions []descriptionVersion) string {
+	var lines []string
+	for _, v := range versions {
+		if v.EditedAt != "" {
+			lines = append(lines, fmt.Sprintf("%s edited: %s", v.EditedAt, v.Description))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s: %s", v.DescribedAt, v.Model, v.Prompt, v.Description))
+	}
+	return strings.Join(lines, "\n")
+}
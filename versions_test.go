@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDescriptionVersions(t *testing.T) {
+	f := useFakes(t)
+	prevSidecars, prevModel := writeSidecars, model
+	writeSidecars = true
+	defer func() { writeSidecars, model = prevSidecars, prevModel }()
+	f.drive.add("a", "a.png", "image/png", "root", []byte("a"))
+	file := *f.drive.files["a"]
+	ctx := context.Background()
+
+	f.generator.response = "First."
+	r := processFile(ctx, file, "a.png")
+	if r.Model != model || !strings.HasPrefix(r.Prompt, builtinPrompt+"@") || r.DescribedAt == "" {
+		t.Errorf("record = %+v, want the model, prompt and time of the description", r)
+	}
+	if len(r.DescriptionVersions) != 0 {
+		t.Errorf("first run versions = %v, want none", r.DescriptionVersions)
+	}
+
+	// the same description isn't kept twice
+	r = processFile(ctx, file, "a.png")
+	if len(r.DescriptionVersions) != 0 {
+		t.Errorf("unchanged description versions = %v, want none", r.DescriptionVersions)
+	}
+
+	// a new model keeps the earlier description
+	model = "gemini-2.5-pro"
+	f.generator.response = "Second."
+	r = processFile(ctx, file, "a.png")
+	if r.Description != "Second." || len(r.DescriptionVersions) != 1 || r.DescriptionVersions[0].Description != "First." || r.DescriptionVersions[0].Model != prevModel {
+		t.Fatalf("record = %+v, want Second. with First. from %s as a prior version", r, prevModel)
+	}
+
+	// skipping the description keeps the current one
+	createDescription = false
+	r = processFile(ctx, file, "a.png")
+	if r.Description != "Second." || r.Model != "gemini-2.5-pro" || len(r.DescriptionVersions) != 1 {
+		t.Errorf("undescribed record = %+v, want Second. kept", r)
+	}
+
+	var sidecar record
+	if err := json.Unmarshal(f.storage.objects["test-bucket/a.png.json"], &sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.Description != "Second." || len(sidecar.DescriptionVersions) != 1 {
+		t.Errorf("sidecar = %+v, want the description history", sidecar)
+	}
+	if got := formatVersions(sidecar.DescriptionVersions); !strings.Contains(got, prevModel+" "+builtinPrompt) || !strings.HasSuffix(got, ": First.") {
+		t.Errorf("formatVersions() = %q", got)
+	}
+}
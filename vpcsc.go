@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// Google API virtual IPs, reached over Private Google Access
+const (
+	vipNone       = "none"       // resolve *.googleapis.com through DNS
+	vipPrivate    = "private"    // private.googleapis.com, for the APIs VPC Service Controls doesn't restrict
+	vipRestricted = "restricted" // restricted.googleapis.com, for only the APIs VPC Service Controls supports
+)
+
+var googleVIPs = []string{vipNone, vipPrivate, vipRestricted}
+
+// vipAddrs are the first addresses of the private.googleapis.com and
+// restricted.googleapis.com ranges, 199.36.153.8/30 and 199.36.153.4/30
+var vipAddrs = map[string]string{
+	vipPrivate:    "199.36.153.8",
+	vipRestricted: "199.36.153.4",
+}
+
+// googleVIP is the virtual IP *.googleapis.com requests are sent to, for a
+// VPC without the DNS zones mapping the APIs to it
+var googleVIP = vipNone
+
+// clientCertFile and clientKeyFile are the PEM client certificate and key
+// presented to Google APIs, for access levels requiring certificate-based
+// access
+var clientCertFile, clientKeyFile string
+
+// clientCert is the loaded -client-cert, or nil
+var clientCert *tls.Certificate
+
+// configureTransport sends *.googleapis.com requests to the -google-vip and
+// presents the -client-cert, by replacing the default HTTP transport, which
+// the OAuth, Drive, Cloud Storage and genai clients all start from
+func configureTransport() error {
+	if (clientCertFile == "") != (clientKeyFile == "") {
+		return fmt.Errorf("-client-cert and -client-key must be set together")
+	}
+	if googleVIP == vipNone && clientCertFile == "" {
+		return nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if googleVIP != vipNone {
+		t.DialContext = vipDialer(vipAddrs[googleVIP], (&net.Dialer{}).DialContext)
+	}
+	if clientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load -client-cert: %v", err)
+		}
+		clientCert = &cert
+		t.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		// have the Drive and Cloud Storage clients use the mTLS endpoints
+		os.Setenv("GOOGLE_API_USE_CLIENT_CERTIFICATE", "true")
+	}
+	http.DefaultTransport = t
+	return nil
+}
+
+// vipDialer returns a dialer connecting to *.googleapis.com hosts at the
+// virtual IP. The TLS server name is still the host, so certificates verify.
+func vipDialer(vip string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err == nil && isGoogleAPIHost(host) {
+			addr = net.JoinHostPort(vip, port)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// isGoogleAPIHost reports whether a host is a Google API
+func isGoogleAPIHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == "googleapis.com" || strings.HasSuffix(host, ".googleapis.com")
+}
+
+// clientCertOptions returns the client options presenting the -client-cert,
+// which also selects the API's mTLS endpoint
+func clientCertOptions() []option.ClientOption {
+	if clientCert == nil {
+		return nil
+	}
+	return []option.ClientOption{option.WithClientCertSource(func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return clientCert, nil
+	})}
+}
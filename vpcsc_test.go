@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVIPDialer(t *testing.T) {
+	var dialed string
+	dial := vipDialer("199.36.153.4", func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		return nil, nil
+	})
+	for addr, want := range map[string]string{
+		"storage.googleapis.com:443":                 "199.36.153.4:443",
+		"europe-west4-aiplatform.googleapis.com:443": "199.36.153.4:443",
+		"oauth2.googleapis.com.:443":                 "199.36.153.4:443",
+		"accounts.google.com:443":                    "accounts.google.com:443",
+		"example.com:443":                            "example.com:443",
+		"notgoogleapis.com:443":                      "notgoogleapis.com:443",
+	} {
+		dial(context.Background(), "tcp", addr)
+		if dialed != want {
+			t.Errorf("dialing %s connected to %s, want %s", addr, dialed, want)
+		}
+	}
+}
+
+// writeTestCert writes a self-signed client certificate and key
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "drivetogcs"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestConfigureTransport(t *testing.T) {
+	defer func(transport http.RoundTripper, vip, certFile, keyFile string) {
+		http.DefaultTransport, googleVIP, clientCertFile, clientKeyFile, clientCert = transport, vip, certFile, keyFile, nil
+	}(http.DefaultTransport, googleVIP, clientCertFile, clientKeyFile)
+	t.Setenv("GOOGLE_API_USE_CLIENT_CERTIFICATE", "")
+	t.Setenv("VERTEX_ENDPOINT", "")
+	prev := http.DefaultTransport
+
+	googleVIP, clientCertFile, clientKeyFile = vipNone, "", ""
+	if err := configureTransport(); err != nil || http.DefaultTransport != prev {
+		t.Errorf("without -google-vip or -client-cert the transport was replaced: %v", err)
+	}
+
+	clientCertFile = "cert.pem"
+	if err := configureTransport(); err == nil {
+		t.Error("configureTransport accepted -client-cert without -client-key")
+	}
+
+	googleVIP = vipRestricted
+	clientCertFile, clientKeyFile = writeTestCert(t)
+	if err := configureTransport(); err != nil {
+		t.Fatal(err)
+	}
+	tr := http.DefaultTransport.(*http.Transport)
+	if tr.DialContext == nil || tr.TLSClientConfig == nil || len(tr.TLSClientConfig.Certificates) != 1 {
+		t.Errorf("transport not configured with the virtual IP and certificate")
+	}
+	if os.Getenv("GOOGLE_API_USE_CLIENT_CERTIFICATE") != "true" || len(driveOptions()) == 0 || len(storageEndpointOptions()) == 0 {
+		t.Error("the Drive and Cloud Storage clients don't present the certificate")
+	}
+	if got := vertexBaseURL("europe-west4"); got != "https://europe-west4-aiplatform.mtls.googleapis.com/" {
+		t.Errorf("vertexBaseURL = %q, want the mTLS endpoint", got)
+	}
+}
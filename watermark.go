@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// watermarkText is copyright text drawn onto the serving copy of images
+var watermarkText string
+
+// watermarkImage is the path of a PNG drawn onto the serving copy of images
+var watermarkImage string
+
+var watermarkOpacity float64 = 0.5
+
+// archivePrefix is the folder, within the destination prefix, that the
+// originals of watermarked images are uploaded under
+var archivePrefix string = "originals"
+
+// stageWatermark is the stats category of watermarking
+const stageWatermark = "watermark"
+
+// originalObjectKey is the metadata key of a watermarked object holding the
+// object path of its original
+const originalObjectKey = "original-object"
+
+// errWatermark is returned when an image could not be watermarked
+var errWatermark = errors.New("unable to watermark")
+
+// watermarkable are the image types that can be watermarked
+var watermarkable = map[string]bool{"image/jpeg": true, "image/png": true}
+
+// watermarks reports whether a file of the mime type is watermarked
+func watermarks(mimeType string) bool {
+	return (watermarkText != "" || watermarkImage != "") && watermarkable[mimeType]
+}
+
+// archiveName returns the object name of the original of a watermarked image
+func archiveName(name string) string {
+	return path.Join(archivePrefix, name)
+}
+
+var (
+	watermarkOnce    sync.Once
+	watermarkOverlay image.Image
+	watermarkFont    *opentype.Font
+	watermarkErr     error
+)
+
+// loadWatermark loads the watermark image and font once
+func loadWatermark() error {
+	watermarkOnce.Do(func() {
+		watermarkFont, watermarkErr = opentype.Parse(goregular.TTF)
+		if watermarkErr != nil || watermarkImage == "" {
+			return
+		}
+		f, err := os.Open(watermarkImage)
+		if err != nil {
+			watermarkErr = fmt.Errorf("unable to open watermark image: %v", err)
+			return
+		}
+		defer f.Close()
+		watermarkOverlay, err = png.Decode(f)
+		if err != nil {
+			watermarkErr = fmt.Errorf("unable to decode watermark image %s: %v", watermarkImage, err)
+		}
+	})
+	return watermarkErr
+}
+
+// watermark draws the watermark image and text onto the bottom right corner
+// of a JPEG or PNG image, returning the encoded serving copy
+func watermark(data []byte, mimeType string) ([]byte, error) {
+	if err := loadWatermark(); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %v", err)
+	}
+	bounds := src.Bounds()
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, bounds, src, bounds.Min, draw.Src)
+
+	alpha := uint8(watermarkOpacity * 255)
+	margin := max(bounds.Dy()/40, 4)
+	bottom := bounds.Max.Y - margin
+
+	if watermarkOverlay != nil {
+		// scale the overlay to at most a fifth of the image width
+		ob := watermarkOverlay.Bounds()
+		w, h := ob.Dx(), ob.Dy()
+		if limit := bounds.Dx() / 5; w > limit && limit > 0 {
+			w, h = limit, h*limit/w
+		}
+		r := image.Rect(bounds.Max.X-margin-w, bottom-h, bounds.Max.X-margin, bottom)
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), watermarkOverlay, ob, draw.Src, nil)
+		draw.DrawMask(img, r, scaled, image.Point{}, image.NewUniform(color.Alpha{A: alpha}), image.Point{}, draw.Over)
+		bottom = r.Min.Y - margin/2
+	}
+
+	if watermarkText != "" {
+		face, err := opentype.NewFace(watermarkFont, &opentype.FaceOptions{
+			Size:    float64(max(bounds.Dy()/30, 10)),
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to load watermark font: %v", err)
+		}
+		defer face.Close()
+		width := font.MeasureString(face, watermarkText).Ceil()
+		descent := face.Metrics().Descent.Ceil()
+		dot := fixed.P(bounds.Max.X-margin-width, bottom-descent)
+		// a dark shadow keeps the text legible on light images
+		for _, layer := range []struct {
+			offset int
+			color  color.Color
+		}{
+			{1, color.NRGBA{0, 0, 0, alpha}},
+			{0, color.NRGBA{255, 255, 255, alpha}},
+		} {
+			d := &font.Drawer{Dst: img, Src: image.NewUniform(layer.color), Face: face}
+			d.Dot = dot.Add(fixed.P(layer.offset, layer.offset))
+			d.DrawString(watermarkText)
+		}
+	}
+
+	var buf bytes.Buffer
+	switch mimeType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode watermarked image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
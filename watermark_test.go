@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{0, 0, 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWatermarkedImagesKeepOriginals(t *testing.T) {
+	f := useFakes(t)
+	prev := watermarkText
+	watermarkText = "© Example"
+	defer func() { watermarkText = prev }()
+	original := testPNG(t, 400, 300)
+	f.drive.add("1", "a.png", "image/png", "folder1", original)
+
+	r := processFile(context.Background(), drive.File{Id: "1", Name: "a.png", MimeType: "image/png"}, "a.png")
+	if r.ObjectPath != "a.png" || r.OriginalPath != "originals/a.png" {
+		t.Errorf("record paths = %q, %q", r.ObjectPath, r.OriginalPath)
+	}
+	if !bytes.Equal(f.storage.objects["test-bucket/originals/a.png"], original) {
+		t.Error("original not uploaded under originals/")
+	}
+	if got := f.storage.attrs["test-bucket/a.png"].Metadata[originalObjectKey]; got != "originals/a.png" {
+		t.Errorf("serving copy %s = %q", originalObjectKey, got)
+	}
+
+	served, err := png.Decode(bytes.NewReader(f.storage.objects["test-bucket/a.png"]))
+	if err != nil {
+		t.Fatalf("serving copy is not a PNG: %v", err)
+	}
+	if served.Bounds() != image.Rect(0, 0, 400, 300) {
+		t.Errorf("serving copy bounds = %v", served.Bounds())
+	}
+	changed := false
+	for y := 200; y < 300 && !changed; y++ {
+		for x := 200; x < 400; x++ {
+			if r, _, _, _ := served.At(x, y).RGBA(); r != 0 {
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		t.Error("no watermark drawn in the bottom right corner")
+	}
+	if r, _, _, _ := served.At(10, 10).RGBA(); r != 0 {
+		t.Error("watermark drawn outside the bottom right corner")
+	}
+}
+
+func TestWatermarkFailureSkipsUpload(t *testing.T) {
+	f := useFakes(t)
+	prev := watermarkText
+	watermarkText = "© Example"
+	defer func() { watermarkText = prev }()
+	f.drive.add("1", "a.jpg", "image/jpeg", "folder1", []byte("not a jpeg"))
+
+	r := processFile(context.Background(), drive.File{Id: "1", Name: "a.jpg", MimeType: "image/jpeg"}, "a.jpg")
+	if f.storage.uploads != 0 || r.ObjectPath != "" {
+		t.Errorf("uploads = %d, object path = %q, want nothing uploaded", f.storage.uploads, r.ObjectPath)
+	}
+	if failed := stats.summary().FailedFiles; len(failed) != 1 || failed[0].Stage != stageWatermark {
+		t.Errorf("failed files = %+v", failed)
+	}
+}
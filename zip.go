@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// expandZips extracts the files in zip archives found in Drive, processing
+// those whose types are in -mime-types individually, besides the archive
+var expandZips bool
+
+// zipMimeTypes are the MIME types Drive gives zip archives
+var zipMimeTypes = []string{"application/zip", "application/x-zip-compressed"}
+
+// maxExtractedBytes is the largest file extracted from an archive
+const maxExtractedBytes = 1 << 30
+
+// extractedFiles holds the contents of the files extracted from archives
+// until they are processed, by their ID, the archive's Drive file ID and
+// their path in the archive
+var extractedFiles sync.Map
+
+// zipArchives holds the contents of the archives downloaded to be described
+// until their files are extracted, by Drive file ID, so they aren't
+// downloaded again
+var zipArchives sync.Map
+
+// isZip reports whether a MIME type is a zip archive
+func isZip(mimeType string) bool {
+	return slices.Contains(zipMimeTypes, mimeType)
+}
+
+// extracted reports whether a file was extracted from an archive, and so
+// is not a Drive file
+func extracted(file drive.File) bool {
+	_, ok := extractedFiles.Load(file.Id)
+	return ok
+}
+
+// withZipMimeTypes adds the zip MIME types to the listed MIME types with
+// -expand-zips
+func withZipMimeTypes(types []string) []string {
+	if !expandZips {
+		return types
+	}
+	for _, t := range zipMimeTypes {
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// keepArchive keeps the contents of an archive downloaded to be described
+// for expandZip
+func keepArchive(file drive.File, data []byte) {
+	if expandZips && isZip(file.MimeType) && data != nil {
+		zipArchives.Store(file.Id, data)
+	}
+}
+
+// archiveBytes returns the contents of an archive, kept when it was
+// described, or downloaded if it wasn't, such as when described from its
+// object
+func archiveBytes(ctx context.Context, archive drive.File) ([]byte, error) {
+	if data, ok := zipArchives.LoadAndDelete(archive.Id); ok {
+		return data.([]byte), nil
+	}
+	return getFileBytes(ctx, archive)
+}
+
+// expandZip extracts the files of an archive whose types are in -mime-types
+// one at a time, calling process with each while its contents are in
+// extractedFiles, so only one is held in memory. They are placed in a
+// folder named after the archive, next to it.
+func expandZip(ctx context.Context, archive drive.File, data []byte, process func(drive.File)) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("unable to read zip %s: %v", archive.Name, err)
+	}
+	folder := strings.TrimSuffix(archive.Name, path.Ext(archive.Name))
+	var count int
+	for _, entry := range zr.File {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		name := path.Clean(strings.ReplaceAll(entry.Name, `\`, "/"))
+		base := path.Base(name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") || strings.HasPrefix(name, "../") {
+			continue
+		}
+		mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(base)))
+		if !slices.Contains(mimeTypes, mimeType) || isZip(mimeType) {
+			continue
+		}
+		contents, err := readZipEntry(entry)
+		if err != nil {
+			return fmt.Errorf("unable to extract %s from %s: %v", name, archive.Name, err)
+		}
+		file := drive.File{
+			Id:           archive.Id + "/" + name,
+			Name:         base,
+			MimeType:     mimeType,
+			Size:         int64(len(contents)),
+			Parents:      archive.Parents,
+			CreatedTime:  archive.CreatedTime,
+			ModifiedTime: entry.Modified.UTC().Format(time.RFC3339),
+		}
+		dir := path.Join(relativePath(archive), folder)
+		if d := path.Dir(name); d != "." {
+			dir = path.Join(dir, d)
+		}
+		fileLocations.Store(file.Id, fileLocation{folderID: fileFolderID(archive), relativePath: dir, ancestors: fileAncestors(archive)})
+		extractedFiles.Store(file.Id, contents)
+		process(file)
+		extractedFiles.Delete(file.Id)
+		count++
+	}
+	log.Printf("%s had %d files to process", archive.Name, count)
+	return nil
+}
+
+// readZipEntry reads a file in an archive, up to maxExtractedBytes
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	if entry.UncompressedSize64 > maxExtractedBytes {
+		return nil, fmt.Errorf("%d bytes is over the limit of %d", entry.UncompressedSize64, maxExtractedBytes)
+	}
+	r, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(io.LimitReader(r, maxExtractedBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) > maxExtractedBytes {
+		return nil, fmt.Errorf("over the limit of %d bytes", maxExtractedBytes)
+	}
+	return contents, nil
+}
+
+// processZip processes the files extracted from an archive, writing their
+// records, and then forgets their contents
+func processZip(ctx context.Context, archive drive.File, output recordWriter) {
+	data, err := archiveBytes(ctx, archive)
+	if err == nil {
+		err = expandZip(ctx, archive, data, func(file drive.File) { processExtracted(ctx, file, output) })
+	}
+	if err != nil {
+		stats.failErr("extract", err)
+		stats.failFile(archive, "extract", err)
+		log.Printf("%v", err)
+	}
+}
+
+// processExtracted processes a file extracted from an archive, writing its
+// record
+func processExtracted(ctx context.Context, file drive.File, output recordWriter) {
+	if pattern, ok := ignored(file); ok {
+		stats.skip("ignored")
+		log.Printf("skipping %s (%s): matches %s in %s", file.Name, file.Id, pattern, ignoreFile)
+	} else if r := ruleFor(file); r != nil && r.Skip {
+		stats.skip("rule")
+		log.Printf("skipping %s (%s): matches a config rule", file.Name, file.Id)
+	} else if name, err := objectName(file); errors.Is(err, errNameCollision) && onCollision == collisionSkip {
+		stats.skip("collision")
+		log.Printf("skipping %s (%s): %v", file.Name, file.Id, err)
+	} else if errors.Is(err, errNameCollision) {
+		stats.fail(stageCollision)
+		stats.failFile(file, stageCollision, err)
+		log.Printf("%s (%s): %v", file.Name, file.Id, err)
+	} else if err := output.Write(processFile(ctx, file, name)); err != nil {
+		stats.fail("output")
+		log.Printf("failed to write record: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func testZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range sortedKeys(files) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(files[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExpandZips(t *testing.T) {
+	f := useFakes(t)
+	prevExpand, prevTypes := expandZips, mimeTypes
+	t.Cleanup(func() { expandZips, mimeTypes = prevExpand, prevTypes })
+	expandZips = true
+	mimeTypes = withZipMimeTypes([]string{"image/jpeg", "image/png"})
+	sourceFolderID = "folder1"
+	f.drive.add("folder1", "Folder One", "application/vnd.google-apps.folder", "root", nil)
+	archive := testZip(t, map[string][]byte{
+		"a.jpg":            []byte("aa"),
+		"sub/b.png":        []byte("bbb"),
+		"notes.txt":        []byte("not an image"),
+		"__MACOSX/._a.jpg": []byte("resource fork"),
+		".DS_Store":        []byte("finder"),
+	})
+	f.drive.add("1", "Shoot.zip", "application/zip", "folder1", archive)
+
+	path := filepath.Join(t.TempDir(), "descriptions.csv")
+	output, err := newCSVRecordWriter(path, []string{"drive_id", "name", "gcs_uri", "description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(chan drive.File, 1)
+	files <- *f.drive.files["1"]
+	close(files)
+	processFiles(context.Background(), files, output)
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string][]string{}
+	for _, row := range readCSV(t, path)[1:] {
+		found[row[0]] = row
+	}
+	if len(found) != 3 {
+		t.Fatalf("records = %v, want the archive and 2 images", found)
+	}
+	if row := found["1"]; row[2] != "gs://test-bucket/Shoot.zip" || row[3] != "Description skipped" {
+		t.Errorf("archive row = %v", row)
+	}
+	if row := found["1/sub/b.png"]; row[1] != "b.png" || row[2] != "gs://test-bucket/Shoot/sub/b.png" || row[3] != f.generator.response {
+		t.Errorf("extracted row = %v", row)
+	}
+	if !bytes.Equal(f.storage.objects["test-bucket/Shoot/a.jpg"], []byte("aa")) || !bytes.Equal(f.storage.objects["test-bucket/Shoot.zip"], archive) {
+		t.Error("archive and extracted files not uploaded")
+	}
+	if f.generator.calls != 2 {
+		t.Errorf("generator calls = %d, want 2", f.generator.calls)
+	}
+	if _, ok := extractedFiles.Load("1/a.jpg"); ok {
+		t.Error("extracted contents kept after processing")
+	}
+	if f.drive.downloads != 1 {
+		t.Errorf("downloads = %d, want the archive downloaded once", f.drive.downloads)
+	}
+	if _, ok := zipArchives.Load("1"); ok {
+		t.Error("archive contents kept after extracting")
+	}
+}
+
+func TestExpandZipOneAtATime(t *testing.T) {
+	useFakes(t)
+	prevTypes := mimeTypes
+	t.Cleanup(func() { mimeTypes = prevTypes })
+	mimeTypes = []string{"image/jpeg"}
+	archive := drive.File{Id: "1", Name: "Shoot.zip", MimeType: "application/zip"}
+	data := testZip(t, map[string][]byte{"a.jpg": []byte("a"), "b.jpg": []byte("b"), "c.jpg": []byte("c")})
+	var names []string
+	err := expandZip(context.Background(), archive, data, func(file drive.File) {
+		var held int
+		extractedFiles.Range(func(any, any) bool { held++; return true })
+		if held != 1 {
+			t.Errorf("%d extracted files held processing %s, want 1", held, file.Name)
+		}
+		names = append(names, file.Name)
+	})
+	if err != nil || len(names) != 3 {
+		t.Errorf("expandZip = %v, processed %v", err, names)
+	}
+}
+
+func TestExpandZipRejectsInvalidArchives(t *testing.T) {
+	f := useFakes(t)
+	f.drive.add("1", "broken.zip", "application/zip", "root", []byte("not a zip"))
+	if err := expandZip(context.Background(), *f.drive.files["1"], []byte("not a zip"), func(drive.File) {}); err == nil {
+		t.Error("expandZip succeeded on an invalid archive")
+	}
+}